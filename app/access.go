@@ -0,0 +1,123 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"strings"
+	"syscall"
+
+	"github.com/florianibach/samba-admin-ui/internal/samba"
+)
+
+// ShareAccessForm is the effective access checker on the share detail page.
+type ShareAccessForm struct {
+	Users  []string // Samba users to choose from
+	User   string
+	Result *samba.AccessResult
+	Error  string
+}
+
+// shareAccessForm completes form (or a fresh one) and evaluates the access
+// of the chosen user to share name with parameters kv.
+func (a *App) shareAccessForm(name string, kv map[string]string, form *ShareAccessForm) *ShareAccessForm {
+	if form == nil {
+		form = &ShareAccessForm{}
+	}
+	if users, err := samba.ListSambaUsers(); err == nil {
+		for _, u := range users {
+			form.Users = append(form.Users, u.Name)
+		}
+	}
+	if form.User == "" {
+		return form
+	}
+	res, err := a.shareAccess(name, kv, form.User)
+	if err != nil {
+		form.Error = err.Error()
+		return form
+	}
+	form.Result = res
+	return form
+}
+
+// shareAccess explains what Samba user may do on share name. kv are the
+// effective parameters of the share.
+func (a *App) shareAccess(name string, kv map[string]string, user string) (*samba.AccessResult, error) {
+	users, err := samba.ListSambaUsers()
+	if err != nil {
+		return nil, err
+	}
+	var su *samba.SambaUser
+	for i := range users {
+		if users[i].Name == user {
+			su = &users[i]
+		}
+	}
+	if su == nil {
+		return nil, opErr(http.StatusNotFound, "no Samba user %s", user)
+	}
+
+	acc, err := samba.LoadAccounts()
+	if err != nil {
+		return nil, err
+	}
+	u, ok := acc.User(user)
+	if !ok {
+		return nil, opErr(http.StatusBadRequest, "Samba user %s has no Linux account", user)
+	}
+	groups := acc.GroupsOf(user)
+
+	in := samba.AccessInput{
+		Share:    name,
+		Params:   kv,
+		User:     user,
+		Groups:   groups,
+		Disabled: su.Disabled,
+		FSUser:   user,
+		FSUID:    u.UID,
+		FSGroups: groups,
+	}
+
+	// force user replaces the identity, force group adds a primary group
+	if fu := kv["force user"]; fu != "" {
+		f, ok := acc.User(fu)
+		if !ok {
+			return nil, opErr(http.StatusBadRequest, "force user %s does not exist", fu)
+		}
+		in.FSUser, in.FSUID, in.FSGroups = f.Name, f.UID, acc.GroupsOf(f.Name)
+	}
+	if fg := strings.TrimLeft(kv["force group"], "+"); fg != "" {
+		g, ok := acc.Group(fg)
+		if !ok {
+			return nil, opErr(http.StatusBadRequest, "force group %s does not exist", fg)
+		}
+		if !hasGroupEntry(in.FSGroups, g.Name) {
+			in.FSGroups = append([]samba.GroupEntry{g}, in.FSGroups...)
+		}
+	}
+
+	if path := kv["path"]; path != "" {
+		// smbd follows a symlinked share path, so Stat, not Lstat
+		if fi, err := os.Stat(path); err == nil && fi.IsDir() {
+			d := &samba.AccessDir{Path: path, Mode: fi.Mode()}
+			if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+				d.UID, d.GID = int(st.Uid), int(st.Gid)
+			}
+			d.Owner, d.Group = ownerNames(acc, d.UID, d.GID)
+			if acl, err := samba.GetACL(path); err == nil {
+				d.ACL = acl
+			}
+			in.Dir = d
+		}
+	}
+	return samba.EvaluateAccess(in), nil
+}
+
+func hasGroupEntry(groups []samba.GroupEntry, name string) bool {
+	for _, g := range groups {
+		if g.Name == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/florianibach/samba-admin-ui/internal/samba"
+	"github.com/florianibach/samba-admin-ui/internal/samba/sambatest"
+)
+
+func TestShareAccess(t *testing.T) {
+	sys := sambatest.Install(t)
+	sys.AddGroup("family", 2000)
+	sys.AddUser("alice", 1000)
+	sys.Passdb["alice"] = &sambatest.SambaAccount{}
+
+	dir := t.TempDir()
+	a := newTestApp(t)
+	kv := map[string]string{"path": dir, "read only": "No", "valid users": "alice"}
+
+	// the fake getfacl reports root:root 0755, so alice is "other"
+	res, err := a.shareAccess("media", kv, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Level != samba.AccessRead || res.FS != samba.AccessRead {
+		t.Errorf("level = %s, steps %+v", res.Level, res.Steps)
+	}
+
+	// force group picks up a named group entry
+	sys.ACLs[dir] = []string{"group:family:rwx"}
+	kv["force group"] = "family"
+	res, err = a.shareAccess("media", kv, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Level != samba.AccessWrite {
+		t.Errorf("with force group: level = %s, steps %+v", res.Level, res.Steps)
+	}
+
+	if _, err := a.shareAccess("media", kv, "bob"); errStatus(err) != 404 {
+		t.Errorf("unknown user err = %v", err)
+	}
+	kv["force group"] = "nosuchgroup"
+	if _, err := a.shareAccess("media", kv, "alice"); errStatus(err) != 400 {
+		t.Errorf("bad force group err = %v", err)
+	}
+}
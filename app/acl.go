@@ -0,0 +1,238 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/florianibach/samba-admin-ui/internal/files"
+	"github.com/florianibach/samba-admin-ui/internal/samba"
+)
+
+// ShareACLForm is the POSIX ACL card on the share detail page.
+type ShareACLForm struct {
+	ACL   *samba.ACL
+	Error string // reading the ACL failed
+
+	// Editable is false for paths outside SHARE_ROOT; the ACL is still shown.
+	Editable    bool
+	LinuxUsers  []string
+	LinuxGroups []string
+
+	// submitted add form
+	Tag       string
+	Qualifier string
+	Perms     string
+	Default   bool
+	Recursive bool
+	FormError string
+}
+
+// Has reports whether the submitted permissions include c ("r", "w", "x").
+func (f *ShareACLForm) Has(c string) bool {
+	return strings.Contains(f.Perms, c)
+}
+
+// shareACLForm completes form (or a fresh one) with the ACL of abs and the
+// names that may be used in new entries.
+func (a *App) shareACLForm(abs string, form *ShareACLForm) *ShareACLForm {
+	if form == nil {
+		form = &ShareACLForm{Tag: "user", Perms: "rwx"}
+	}
+	if abs == "" {
+		form.Error = "share has no path"
+		return form
+	}
+
+	acl, err := samba.GetACL(abs)
+	if err != nil {
+		form.Error = err.Error()
+		return form
+	}
+	form.ACL = acl
+
+	if _, err := files.Rel(a.shareRoot, abs); err == nil {
+		form.Editable = true
+		form.LinuxUsers, form.LinuxGroups = linuxNames()
+	}
+	return form
+}
+
+// linuxNames returns the Linux users (UID >= 1000) and groups the app lists
+// elsewhere, as candidates for ACL entries.
+func linuxNames() (users, groups []string) {
+	if us, err := samba.ListLinuxUsersHuman(); err == nil {
+		for _, u := range us {
+			users = append(users, u.Name)
+		}
+	}
+	if gs, err := samba.ListLinuxGroups(); err == nil {
+		for _, g := range gs {
+			groups = append(groups, g.Name)
+		}
+	}
+	return users, groups
+}
+
+// aclEntryFrom validates a user supplied named entry.
+func aclEntryFrom(tag, qualifier, perms string, isDefault bool) (samba.ACLEntry, error) {
+	qualifier = strings.TrimPrefix(strings.TrimSpace(qualifier), "@")
+	if qualifier == "" {
+		return samba.ACLEntry{}, opErr(http.StatusBadRequest, "user or group name required")
+	}
+
+	users, groups := linuxNames()
+	switch tag {
+	case "user":
+		if !slices.Contains(users, qualifier) {
+			return samba.ACLEntry{}, opErr(http.StatusBadRequest, "unknown Linux user %s", qualifier)
+		}
+	case "group":
+		if !slices.Contains(groups, qualifier) {
+			return samba.ACLEntry{}, opErr(http.StatusBadRequest, "unknown Linux group %s", qualifier)
+		}
+	default:
+		return samba.ACLEntry{}, opErr(http.StatusBadRequest, "tag must be user or group")
+	}
+
+	p, err := samba.NormalizeACLPerms(perms)
+	if err != nil {
+		return samba.ACLEntry{}, opErr(http.StatusBadRequest, "%s", err)
+	}
+	return samba.ACLEntry{Default: isDefault, Tag: tag, Qualifier: qualifier, Perms: p}, nil
+}
+
+// shareACL reads the ACL of share name's directory, wherever it is.
+func (a *App) shareACL(name string) (*samba.ACL, error) {
+	sections, _, err := samba.ReadEffectiveConfig(a.smbConf)
+	if err != nil {
+		return nil, err
+	}
+	kv, ok := sections[name]
+	if !ok {
+		return nil, opErr(http.StatusNotFound, "share %s not found", name)
+	}
+	if kv["path"] == "" {
+		return nil, opErr(http.StatusBadRequest, "share %s has no path", name)
+	}
+	acl, err := samba.GetACL(kv["path"])
+	if err != nil {
+		return nil, opErr(http.StatusBadRequest, "%s", err)
+	}
+	return acl, nil
+}
+
+// shareACLPath returns the directory of share name if its ACL may be
+// changed, i.e. it lies inside SHARE_ROOT.
+func (a *App) shareACLPath(name string) (abs, rel string, err error) {
+	abs, rel, err = a.shareDirRel(name)
+	if err != nil {
+		return "", "", err
+	}
+	e, err := files.Stat(a.shareRoot, rel)
+	if err != nil {
+		return "", "", fileErr(err)
+	}
+	if !e.Mode.IsDir() {
+		return "", "", opErr(http.StatusBadRequest, "%s is not a directory", abs)
+	}
+	return abs, rel, nil
+}
+
+// setShareACL adds/replaces (remove=false) or removes a named ACL entry on
+// the directory of share name.
+func (a *App) setShareACL(name string, e samba.ACLEntry, recursive, remove bool) error {
+	if remove && !e.Named() {
+		return opErr(http.StatusBadRequest, "only named user and group entries can be removed")
+	}
+
+	path, rel, err := a.shareACLPath(name)
+	if err != nil {
+		return err
+	}
+	paths := []string{path}
+	if e.Default && recursive {
+		// only directories have default ACLs; setfacl -R would fail on
+		// every file
+		dirs, err := files.Dirs(a.shareRoot, rel)
+		if err != nil {
+			return fileErr(err)
+		}
+		paths = paths[:0]
+		for _, d := range dirs {
+			paths = append(paths, files.Abs(a.shareRoot, d))
+		}
+		recursive = false
+	}
+
+	if remove {
+		err = samba.RemoveACLEntry(e, recursive, paths...)
+	} else {
+		err = samba.SetACLEntry(e, recursive, paths...)
+	}
+	if err != nil {
+		return opErr(http.StatusBadRequest, "%s", err)
+	}
+	return nil
+}
+
+func aclTarget(name string, e samba.ACLEntry) string {
+	return fmt.Sprintf("%s %s", name, e.Spec(e.Perms != ""))
+}
+
+func (a *App) shareACLSet(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/shares", http.StatusSeeOther)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	name := strings.TrimSpace(r.FormValue("name"))
+	form := &ShareACLForm{
+		Tag:       r.FormValue("tag"),
+		Qualifier: strings.TrimSpace(r.FormValue("qualifier")),
+		Perms:     r.FormValue("r") + r.FormValue("w") + r.FormValue("x"),
+		Default:   r.FormValue("default") == "on",
+		Recursive: r.FormValue("recursive") == "on",
+	}
+
+	e, err := aclEntryFrom(form.Tag, form.Qualifier, form.Perms, form.Default)
+	if err == nil {
+		err = a.setShareACL(name, e, form.Recursive, false)
+	}
+	a.audit(actorOf(r), "share.acl.set", aclTarget(name, e), err)
+	if err != nil {
+		form.FormError = err.Error()
+		a.renderShareDetail(w, r, name, shareForms{ACL: form})
+		return
+	}
+	http.Redirect(w, r, "/shares/"+name+"#acl", http.StatusSeeOther)
+}
+
+func (a *App) shareACLRemove(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/shares", http.StatusSeeOther)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	name := strings.TrimSpace(r.FormValue("name"))
+	e := samba.ACLEntry{
+		Tag:       r.FormValue("tag"),
+		Qualifier: strings.TrimSpace(r.FormValue("qualifier")),
+		Default:   r.FormValue("default") == "1",
+	}
+
+	err := a.setShareACL(name, e, r.FormValue("recursive") == "on", true)
+	a.audit(actorOf(r), "share.acl.remove", aclTarget(name, e), err)
+	if err != nil {
+		a.renderShareDetail(w, r, name, shareForms{ACL: &ShareACLForm{Tag: "user", Perms: "rwx", FormError: err.Error()}})
+		return
+	}
+	http.Redirect(w, r, "/shares/"+name+"#acl", http.StatusSeeOther)
+}
@@ -0,0 +1,1035 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/florianibach/samba-admin-ui/internal/backup"
+	"github.com/florianibach/samba-admin-ui/internal/diff"
+	"github.com/florianibach/samba-admin-ui/internal/files"
+	"github.com/florianibach/samba-admin-ui/internal/reconcile"
+	"github.com/florianibach/samba-admin-ui/internal/samba"
+	"github.com/florianibach/samba-admin-ui/internal/state"
+)
+
+// The JSON API under /api/v1/ mirrors the HTML actions. It uses the same
+// operations (ops.go) and reports failures as
+//
+//	{"error": {"status": 400, "message": "..."}}
+
+const maxAPIBody = 1 << 20
+
+type apiErrorBody struct {
+	Error apiError `json:"error"`
+}
+
+type apiError struct {
+	Status  int    `json:"status"`
+	Message string `json:"message"`
+	// InUse lists who is connected when a share change was refused.
+	InUse *shareUse `json:"in_use,omitempty"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if v == nil {
+		return
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(v)
+}
+
+func writeAPIError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, apiErrorBody{Error: apiError{Status: status, Message: msg}})
+}
+
+func apiFail(w http.ResponseWriter, err error) {
+	var busy *shareBusyError
+	if errors.As(err, &busy) {
+		writeJSON(w, busy.status, apiErrorBody{Error: apiError{Status: busy.status, Message: busy.Error(), InUse: busy.Use}})
+		return
+	}
+	writeAPIError(w, errStatus(err), err.Error())
+}
+
+func decodeJSON(w http.ResponseWriter, r *http.Request, v any) error {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAPIBody))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		if errors.Is(err, io.EOF) {
+			return opErr(http.StatusBadRequest, "request body required")
+		}
+		return opErr(http.StatusBadRequest, "invalid JSON: %s", err)
+	}
+	return nil
+}
+
+func isAPIPath(p string) bool {
+	return strings.HasPrefix(p, "/api/")
+}
+
+func (a *App) apiHandler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /api/v1/shares", a.apiListShares)
+	mux.HandleFunc("POST /api/v1/shares", a.apiCreateShare)
+	mux.HandleFunc("GET /api/v1/shares/{name}", a.apiGetShare)
+	mux.HandleFunc("PUT /api/v1/shares/{name}", a.apiUpdateShare)
+	mux.HandleFunc("POST /api/v1/shares/{name}/enable", a.apiShareState(false))
+	mux.HandleFunc("POST /api/v1/shares/{name}/disable", a.apiShareState(true))
+	mux.HandleFunc("DELETE /api/v1/shares/{name}", a.apiDeleteShare)
+	mux.HandleFunc("POST /api/v1/shares/{name}/permissions", a.apiSharePermissions)
+	mux.HandleFunc("GET /api/v1/shares/{name}/permissions/{job}", a.apiPermissionsJob)
+	mux.HandleFunc("GET /api/v1/shares/{name}/acl", a.apiGetShareACL)
+	mux.HandleFunc("POST /api/v1/shares/{name}/acl", a.apiSetShareACL)
+	mux.HandleFunc("DELETE /api/v1/shares/{name}/acl/{tag}/{qualifier}", a.apiRemoveShareACL)
+	mux.HandleFunc("GET /api/v1/shares/{name}/access", a.apiShareAccess)
+	mux.HandleFunc("GET /api/v1/shares/{name}/import", a.apiShareImportPlan)
+	mux.HandleFunc("POST /api/v1/shares/{name}/import", a.apiShareImport)
+	mux.HandleFunc("GET /api/v1/shares/{name}/history", a.apiShareHistory)
+	mux.HandleFunc("GET /api/v1/shares/{name}/history/diff", a.apiShareDiff)
+	mux.HandleFunc("POST /api/v1/shares/{name}/history/{id}/rollback", a.apiShareRollback)
+
+	mux.HandleFunc("GET /api/v1/users", a.apiListUsers)
+	mux.HandleFunc("POST /api/v1/users", a.apiCreateUser)
+	mux.HandleFunc("PUT /api/v1/users/{name}/password", a.apiUserPassword)
+	mux.HandleFunc("POST /api/v1/users/{name}/enable", a.apiUserAction("user.enable", samba.EnableSambaUser))
+	mux.HandleFunc("POST /api/v1/users/{name}/disable", a.apiUserAction("user.disable", samba.DisableSambaUser))
+	mux.HandleFunc("DELETE /api/v1/users/{name}", a.apiUserAction("user.delete", samba.DeleteSambaUser))
+	mux.HandleFunc("GET /api/v1/users/{name}/groups", a.apiGetUserGroups)
+	mux.HandleFunc("PUT /api/v1/users/{name}/groups", a.apiSetUserGroups)
+
+	mux.HandleFunc("GET /api/v1/groups", a.apiListGroups)
+	mux.HandleFunc("POST /api/v1/groups", a.apiCreateGroup)
+	mux.HandleFunc("DELETE /api/v1/groups/{name}", a.apiDeleteGroup)
+
+	mux.HandleFunc("GET /api/v1/connections", a.apiConnections)
+	mux.HandleFunc("POST /api/v1/connections/{pid}/close", a.apiCloseConnection)
+	mux.HandleFunc("POST /api/v1/connections/{pid}/kill", a.apiKillSession)
+
+	mux.HandleFunc("GET /api/v1/logs", a.apiListLogs)
+	mux.HandleFunc("GET /api/v1/logs/{file}", a.apiReadLog)
+
+	mux.HandleFunc("GET /api/v1/files", a.apiListFiles)
+	mux.HandleFunc("POST /api/v1/files", a.apiMkdir)
+
+	mux.HandleFunc("GET /api/v1/drift", a.apiDrift)
+	mux.HandleFunc("POST /api/v1/drift/adopt", a.apiResolveDrift(true))
+	mux.HandleFunc("POST /api/v1/drift/fix", a.apiResolveDrift(false))
+
+	mux.HandleFunc("GET /api/v1/backup", a.apiBackup)
+	mux.HandleFunc("POST /api/v1/backup/plan", a.apiBackupPlan)
+	mux.HandleFunc("POST /api/v1/backup/restore", a.apiBackupRestore)
+	mux.HandleFunc("GET /api/v1/backup/snapshots", a.apiListSnapshots)
+	mux.HandleFunc("POST /api/v1/backup/snapshots", a.apiCreateSnapshot)
+	mux.HandleFunc("GET /api/v1/backup/snapshots/{name}", a.apiGetSnapshot)
+	mux.HandleFunc("POST /api/v1/backup/snapshots/{name}/restore", a.apiRestoreSnapshot)
+
+	mux.HandleFunc("GET /api/v1/reconcile/plan", a.apiReconcilePlan)
+	mux.HandleFunc("POST /api/v1/reconcile/apply", a.apiReconcileApply)
+
+	mux.HandleFunc("/api/", func(w http.ResponseWriter, r *http.Request) {
+		writeAPIError(w, http.StatusNotFound, "no such endpoint: "+r.Method+" "+r.URL.Path)
+	})
+
+	return mux
+}
+
+// --- shares ---
+
+type apiShareRequest struct {
+	Name       string `json:"name"`
+	Path       string `json:"path"`
+	ReadOnly   bool   `json:"read_only"`
+	Browseable *bool  `json:"browseable"`
+	ValidUsers string `json:"valid_users"`
+}
+
+func (req apiShareRequest) options() samba.CreateShareOptions {
+	browseable := true
+	if req.Browseable != nil {
+		browseable = *req.Browseable
+	}
+	return samba.CreateShareOptions{
+		Name:       strings.TrimSpace(req.Name),
+		Path:       strings.TrimSpace(req.Path),
+		ReadOnly:   req.ReadOnly,
+		Browseable: browseable,
+		ValidUsers: strings.TrimSpace(req.ValidUsers),
+	}
+}
+
+type apiShareDetail struct {
+	ShareInfo
+	Settings map[string]string `json:"settings"`
+	Managed  *apiShareRequest  `json:"managed_options,omitempty"`
+}
+
+func (a *App) apiListShares(w http.ResponseWriter, r *http.Request) {
+	rows, _, err := a.listShares()
+	if err != nil {
+		apiFail(w, err)
+		return
+	}
+	if rows == nil {
+		rows = []ShareInfo{}
+	}
+	writeJSON(w, http.StatusOK, rows)
+}
+
+func (a *App) apiGetShare(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+
+	rows, _, err := a.listShares()
+	if err != nil {
+		apiFail(w, err)
+		return
+	}
+	sections, _, err := samba.ReadEffectiveConfig(a.smbConf)
+	if err != nil {
+		apiFail(w, err)
+		return
+	}
+
+	for _, row := range rows {
+		if row.Name != name {
+			continue
+		}
+		detail := apiShareDetail{ShareInfo: row, Settings: sections[name]}
+		if row.Managed {
+			sharesDir, _ := shareDirs()
+			if opt, err := samba.ReadShareSnippet(sharesDir, name); err == nil {
+				browseable := opt.Browseable
+				detail.Managed = &apiShareRequest{
+					Name:       opt.Name,
+					Path:       opt.Path,
+					ReadOnly:   opt.ReadOnly,
+					Browseable: &browseable,
+					ValidUsers: opt.ValidUsers,
+				}
+			}
+		}
+		writeJSON(w, http.StatusOK, detail)
+		return
+	}
+	writeAPIError(w, http.StatusNotFound, "share not found in effective config")
+}
+
+func (a *App) apiCreateShare(w http.ResponseWriter, r *http.Request) {
+	var req apiShareRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		apiFail(w, err)
+		return
+	}
+	err := a.createShare(actorOf(r), req.options())
+	a.audit(actorOf(r), "share.create", req.options().Name, err)
+	if err != nil {
+		apiFail(w, err)
+		return
+	}
+	w.Header().Set("Location", "/api/v1/shares/"+req.options().Name)
+	writeJSON(w, http.StatusCreated, req.options())
+}
+
+func (a *App) apiUpdateShare(w http.ResponseWriter, r *http.Request) {
+	original := r.PathValue("name")
+	var req apiShareRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		apiFail(w, err)
+		return
+	}
+	if strings.TrimSpace(req.Name) == "" {
+		req.Name = original
+	}
+	opt := req.options()
+	err := a.updateShare(actorOf(r), original, opt)
+	a.audit(actorOf(r), "share.update", original, err)
+	if err != nil {
+		apiFail(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, opt)
+}
+
+func (a *App) apiShareState(disabled bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("name")
+		err := a.setShareState(actorOf(r), name, disabled, apiShareChange(r))
+		a.audit(actorOf(r), shareStateAction(disabled), name, err)
+		if err != nil {
+			apiFail(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// apiShareChange reads ?force=true&close=true, needed to disable or delete
+// a share that is in use.
+func apiShareChange(r *http.Request) shareChange {
+	q := r.URL.Query()
+	return shareChange{Force: q.Get("force") == "true", Close: q.Get("close") == "true"}
+}
+
+func (a *App) apiDeleteShare(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	err := a.deleteShare(actorOf(r), name, apiShareChange(r))
+	a.audit(actorOf(r), "share.delete", name, err)
+	if err != nil {
+		apiFail(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type apiShareImport struct {
+	Name            string            `json:"name"`
+	Source          string            `json:"source"`
+	RemoveLines     []apiImportedLine `json:"remove_lines"`
+	SnippetPath     string            `json:"snippet_path"`
+	Snippet         string            `json:"snippet"`
+	IndexPath       string            `json:"index_path"`
+	IndexBlock      string            `json:"index_block"`
+	Valid           bool              `json:"valid"`
+	ValidationError string            `json:"validation_error,omitempty"`
+}
+
+type apiImportedLine struct {
+	Line int    `json:"line"`
+	Text string `json:"text"`
+}
+
+func (a *App) apiShareImportPlan(w http.ResponseWriter, r *http.Request) {
+	imp, invalid, err := a.planShareImport(r.PathValue("name"))
+	if err != nil {
+		apiFail(w, err)
+		return
+	}
+	res := apiShareImport{
+		Name:        imp.Name,
+		Source:      imp.Source,
+		RemoveLines: []apiImportedLine{},
+		SnippetPath: imp.SnippetPath,
+		Snippet:     imp.Snippet,
+		IndexPath:   imp.IndexPath,
+		IndexBlock:  imp.IndexBlock,
+		Valid:       invalid == nil,
+	}
+	if invalid != nil {
+		res.ValidationError = invalid.Error()
+	}
+	for _, l := range imp.Remove {
+		res.RemoveLines = append(res.RemoveLines, apiImportedLine{Line: l.Num, Text: l.Raw})
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+func (a *App) apiShareImport(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	err := a.importShare(actorOf(r), name)
+	a.audit(actorOf(r), "share.import", name, err)
+	if err != nil {
+		apiFail(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *App) apiShareHistory(w http.ResponseWriter, r *http.Request) {
+	revs, err := a.store.ListShareRevisions(r.PathValue("name"))
+	if err != nil {
+		apiFail(w, err)
+		return
+	}
+	if revs == nil {
+		revs = []state.ShareRevision{}
+	}
+	writeJSON(w, http.StatusOK, revs)
+}
+
+// apiShareDiff returns the unified diff between the revisions ?from= and
+// ?to= of a share.
+func (a *App) apiShareDiff(w http.ResponseWriter, r *http.Request) {
+	from, err1 := strconv.ParseInt(r.URL.Query().Get("from"), 10, 64)
+	to, err2 := strconv.ParseInt(r.URL.Query().Get("to"), 10, 64)
+	if err1 != nil || err2 != nil {
+		writeAPIError(w, http.StatusBadRequest, "from and to must be revision ids")
+		return
+	}
+	lines, err := a.compareRevisions(r.PathValue("name"), from, to)
+	if err != nil {
+		apiFail(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"from": from, "to": to, "diff": diff.Format(lines)})
+}
+
+func (a *App) apiShareRollback(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid revision id")
+		return
+	}
+	err = a.rollbackShare(actorOf(r), name, id)
+	a.audit(actorOf(r), "share.rollback", fmt.Sprintf("%s@%d", name, id), err)
+	if err != nil {
+		apiFail(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type apiPermissionsRequest struct {
+	Preset    string `json:"preset"` // private, group or group-ro
+	User      string `json:"user"`
+	Group     string `json:"group"`
+	Recursive bool   `json:"recursive"`
+}
+
+type apiPermState struct {
+	UID  int    `json:"uid"`
+	GID  int    `json:"gid"`
+	Mode string `json:"mode"`
+}
+
+type apiPermJob struct {
+	ID       string        `json:"id"`
+	Share    string        `json:"share"`
+	Path     string        `json:"path"`
+	Preset   string        `json:"preset"`
+	Done     int           `json:"done"`
+	Total    int           `json:"total"`
+	Finished bool          `json:"finished"`
+	Changed  int           `json:"changed"`
+	Failed   int           `json:"failed"`
+	Error    string        `json:"error,omitempty"`
+	Before   apiPermState  `json:"before"`
+	After    *apiPermState `json:"after,omitempty"`
+}
+
+func toAPIPermJob(v permJobView) apiPermJob {
+	res := apiPermJob{
+		ID: v.ID, Share: v.Share, Path: v.Path, Preset: v.Preset.ID,
+		Done: v.Done, Total: v.Total, Finished: v.Finished,
+		Changed: v.Result.Changed, Failed: v.Result.Failed, Error: v.Error,
+		Before: apiPermState{UID: v.Before.UID, GID: v.Before.GID, Mode: v.Before.Octal()},
+	}
+	if v.After != nil {
+		res.After = &apiPermState{UID: v.After.UID, GID: v.After.GID, Mode: v.After.Octal()}
+	}
+	return res
+}
+
+// apiSharePermissions starts applying a preset; poll the returned job.
+func (a *App) apiSharePermissions(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	var req apiPermissionsRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		apiFail(w, err)
+		return
+	}
+	j, err := a.startPermissions(actorOf(r), name, req.Preset, req.User, req.Group, req.Recursive)
+	if err != nil {
+		a.audit(actorOf(r), "share.permissions", name, err)
+		apiFail(w, err)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, toAPIPermJob(j.view()))
+}
+
+func (a *App) apiPermissionsJob(w http.ResponseWriter, r *http.Request) {
+	j := a.permJobs.get(r.PathValue("job"))
+	if j == nil || j.Share != r.PathValue("name") {
+		writeAPIError(w, http.StatusNotFound, "job not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, toAPIPermJob(j.view()))
+}
+
+type apiACLRequest struct {
+	Tag       string `json:"tag"` // user or group
+	Qualifier string `json:"qualifier"`
+	Perms     string `json:"perms"` // e.g. "rwx", "r-x" or "rx"
+	Default   bool   `json:"default"`
+	Recursive bool   `json:"recursive"`
+}
+
+func (a *App) apiGetShareACL(w http.ResponseWriter, r *http.Request) {
+	acl, err := a.shareACL(r.PathValue("name"))
+	if err != nil {
+		apiFail(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, acl)
+}
+
+func (a *App) apiSetShareACL(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	var req apiACLRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		apiFail(w, err)
+		return
+	}
+	e, err := aclEntryFrom(req.Tag, req.Qualifier, req.Perms, req.Default)
+	if err == nil {
+		err = a.setShareACL(name, e, req.Recursive, false)
+	}
+	a.audit(actorOf(r), "share.acl.set", aclTarget(name, e), err)
+	if err != nil {
+		apiFail(w, err)
+		return
+	}
+	a.apiGetShareACL(w, r)
+}
+
+// apiRemoveShareACL removes a named entry; ?default=true targets the default
+// ACL, ?recursive=true also removes it below the share directory.
+func (a *App) apiRemoveShareACL(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	q := r.URL.Query()
+	e := samba.ACLEntry{Tag: r.PathValue("tag"), Qualifier: r.PathValue("qualifier"), Default: q.Get("default") == "true"}
+
+	err := a.setShareACL(name, e, q.Get("recursive") == "true", true)
+	a.audit(actorOf(r), "share.acl.remove", aclTarget(name, e), err)
+	if err != nil {
+		apiFail(w, err)
+		return
+	}
+	a.apiGetShareACL(w, r)
+}
+
+// apiShareAccess explains the access of ?user= to the share.
+func (a *App) apiShareAccess(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	user := r.URL.Query().Get("user")
+	if user == "" {
+		writeAPIError(w, http.StatusBadRequest, "user required")
+		return
+	}
+	sections, _, err := samba.ReadEffectiveConfig(a.smbConf)
+	if err != nil {
+		apiFail(w, err)
+		return
+	}
+	kv, ok := sections[name]
+	if !ok {
+		writeAPIError(w, http.StatusNotFound, "share not found")
+		return
+	}
+	res, err := a.shareAccess(name, kv, user)
+	if err != nil {
+		apiFail(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+// --- connections ---
+
+func (a *App) apiConnections(w http.ResponseWriter, r *http.Request) {
+	st, err := samba.GetSmbStatus()
+	if err != nil {
+		apiFail(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, st)
+}
+
+type apiCloseRequest struct {
+	Share string `json:"share"`
+}
+
+func (a *App) apiCloseConnection(w http.ResponseWriter, r *http.Request) {
+	pid := r.PathValue("pid")
+	var req apiCloseRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		apiFail(w, err)
+		return
+	}
+	target, err := a.closeConnection(pid, req.Share)
+	if target == "" {
+		target = "pid " + pid + " " + req.Share
+	}
+	a.audit(actorOf(r), "connection.close", target, err)
+	if err != nil {
+		apiFail(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *App) apiKillSession(w http.ResponseWriter, r *http.Request) {
+	pid := r.PathValue("pid")
+	target, err := a.killSession(pid)
+	if target == "" {
+		target = "pid " + pid
+	}
+	a.audit(actorOf(r), "connection.kill", target, err)
+	if err != nil {
+		apiFail(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// --- files ---
+
+type apiDirEntry struct {
+	Name    string `json:"name"`
+	Path    string `json:"path"` // relative to SHARE_ROOT
+	Abs     string `json:"abs"`
+	UID     int    `json:"uid"`
+	GID     int    `json:"gid"`
+	Owner   string `json:"owner"`
+	Group   string `json:"group"`
+	Mode    string `json:"mode"`
+	Symlink bool   `json:"symlink"`
+}
+
+type apiDirListing struct {
+	apiDirEntry
+	Dirs    []apiDirEntry `json:"dirs"`
+	Files   int           `json:"files"`
+	Skipped int           `json:"skipped"`
+}
+
+func toAPIDir(r fileRow) apiDirEntry {
+	return apiDirEntry{
+		Name: r.Name, Path: r.Rel, Abs: r.Abs,
+		UID: r.UID, GID: r.GID, Owner: r.Owner, Group: r.Group,
+		Mode: r.Octal(), Symlink: r.Symlink,
+	}
+}
+
+func (a *App) apiListLogs(w http.ResponseWriter, r *http.Request) {
+	files, err := samba.ListLogFiles(a.logDir)
+	if err != nil {
+		apiFail(w, fmt.Errorf("reading log directory: %w", err))
+		return
+	}
+	writeJSON(w, http.StatusOK, files)
+}
+
+// apiReadLog returns the last entries of one log file ("all" merges the
+// current ones), filtered like the /logs page: ?level=&q=&limit=.
+func (a *App) apiReadLog(w http.ResponseWriter, r *http.Request) {
+	_, f := logsQuery(r)
+	file := r.PathValue("file")
+	if file == "all" {
+		file = ""
+	}
+	limit := logLimit
+	if v, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && v > 0 {
+		limit = min(v, 5000)
+	}
+	entries, _, err := a.logEntries(file, f, limit)
+	if err != nil {
+		apiFail(w, err)
+		return
+	}
+	if entries == nil {
+		entries = []logEntry{}
+	}
+	writeJSON(w, http.StatusOK, entries)
+}
+
+func (a *App) apiListFiles(w http.ResponseWriter, r *http.Request) {
+	dir, rows, l, err := a.listDir(files.Clean(r.URL.Query().Get("path")))
+	if err != nil {
+		apiFail(w, err)
+		return
+	}
+	res := apiDirListing{apiDirEntry: toAPIDir(dir), Dirs: []apiDirEntry{}, Files: l.Files, Skipped: l.Skipped}
+	for _, row := range rows {
+		res.Dirs = append(res.Dirs, toAPIDir(row))
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+type apiMkdirRequest struct {
+	Path string `json:"path"` // parent, relative to SHARE_ROOT
+	Name string `json:"name"`
+}
+
+func (a *App) apiMkdir(w http.ResponseWriter, r *http.Request) {
+	var req apiMkdirRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		apiFail(w, err)
+		return
+	}
+	rel := files.Clean(req.Path)
+	child, err := a.makeDir(rel, req.Name)
+	a.audit(actorOf(r), "files.mkdir", path.Join(a.shareRoot, rel, req.Name), err)
+	if err != nil {
+		apiFail(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, map[string]string{"path": child, "abs": files.Abs(a.shareRoot, child)})
+}
+
+// --- users ---
+
+type apiUserRequest struct {
+	Name     string `json:"name"`
+	Password string `json:"password"`
+	UID      *int   `json:"uid"`
+	GID      *int   `json:"gid"`
+}
+
+func (a *App) apiListUsers(w http.ResponseWriter, r *http.Request) {
+	rows, err := a.listUsers()
+	if err != nil {
+		apiFail(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, rows)
+}
+
+func (a *App) apiCreateUser(w http.ResponseWriter, r *http.Request) {
+	var req apiUserRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		apiFail(w, err)
+		return
+	}
+	name := strings.TrimSpace(req.Name)
+	err := a.createUser(actorOf(r), name, req.Password, req.UID, req.GID)
+	a.audit(actorOf(r), "user.create", name, err)
+	if err != nil {
+		apiFail(w, err)
+		return
+	}
+	w.Header().Set("Location", "/api/v1/users/"+name)
+	writeJSON(w, http.StatusCreated, UserInfo{
+		SambaUser:   samba.SambaUser{Name: name},
+		LinuxExists: samba.LinuxUserExists(name),
+	})
+}
+
+func (a *App) apiUserPassword(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Password string `json:"password"`
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		apiFail(w, err)
+		return
+	}
+	name := r.PathValue("name")
+	if err := requireSambaUser(name); err != nil {
+		apiFail(w, err)
+		return
+	}
+	err := a.setUserPassword(name, req.Password)
+	a.audit(actorOf(r), "user.password", name, err)
+	if err != nil {
+		apiFail(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *App) apiUserAction(action string, fn func(string) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("name")
+		if name == "" {
+			writeAPIError(w, http.StatusBadRequest, "name required")
+			return
+		}
+		if err := requireSambaUser(name); err != nil {
+			apiFail(w, err)
+			return
+		}
+		err := fn(name)
+		a.audit(actorOf(r), action, name, err)
+		if err != nil {
+			apiFail(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+type apiUserGroups struct {
+	User   string   `json:"user"`
+	Groups []string `json:"groups"`
+}
+
+func (a *App) apiGetUserGroups(w http.ResponseWriter, r *http.Request) {
+	user := r.PathValue("name")
+	if err := a.requireUser(user); err != nil {
+		apiFail(w, err)
+		return
+	}
+	groups, err := a.store.ListUserGroups(user)
+	if err != nil {
+		apiFail(w, err)
+		return
+	}
+	if groups == nil {
+		groups = []string{}
+	}
+	writeJSON(w, http.StatusOK, apiUserGroups{User: user, Groups: groups})
+}
+
+func (a *App) apiSetUserGroups(w http.ResponseWriter, r *http.Request) {
+	user := r.PathValue("name")
+	var req struct {
+		Groups []string `json:"groups"`
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		apiFail(w, err)
+		return
+	}
+	if req.Groups == nil {
+		req.Groups = []string{}
+	}
+	if err := a.requireUser(user); err != nil {
+		apiFail(w, err)
+		return
+	}
+	err := a.saveUserGroups(user, req.Groups)
+	a.audit(actorOf(r), "user.groups", user, err)
+	if err != nil {
+		apiFail(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, apiUserGroups{User: user, Groups: req.Groups})
+}
+
+// --- groups ---
+
+func (a *App) apiListGroups(w http.ResponseWriter, r *http.Request) {
+	rows, err := a.listGroups()
+	if err != nil {
+		apiFail(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, rows)
+}
+
+func (a *App) apiCreateGroup(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name string `json:"name"`
+		GID  *int   `json:"gid"`
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		apiFail(w, err)
+		return
+	}
+	name := strings.TrimSpace(req.Name)
+	err := a.createGroup(actorOf(r), name, req.GID)
+	a.audit(actorOf(r), "group.create", name, err)
+	if err != nil {
+		apiFail(w, err)
+		return
+	}
+	w.Header().Set("Location", "/api/v1/groups/"+name)
+	writeJSON(w, http.StatusCreated, struct {
+		Name string `json:"name"`
+		GID  *int   `json:"gid,omitempty"`
+	}{Name: name, GID: req.GID})
+}
+
+func (a *App) apiDeleteGroup(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	found, err := a.deleteGroup(name)
+	if found || err != nil {
+		a.audit(actorOf(r), "group.delete", name, err)
+	}
+	if err != nil {
+		apiFail(w, err)
+		return
+	}
+	if !found {
+		writeAPIError(w, http.StatusNotFound, "group is not managed by UI")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// --- reconcile ---
+
+type apiReconcileResult struct {
+	DryRun  bool     `json:"dry_run"`
+	Actions []string `json:"actions"`
+}
+
+func (a *App) apiReconcilePlan(w http.ResponseWriter, r *http.Request) {
+	res, err := reconcile.Plan(a.store)
+	if err != nil {
+		apiFail(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, apiReconcileResult{DryRun: true, Actions: nonNil(res.Actions)})
+}
+
+func (a *App) apiReconcileApply(w http.ResponseWriter, r *http.Request) {
+	res, err := a.applyReconcile(actorOf(r))
+	if err != nil {
+		apiFail(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, apiReconcileResult{Actions: nonNil(res.Actions)})
+}
+
+func nonNil(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
+
+// --- drift ---
+
+type apiDriftRequest struct {
+	Kind  string `json:"kind"`
+	Name  string `json:"name"`
+	Group string `json:"group"`
+}
+
+func (a *App) apiDrift(w http.ResponseWriter, r *http.Request) {
+	rep, err := reconcile.DetectDrift(a.store)
+	if err != nil {
+		apiFail(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, rep)
+}
+
+func (a *App) apiResolveDrift(adopt bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req apiDriftRequest
+		if err := decodeJSON(w, r, &req); err != nil {
+			apiFail(w, err)
+			return
+		}
+		if req.Kind == "" || req.Name == "" {
+			apiFail(w, opErr(http.StatusBadRequest, "kind and name required"))
+			return
+		}
+		if err := a.resolveDrift(actorOf(r), adopt, req.Kind, req.Name, req.Group); err != nil {
+			apiFail(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// apiBackup returns a backup bundle. It contains password hashes, so
+// read-only tokens may not fetch it.
+func (a *App) apiBackup(w http.ResponseWriter, r *http.Request) {
+	if currentPrincipal(r).ReadOnly {
+		writeAPIError(w, http.StatusForbidden, "backups contain password hashes; a read-only token cannot download them")
+		return
+	}
+	b, err := a.backupBundle()
+	a.audit(actorOf(r), "backup.create", "download", err)
+	if err != nil {
+		apiFail(w, err)
+		return
+	}
+	writeBundle(w, b)
+}
+
+// apiReadBundle reads a bundle sent as the request body.
+func apiReadBundle(w http.ResponseWriter, r *http.Request) (*backup.Bundle, error) {
+	b, err := backup.Read(http.MaxBytesReader(w, r.Body, maxBackupSize), maxBackupSize)
+	if err != nil {
+		return nil, opErr(http.StatusBadRequest, "%s", err)
+	}
+	return b, nil
+}
+
+func (a *App) apiBackupPlan(w http.ResponseWriter, r *http.Request) {
+	b, err := apiReadBundle(w, r)
+	if err != nil {
+		apiFail(w, err)
+		return
+	}
+	plan, err := a.planRestore(b, currentUser(r))
+	if err != nil {
+		apiFail(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, plan)
+}
+
+func (a *App) apiBackupRestore(w http.ResponseWriter, r *http.Request) {
+	b, err := apiReadBundle(w, r)
+	if err != nil {
+		apiFail(w, err)
+		return
+	}
+	res, err := a.restoreBackup(actorOf(r), b)
+	a.audit(actorOf(r), "backup.restore", bundleTarget(b), err)
+	if err != nil {
+		apiFail(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+func (a *App) apiListSnapshots(w http.ResponseWriter, r *http.Request) {
+	v := a.snapshotsView()
+	if v.Error != "" {
+		writeAPIError(w, http.StatusInternalServerError, v.Error)
+		return
+	}
+	if v.Snapshots == nil {
+		v.Snapshots = []backup.Snapshot{}
+	}
+	writeJSON(w, http.StatusOK, v.Snapshots)
+}
+
+func (a *App) apiCreateSnapshot(w http.ResponseWriter, r *http.Request) {
+	snap, err := a.takeSnapshot("manual", true)
+	target := "manual"
+	if snap != nil {
+		target = snap.Name
+	}
+	a.audit(actorOf(r), "backup.snapshot", target, err)
+	if err != nil {
+		apiFail(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, snap)
+}
+
+// apiGetSnapshot returns a snapshot bundle; like apiBackup not to
+// read-only tokens.
+func (a *App) apiGetSnapshot(w http.ResponseWriter, r *http.Request) {
+	if currentPrincipal(r).ReadOnly {
+		writeAPIError(w, http.StatusForbidden, "backups contain password hashes; a read-only token cannot download them")
+		return
+	}
+	b, err := a.openSnapshot(r.PathValue("name"))
+	if err != nil {
+		apiFail(w, err)
+		return
+	}
+	writeBundle(w, b)
+}
+
+func (a *App) apiRestoreSnapshot(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	b, err := a.openSnapshot(name)
+	if err != nil {
+		apiFail(w, err)
+		return
+	}
+	res, err := a.restoreBackup(actorOf(r), b)
+	a.audit(actorOf(r), "backup.restore", "snapshot "+name, err)
+	if err != nil {
+		apiFail(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, res)
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/florianibach/samba-admin-ui/internal/auth"
+	"github.com/florianibach/samba-admin-ui/internal/policy"
+	"github.com/florianibach/samba-admin-ui/internal/samba"
+	"github.com/florianibach/samba-admin-ui/internal/samba/sambatest"
+)
+
+// apiClient sends requests through the full handler chain with a bearer
+// token.
+type apiClient struct {
+	t     *testing.T
+	h     http.Handler
+	token string
+}
+
+func newAPIClient(t *testing.T, a *App, readOnly bool) *apiClient {
+	t.Helper()
+	base, err := parseBase()
+	must(t, err)
+	a.base = base
+	hash, err := auth.HashPassword("S3cure!pass")
+	must(t, err)
+	must(t, a.store.CreateAdmin("admin", hash))
+	token := "sau_rw"
+	if readOnly {
+		token = "sau_ro"
+	}
+	must(t, a.store.CreateAPIToken(token, auth.TokenHash(token), readOnly, "admin"))
+	return &apiClient{t: t, h: a.routes(), token: token}
+}
+
+func (c *apiClient) do(method, path, body string) *httptest.ResponseRecorder {
+	var r *http.Request
+	if body != "" {
+		r = httptest.NewRequest(method, path, strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+	} else {
+		r = httptest.NewRequest(method, path, nil)
+	}
+	r.Header.Set("Authorization", "Bearer "+c.token)
+	w := httptest.NewRecorder()
+	c.h.ServeHTTP(w, r)
+	return w
+}
+
+// apiErr decodes the error body of w, failing if it is not one.
+func apiErr(t *testing.T, w *httptest.ResponseRecorder) apiError {
+	t.Helper()
+	var body apiErrorBody
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || body.Error.Status != w.Code {
+		t.Fatalf("error body %q (%v), status %d", w.Body, err, w.Code)
+	}
+	return body.Error
+}
+
+func TestAPIShares(t *testing.T) {
+	sys := sambatest.Install(t)
+	a := newTestApp(t)
+	withShareDirs(t, a)
+	c := newAPIClient(t, a, false)
+
+	w := c.do("POST", "/api/v1/shares", `{"name": "media", "path": "/shares/media", "read_only": true}`)
+	if w.Code != http.StatusCreated || w.Header().Get("Location") != "/api/v1/shares/media" {
+		t.Fatalf("create: %d %s", w.Code, w.Body)
+	}
+	managed := func(name string) bool {
+		t.Helper()
+		_, indexPath := shareDirs()
+		m, err := samba.ReadManagedSharesIndex(indexPath)
+		must(t, err)
+		_, ok := m[name]
+		return ok
+	}
+	if !managed("media") {
+		t.Fatal("media not in the index")
+	}
+	if w := c.do("POST", "/api/v1/shares", `{"name": "media", "path": "/shares/media"}`); w.Code != http.StatusConflict {
+		t.Errorf("duplicate create: %d", w.Code)
+	} else {
+		apiErr(t, w)
+	}
+
+	for _, tc := range []struct {
+		name, body, msg string
+	}{
+		{"no body", "", "request body required"},
+		{"broken JSON", `{"name": "x",`, "invalid JSON"},
+		{"unknown field", `{"name": "x", "path": "/shares/x", "guest_ok": true}`, `unknown field "guest_ok"`},
+		{"wrong type", `{"name": "x", "path": "/shares/x", "read_only": "yes"}`, "invalid JSON"},
+		{"relative path", `{"name": "x", "path": "shares/x"}`, "absolute path"},
+		{"injected parameter", `{"name": "x", "path": "/shares/x\nroot preexec = /bin/sh"}`, "control characters"},
+	} {
+		w := c.do("POST", "/api/v1/shares", tc.body)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: %d %s", tc.name, w.Code, w.Body)
+			continue
+		}
+		if e := apiErr(t, w); !strings.Contains(e.Message, tc.msg) {
+			t.Errorf("%s: message %q, want %q", tc.name, e.Message, tc.msg)
+		}
+	}
+
+	for _, rq := range [][2]string{
+		{"GET", "/api/v1/shares/ghost"},
+		{"DELETE", "/api/v1/shares/ghost"},
+		{"POST", "/api/v1/shares/ghost/disable"},
+		{"POST", "/api/v1/shares/ghost/enable"},
+	} {
+		if w := c.do(rq[0], rq[1], ""); w.Code != http.StatusNotFound {
+			t.Errorf("%s %s: %d %s", rq[0], rq[1], w.Code, w.Body)
+		} else {
+			apiErr(t, w)
+		}
+	}
+	if w := c.do("PUT", "/api/v1/shares/ghost", `{"path": "/shares/ghost"}`); w.Code != http.StatusNotFound {
+		t.Errorf("update ghost: %d", w.Code)
+	}
+
+	// a share in use is only deleted when forced
+	sys.SmbStatus = testSmbstatus
+	w = c.do("DELETE", "/api/v1/shares/media", "")
+	if w.Code != http.StatusConflict {
+		t.Fatalf("delete busy share: %d %s", w.Code, w.Body)
+	}
+	if e := apiErr(t, w); e.InUse == nil || len(e.InUse.Tcons) != 1 || e.InUse.Tcons[0].User != "alice" {
+		t.Errorf("in_use = %+v", e.InUse)
+	}
+	if !managed("media") {
+		t.Error("share gone after refused delete")
+	}
+	if w := c.do("DELETE", "/api/v1/shares/media?force=true", ""); w.Code != http.StatusNoContent {
+		t.Errorf("forced delete: %d %s", w.Code, w.Body)
+	}
+	if managed("media") {
+		t.Error("share still in the index after delete")
+	}
+}
+
+func TestAPIUsersAndGroups(t *testing.T) {
+	sys := sambatest.Install(t)
+	a := newTestApp(t)
+	a.pwPolicy = policy.Default
+	c := newAPIClient(t, a, false)
+
+	w := c.do("POST", "/api/v1/users", `{"name": "bob", "password": "S3cure!pass"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create user: %d %s", w.Code, w.Body)
+	}
+	if _, ok := sys.Passdb["bob"]; !ok {
+		t.Fatal("no Samba account for bob")
+	}
+	if w := c.do("POST", "/api/v1/users", `{"name": "carol", "pasword": "S3cure!pass"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("misspelt field: %d", w.Code)
+	} else if e := apiErr(t, w); !strings.Contains(e.Message, `unknown field "pasword"`) {
+		t.Errorf("misspelt field: %q", e.Message)
+	}
+	if w := c.do("POST", "/api/v1/users", `{"name": "carol", "password": "short"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("weak password: %d", w.Code)
+	} else {
+		apiErr(t, w)
+	}
+	if w := c.do("PUT", "/api/v1/users/bob/groups", `{"groups": ["media"], "primary": "bob"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("unknown field in groups: %d", w.Code)
+	}
+	if w := c.do("POST", "/api/v1/users/bob/disable", ""); w.Code != http.StatusNoContent || !sys.Passdb["bob"].Disabled {
+		t.Errorf("disable: %d %s", w.Code, w.Body)
+	}
+
+	for _, rq := range [][3]string{
+		{"POST", "/api/v1/users/ghost/enable", ""},
+		{"POST", "/api/v1/users/ghost/disable", ""},
+		{"DELETE", "/api/v1/users/ghost", ""},
+		{"PUT", "/api/v1/users/ghost/password", `{"password": "S3cure!pass"}`},
+		{"GET", "/api/v1/users/ghost/groups", ""},
+		{"PUT", "/api/v1/users/ghost/groups", `{"groups": []}`},
+		{"DELETE", "/api/v1/groups/ghost", ""},
+	} {
+		if w := c.do(rq[0], rq[1], rq[2]); w.Code != http.StatusNotFound {
+			t.Errorf("%s %s: %d %s", rq[0], rq[1], w.Code, w.Body)
+		} else {
+			apiErr(t, w)
+		}
+	}
+	if groups, _ := a.store.ListUserGroups("ghost"); len(groups) != 0 {
+		t.Errorf("groups stored for unknown user: %q", groups)
+	}
+
+	if w := c.do("GET", "/api/v1/nothing", ""); w.Code != http.StatusNotFound {
+		t.Errorf("unknown endpoint: %d", w.Code)
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"html/template"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/florianibach/samba-admin-ui/internal/reconcile"
+	"github.com/florianibach/samba-admin-ui/internal/state"
+)
+
+// actor identifies who triggered an action, for the audit log.
+type actor struct {
+	Name string
+	IP   string
+}
+
+var systemActor = actor{Name: "system"}
+
+func actorOf(r *http.Request) actor {
+	ip := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		ip = host
+	}
+	name := currentUser(r)
+	if name == "" {
+		name = "anonymous"
+	}
+	return actor{Name: name, IP: ip}
+}
+
+// audit records an administrative action with its outcome. Failing to write
+// the audit log is logged but never fails the action itself.
+func (a *App) audit(act actor, action, target string, err error) {
+	e := state.AuditEntry{
+		At:       time.Now(),
+		Actor:    act.Name,
+		ClientIP: act.IP,
+		Action:   action,
+		Target:   target,
+		Outcome:  state.OutcomeSuccess,
+	}
+	if err != nil {
+		e.Outcome = state.OutcomeError
+		e.Error = err.Error()
+	}
+	if werr := a.store.AddAudit(e); werr != nil {
+		log.Printf("audit log write failed (%s %s): %v", action, target, werr)
+	}
+}
+
+// reconcile runs reconcile.Apply and records every action it carried out.
+func (a *App) reconcile(act actor) error {
+	_, err := a.applyReconcile(act)
+	return err
+}
+
+// applyReconcile runs reconcile.Apply and records every executed action.
+func (a *App) applyReconcile(act actor) (*reconcile.Result, error) {
+	res, err := reconcile.Apply(a.store)
+	if res != nil {
+		for _, action := range res.Actions {
+			a.audit(act, "reconcile.apply", action, nil)
+		}
+	}
+	if err != nil {
+		a.audit(act, "reconcile.apply", "", err)
+	}
+	return res, err
+}
+
+func shareStateAction(disabled bool) string {
+	if disabled {
+		return "share.disable"
+	}
+	return "share.enable"
+}
+
+const auditPageLimit = 500
+
+// auditDate is the format of the from/to dates of the audit filter; both
+// days are included.
+const auditDate = "2006-01-02"
+
+func auditFilterFrom(r *http.Request) state.AuditFilter {
+	q := r.URL.Query()
+	f := state.AuditFilter{
+		Action: strings.TrimSpace(q.Get("action")),
+		Target: strings.TrimSpace(q.Get("target")),
+		Actor:  strings.TrimSpace(q.Get("actor")),
+	}
+	if d, err := time.ParseInLocation(auditDate, q.Get("from"), time.Local); err == nil {
+		f.Since = d
+	}
+	if d, err := time.ParseInLocation(auditDate, q.Get("to"), time.Local); err == nil {
+		f.Until = d.AddDate(0, 0, 1)
+	}
+	return f
+}
+
+// auditQuery encodes f for the export links of the audit page.
+func auditQuery(f state.AuditFilter) string {
+	q := url.Values{}
+	for k, v := range map[string]string{"action": f.Action, "target": f.Target, "actor": f.Actor} {
+		if v != "" {
+			q.Set(k, v)
+		}
+	}
+	if !f.Since.IsZero() {
+		q.Set("from", f.Since.Format(auditDate))
+	}
+	if !f.Until.IsZero() {
+		q.Set("to", f.Until.AddDate(0, 0, -1).Format(auditDate))
+	}
+	return q.Encode()
+}
+
+// csvCell keeps spreadsheets from evaluating a cell as a formula.
+func csvCell(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+func (a *App) auditLog(w http.ResponseWriter, r *http.Request) {
+	type vm struct {
+		Error       string
+		Filter      state.AuditFilter
+		From, To    string
+		Query       template.URL // the filter, for the export links
+		ActionTypes []string
+		Entries     []state.AuditEntry
+		Limit       int
+	}
+
+	f := auditFilterFrom(r)
+	data := vm{Filter: f, Query: template.URL(auditQuery(f)), Limit: auditPageLimit}
+	if !f.Since.IsZero() {
+		data.From = f.Since.Format(auditDate)
+	}
+	if !f.Until.IsZero() {
+		data.To = f.Until.AddDate(0, 0, -1).Format(auditDate)
+	}
+
+	types, err := a.store.ListAuditActionTypes()
+	if err != nil {
+		data.Error = err.Error()
+	}
+	data.ActionTypes = types
+
+	f.Limit = auditPageLimit
+	entries, err := a.store.ListAudit(f)
+	if err != nil {
+		data.Error = err.Error()
+	}
+	data.Entries = entries
+
+	a.render(w, r, "audit.html", "Audit log", data)
+}
+
+// auditExport streams all entries matching the page's filters as CSV or JSON.
+func (a *App) auditExport(w http.ResponseWriter, r *http.Request) {
+	entries, err := a.store.ListAudit(auditFilterFrom(r))
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	stamp := time.Now().UTC().Format("20060102-150405")
+	switch r.URL.Query().Get("format") {
+	case "json":
+		if entries == nil {
+			entries = []state.AuditEntry{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", `attachment; filename="audit-`+stamp+`.json"`)
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(entries)
+	default:
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="audit-`+stamp+`.csv"`)
+		cw := csv.NewWriter(w)
+		_ = cw.Write([]string{"id", "at", "actor", "client_ip", "action", "target", "outcome", "error"})
+		for _, e := range entries {
+			_ = cw.Write([]string{
+				strconv.FormatInt(e.ID, 10),
+				e.At.UTC().Format(time.RFC3339),
+				csvCell(e.Actor), csvCell(e.ClientIP), csvCell(e.Action), csvCell(e.Target), csvCell(e.Outcome), csvCell(e.Error),
+			})
+		}
+		cw.Flush()
+	}
+}
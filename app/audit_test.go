@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/florianibach/samba-admin-ui/internal/state"
+)
+
+func TestAuditExport(t *testing.T) {
+	a := newTestApp(t)
+	a.audit(actor{Name: "admin", IP: "10.0.0.1"}, "share.create", "media", nil)
+	a.audit(actor{Name: "admin", IP: "10.0.0.1"}, "user.create", "=HYPERLINK(\"http://evil\")", errors.New("-2+3"))
+	a.audit(actor{Name: "@bob", IP: "10.0.0.2"}, "share.delete", "+media", nil)
+
+	w := httptest.NewRecorder()
+	a.auditExport(w, httptest.NewRequest("GET", "/audit/export?format=csv", nil))
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/csv") {
+		t.Errorf("content type %q", ct)
+	}
+	rows, err := csv.NewReader(w.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 4 || strings.Join(rows[0], ",") != "id,at,actor,client_ip,action,target,outcome,error" {
+		t.Fatalf("rows = %q", rows)
+	}
+	// newest first; cells that look like formulas are quoted
+	if got := rows[1][2:]; strings.Join(got, "|") != "'@bob|10.0.0.2|share.delete|'+media|success|" {
+		t.Errorf("row 1 = %q", got)
+	}
+	if got := rows[2][5:]; strings.Join(got, "|") != `'=HYPERLINK("http://evil")|error|'-2+3` {
+		t.Errorf("row 2 = %q", got)
+	}
+
+	w = httptest.NewRecorder()
+	a.auditExport(w, httptest.NewRequest("GET", "/audit/export?format=json&action=share&actor=admin", nil))
+	var entries []state.AuditEntry
+	if err := json.Unmarshal(w.Body.Bytes(), &entries); err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Target != "media" || entries[0].ClientIP != "10.0.0.1" {
+		t.Errorf("entries = %+v", entries)
+	}
+
+	// the page's filter survives into the export links
+	f := auditFilterFrom(httptest.NewRequest("GET", "/audit?action=share&actor=admin&from=2026-10-01&to=2026-10-17", nil))
+	if got := auditQuery(f); got != "action=share&actor=admin&from=2026-10-01&to=2026-10-17" {
+		t.Errorf("query = %q", got)
+	}
+	w = httptest.NewRecorder()
+	a.auditExport(w, httptest.NewRequest("GET", "/audit/export?format=json&to=2000-01-01", nil))
+	if strings.TrimSpace(w.Body.String()) != "[]" {
+		t.Errorf("entries before 2000 = %s", w.Body)
+	}
+
+	base, err := parseBase()
+	must(t, err)
+	a.base = base
+	w = httptest.NewRecorder()
+	a.auditLog(w, httptest.NewRequest("GET", "/audit?action=share&actor=admin&from=2026-10-01&to=2026-10-17", nil))
+	if !strings.Contains(w.Body.String(), `href="/audit/export?format=csv&action=share&amp;actor=admin&amp;from=2026-10-01&amp;to=2026-10-17"`) {
+		t.Errorf("audit page export link missing:\n%s", w.Body)
+	}
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/florianibach/samba-admin-ui/internal/auth"
+)
+
+const sessionCookie = "samba_admin_session"
+
+type principalCtxKey struct{}
+
+// principal is who is making a request: a logged-in admin or an API token.
+type principal struct {
+	Name     string // admin name, or "token:<name>" for API tokens
+	Token    bool
+	ReadOnly bool
+}
+
+func currentPrincipal(r *http.Request) principal {
+	if r == nil {
+		return principal{}
+	}
+	p, _ := r.Context().Value(principalCtxKey{}).(principal)
+	return p
+}
+
+// currentUser returns the name attached to r by withAuth ("" if none).
+func currentUser(r *http.Request) string {
+	return currentPrincipal(r).Name
+}
+
+func isPublicPath(p string) bool {
+	return p == "/login" || p == "/setup" || strings.HasPrefix(p, "/static/")
+}
+
+// withAuth requires a valid session for everything except the login/setup
+// pages and static assets; the JSON API also takes an API token
+// (Authorization: Bearer). As long as no admin exists, all requests are sent
+// to the one-time setup page.
+func (a *App) withAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if bearer, ok := bearerToken(r); ok && isAPIPath(r.URL.Path) {
+			p, ok := a.tokenPrincipal(bearer)
+			if !ok {
+				writeAPIError(w, http.StatusUnauthorized, "invalid API token")
+				return
+			}
+			if p.ReadOnly && !isSafeMethod(r.Method) {
+				writeAPIError(w, http.StatusForbidden, "API token is read-only")
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalCtxKey{}, p)))
+			return
+		}
+
+		if user, ok := a.sessionUser(r); ok {
+			r = r.WithContext(context.WithValue(r.Context(), principalCtxKey{}, principal{Name: user}))
+		}
+
+		if isPublicPath(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		n, err := a.store.CountAdmins()
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		if isAPIPath(r.URL.Path) && (n == 0 || currentUser(r) == "") {
+			writeAPIError(w, http.StatusUnauthorized, "authentication required")
+			return
+		}
+		if n == 0 {
+			http.Redirect(w, r, "/setup", http.StatusSeeOther)
+			return
+		}
+
+		if currentUser(r) == "" {
+			target := "/login"
+			if r.Method == http.MethodGet && r.URL.Path != "/" {
+				target += "?next=" + url.QueryEscape(r.URL.RequestURI())
+			}
+			http.Redirect(w, r, target, http.StatusSeeOther)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (a *App) sessionUser(r *http.Request) (string, bool) {
+	c, err := r.Cookie(sessionCookie)
+	if err != nil || c.Value == "" {
+		return "", false
+	}
+	sess, ok, err := a.store.GetSession(auth.TokenHash(c.Value), time.Now())
+	if err != nil || !ok {
+		return "", false
+	}
+	return sess.Admin, true
+}
+
+// bootstrapAdmin creates the first admin from ADMIN_USER/ADMIN_PASSWORD if
+// both are set and no admin exists yet. Otherwise /setup takes over.
+func (a *App) bootstrapAdmin(name, password string) error {
+	if name == "" || password == "" {
+		return nil
+	}
+	n, err := a.store.CountAdmins()
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		return nil
+	}
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		return err
+	}
+	if err := a.store.CreateAdmin(name, hash); err != nil {
+		return err
+	}
+	log.Printf("created admin %q from ADMIN_USER", name)
+	a.audit(systemActor, "auth.setup", name, nil)
+	return nil
+}
+
+func (a *App) startSession(w http.ResponseWriter, r *http.Request, admin string) error {
+	token, err := auth.NewToken()
+	if err != nil {
+		return err
+	}
+	expires := time.Now().Add(a.sessionTTL)
+	if err := a.store.CreateSession(auth.TokenHash(token), admin, expires); err != nil {
+		return err
+	}
+	_ = a.store.DeleteExpiredSessions(time.Now())
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    token,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+// safeNext only allows local redirect targets after login.
+func safeNext(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/"
+	}
+	return next
+}
+
+type LoginForm struct {
+	Name  string
+	Next  string
+	Error string
+}
+
+func (a *App) login(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		if currentUser(r) != "" {
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		}
+		a.render(w, r, "login.html", "Login", LoginForm{Next: safeNext(r.URL.Query().Get("next"))})
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	form := LoginForm{
+		Name: strings.TrimSpace(r.FormValue("name")),
+		Next: safeNext(r.FormValue("next")),
+	}
+	pass := r.FormValue("password")
+
+	admin, ok, err := a.store.GetAdmin(form.Name)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if !ok || !auth.CheckPassword(admin.PasswordHash, pass) {
+		log.Printf("failed login for %q from %s", form.Name, r.RemoteAddr)
+		a.audit(actor{Name: form.Name, IP: actorOf(r).IP}, "auth.login", form.Name, errors.New("invalid username or password"))
+		form.Error = "invalid username or password"
+		a.renderStatus(w, r, http.StatusUnauthorized, "login.html", "Login", form)
+		return
+	}
+
+	if err := a.startSession(w, r, admin.Name); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	a.audit(actor{Name: admin.Name, IP: actorOf(r).IP}, "auth.login", admin.Name, nil)
+	http.Redirect(w, r, form.Next, http.StatusSeeOther)
+}
+
+func (a *App) logout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	if c, err := r.Cookie(sessionCookie); err == nil && c.Value != "" {
+		_ = a.store.DeleteSession(auth.TokenHash(c.Value))
+		a.audit(actorOf(r), "auth.logout", currentUser(r), nil)
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
+
+type SetupForm struct {
+	Name  string
+	Error string
+}
+
+// setup creates the first admin account. It is only reachable while the
+// admins table is empty.
+func (a *App) setup(w http.ResponseWriter, r *http.Request) {
+	n, err := a.store.CountAdmins()
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if n > 0 {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		a.render(w, r, "setup.html", "Setup", SetupForm{Name: "admin"})
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/setup", http.StatusSeeOther)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	form := SetupForm{Name: strings.TrimSpace(r.FormValue("name"))}
+	pass := r.FormValue("password")
+	confirm := r.FormValue("confirm_password")
+
+	switch {
+	case form.Name == "":
+		form.Error = "username required"
+	case len(pass) < 8:
+		form.Error = "password must be at least 8 characters"
+	case pass != confirm:
+		form.Error = "passwords do not match"
+	}
+	if form.Error != "" {
+		a.render(w, r, "setup.html", "Setup", form)
+		return
+	}
+
+	hash, err := auth.HashPassword(pass)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	err = a.store.CreateAdmin(form.Name, hash)
+	a.audit(actor{Name: form.Name, IP: actorOf(r).IP}, "auth.setup", form.Name, err)
+	if err != nil {
+		form.Error = err.Error()
+		a.render(w, r, "setup.html", "Setup", form)
+		return
+	}
+
+	if err := a.startSession(w, r, form.Name); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
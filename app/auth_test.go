@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/florianibach/samba-admin-ui/internal/auth"
+)
+
+func TestSafeNext(t *testing.T) {
+	for next, want := range map[string]string{
+		"":                   "/",
+		"/shares":            "/shares",
+		"/shares?name=media": "/shares?name=media",
+		"//evil.com":         "/",
+		`/\evil.com`:         "/",
+		"https://evil.com":   "/",
+		"evil.com":           "/",
+	} {
+		if got := safeNext(next); got != want {
+			t.Errorf("safeNext(%q) = %q, want %q", next, got, want)
+		}
+	}
+}
+
+func TestWithAuth(t *testing.T) {
+	a := newTestApp(t)
+	a.sessionTTL = time.Hour
+	h := a.withAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/setup" {
+			a.setup(w, r)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	do := func(method, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+		return w
+	}
+
+	// no admin yet: everything goes to the setup page
+	if w := do("GET", "/shares"); w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/setup" {
+		t.Errorf("before setup: %d %s", w.Code, w.Header().Get("Location"))
+	}
+
+	hash, err := auth.HashPassword("S3cure!pass")
+	must(t, err)
+	must(t, a.store.CreateAdmin("admin", hash))
+
+	for _, tc := range []struct {
+		method, path string
+		code         int
+		location     string
+	}{
+		{"GET", "/", http.StatusSeeOther, "/login"},
+		{"GET", "/shares?name=media", http.StatusSeeOther, "/login?next=%2Fshares%3Fname%3Dmedia"},
+		{"POST", "/shares/create", http.StatusSeeOther, "/login"},
+		{"GET", "/api/v1/shares", http.StatusUnauthorized, ""},
+		{"GET", "/login", http.StatusNoContent, ""},
+		{"GET", "/setup", http.StatusSeeOther, "/login"},
+		{"POST", "/setup", http.StatusSeeOther, "/login"},
+	} {
+		w := do(tc.method, tc.path)
+		if w.Code != tc.code || w.Header().Get("Location") != tc.location {
+			t.Errorf("%s %s: %d %q, want %d %q", tc.method, tc.path, w.Code, w.Header().Get("Location"), tc.code, tc.location)
+		}
+	}
+	if n, _ := a.store.CountAdmins(); n != 1 {
+		t.Errorf("%d admins after setup was refused", n)
+	}
+
+	// a session gets through
+	r := httptest.NewRequest("GET", "/shares", nil)
+	w := httptest.NewRecorder()
+	must(t, a.startSession(w, r, "admin"))
+	r.AddCookie(w.Result().Cookies()[0])
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusNoContent {
+		t.Errorf("with session: %d", w.Code)
+	}
+}
+
+func TestWithAuthTokens(t *testing.T) {
+	a := newTestApp(t)
+	hash, err := auth.HashPassword("S3cure!pass")
+	must(t, err)
+	must(t, a.store.CreateAdmin("admin", hash))
+	must(t, a.store.CreateAPIToken("rw", auth.TokenHash("sau_rw"), false, "admin"))
+	must(t, a.store.CreateAPIToken("ro", auth.TokenHash("sau_ro"), true, "admin"))
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/settings/tokens/revoke", a.tokenRevoke)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) })
+	h := a.withAuth(mux)
+
+	for _, tc := range []struct {
+		token, method, path string
+		code                int
+	}{
+		{"sau_ro", "GET", "/api/v1/shares", http.StatusNoContent},
+		{"sau_ro", "POST", "/api/v1/shares", http.StatusForbidden},
+		{"sau_rw", "POST", "/api/v1/shares", http.StatusNoContent},
+		{"sau_bad", "GET", "/api/v1/shares", http.StatusUnauthorized},
+		// the HTML pages only take sessions
+		{"sau_ro", "GET", "/backup/download", http.StatusSeeOther},
+		{"sau_rw", "GET", "/shares", http.StatusSeeOther},
+		{"sau_rw", "POST", "/settings/tokens/revoke?id=2", http.StatusSeeOther},
+	} {
+		r := httptest.NewRequest(tc.method, tc.path, nil)
+		r.Header.Set("Authorization", "Bearer "+tc.token)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != tc.code {
+			t.Errorf("%s %s %s: %d, want %d", tc.token, tc.method, tc.path, w.Code, tc.code)
+		}
+	}
+	if tokens, _ := a.store.ListAPITokens(); len(tokens) != 2 {
+		t.Errorf("%d tokens left", len(tokens))
+	}
+
+	// behind a token principal, revoking is refused as well
+	r := httptest.NewRequest("POST", "/settings/tokens/revoke?id=2", nil)
+	r = r.WithContext(context.WithValue(r.Context(), principalCtxKey{}, principal{Name: "token:rw", Token: true}))
+	w := httptest.NewRecorder()
+	a.tokenRevoke(w, r)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("revoke by token: %d", w.Code)
+	}
+}
@@ -0,0 +1,558 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/florianibach/samba-admin-ui/internal/auth"
+	"github.com/florianibach/samba-admin-ui/internal/backup"
+	"github.com/florianibach/samba-admin-ui/internal/reconcile"
+	"github.com/florianibach/samba-admin-ui/internal/samba"
+	"github.com/florianibach/samba-admin-ui/internal/state"
+)
+
+// maxBackupSize limits uploaded bundles, compressed and uncompressed.
+const maxBackupSize = 64 << 20
+
+// shareFiles returns the share files in sharesDir by file name; the index
+// and hidden (staged) files are skipped.
+func shareFiles(sharesDir, indexPath string) (map[string][]byte, error) {
+	entries, err := os.ReadDir(sharesDir)
+	if errors.Is(err, os.ErrNotExist) {
+		return map[string][]byte{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	res := map[string][]byte{}
+	for _, e := range entries {
+		name := e.Name()
+		path := filepath.Join(sharesDir, name)
+		if !e.Type().IsRegular() || !strings.HasSuffix(name, ".conf") || strings.HasPrefix(name, ".") || path == filepath.Clean(indexPath) {
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		res[name] = data
+	}
+	return res, nil
+}
+
+// readOptional reads path; a missing file is empty.
+func readOptional(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return []byte{}, nil
+	}
+	return data, err
+}
+
+// backupBundle captures the current configuration: a snapshot of the app
+// database, the shares index, the share files and a passdb export.
+func (a *App) backupBundle() (*backup.Bundle, error) {
+	sharesDir, indexPath := shareDirs()
+	host, _ := os.Hostname()
+	b := backup.New(sharesDir, indexPath, host)
+
+	tmp, err := os.MkdirTemp("", "backup-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmp)
+	dbFile := filepath.Join(tmp, "app.db")
+	if err := a.store.SnapshotTo(dbFile); err != nil {
+		return nil, fmt.Errorf("database snapshot: %w", err)
+	}
+	db, err := os.ReadFile(dbFile)
+	if err != nil {
+		return nil, err
+	}
+	b.Add(backup.DBPath, db)
+
+	index, err := readOptional(indexPath)
+	if err != nil {
+		return nil, fmt.Errorf("shares index: %w", err)
+	}
+	b.Add(backup.IndexPath, index)
+
+	snippets, err := shareFiles(sharesDir, indexPath)
+	if err != nil {
+		return nil, fmt.Errorf("share files: %w", err)
+	}
+	for _, name := range sortedNames(snippets) {
+		b.Add(backup.SnippetPrefix+name, snippets[name])
+	}
+
+	passdb, err := samba.ExportPassdb()
+	if err != nil {
+		return nil, err
+	}
+	b.Add(backup.PassdbPath, passdb)
+	return b, nil
+}
+
+func sortedNames[V any](m map[string]V) []string {
+	names := make([]string, 0, len(m))
+	for n := range m {
+		names = append(names, n)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// bundleIndex returns the bundle's index with include paths moved to
+// sharesDir, in case the backup was taken with a different UI_SHARES_DIR.
+func bundleIndex(b *backup.Bundle, sharesDir string) []byte {
+	index, _ := b.Get(backup.IndexPath)
+	old := strings.TrimRight(b.Manifest.SharesDir, "/")
+	if old == "" || old == strings.TrimRight(sharesDir, "/") {
+		return index
+	}
+	return bytes.ReplaceAll(index, []byte(old+"/"), []byte(strings.TrimRight(sharesDir, "/")+"/"))
+}
+
+// fileChange is a share file (or the index) a restore adds, removes or
+// changes.
+type fileChange struct {
+	Name   string `json:"name"`
+	Change string `json:"change"` // added, removed, changed
+}
+
+// passdbChange is a Samba account a restore adds, removes or changes.
+type passdbChange struct {
+	Name   string `json:"name"`
+	Change string `json:"change"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// restorePlan is what restoring a bundle would change.
+type restorePlan struct {
+	Manifest backup.Manifest   `json:"manifest"`
+	Tables   []state.TableDiff `json:"tables"`
+	Files    []fileChange      `json:"files"`
+	Passdb   []passdbChange    `json:"passdb"`
+	// LockedOut is set if the acting admin does not exist in the backup.
+	LockedOut bool `json:"locked_out"`
+}
+
+// Unchanged reports whether the restore would change nothing.
+func (p *restorePlan) Unchanged() bool {
+	return len(p.ChangedTables())+len(p.Files)+len(p.Passdb) == 0
+}
+
+// ChangedTables returns the table diffs that are not empty.
+func (p *restorePlan) ChangedTables() []state.TableDiff {
+	var res []state.TableDiff
+	for _, t := range p.Tables {
+		if !t.Empty() {
+			res = append(res, t)
+		}
+	}
+	return res
+}
+
+// withBundleDB writes the bundle's database to a temporary file for fn.
+func withBundleDB(b *backup.Bundle, fn func(path string) error) error {
+	tmp, err := os.MkdirTemp("", "restore-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	path := filepath.Join(tmp, "app.db")
+	db, _ := b.Get(backup.DBPath)
+	if err := os.WriteFile(path, db, 0600); err != nil {
+		return err
+	}
+	return fn(path)
+}
+
+// planRestore compares the bundle with the current configuration.
+func (a *App) planRestore(b *backup.Bundle, admin string) (*restorePlan, error) {
+	plan := &restorePlan{Manifest: b.Manifest, Files: []fileChange{}, Passdb: []passdbChange{}}
+
+	err := withBundleDB(b, func(path string) error {
+		tables, err := a.store.DiffFrom(path)
+		plan.Tables = tables
+		return err
+	})
+	if err != nil {
+		return nil, opErr(http.StatusBadRequest, "backup database: %s", err)
+	}
+	for _, t := range plan.Tables {
+		if t.Table == "admins" && slices.Contains(t.Removed, admin) {
+			plan.LockedOut = true
+		}
+	}
+
+	sharesDir, indexPath := shareDirs()
+	index, err := readOptional(indexPath)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(index, bundleIndex(b, sharesDir)) {
+		plan.Files = append(plan.Files, fileChange{Name: filepath.Base(indexPath) + " (index)", Change: "changed"})
+	}
+	current, err := shareFiles(sharesDir, indexPath)
+	if err != nil {
+		return nil, err
+	}
+	restored := b.Snippets()
+	for _, name := range sortedNames(restored) {
+		if cur, ok := current[name]; !ok {
+			plan.Files = append(plan.Files, fileChange{Name: name, Change: "added"})
+		} else if !bytes.Equal(cur, restored[name]) {
+			plan.Files = append(plan.Files, fileChange{Name: name, Change: "changed"})
+		}
+	}
+	for _, name := range sortedNames(current) {
+		if _, ok := restored[name]; !ok {
+			plan.Files = append(plan.Files, fileChange{Name: name, Change: "removed"})
+		}
+	}
+
+	data, _ := b.Get(backup.PassdbPath)
+	want, err := samba.ParseSmbpasswd(data)
+	if err != nil {
+		return nil, opErr(http.StatusBadRequest, "backup passdb: %s", err)
+	}
+	curData, err := samba.ExportPassdb()
+	if err != nil {
+		return nil, err
+	}
+	have, err := samba.ParseSmbpasswd(curData)
+	if err != nil {
+		return nil, err
+	}
+	plan.Passdb = diffPassdb(have, want)
+	return plan, nil
+}
+
+func diffPassdb(have, want []samba.PassdbEntry) []passdbChange {
+	res := []passdbChange{}
+	byName := map[string]samba.PassdbEntry{}
+	for _, e := range have {
+		byName[e.Name] = e
+	}
+	for _, w := range want {
+		h, ok := byName[w.Name]
+		delete(byName, w.Name)
+		switch {
+		case !ok:
+			res = append(res, passdbChange{Name: w.Name, Change: "added"})
+		case !h.SameSecret(w):
+			res = append(res, passdbChange{Name: w.Name, Change: "changed", Detail: "password"})
+		case h.Disabled != w.Disabled:
+			detail := "enabled"
+			if w.Disabled {
+				detail = "disabled"
+			}
+			res = append(res, passdbChange{Name: w.Name, Change: "changed", Detail: detail})
+		}
+	}
+	for _, name := range sortedNames(byName) {
+		res = append(res, passdbChange{Name: name, Change: "removed"})
+	}
+	return res
+}
+
+// applyBundle writes the bundle's database tables, index and share files.
+// Passdb and Linux accounts are left to restoreBackup.
+func (a *App) applyBundle(b *backup.Bundle) error {
+	err := withBundleDB(b, func(path string) error {
+		return a.store.RestoreFrom(path)
+	})
+	if err != nil {
+		return fmt.Errorf("restore database: %w", err)
+	}
+
+	sharesDir, indexPath := shareDirs()
+	if err := os.MkdirAll(sharesDir, 0755); err != nil {
+		return err
+	}
+	current, err := shareFiles(sharesDir, indexPath)
+	if err != nil {
+		return err
+	}
+
+	// Stage everything next to its target first, then swap in by rename.
+	files := map[string][]byte{indexPath: bundleIndex(b, sharesDir)}
+	for name, data := range b.Snippets() {
+		files[filepath.Join(sharesDir, name)] = data
+	}
+	staged := map[string]string{}
+	defer func() {
+		for _, tmp := range staged {
+			os.Remove(tmp)
+		}
+	}()
+	for _, path := range sortedNames(files) {
+		tmp := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".restore")
+		if err := os.WriteFile(tmp, files[path], 0644); err != nil {
+			return err
+		}
+		staged[path] = tmp
+	}
+	for _, path := range sortedNames(staged) {
+		if err := os.Rename(staged[path], path); err != nil {
+			return err
+		}
+		delete(staged, path)
+	}
+	for name := range current {
+		if _, keep := files[filepath.Join(sharesDir, name)]; !keep {
+			if err := os.Remove(filepath.Join(sharesDir, name)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// restoreResult reports what a restore did after the files were in place.
+type restoreResult struct {
+	Plan      *restorePlan `json:"plan"`
+	Reconcile []string     `json:"reconcile"`
+}
+
+// restoreBackup makes the configuration match b. The current state is
+// captured first; if writing the database or files or the testparm check
+// fails, it is put back, so nothing is left half restored. Afterwards the
+// Linux accounts are reconciled with the restored database, the passdb is
+// imported (accounts missing from the backup are deleted) and Samba
+// reloads.
+func (a *App) restoreBackup(act actor, b *backup.Bundle) (*restoreResult, error) {
+	plan, err := a.planRestore(b, act.Name)
+	if err != nil {
+		return nil, err
+	}
+	prev, err := a.backupBundle()
+	if err != nil {
+		return nil, fmt.Errorf("capturing the current state failed, nothing was changed: %w", err)
+	}
+	defer a.trackShares(act, "backup.restore", bundleShares(prev, b)...)()
+	err = a.applyBundle(b)
+	if err == nil {
+		if ok, errStr := samba.TestparmOK(a.smbConf); !ok {
+			err = opErr(http.StatusBadRequest, "testparm rejected the restored configuration: %s", errStr)
+		}
+	}
+	if err != nil {
+		if rerr := a.applyBundle(prev); rerr != nil {
+			return nil, fmt.Errorf("%w; rolling back failed too: %v", err, rerr)
+		}
+		return nil, err
+	}
+
+	res := &restoreResult{Plan: plan, Reconcile: []string{}}
+	rec, err := reconcile.Apply(a.store)
+	if rec != nil {
+		res.Reconcile = rec.Actions
+	}
+	if err != nil {
+		return res, fmt.Errorf("restored, but reconcile failed: %w", err)
+	}
+
+	passdb, _ := b.Get(backup.PassdbPath)
+	if err := samba.ImportPassdb(passdb); err != nil {
+		return res, fmt.Errorf("restored, but importing the passdb failed: %w", err)
+	}
+	for _, c := range plan.Passdb {
+		if c.Change == "removed" {
+			if err := samba.DeleteSambaUser(c.Name); err != nil {
+				return res, fmt.Errorf("restored, but removing Samba user %s failed: %w", c.Name, err)
+			}
+		}
+	}
+	if err := a.reloadSamba(); err != nil {
+		return res, fmt.Errorf("restored, but %w", err)
+	}
+	return res, nil
+}
+
+// bundleShares returns the names of the share files in the bundles.
+func bundleShares(bundles ...*backup.Bundle) []string {
+	var names []string
+	for _, b := range bundles {
+		for name := range b.Snippets() {
+			names = append(names, strings.TrimSuffix(name, ".conf"))
+		}
+	}
+	return names
+}
+
+// bundleTarget describes a bundle for the audit log.
+func bundleTarget(b *backup.Bundle) string {
+	t := "backup of " + b.Manifest.CreatedAt.Local().Format("2006-01-02 15:04:05")
+	if b.Manifest.Host != "" {
+		t += " from " + b.Manifest.Host
+	}
+	return t
+}
+
+func backupFileName(t time.Time) string {
+	return "samba-admin-ui-" + t.Local().Format("20060102-150405") + ".tar.gz"
+}
+
+// backupUploads keeps uploaded bundles between preview and confirmation.
+type backupUploads struct {
+	mu      sync.Mutex
+	uploads []backupUpload // oldest first
+}
+
+type backupUpload struct {
+	ID     string
+	Bundle *backup.Bundle
+	At     time.Time
+}
+
+const (
+	maxBackupUploads = 5
+	backupUploadTTL  = 30 * time.Minute
+)
+
+func (u *backupUploads) add(b *backup.Bundle) (string, error) {
+	id, err := auth.NewToken()
+	if err != nil {
+		return "", err
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.uploads = append(u.uploads, backupUpload{ID: id, Bundle: b, At: time.Now()})
+	if len(u.uploads) > maxBackupUploads {
+		u.uploads = u.uploads[len(u.uploads)-maxBackupUploads:]
+	}
+	return id, nil
+}
+
+func (u *backupUploads) get(id string) *backup.Bundle {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	for _, up := range u.uploads {
+		if up.ID == id && time.Since(up.At) < backupUploadTTL {
+			return up.Bundle
+		}
+	}
+	return nil
+}
+
+func (u *backupUploads) remove(id string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.uploads = slices.DeleteFunc(u.uploads, func(up backupUpload) bool { return up.ID == id })
+}
+
+// BackupView is the /backup page, with a restore preview after an upload.
+type BackupView struct {
+	Done   string
+	Error  string
+	Upload string // id of the previewed upload
+	Plan   *restorePlan
+}
+
+func (a *App) backupPage(w http.ResponseWriter, r *http.Request) {
+	a.render(w, r, "backup.html", "Backup", BackupView{Done: r.URL.Query().Get("done")})
+}
+
+func (a *App) backupDownload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Redirect(w, r, "/backup", http.StatusSeeOther)
+		return
+	}
+	b, err := a.backupBundle()
+	if err != nil {
+		a.audit(actorOf(r), "backup.create", "download", err)
+		a.renderStatus(w, r, errStatus(err), "backup.html", "Backup", BackupView{Error: err.Error()})
+		return
+	}
+	a.audit(actorOf(r), "backup.create", "download", nil)
+	writeBundle(w, b)
+}
+
+func writeBundle(w http.ResponseWriter, b *backup.Bundle) {
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+backupFileName(b.Manifest.CreatedAt)+`"`)
+	w.Header().Set("Cache-Control", "no-store")
+	_, _ = b.WriteTo(w)
+}
+
+// backupUpload validates an uploaded bundle and shows what restoring it
+// would change.
+func (a *App) backupUpload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/backup", http.StatusSeeOther)
+		return
+	}
+	f, _, err := r.FormFile("bundle")
+	if err != nil {
+		a.renderStatus(w, r, http.StatusBadRequest, "backup.html", "Backup", BackupView{Error: "no backup file uploaded: " + err.Error()})
+		return
+	}
+	defer f.Close()
+
+	b, err := backup.Read(f, maxBackupSize)
+	if err != nil {
+		a.renderStatus(w, r, http.StatusBadRequest, "backup.html", "Backup", BackupView{Error: err.Error()})
+		return
+	}
+	plan, err := a.planRestore(b, currentUser(r))
+	if err != nil {
+		a.renderStatus(w, r, errStatus(err), "backup.html", "Backup", BackupView{Error: err.Error()})
+		return
+	}
+	id, err := a.backupUploads.add(b)
+	if err != nil {
+		a.renderStatus(w, r, http.StatusInternalServerError, "backup.html", "Backup", BackupView{Error: err.Error()})
+		return
+	}
+	a.render(w, r, "backup.html", "Backup", BackupView{Upload: id, Plan: plan})
+}
+
+func (a *App) backupRestore(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/backup", http.StatusSeeOther)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	id := r.FormValue("upload")
+	b := a.backupUploads.get(id)
+	if b == nil {
+		a.renderStatus(w, r, http.StatusNotFound, "backup.html", "Backup", BackupView{Error: "The uploaded backup expired. Please upload it again."})
+		return
+	}
+
+	res, err := a.restoreBackup(actorOf(r), b)
+	a.audit(actorOf(r), "backup.restore", bundleTarget(b), err)
+	if err != nil {
+		v := BackupView{Error: err.Error()}
+		if res == nil {
+			// nothing changed; the preview is still valid
+			v.Upload = id
+			v.Plan, _ = a.planRestore(b, currentUser(r))
+		} else {
+			a.backupUploads.remove(id)
+		}
+		a.renderStatus(w, r, errStatus(err), "backup.html", "Backup", v)
+		return
+	}
+	a.backupUploads.remove(id)
+
+	done := "Restored " + bundleTarget(b) + "."
+	if n := len(res.Reconcile); n > 0 {
+		done += fmt.Sprintf(" Reconcile: %s.", strings.Join(res.Reconcile, ", "))
+	}
+	http.Redirect(w, r, "/backup?done="+url.QueryEscape(done), http.StatusSeeOther)
+}
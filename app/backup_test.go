@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/florianibach/samba-admin-ui/internal/auth"
+	"github.com/florianibach/samba-admin-ui/internal/backup"
+	"github.com/florianibach/samba-admin-ui/internal/samba/sambatest"
+	"github.com/florianibach/samba-admin-ui/internal/state"
+)
+
+func must(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBackupRestore(t *testing.T) {
+	sys := sambatest.Install(t)
+	a := newTestApp(t)
+	dir := t.TempDir()
+	sharesDir := filepath.Join(dir, "ui")
+	indexPath := filepath.Join(sharesDir, "shares.conf")
+	t.Setenv("UI_SHARES_DIR", sharesDir)
+	t.Setenv("UI_SHARES_INDEX", indexPath)
+	a.smbConf = filepath.Join(dir, "smb.conf")
+	write := func(path, data string) {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(a.smbConf, "[global]\n")
+	write(indexPath, "; samba-admin-ui:begin media\n[media]\n   include = "+sharesDir+"/media.conf\n; samba-admin-ui:end media\n")
+	write(filepath.Join(sharesDir, "media.conf"), "path = /shares/media\n")
+
+	must(t, a.store.UpsertGroup(state.Group{Name: "family", GID: intp(1100)}))
+	must(t, a.store.UpsertUser(state.User{Name: "alice", UID: intp(1000), GID: intp(1000)}))
+	must(t, a.store.AddMembership("alice", "family"))
+	must(t, a.store.CreateAdmin("admin", "hash"))
+	sys.AddUser("alice", 1000, "family")
+	sys.AddGroup("family", 1100)
+	sys.Passdb["alice"] = &sambatest.SambaAccount{Password: "old-secret"}
+
+	b, err := a.backupBundle()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := b.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err = backup.Read(bytes.NewReader(data), maxBackupSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan, err := a.planRestore(b, "admin"); err != nil || !plan.Unchanged() {
+		t.Fatalf("plan right after backup = %+v, %v", plan, err)
+	}
+
+	// change everything
+	must(t, a.store.UpsertUser(state.User{Name: "bob", UID: intp(1001), GID: intp(1001)}))
+	must(t, a.store.DeleteGroup("family"))
+	write(filepath.Join(sharesDir, "media.conf"), "path = /shares/other\n")
+	write(filepath.Join(sharesDir, "extra.conf"), "path = /shares/extra\n")
+	sys.AddUser("bob", 1001)
+	sys.Passdb["alice"].Password = "new-secret"
+	sys.Passdb["bob"] = &sambatest.SambaAccount{Password: "bob"}
+
+	plan, err := a.planRestore(b, "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tables := map[string]state.TableDiff{}
+	for _, d := range plan.Tables {
+		tables[d.Table] = d
+	}
+	if d := tables["users"]; !slices.Equal(d.Removed, []string{"bob"}) {
+		t.Errorf("users diff = %+v", d)
+	}
+	if d := tables["groups"]; !slices.Equal(d.Added, []string{"family"}) {
+		t.Errorf("groups diff = %+v", d)
+	}
+	if d := tables["user_groups"]; !slices.Equal(d.Added, []string{"alice/family"}) {
+		t.Errorf("memberships diff = %+v", d)
+	}
+	wantFiles := []fileChange{{"media.conf", "changed"}, {"extra.conf", "removed"}}
+	if !slices.Equal(plan.Files, wantFiles) {
+		t.Errorf("files = %+v", plan.Files)
+	}
+	wantPassdb := []passdbChange{{"alice", "changed", "password"}, {"bob", "removed", ""}}
+	if !slices.Equal(plan.Passdb, wantPassdb) {
+		t.Errorf("passdb = %+v", plan.Passdb)
+	}
+
+	if _, err := a.restoreBackup(actor{Name: "admin"}, b); err != nil {
+		t.Fatal(err)
+	}
+	users, _ := a.store.ListUsers()
+	if len(users) != 1 || users[0].Name != "alice" {
+		t.Errorf("users = %+v", users)
+	}
+	if groups, _ := a.store.ListUserGroups("alice"); !slices.Equal(groups, []string{"family"}) {
+		t.Errorf("alice's groups = %q", groups)
+	}
+	if got, _ := os.ReadFile(filepath.Join(sharesDir, "media.conf")); string(got) != "path = /shares/media\n" {
+		t.Errorf("media.conf = %q", got)
+	}
+	if _, err := os.Stat(filepath.Join(sharesDir, "extra.conf")); !os.IsNotExist(err) {
+		t.Errorf("extra.conf still there: %v", err)
+	}
+	if sys.Passdb["alice"].Password != "old-secret" || sys.Passdb["bob"] != nil {
+		t.Errorf("passdb = %+v", sys.Passdb)
+	}
+	if _, ok, _ := a.store.GetAdmin("admin"); !ok {
+		t.Error("admin lost")
+	}
+}
+
+func TestRestoreRollsBackWhenTestparmFails(t *testing.T) {
+	sys := sambatest.Install(t)
+	a := newTestApp(t)
+	dir := t.TempDir()
+	sharesDir := filepath.Join(dir, "ui")
+	t.Setenv("UI_SHARES_DIR", sharesDir)
+	t.Setenv("UI_SHARES_INDEX", filepath.Join(sharesDir, "shares.conf"))
+	a.smbConf = filepath.Join(dir, "smb.conf")
+	must(t, os.WriteFile(a.smbConf, []byte("[global]\n"), 0o644))
+	must(t, os.MkdirAll(sharesDir, 0o755))
+
+	must(t, a.store.UpsertUser(state.User{Name: "alice"}))
+	b, err := a.backupBundle()
+	if err != nil {
+		t.Fatal(err)
+	}
+	must(t, a.store.UpsertUser(state.User{Name: "bob"}))
+	must(t, os.WriteFile(filepath.Join(sharesDir, "new.conf"), []byte("path = /x\n"), 0o644))
+
+	sys.TestparmError = "Unknown parameter encountered"
+	if _, err := a.restoreBackup(actor{Name: "admin"}, b); err == nil || errStatus(err) != 400 {
+		t.Fatalf("restore = %v", err)
+	}
+	users, _ := a.store.ListUsers()
+	if len(users) != 2 {
+		t.Errorf("users after rollback = %+v", users)
+	}
+	if _, err := os.Stat(filepath.Join(sharesDir, "new.conf")); err != nil {
+		t.Errorf("new.conf after rollback: %v", err)
+	}
+}
+
+func TestAPIBackupRefusesReadOnlyToken(t *testing.T) {
+	sambatest.Install(t)
+	a := newTestApp(t)
+	c := newAPIClient(t, a, true)
+	must(t, a.store.CreateAPIToken("sau_rw", auth.TokenHash("sau_rw"), false, "admin"))
+
+	// backups hold password hashes
+	if w := c.do("GET", "/api/v1/backup", ""); w.Code != http.StatusForbidden {
+		t.Errorf("read-only token: %d %s", w.Code, w.Body)
+	} else if e := apiErr(t, w); !strings.Contains(e.Message, "read-only") {
+		t.Errorf("error = %+v", e)
+	}
+	c.token = "sau_rw"
+	if w := c.do("GET", "/api/v1/backup", ""); w.Code != http.StatusOK {
+		t.Errorf("read-write token: %d %s", w.Code, w.Body)
+	}
+}
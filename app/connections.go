@@ -0,0 +1,214 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/florianibach/samba-admin-ui/internal/samba"
+)
+
+// connShare groups the tree connects of one share.
+type connShare struct {
+	Service string
+	Tcons   []connTcon
+}
+
+type connTcon struct {
+	samba.SmbTcon
+	User string `json:"user"`
+}
+
+type connFile struct {
+	samba.SmbOpenFile
+	User string `json:"user"`
+}
+
+type connLock struct {
+	samba.SmbLock
+	User string `json:"user"`
+}
+
+// ConnectionsView is the /connections page.
+type ConnectionsView struct {
+	Status   *samba.SmbStatus
+	Shares   []connShare
+	Files    []connFile
+	Locks    []connLock
+	Error    string // smbstatus failed
+	Failed   string // a close/kill action failed
+	Done     string
+	Paused   bool
+	Interval int // seconds between reloads
+}
+
+// connectionsView loads smbstatus and resolves pids to user names.
+func connectionsView() ConnectionsView {
+	v := ConnectionsView{Interval: 5}
+	st, err := samba.GetSmbStatus()
+	if err != nil {
+		v.Error = err.Error()
+		return v
+	}
+	v.Status = st
+
+	for _, t := range st.Tcons {
+		if n := len(v.Shares); n == 0 || v.Shares[n-1].Service != t.Service {
+			v.Shares = append(v.Shares, connShare{Service: t.Service})
+		}
+		sh := &v.Shares[len(v.Shares)-1]
+		sh.Tcons = append(sh.Tcons, connTcon{SmbTcon: t, User: sessionUser(st, t.PID)})
+	}
+	v.Files = connFiles(st, st.OpenFiles)
+	for _, l := range st.Locks {
+		v.Locks = append(v.Locks, connLock{SmbLock: l, User: sessionUser(st, l.PID)})
+	}
+	return v
+}
+
+// sessionUser returns the user of the session served by pid, if known.
+func sessionUser(st *samba.SmbStatus, pid string) string {
+	if s, ok := st.Session(pid); ok {
+		return s.Username
+	}
+	return ""
+}
+
+func connFiles(st *samba.SmbStatus, files []samba.SmbOpenFile) []connFile {
+	var res []connFile
+	for _, f := range files {
+		res = append(res, connFile{SmbOpenFile: f, User: sessionUser(st, f.PID)})
+	}
+	return res
+}
+
+// shareUse is who is using a share right now.
+type shareUse struct {
+	Tcons []connTcon `json:"connections"`
+	Files []connFile `json:"open_files"`
+}
+
+func (u *shareUse) Busy() bool {
+	return len(u.Tcons)+len(u.Files) > 0
+}
+
+// shareInUse lists the connections to share name and the files open in its
+// path.
+func (a *App) shareInUse(name string) (*shareUse, error) {
+	st, err := samba.GetSmbStatus()
+	if err != nil {
+		return nil, err
+	}
+	path := ""
+	if sections, _, err := samba.ReadEffectiveConfig(a.smbConf); err == nil {
+		path = sections[name]["path"]
+	}
+	tcons, files := st.ForShare(name, path)
+
+	use := &shareUse{Files: connFiles(st, files)}
+	for _, t := range tcons {
+		use.Tcons = append(use.Tcons, connTcon{SmbTcon: t, User: sessionUser(st, t.PID)})
+	}
+	return use, nil
+}
+
+// closeConnection makes the smbd process pid close its tree connects to
+// share. The connection must still be listed by smbstatus.
+func (a *App) closeConnection(pid, share string) (string, error) {
+	st, err := samba.GetSmbStatus()
+	if err != nil {
+		return "", err
+	}
+	found := false
+	for _, t := range st.Tcons {
+		if t.PID == pid && strings.EqualFold(t.Service, share) {
+			found = true
+		}
+	}
+	if !found {
+		return "", opErr(http.StatusNotFound, "no connection of pid %s to %s (it may have ended)", pid, share)
+	}
+	if err := samba.CloseShare(pid, share); err != nil {
+		return "", opErr(http.StatusBadGateway, "%s", err)
+	}
+	return connTarget(st, pid) + " " + share, nil
+}
+
+// killSession terminates the smbd process pid. Only processes smbstatus
+// lists as sessions may be killed.
+func (a *App) killSession(pid string) (string, error) {
+	st, err := samba.GetSmbStatus()
+	if err != nil {
+		return "", err
+	}
+	if _, ok := st.Session(pid); !ok {
+		return "", opErr(http.StatusNotFound, "no session with pid %s (it may have ended)", pid)
+	}
+	if err := samba.KillSession(pid); err != nil {
+		return "", opErr(http.StatusBadGateway, "%s", err)
+	}
+	return connTarget(st, pid), nil
+}
+
+// connTarget describes a session for the audit log.
+func connTarget(st *samba.SmbStatus, pid string) string {
+	if s, ok := st.Session(pid); ok {
+		return fmt.Sprintf("pid %s (%s@%s)", pid, s.Username, s.Machine)
+	}
+	return "pid " + pid
+}
+
+func (a *App) connectionsPage(w http.ResponseWriter, r *http.Request) {
+	v := connectionsView()
+	v.Paused = r.URL.Query().Get("pause") == "1"
+	v.Done = r.URL.Query().Get("done")
+	a.render(w, r, "connections.html", "Connections", v)
+}
+
+func (a *App) connectionClose(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/connections", http.StatusSeeOther)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	pid, share := r.FormValue("pid"), r.FormValue("share")
+	target, err := a.closeConnection(pid, share)
+	if target == "" {
+		target = "pid " + pid + " " + share
+	}
+	a.audit(actorOf(r), "connection.close", target, err)
+	a.connectionResult(w, r, err, "Closed connection "+target+".")
+}
+
+func (a *App) connectionKill(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/connections", http.StatusSeeOther)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	pid := r.FormValue("pid")
+	target, err := a.killSession(pid)
+	if target == "" {
+		target = "pid " + pid
+	}
+	a.audit(actorOf(r), "connection.kill", target, err)
+	a.connectionResult(w, r, err, "Terminated "+target+".")
+}
+
+func (a *App) connectionResult(w http.ResponseWriter, r *http.Request, err error, done string) {
+	if err != nil {
+		v := connectionsView()
+		v.Paused = true
+		v.Failed = err.Error()
+		a.renderStatus(w, r, errStatus(err), "connections.html", "Connections", v)
+		return
+	}
+	http.Redirect(w, r, "/connections?done="+url.QueryEscape(done), http.StatusSeeOther)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/florianibach/samba-admin-ui/internal/samba/sambatest"
+)
+
+const testSmbstatus = `{"version": "4.17.12", "sessions": {"1": {"server_id": {"pid": "1234"}, "username": "alice", "remote_machine": "10.0.0.5"}},
+ "tcons": {"2": {"service": "media", "server_id": {"pid": "1234"}, "machine": "10.0.0.5"}}}`
+
+func TestConnectionActionsRequireListedProcess(t *testing.T) {
+	sys := sambatest.Install(t)
+	sys.SmbStatus = testSmbstatus
+	sys.Script("kill", func(stdin string, args []string) (string, string, int) { return "", "", 0 })
+	a := newTestApp(t)
+
+	if _, err := a.killSession("1"); errStatus(err) != 404 {
+		t.Errorf("kill of unlisted pid: %v", err)
+	}
+	if _, err := a.closeConnection("1234", "backup"); errStatus(err) != 404 {
+		t.Errorf("close of unused share: %v", err)
+	}
+	if slices.ContainsFunc(sys.Calls(), func(c string) bool { return c != "smbstatus --json" }) {
+		t.Errorf("calls = %q", sys.Calls())
+	}
+
+	target, err := a.killSession("1234")
+	if err != nil || target != "pid 1234 (alice@10.0.0.5)" {
+		t.Errorf("kill = %q, %v", target, err)
+	}
+	if _, err := a.closeConnection("1234", "media"); err != nil {
+		t.Error(err)
+	}
+	if !slices.Contains(sys.Calls(), "kill -TERM 1234") || !slices.Contains(sys.Calls(), "smbcontrol 1234 close-share media") {
+		t.Errorf("calls = %q", sys.Calls())
+	}
+}
+
+func TestCheckShareIdle(t *testing.T) {
+	sys := sambatest.Install(t)
+	a := newTestApp(t)
+
+	if err := a.checkShareIdle("media", shareChange{}); err != nil {
+		t.Fatalf("idle share: %v", err)
+	}
+
+	sys.SmbStatus = testSmbstatus
+	err := a.checkShareIdle("media", shareChange{})
+	var busy *shareBusyError
+	if !errors.As(err, &busy) || errStatus(err) != 409 || busy.Use == nil || len(busy.Use.Tcons) != 1 || busy.Use.Tcons[0].User != "alice" {
+		t.Fatalf("busy share: %v", err)
+	}
+
+	for _, ch := range []shareChange{{Force: true}, {Force: true, Close: true}} {
+		if err := a.checkShareIdle("media", ch); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if m := sys.Mutations(); len(m) != 0 {
+		t.Errorf("forced check ran %q", m)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/florianibach/samba-admin-ui/internal/auth"
+)
+
+const csrfFormField = "csrf_token"
+const csrfHeader = "X-CSRF-Token"
+
+// maxRequestBody caps the body of state-changing requests; the largest is
+// an uploaded backup bundle.
+const maxRequestBody = maxBackupSize + 1<<20
+
+// csrfTokenFor returns the CSRF token of the session attached to r, or "".
+func csrfTokenFor(r *http.Request) string {
+	if r == nil || currentUser(r) == "" || currentPrincipal(r).Token {
+		return ""
+	}
+	c, err := r.Cookie(sessionCookie)
+	if err != nil || c.Value == "" {
+		return ""
+	}
+	return auth.CSRFToken(c.Value)
+}
+
+func isSafeMethod(m string) bool {
+	switch m {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return false
+}
+
+// withCSRF rejects state-changing requests of a logged-in session unless they
+// carry the session's CSRF token, either as the csrf_token form field (added
+// to every form via {{ csrfField }}) or as X-CSRF-Token header. Their bodies
+// are capped at maxRequestBody before the form is parsed.
+func (a *App) withCSRF(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isSafeMethod(r.Method) {
+			r.Body = http.MaxBytesReader(w, r.Body, maxRequestBody)
+		}
+		// API tokens are sent explicitly and cannot be forged cross-site.
+		if isSafeMethod(r.Method) || currentUser(r) == "" || currentPrincipal(r).Token {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		c, err := r.Cookie(sessionCookie)
+		if err != nil {
+			a.csrfFailed(w, r)
+			return
+		}
+		submitted := r.Header.Get(csrfHeader)
+		if submitted == "" {
+			// ParseMultipartForm also parses url-encoded forms
+			var tooLarge *http.MaxBytesError
+			if err := r.ParseMultipartForm(32 << 20); errors.As(err, &tooLarge) {
+				http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+			submitted = r.PostFormValue(csrfFormField)
+		}
+		if !auth.CheckCSRF(c.Value, submitted) {
+			a.csrfFailed(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (a *App) csrfFailed(w http.ResponseWriter, r *http.Request) {
+	log.Printf("csrf check failed: %s %s from %s", r.Method, r.URL.Path, r.RemoteAddr)
+	if isAPIPath(r.URL.Path) {
+		writeAPIError(w, http.StatusForbidden, "missing or invalid CSRF token (send X-CSRF-Token)")
+		return
+	}
+	a.renderStatus(w, r, http.StatusForbidden, "forbidden.html", "Forbidden", struct {
+		Message string
+	}{
+		Message: "The request could not be verified (missing or invalid CSRF token). Reload the page and try again.",
+	})
+}
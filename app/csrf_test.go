@@ -0,0 +1,106 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/florianibach/samba-admin-ui/internal/auth"
+)
+
+func TestWithCSRF(t *testing.T) {
+	a := newTestApp(t)
+	base, err := parseBase()
+	must(t, err)
+	a.base = base
+	a.sessionTTL = time.Hour
+	hash, err := auth.HashPassword("S3cure!pass")
+	must(t, err)
+	must(t, a.store.CreateAdmin("admin", hash))
+
+	w := httptest.NewRecorder()
+	must(t, a.startSession(w, httptest.NewRequest("POST", "/login", nil), "admin"))
+	session := w.Result().Cookies()[0]
+	token := auth.CSRFToken(session.Value)
+
+	h := a.withAuth(a.withCSRF(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})))
+
+	for _, tc := range []struct {
+		name   string
+		form   string // csrf_token field, if not empty
+		header string // X-CSRF-Token, if not empty
+		code   int
+	}{
+		{"no token", "", "", http.StatusForbidden},
+		{"wrong form token", "x" + token, "", http.StatusForbidden},
+		{"wrong header", "", "x" + token, http.StatusForbidden},
+		{"token of another session", auth.CSRFToken("other"), "", http.StatusForbidden},
+		{"form token", token, "", http.StatusNoContent},
+		{"header", "", token, http.StatusNoContent},
+	} {
+		form := url.Values{"name": {"media"}}
+		if tc.form != "" {
+			form.Set(csrfFormField, tc.form)
+		}
+		r := httptest.NewRequest("POST", "/shares/delete", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if tc.header != "" {
+			r.Header.Set(csrfHeader, tc.header)
+		}
+		r.AddCookie(session)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != tc.code {
+			t.Errorf("%s: %d, want %d", tc.name, w.Code, tc.code)
+		}
+	}
+
+	// a JSON API call of the session needs the header as well
+	r := httptest.NewRequest("DELETE", "/api/v1/shares/media", nil)
+	r.AddCookie(session)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("API call without header: %d", w.Code)
+	}
+}
+
+func TestWithCSRFLimitsBody(t *testing.T) {
+	a := newTestApp(t)
+	a.sessionTTL = time.Hour
+	hash, err := auth.HashPassword("S3cure!pass")
+	must(t, err)
+	must(t, a.store.CreateAdmin("admin", hash))
+	w := httptest.NewRecorder()
+	must(t, a.startSession(w, httptest.NewRequest("POST", "/login", nil), "admin"))
+	session := w.Result().Cookies()[0]
+
+	calls := 0
+	h := a.withAuth(a.withCSRF(http.HandlerFunc(func(http.ResponseWriter, *http.Request) { calls++ })))
+
+	// an upload one byte over the limit, streamed
+	head := "--b\r\nContent-Disposition: form-data; name=\"" + csrfFormField + "\"\r\n\r\n" + auth.CSRFToken(session.Value) +
+		"\r\n--b\r\nContent-Disposition: form-data; name=\"bundle\"; filename=\"x.tar.gz\"\r\n\r\n"
+	body := io.MultiReader(strings.NewReader(head), io.LimitReader(zeros{}, maxRequestBody-int64(len(head))+1))
+	r := httptest.NewRequest("POST", "/backup/upload", body)
+	r.Header.Set("Content-Type", "multipart/form-data; boundary=b")
+	r.AddCookie(session)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusRequestEntityTooLarge || calls != 0 {
+		t.Errorf("oversized upload: %d, handler ran %d times", w.Code, calls)
+	}
+}
+
+type zeros struct{}
+
+func (zeros) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/florianibach/samba-admin-ui/internal/reconcile"
+)
+
+// driftView is what the drift_items partial renders.
+type driftView struct {
+	Items    []reconcile.DriftItem
+	Warnings []string
+	Error    string
+	Back     string // page to return to after an action
+}
+
+func (a *App) driftView(back string, pick func(*reconcile.DriftReport) []reconcile.DriftItem) driftView {
+	rep, err := reconcile.DetectDrift(a.store)
+	if err != nil {
+		return driftView{Error: err.Error(), Back: back}
+	}
+	return driftView{Items: pick(rep), Warnings: rep.Warnings, Back: back}
+}
+
+func allDrift(rep *reconcile.DriftReport) []reconcile.DriftItem { return rep.Items }
+
+// resolveDrift adopts (DB := system) or fixes (system := DB) one drift item.
+func (a *App) resolveDrift(act actor, adopt bool, kind, name, group string) error {
+	var err error
+	if adopt {
+		err = reconcile.AdoptDrift(a.store, reconcile.DriftKind(kind), name, group)
+	} else {
+		err = reconcile.FixDrift(a.store, reconcile.DriftKind(kind), name, group)
+	}
+
+	action, target := "drift.fix", kind+" "+name
+	if adopt {
+		action = "drift.adopt"
+	}
+	if group != "" {
+		target += " " + group
+	}
+	a.audit(act, action, target, err)
+
+	if errors.Is(err, reconcile.ErrNoDrift) {
+		return opErr(http.StatusNotFound, "%s", err)
+	}
+	return err
+}
+
+func (a *App) driftAction(adopt bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		}
+		_ = r.ParseForm()
+		kind := strings.TrimSpace(r.FormValue("kind"))
+		name := strings.TrimSpace(r.FormValue("name"))
+		group := strings.TrimSpace(r.FormValue("group"))
+		if kind == "" || name == "" {
+			http.Error(w, "kind and name required", 400)
+			return
+		}
+
+		if err := a.resolveDrift(actorOf(r), adopt, kind, name, group); err != nil {
+			http.Error(w, err.Error(), errStatus(err))
+			return
+		}
+		http.Redirect(w, r, safeNext(r.FormValue("back")), http.StatusSeeOther)
+	}
+}
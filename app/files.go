@@ -0,0 +1,150 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/florianibach/samba-admin-ui/internal/files"
+	"github.com/florianibach/samba-admin-ui/internal/samba"
+)
+
+// fileRow is a directory below SHARE_ROOT with owner and group resolved.
+type fileRow struct {
+	files.Entry
+	Abs   string
+	Owner string
+	Group string
+}
+
+// listDir lists the directories in rel (relative to SHARE_ROOT).
+func (a *App) listDir(rel string) (dir fileRow, rows []fileRow, l *files.Listing, err error) {
+	l, err = files.List(a.shareRoot, rel)
+	if err != nil {
+		return fileRow{}, nil, nil, fileErr(err)
+	}
+
+	acc, _ := samba.LoadAccounts() // names are cosmetic; fall back to IDs
+	row := func(e files.Entry) fileRow {
+		r := fileRow{Entry: e, Abs: files.Abs(a.shareRoot, e.Rel)}
+		r.Owner, r.Group = ownerNames(acc, e.UID, e.GID)
+		return r
+	}
+
+	for _, e := range l.Dirs {
+		rows = append(rows, row(e))
+	}
+	return row(l.Dir), rows, l, nil
+}
+
+// ownerNames resolves uid and gid to names, falling back to the numbers.
+// acc may be nil.
+func ownerNames(acc *samba.Accounts, uid, gid int) (owner, group string) {
+	owner, group = strconv.Itoa(uid), strconv.Itoa(gid)
+	if acc != nil {
+		if u, ok := acc.UserByUID(uid); ok {
+			owner = u.Name
+		}
+		if g, ok := acc.GroupByGID(gid); ok {
+			group = g.Name
+		}
+	}
+	return owner, group
+}
+
+// makeDir creates name inside rel and returns the new relative path.
+func (a *App) makeDir(rel, name string) (string, error) {
+	child, err := files.Mkdir(a.shareRoot, rel, name)
+	if err != nil {
+		if errors.Is(err, fs.ErrExist) {
+			return "", opErr(http.StatusConflict, "%s already exists", name)
+		}
+		return "", fileErr(err)
+	}
+	return child, nil
+}
+
+func fileErr(err error) error {
+	switch {
+	case errors.Is(err, files.ErrOutsideRoot):
+		return opErr(http.StatusForbidden, "%s", err)
+	case errors.Is(err, fs.ErrNotExist):
+		return opErr(http.StatusNotFound, "%s", err)
+	}
+	return opErr(http.StatusBadRequest, "%s", err)
+}
+
+type crumb struct {
+	Name string
+	Rel  string
+}
+
+func breadcrumbs(rel string) []crumb {
+	res := []crumb{{Name: "/", Rel: "."}}
+	if rel == "." {
+		return res
+	}
+	parts := strings.Split(rel, "/")
+	for i := range parts {
+		res = append(res, crumb{Name: parts[i], Rel: path.Join(parts[:i+1]...)})
+	}
+	return res
+}
+
+// filesPage browses SHARE_ROOT: /files?path=<relative dir>
+func (a *App) filesPage(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	a.renderFiles(w, r, files.Clean(q.Get("path")), q.Get("created"), "")
+}
+
+func (a *App) renderFiles(w http.ResponseWriter, r *http.Request, rel, created, formErr string) {
+	type vm struct {
+		Root    string
+		Rel     string
+		Parent  string
+		Crumbs  []crumb
+		Dir     fileRow
+		Dirs    []fileRow
+		Files   int
+		Skipped int
+
+		Created string
+		Error   string
+		FormErr string
+	}
+
+	v := vm{Root: a.shareRoot, Rel: rel, Parent: files.Parent(rel), Crumbs: breadcrumbs(rel), Created: created, FormErr: formErr}
+	dir, rows, l, err := a.listDir(rel)
+	if err != nil {
+		v.Error = err.Error()
+		a.renderStatus(w, r, errStatus(err), "files.html", "Files", v)
+		return
+	}
+	v.Dir, v.Dirs, v.Files, v.Skipped = dir, rows, l.Files, l.Skipped
+	a.render(w, r, "files.html", "Files", v)
+}
+
+func (a *App) filesMkdir(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/files", http.StatusSeeOther)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	rel := files.Clean(r.FormValue("path"))
+	name := strings.TrimSpace(r.FormValue("name"))
+
+	child, err := a.makeDir(rel, name)
+	a.audit(actorOf(r), "files.mkdir", path.Join(a.shareRoot, rel, name), err)
+	if err != nil {
+		a.renderFiles(w, r, rel, "", err.Error())
+		return
+	}
+	http.Redirect(w, r, "/files?path="+url.QueryEscape(rel)+"&created="+url.QueryEscape(path.Base(child)), http.StatusSeeOther)
+}
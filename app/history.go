@@ -0,0 +1,332 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/florianibach/samba-admin-ui/internal/diff"
+	"github.com/florianibach/samba-admin-ui/internal/samba"
+	"github.com/florianibach/samba-admin-ui/internal/state"
+)
+
+// diffContext is the number of unchanged lines shown around a change.
+const diffContext = 3
+
+// shareState reads the snippet file and index block of share name; nil
+// means missing.
+func shareState(name string) (snippet, block *string, err error) {
+	if name == "" || strings.Contains(name, "/") {
+		return nil, nil, opErr(http.StatusNotFound, "invalid share name %q", name)
+	}
+	sharesDir, indexPath := shareDirs()
+	data, err := os.ReadFile(filepath.Join(sharesDir, name+".conf"))
+	switch {
+	case err == nil:
+		s := string(data)
+		snippet = &s
+	case !errors.Is(err, os.ErrNotExist):
+		return nil, nil, err
+	}
+	b, ok, err := samba.ShareIndexBlock(indexPath, name)
+	if err != nil {
+		return nil, nil, err
+	}
+	if ok {
+		block = &b
+	}
+	return snippet, block, nil
+}
+
+func sameContent(a, b *string) bool {
+	return (a == nil && b == nil) || (a != nil && b != nil && *a == *b)
+}
+
+// recordShare stores a revision of share name if its snippet or index block
+// differs from the newest revision. A share without history is only
+// recorded once it exists.
+func (a *App) recordShare(act actor, action, name string) error {
+	snippet, block, err := shareState(name)
+	if err != nil {
+		return err
+	}
+	last, ok, err := a.store.LatestShareRevision(name)
+	if err != nil {
+		return err
+	}
+	if ok && sameContent(last.Snippet, snippet) && sameContent(last.IndexBlock, block) {
+		return nil
+	}
+	if !ok && snippet == nil && block == nil {
+		return nil
+	}
+	_, err = a.store.AddShareRevision(state.ShareRevision{
+		Share:      name,
+		Snippet:    snippet,
+		IndexBlock: block,
+		Actor:      act.Name,
+		Action:     action,
+	})
+	return err
+}
+
+// trackShares keeps the history of the shares a change touches. The state
+// before the change is recorded as a baseline for shares without history
+// (e.g. files written before history existed); the returned function records
+// the state after it. Like the audit log, failures are only logged.
+func (a *App) trackShares(act actor, action string, names ...string) func() {
+	slices.Sort(names)
+	names = slices.Compact(names)
+	for _, name := range names {
+		if err := a.recordShare(systemActor, "share.baseline", name); err != nil {
+			log.Printf("share history of %s: %v", name, err)
+		}
+	}
+	return func() {
+		for _, name := range names {
+			if err := a.recordShare(act, action, name); err != nil {
+				log.Printf("share history of %s: %v", name, err)
+			}
+		}
+	}
+}
+
+// rollbackShare puts the snippet and index block of revision id back. The
+// whole configuration is checked with testparm; if it is rejected, the
+// current content is restored. Samba reloads afterwards.
+func (a *App) rollbackShare(act actor, name string, id int64) error {
+	rev, ok, err := a.store.GetShareRevision(id)
+	if err != nil {
+		return err
+	}
+	if !ok || rev.Share != name {
+		return opErr(http.StatusNotFound, "share %s has no revision %d", name, id)
+	}
+	sharesDir, indexPath := shareDirs()
+	if err := a.checkIndexIncluded(indexPath); err != nil {
+		return err
+	}
+	if rev.IndexBlock == nil {
+		// the share goes away; same rule as for deleting it
+		if err := a.checkShareIdle(name, shareChange{}); err != nil {
+			return err
+		}
+	}
+
+	// check the revision on a staged copy before the live files change
+	if rev.Snippet != nil {
+		if err := samba.ValidateShareContent(sharesDir, name, *rev.Snippet); err != nil {
+			return opErr(http.StatusBadRequest, "cannot roll back to revision %d, nothing was changed: %s", id, err)
+		}
+	}
+
+	snippet, block, err := shareState(name)
+	if err != nil {
+		return err
+	}
+	defer a.trackShares(act, "share.rollback", name)()
+
+	if err := writeShareState(sharesDir, indexPath, name, rev.Snippet, rev.IndexBlock); err != nil {
+		if rerr := writeShareState(sharesDir, indexPath, name, snippet, block); rerr != nil {
+			return fmt.Errorf("%w; restoring the current content failed too: %v", err, rerr)
+		}
+		return err
+	}
+	// the index block can still clash with the rest of the configuration
+	if ok, errStr := samba.TestparmOK(a.smbConf); !ok {
+		if rerr := writeShareState(sharesDir, indexPath, name, snippet, block); rerr != nil {
+			return fmt.Errorf("testparm rejected revision %d (%s); restoring the current content failed: %v", id, errStr, rerr)
+		}
+		return opErr(http.StatusBadRequest, "testparm rejected revision %d, nothing was changed: %s", id, errStr)
+	}
+	return a.reloadSamba()
+}
+
+func writeShareState(sharesDir, indexPath, name string, snippet, block *string) error {
+	if snippet != nil {
+		if err := samba.WriteShareSnippet(sharesDir, name, *snippet); err != nil {
+			return err
+		}
+	} else if err := os.Remove(filepath.Join(sharesDir, name+".conf")); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	b := ""
+	if block != nil {
+		b = *block
+	}
+	return samba.SetShareIndexBlock(indexPath, name, b)
+}
+
+// revisionDiff is the unified diff of the snippet and the index block
+// between two revisions; from may be nil (nothing before).
+func revisionDiff(from *state.ShareRevision, to state.ShareRevision) []diff.Line {
+	label := func(r *state.ShareRevision, s *string, file string) string {
+		if r == nil || s == nil {
+			return "/dev/null"
+		}
+		return fmt.Sprintf("%s (revision %d)", file, r.ID)
+	}
+	content := func(s *string) string {
+		if s == nil {
+			return ""
+		}
+		return *s
+	}
+	var fromSnippet, fromBlock *string
+	if from != nil {
+		fromSnippet, fromBlock = from.Snippet, from.IndexBlock
+	}
+	file := to.Share + ".conf"
+	res := diff.Unified(label(from, fromSnippet, file), label(&to, to.Snippet, file), content(fromSnippet), content(to.Snippet), diffContext)
+	return append(res, diff.Unified(label(from, fromBlock, "index"), label(&to, to.IndexBlock, "index"), strings.TrimPrefix(content(fromBlock), "\n"), strings.TrimPrefix(content(to.IndexBlock), "\n"), diffContext)...)
+}
+
+// diffLine is a diff line for the template.
+type diffLine struct{ diff.Line }
+
+func (l diffLine) Class() string {
+	switch l.Kind {
+	case "add":
+		return "text-success"
+	case "del":
+		return "text-danger"
+	case "hunk":
+		return "text-info"
+	case "file":
+		return "fw-bold"
+	}
+	return ""
+}
+
+func diffLines(lines []diff.Line) []diffLine {
+	res := make([]diffLine, len(lines))
+	for i, l := range lines {
+		res[i] = diffLine{l}
+	}
+	return res
+}
+
+// RevisionView is a revision with its changes against the one before.
+type RevisionView struct {
+	state.ShareRevision
+	Diff    []diffLine
+	Current bool // matches the files now
+}
+
+// Deleted reports whether the share did not exist in this revision.
+func (r RevisionView) Deleted() bool { return r.Snippet == nil && r.IndexBlock == nil }
+
+// ShareHistoryView is the /shares/history page.
+type ShareHistoryView struct {
+	Name      string
+	Done      string
+	Error     string
+	Revisions []RevisionView
+
+	// Compare is the diff between the revisions From and To, if Compared.
+	From, To int64
+	Compared bool
+	Compare  []diffLine
+}
+
+// shareHistory returns the revisions of name, newest first, each with its
+// diff against the previous one.
+func (a *App) shareHistory(name string) ([]RevisionView, error) {
+	revs, err := a.store.ListShareRevisions(name)
+	if err != nil {
+		return nil, err
+	}
+	snippet, block, err := shareState(name)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]RevisionView, len(revs))
+	for i, r := range revs {
+		var prev *state.ShareRevision
+		if i+1 < len(revs) {
+			prev = &revs[i+1]
+		}
+		res[i] = RevisionView{ShareRevision: r, Diff: diffLines(revisionDiff(prev, r))}
+	}
+	if len(res) > 0 {
+		res[0].Current = sameContent(res[0].Snippet, snippet) && sameContent(res[0].IndexBlock, block)
+	}
+	return res, nil
+}
+
+// compareRevisions diffs two revisions of share name.
+func (a *App) compareRevisions(name string, from, to int64) ([]diff.Line, error) {
+	var revs [2]state.ShareRevision
+	for i, id := range []int64{from, to} {
+		r, ok, err := a.store.GetShareRevision(id)
+		if err != nil {
+			return nil, err
+		}
+		if !ok || r.Share != name {
+			return nil, opErr(http.StatusNotFound, "share %s has no revision %d", name, id)
+		}
+		revs[i] = r
+	}
+	return revisionDiff(&revs[0], revs[1]), nil
+}
+
+func (a *App) shareHistoryPage(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	v := ShareHistoryView{Name: strings.TrimSpace(q.Get("name")), Done: q.Get("done")}
+	if v.Name == "" {
+		http.Redirect(w, r, "/shares", http.StatusSeeOther)
+		return
+	}
+	status := http.StatusOK
+	revs, err := a.shareHistory(v.Name)
+	if err != nil {
+		v.Error, status = err.Error(), errStatus(err)
+	}
+	v.Revisions = revs
+
+	if len(revs) > 1 {
+		v.From, v.To = revs[1].ID, revs[0].ID
+	}
+	if q.Get("from") != "" || q.Get("to") != "" {
+		v.Compared = true
+		v.From, _ = strconv.ParseInt(q.Get("from"), 10, 64)
+		v.To, _ = strconv.ParseInt(q.Get("to"), 10, 64)
+		lines, err := a.compareRevisions(v.Name, v.From, v.To)
+		if err != nil {
+			v.Error, status = err.Error(), errStatus(err)
+		}
+		v.Compare = diffLines(lines)
+	}
+	a.renderStatus(w, r, status, "share_history.html", "History: "+v.Name, v)
+}
+
+func (a *App) shareRollback(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/shares", http.StatusSeeOther)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	name := strings.TrimSpace(r.FormValue("name"))
+	id, _ := strconv.ParseInt(r.FormValue("revision"), 10, 64)
+
+	err := a.rollbackShare(actorOf(r), name, id)
+	a.audit(actorOf(r), "share.rollback", fmt.Sprintf("%s@%d", name, id), err)
+	if err != nil {
+		v := ShareHistoryView{Name: name, Error: err.Error()}
+		v.Revisions, _ = a.shareHistory(name)
+		a.renderStatus(w, r, errStatus(err), "share_history.html", "History: "+name, v)
+		return
+	}
+	done := fmt.Sprintf("Rolled back to revision %d.", id)
+	http.Redirect(w, r, "/shares/history?name="+url.QueryEscape(name)+"&done="+url.QueryEscape(done), http.StatusSeeOther)
+}
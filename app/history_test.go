@@ -0,0 +1,161 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/florianibach/samba-admin-ui/internal/samba"
+	"github.com/florianibach/samba-admin-ui/internal/samba/sambatest"
+)
+
+func diffText(lines []diffLine) string {
+	var b strings.Builder
+	for _, l := range lines {
+		b.WriteString(l.Text + "\n")
+	}
+	return b.String()
+}
+
+func TestShareHistoryAndRollback(t *testing.T) {
+	sys := sambatest.Install(t)
+	a := newTestApp(t)
+	dir := t.TempDir()
+	sharesDir := filepath.Join(dir, "ui")
+	indexPath := filepath.Join(sharesDir, "shares.conf")
+	t.Setenv("UI_SHARES_DIR", sharesDir)
+	t.Setenv("UI_SHARES_INDEX", indexPath)
+	a.smbConf = filepath.Join(dir, "smb.conf")
+	must(t, os.WriteFile(a.smbConf, []byte("[global]\n   include = "+indexPath+"\n"), 0o644))
+	alice := actor{Name: "alice"}
+	snippet := filepath.Join(sharesDir, "media.conf")
+
+	must(t, a.createShare(alice, samba.CreateShareOptions{Name: "media", Path: "/shares/media", Browseable: true}))
+	must(t, a.updateShare(alice, "media", samba.CreateShareOptions{Name: "media", Path: "/shares/media", Browseable: true, ReadOnly: true}))
+	must(t, a.setShareState(alice, "media", true, shareChange{}))
+	must(t, a.deleteShare(alice, "media", shareChange{}))
+
+	revs, err := a.shareHistory("media")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var actions []string
+	for _, r := range revs {
+		actions = append(actions, r.Action)
+		if r.Actor != "alice" {
+			t.Errorf("revision %d by %q", r.ID, r.Actor)
+		}
+	}
+	if strings.Join(actions, " ") != "share.delete share.disable share.update share.create" {
+		t.Fatalf("actions = %q", actions)
+	}
+	if !revs[0].Deleted() || !revs[0].Current {
+		t.Errorf("newest revision = %+v", revs[0])
+	}
+	if got := diffText(revs[2].Diff); !strings.Contains(got, "-read only = no\n+read only = yes\n") {
+		t.Errorf("update diff:\n%s", got)
+	}
+
+	// roll back to the edited, enabled share
+	must(t, a.rollbackShare(alice, "media", revs[2].ID))
+	if got, _ := os.ReadFile(snippet); !strings.Contains(string(got), "read only = yes") {
+		t.Errorf("snippet after rollback = %q", got)
+	}
+	if st, _ := samba.ReadManagedSharesIndex(indexPath); st["media"].Disabled {
+		t.Errorf("index after rollback = %+v", st)
+	}
+	revs, _ = a.shareHistory("media")
+	if revs[0].Action != "share.rollback" || !revs[0].Current {
+		t.Errorf("rollback revision = %+v", revs[0].ShareRevision)
+	}
+
+	// a revision testparm rejects leaves everything as it is
+	before, _ := os.ReadFile(snippet)
+	sys.TestparmError = "Unknown parameter encountered"
+	if err := a.rollbackShare(alice, "media", revs[len(revs)-1].ID); errStatus(err) != 400 {
+		t.Fatalf("rollback = %v", err)
+	}
+	if got, _ := os.ReadFile(snippet); string(got) != string(before) {
+		t.Errorf("snippet changed to %q", got)
+	}
+	if n, _ := a.store.ListShareRevisions("media"); len(n) != len(revs) {
+		t.Errorf("%d revisions after a failed rollback, want %d", len(n), len(revs))
+	}
+	if err := a.rollbackShare(alice, "other", revs[0].ID); errStatus(err) != 404 {
+		t.Errorf("revision of another share = %v", err)
+	}
+}
+
+func TestShareHistoryBaseline(t *testing.T) {
+	sambatest.Install(t)
+	a := newTestApp(t)
+	dir := t.TempDir()
+	sharesDir := filepath.Join(dir, "ui")
+	indexPath := filepath.Join(sharesDir, "shares.conf")
+	t.Setenv("UI_SHARES_DIR", sharesDir)
+	t.Setenv("UI_SHARES_INDEX", indexPath)
+	a.smbConf = filepath.Join(dir, "smb.conf")
+	must(t, os.WriteFile(a.smbConf, []byte("[global]\n   include = "+indexPath+"\n"), 0o644))
+
+	// written before the history existed
+	must(t, os.MkdirAll(sharesDir, 0o755))
+	must(t, os.WriteFile(filepath.Join(sharesDir, "old.conf"), []byte("path = /shares/old\n"), 0o644))
+	must(t, samba.EnsureIndexReferencesShare(indexPath, "old", filepath.Join(sharesDir, "old.conf")))
+
+	must(t, a.setShareState(actor{Name: "bob"}, "old", true, shareChange{}))
+	revs, _ := a.shareHistory("old")
+	if len(revs) != 2 || revs[1].Action != "share.baseline" || revs[1].Actor != "system" || revs[0].Actor != "bob" {
+		t.Fatalf("revisions = %+v", revs)
+	}
+	if revs[1].Snippet == nil || *revs[1].Snippet != "path = /shares/old\n" {
+		t.Errorf("baseline snippet = %v", revs[1].Snippet)
+	}
+}
+
+func TestRollbackRejectedLeavesFilesAlone(t *testing.T) {
+	sys := sambatest.Install(t)
+	a := newTestApp(t)
+	sharesDir, indexPath := withShareDirs(t, a)
+	alice := actor{Name: "alice"}
+	snippet := filepath.Join(sharesDir, "media.conf")
+
+	must(t, a.createShare(alice, samba.CreateShareOptions{Name: "media", Path: "/shares/media", Browseable: true}))
+	must(t, a.updateShare(alice, "media", samba.CreateShareOptions{Name: "media", Path: "/shares/films", Browseable: true, ReadOnly: true}))
+	must(t, a.setShareState(alice, "media", true, shareChange{}))
+	revs, _ := a.shareHistory("media")
+	beforeSnippet, _ := os.ReadFile(snippet)
+	beforeIndex, _ := os.ReadFile(indexPath)
+
+	// testparm must never see the live files changed
+	var touched []string
+	sys.Script("testparm", func(stdin string, args []string) (string, string, int) {
+		if b, _ := os.ReadFile(snippet); string(b) != string(beforeSnippet) {
+			touched = append(touched, "snippet")
+		}
+		if b, _ := os.ReadFile(indexPath); string(b) != string(beforeIndex) {
+			touched = append(touched, "index")
+		}
+		return "", "Unknown parameter encountered\n", 1
+	})
+
+	err := a.rollbackShare(alice, "media", revs[len(revs)-1].ID)
+	if errStatus(err) != 400 || !strings.Contains(err.Error(), "Unknown parameter") {
+		t.Fatalf("rollback = %v", err)
+	}
+	if len(touched) != 0 {
+		t.Errorf("live files changed before testparm: %q", touched)
+	}
+	if got, _ := os.ReadFile(snippet); string(got) != string(beforeSnippet) {
+		t.Errorf("snippet changed to %q", got)
+	}
+	if got, _ := os.ReadFile(indexPath); string(got) != string(beforeIndex) {
+		t.Errorf("index changed to %q", got)
+	}
+	if entries, _ := os.ReadDir(sharesDir); len(entries) != 2 {
+		t.Errorf("share directory holds %d files", len(entries))
+	}
+	if n, _ := a.store.ListShareRevisions("media"); len(n) != len(revs) {
+		t.Errorf("%d revisions after a failed rollback, want %d", len(n), len(revs))
+	}
+}
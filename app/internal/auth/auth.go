@@ -0,0 +1,92 @@
+package auth
+
+import (
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	hashScheme     = "pbkdf2-sha256"
+	hashIterations = 210000
+	hashKeyLen     = 32
+	saltLen        = 16
+)
+
+// HashPassword returns an encoded PBKDF2-SHA256 hash of pw:
+// "pbkdf2-sha256$<iterations>$<salt>$<key>" (salt/key base64, unpadded).
+func HashPassword(pw string) (string, error) {
+	salt := make([]byte, saltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key, err := pbkdf2.Key(sha256.New, pw, salt, hashIterations, hashKeyLen)
+	if err != nil {
+		return "", err
+	}
+	enc := base64.RawStdEncoding
+	return fmt.Sprintf("%s$%d$%s$%s", hashScheme, hashIterations, enc.EncodeToString(salt), enc.EncodeToString(key)), nil
+}
+
+// CheckPassword reports whether pw matches an encoded hash from HashPassword.
+func CheckPassword(encoded, pw string) bool {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 4 || parts[0] != hashScheme {
+		return false
+	}
+	iter, err := strconv.Atoi(parts[1])
+	if err != nil || iter <= 0 {
+		return false
+	}
+	enc := base64.RawStdEncoding
+	salt, err := enc.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+	want, err := enc.DecodeString(parts[3])
+	if err != nil {
+		return false
+	}
+	got, err := pbkdf2.Key(sha256.New, pw, salt, iter, len(want))
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare(got, want) == 1
+}
+
+// NewToken returns a random URL-safe token for sessions and similar secrets.
+func NewToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// TokenHash is what gets stored for a token, so a leaked DB does not leak
+// usable tokens.
+func TokenHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CSRFToken derives the CSRF token for a session from its (secret) session
+// token, so it is stable for the session's lifetime and needs no storage.
+func CSRFToken(sessionToken string) string {
+	return TokenHash("csrf:" + sessionToken)
+}
+
+// CheckCSRF compares a submitted CSRF token against the session's token in
+// constant time.
+func CheckCSRF(sessionToken, submitted string) bool {
+	if sessionToken == "" || submitted == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(CSRFToken(sessionToken)), []byte(submitted)) == 1
+}
@@ -0,0 +1,58 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPasswordHash(t *testing.T) {
+	hash, err := HashPassword("S3cure!pass")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(hash, hashScheme+"$") {
+		t.Errorf("hash = %q", hash)
+	}
+	if again, _ := HashPassword("S3cure!pass"); again == hash {
+		t.Error("same hash twice; salt not random")
+	}
+	parts := strings.Split(hash, "$")
+
+	for _, tc := range []struct {
+		name, encoded, pw string
+		want              bool
+	}{
+		{"right password", hash, "S3cure!pass", true},
+		{"wrong password", hash, "S3cure!pasS", false},
+		{"empty password", hash, "", false},
+		{"empty hash", "", "S3cure!pass", false},
+		{"other scheme", "bcrypt$" + strings.Join(parts[1:], "$"), "S3cure!pass", false},
+		{"missing part", strings.Join(parts[:3], "$"), "S3cure!pass", false},
+		{"bad iterations", strings.Join([]string{parts[0], "x", parts[2], parts[3]}, "$"), "S3cure!pass", false},
+		{"zero iterations", strings.Join([]string{parts[0], "0", parts[2], parts[3]}, "$"), "S3cure!pass", false},
+		{"bad salt", strings.Join([]string{parts[0], parts[1], "!!", parts[3]}, "$"), "S3cure!pass", false},
+		{"bad key", strings.Join([]string{parts[0], parts[1], parts[2], "!!"}, "$"), "S3cure!pass", false},
+	} {
+		if got := CheckPassword(tc.encoded, tc.pw); got != tc.want {
+			t.Errorf("%s: CheckPassword = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestCSRF(t *testing.T) {
+	tok := CSRFToken("session")
+	for _, tc := range []struct {
+		session, submitted string
+		want               bool
+	}{
+		{"session", tok, true},
+		{"session", tok + "x", false},
+		{"other", tok, false},
+		{"session", "", false},
+		{"", CSRFToken(""), false},
+	} {
+		if got := CheckCSRF(tc.session, tc.submitted); got != tc.want {
+			t.Errorf("CheckCSRF(%q, %q) = %v, want %v", tc.session, tc.submitted, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,265 @@
+// Package backup reads and writes configuration backup bundles: gzipped tar
+// archives with a manifest.json listing every other member with its size
+// and SHA-256.
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	Format  = "samba-admin-ui-backup"
+	Version = 1
+
+	ManifestPath  = "manifest.json"
+	DBPath        = "db/app.db"
+	IndexPath     = "shares/index.conf"
+	SnippetPrefix = "shares/snippets/"
+	PassdbPath    = "passdb/smbpasswd"
+)
+
+// ErrInvalid marks bundles that fail validation.
+var ErrInvalid = errors.New("invalid backup")
+
+func invalid(format string, args ...any) error {
+	return fmt.Errorf("%w: %s", ErrInvalid, fmt.Sprintf(format, args...))
+}
+
+// File is a manifest entry.
+type File struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// Manifest describes a bundle; it is stored as manifest.json.
+type Manifest struct {
+	Format    string    `json:"format"`
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"created_at"`
+	Host      string    `json:"host,omitempty"`
+	// SharesDir and IndexFile are where the share files lived; include
+	// lines in the index refer to SharesDir.
+	SharesDir string `json:"shares_dir"`
+	IndexFile string `json:"index_file"`
+	Files     []File `json:"files"`
+}
+
+// Bundle is a backup held in memory.
+type Bundle struct {
+	Manifest Manifest
+	data     map[string][]byte
+}
+
+// New returns an empty bundle.
+func New(sharesDir, indexFile, host string) *Bundle {
+	return &Bundle{
+		Manifest: Manifest{
+			Format:    Format,
+			Version:   Version,
+			CreatedAt: time.Now().UTC(),
+			Host:      host,
+			SharesDir: sharesDir,
+			IndexFile: indexFile,
+		},
+		data: map[string][]byte{},
+	}
+}
+
+// Add adds or replaces a member.
+func (b *Bundle) Add(name string, data []byte) {
+	sum := sha256.Sum256(data)
+	f := File{Path: name, Size: int64(len(data)), SHA256: hex.EncodeToString(sum[:])}
+	if i := slices.IndexFunc(b.Manifest.Files, func(f File) bool { return f.Path == name }); i >= 0 {
+		b.Manifest.Files[i] = f
+	} else {
+		b.Manifest.Files = append(b.Manifest.Files, f)
+	}
+	b.data[name] = data
+}
+
+// Get returns the content of a member.
+func (b *Bundle) Get(name string) ([]byte, bool) {
+	d, ok := b.data[name]
+	return d, ok
+}
+
+// Snippets returns the share files by file name.
+func (b *Bundle) Snippets() map[string][]byte {
+	res := map[string][]byte{}
+	for name, d := range b.data {
+		if base, ok := strings.CutPrefix(name, SnippetPrefix); ok {
+			res[base] = d
+		}
+	}
+	return res
+}
+
+// WriteTo writes the bundle as tar.gz.
+func (b *Bundle) WriteTo(w io.Writer) (int64, error) {
+	cw := &countWriter{w: w}
+	gz := gzip.NewWriter(cw)
+	tw := tar.NewWriter(gz)
+
+	manifest, err := json.MarshalIndent(b.Manifest, "", "  ")
+	if err != nil {
+		return cw.n, err
+	}
+	add := func(name string, data []byte) error {
+		hdr := &tar.Header{
+			Name:    name,
+			Mode:    0600,
+			Size:    int64(len(data)),
+			ModTime: b.Manifest.CreatedAt,
+			Format:  tar.FormatPAX,
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		_, err := tw.Write(data)
+		return err
+	}
+	if err := add(ManifestPath, append(manifest, '\n')); err != nil {
+		return cw.n, err
+	}
+	for _, f := range b.Manifest.Files {
+		if err := add(f.Path, b.data[f.Path]); err != nil {
+			return cw.n, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return cw.n, err
+	}
+	err = gz.Close()
+	return cw.n, err
+}
+
+// Bytes returns the bundle as tar.gz.
+func (b *Bundle) Bytes() ([]byte, error) {
+	var buf bytes.Buffer
+	_, err := b.WriteTo(&buf)
+	return buf.Bytes(), err
+}
+
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// validName accepts the member names a bundle may contain.
+func validName(name string) bool {
+	if name != path.Clean(name) || path.IsAbs(name) || strings.HasPrefix(name, "../") {
+		return false
+	}
+	switch name {
+	case DBPath, IndexPath, PassdbPath:
+		return true
+	}
+	base, ok := strings.CutPrefix(name, SnippetPrefix)
+	return ok && base != "" && !strings.ContainsAny(base, "/\\") && strings.HasSuffix(base, ".conf") && !strings.HasPrefix(base, ".")
+}
+
+// Read reads and validates a bundle: the manifest must be the first member
+// and of a known format, every other member must be listed with matching
+// size and checksum, and every listed member must be present. The
+// uncompressed content may not exceed limit bytes.
+func Read(r io.Reader, limit int64) (*Bundle, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, invalid("not a gzip file: %s", err)
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+
+	b := &Bundle{data: map[string][]byte{}}
+	var total int64
+	first := true
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, invalid("reading archive: %s", err)
+		}
+		if hdr.Typeflag == tar.TypeDir {
+			continue
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			return nil, invalid("%s is not a regular file", hdr.Name)
+		}
+		total += hdr.Size
+		if hdr.Size < 0 || total > limit {
+			return nil, invalid("content larger than %d bytes", limit)
+		}
+		data, err := io.ReadAll(io.LimitReader(tr, hdr.Size))
+		if err != nil {
+			return nil, invalid("reading %s: %s", hdr.Name, err)
+		}
+
+		if first {
+			if hdr.Name != ManifestPath {
+				return nil, invalid("%s must be the first member", ManifestPath)
+			}
+			if err := json.Unmarshal(data, &b.Manifest); err != nil {
+				return nil, invalid("manifest: %s", err)
+			}
+			if b.Manifest.Format != Format {
+				return nil, invalid("unknown format %q", b.Manifest.Format)
+			}
+			if b.Manifest.Version < 1 || b.Manifest.Version > Version {
+				return nil, invalid("format version %d is not supported (this build reads up to %d)", b.Manifest.Version, Version)
+			}
+			first = false
+			continue
+		}
+
+		if !validName(hdr.Name) {
+			return nil, invalid("unexpected member %s", hdr.Name)
+		}
+		if _, dup := b.data[hdr.Name]; dup {
+			return nil, invalid("%s appears twice", hdr.Name)
+		}
+		i := slices.IndexFunc(b.Manifest.Files, func(f File) bool { return f.Path == hdr.Name })
+		if i < 0 {
+			return nil, invalid("%s is not listed in the manifest", hdr.Name)
+		}
+		sum := sha256.Sum256(data)
+		if f := b.Manifest.Files[i]; f.Size != int64(len(data)) || f.SHA256 != hex.EncodeToString(sum[:]) {
+			return nil, invalid("checksum mismatch for %s", hdr.Name)
+		}
+		b.data[hdr.Name] = data
+	}
+	if first {
+		return nil, invalid("empty archive")
+	}
+	for _, f := range b.Manifest.Files {
+		if _, ok := b.data[f.Path]; !ok {
+			return nil, invalid("%s is listed in the manifest but missing", f.Path)
+		}
+	}
+	for _, required := range []string{DBPath, IndexPath, PassdbPath} {
+		if _, ok := b.data[required]; !ok {
+			return nil, invalid("%s is missing", required)
+		}
+	}
+	return b, nil
+}
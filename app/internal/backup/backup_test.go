@@ -0,0 +1,109 @@
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func testBundle() *Bundle {
+	b := New("/etc/samba/shares.d/ui", "/etc/samba/shares.d/ui/shares.conf", "nas")
+	b.Add(DBPath, []byte("SQLite format 3\x00"))
+	b.Add(IndexPath, []byte("[media]\n   include = /etc/samba/shares.d/ui/media.conf\n"))
+	b.Add(SnippetPrefix+"media.conf", []byte("path = /shares/media\n"))
+	b.Add(PassdbPath, []byte("alice:1000:XXXX:AAAA:[U          ]:LCT-65920000:\n"))
+	return b
+}
+
+func TestRoundTrip(t *testing.T) {
+	data, err := testBundle().Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := Read(bytes.NewReader(data), 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Manifest.Host != "nas" || len(b.Manifest.Files) != 4 {
+		t.Errorf("manifest = %+v", b.Manifest)
+	}
+	if s := b.Snippets(); len(s) != 1 || string(s["media.conf"]) != "path = /shares/media\n" {
+		t.Errorf("snippets = %q", s)
+	}
+}
+
+// archive builds a tar.gz from name/content pairs.
+func archive(t *testing.T, members ...string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for i := 0; i < len(members); i += 2 {
+		if err := tw.WriteHeader(&tar.Header{Name: members[i], Mode: 0600, Size: int64(len(members[i+1]))}); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write([]byte(members[i+1]))
+	}
+	tw.Close()
+	gz.Close()
+	return buf.Bytes()
+}
+
+func TestReadRejects(t *testing.T) {
+	good := testBundle()
+	manifest := func(mod func(*Manifest)) string {
+		m := good.Manifest
+		m.Files = append([]File(nil), m.Files...)
+		mod(&m)
+		b := &Bundle{Manifest: m, data: map[string][]byte{}}
+		data, _ := b.Bytes()
+		// the manifest is the first member; take it back out
+		gz, _ := gzip.NewReader(bytes.NewReader(data))
+		tr := tar.NewReader(gz)
+		tr.Next()
+		var out bytes.Buffer
+		out.ReadFrom(tr)
+		return out.String()
+	}
+	members := func(m string) []string {
+		res := []string{ManifestPath, m}
+		for _, f := range good.Manifest.Files {
+			d, _ := good.Get(f.Path)
+			res = append(res, f.Path, string(d))
+		}
+		return res
+	}
+	ok := manifest(func(*Manifest) {})
+	tampered := members(ok)
+	tampered[len(tampered)-1] = "bob:1001:X:Y:[U          ]:LCT-0:\n"
+
+	for _, tc := range []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"not gzip", []byte("hello"), "not a gzip file"},
+		{"no manifest first", archive(t, DBPath, "x"), "must be the first member"},
+		{"wrong format", archive(t, ManifestPath, manifest(func(m *Manifest) { m.Format = "other" })), "unknown format"},
+		{"newer version", archive(t, ManifestPath, manifest(func(m *Manifest) { m.Version = Version + 1 })), "not supported"},
+		{"tampered", archive(t, tampered...), "checksum mismatch"},
+		{"unlisted", archive(t, append(members(ok), SnippetPrefix+"extra.conf", "x")...), "not listed"},
+		{"traversal", archive(t, append(members(ok), "shares/snippets/../../etc/passwd", "x")...), "unexpected member"},
+		{"missing", archive(t, members(ok)[:4]...), "missing"},
+		{"too large", archive(t, members(ok)...), "larger than"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			limit := int64(1 << 20)
+			if tc.name == "too large" {
+				limit = 100
+			}
+			_, err := Read(bytes.NewReader(tc.data), limit)
+			if !errors.Is(err, ErrInvalid) || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("err = %v, want %q", err, tc.want)
+			}
+		})
+	}
+}
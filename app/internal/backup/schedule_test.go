@@ -0,0 +1,52 @@
+package backup
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScheduleNext(t *testing.T) {
+	at := func(s string) time.Time {
+		t.Helper()
+		v, err := time.ParseInLocation("2006-01-02 15:04", s, time.UTC)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	for _, tc := range []struct {
+		spec, from, want string
+	}{
+		{"30 3 * * *", "2026-10-17 02:00", "2026-10-17 03:30"},
+		{"30 3 * * *", "2026-10-17 03:30", "2026-10-18 03:30"},
+		{"*/15 * * * *", "2026-10-17 10:07", "2026-10-17 10:15"},
+		{"0 9-17/4 * * 1-5", "2026-10-17 10:00", "2026-10-19 09:00"}, // Saturday
+		{"@weekly", "2026-10-17 10:00", "2026-10-18 00:00"},
+		{"0 0 * * 7", "2026-10-17 10:00", "2026-10-18 00:00"},
+		{"0 0 1 * *", "2026-12-05 00:00", "2027-01-01 00:00"},
+		{"0 0 13 * 5", "2026-10-17 10:00", "2026-10-23 00:00"}, // day 13 or Friday
+		{"0 0 29 2 *", "2026-03-01 00:00", "2028-02-29 00:00"},
+		{"5,10 1 * * *", "2026-10-17 01:05", "2026-10-17 01:10"},
+	} {
+		s, err := ParseSchedule(tc.spec)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.spec, err)
+		}
+		if got := s.Next(at(tc.from)); !got.Equal(at(tc.want)) {
+			t.Errorf("%s from %s = %s, want %s", tc.spec, tc.from, got.Format("2006-01-02 15:04"), tc.want)
+		}
+	}
+
+	s, _ := ParseSchedule("0 0 31 2 *")
+	if got := s.Next(at("2026-01-01 00:00")); !got.IsZero() {
+		t.Errorf("impossible schedule matched %s", got)
+	}
+}
+
+func TestParseScheduleErrors(t *testing.T) {
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "0 0 0 * *", "*/0 * * * *", "5-1 * * * *", "a * * * *", "@yearly"} {
+		if _, err := ParseSchedule(spec); err == nil {
+			t.Errorf("%q accepted", spec)
+		}
+	}
+}
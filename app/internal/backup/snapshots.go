@@ -0,0 +1,180 @@
+package backup
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Snapshot is a bundle stored in a snapshot directory. Its file name holds
+// when and why it was taken: snapshot-20060102-150405.000-<reason>.tar.gz.
+type Snapshot struct {
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	Reason    string    `json:"reason"`
+	Size      int64     `json:"size"`
+}
+
+const (
+	snapshotPrefix = "snapshot-"
+	snapshotSuffix = ".tar.gz"
+	snapshotTime   = "20060102-150405.000"
+)
+
+var (
+	// ErrBadSnapshotName is returned for names that are not snapshot files.
+	ErrBadSnapshotName = errors.New("not a snapshot name")
+
+	snapshotName = regexp.MustCompile(`^snapshot-(\d{8}-\d{6}\.\d{3})-([a-z0-9-]{1,64})\.tar\.gz$`)
+	reasonUnsafe = regexp.MustCompile(`[^a-z0-9]+`)
+)
+
+// SnapshotReason turns s into the part of a file name that says why a
+// snapshot was taken: lower case letters, digits and dashes.
+func SnapshotReason(s string) string {
+	r := strings.Trim(reasonUnsafe.ReplaceAllString(strings.ToLower(s), "-"), "-")
+	if len(r) > 64 {
+		r = strings.TrimRight(r[:64], "-")
+	}
+	if r == "" {
+		r = "manual"
+	}
+	return r
+}
+
+// ParseSnapshotName returns the snapshot a file name describes.
+func ParseSnapshotName(name string) (Snapshot, error) {
+	m := snapshotName.FindStringSubmatch(name)
+	if m == nil {
+		return Snapshot{}, ErrBadSnapshotName
+	}
+	at, err := time.Parse(snapshotTime, m[1])
+	if err != nil {
+		return Snapshot{}, ErrBadSnapshotName
+	}
+	return Snapshot{Name: name, CreatedAt: at, Reason: m[2]}, nil
+}
+
+// SaveSnapshot writes b to dir, which is created if needed. The file is
+// named after the bundle's creation time and reason.
+func SaveSnapshot(dir string, b *Bundle, reason string) (Snapshot, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return Snapshot{}, err
+	}
+	// Names sort by time; a name that is taken moves a millisecond on.
+	var name string
+	for at := b.Manifest.CreatedAt.UTC(); ; at = at.Add(time.Millisecond) {
+		name = snapshotPrefix + at.Format(snapshotTime) + "-" + SnapshotReason(reason) + snapshotSuffix
+		if _, err := os.Lstat(filepath.Join(dir, name)); errors.Is(err, fs.ErrNotExist) {
+			break
+		}
+	}
+	s, err := ParseSnapshotName(name)
+	if err != nil {
+		return Snapshot{}, err
+	}
+
+	tmp, err := os.CreateTemp(dir, ".snapshot-*")
+	if err != nil {
+		return Snapshot{}, err
+	}
+	defer os.Remove(tmp.Name())
+	n, err := b.WriteTo(tmp)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return Snapshot{}, err
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(dir, name)); err != nil {
+		return Snapshot{}, err
+	}
+	s.Size = n
+	return s, nil
+}
+
+// ListSnapshots returns the snapshots in dir, newest first. A missing
+// directory has none; other files are ignored.
+func ListSnapshots(dir string) ([]Snapshot, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var res []Snapshot
+	for _, e := range entries {
+		s, err := ParseSnapshotName(e.Name())
+		if err != nil || !e.Type().IsRegular() {
+			continue
+		}
+		if info, err := e.Info(); err == nil {
+			s.Size = info.Size()
+		}
+		res = append(res, s)
+	}
+	slices.SortFunc(res, func(a, b Snapshot) int { return b.CreatedAt.Compare(a.CreatedAt) })
+	return res, nil
+}
+
+// OpenSnapshot reads and validates the snapshot name in dir.
+func OpenSnapshot(dir, name string, limit int64) (*Bundle, error) {
+	if _, err := ParseSnapshotName(name); err != nil {
+		return nil, err
+	}
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return nil, err
+	}
+	defer root.Close()
+	f, err := root.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Read(f, limit)
+}
+
+// Prune removes the snapshots in dir beyond the keep newest ones and those
+// older than maxAge; zero disables either limit. Snapshots are counted per
+// reason class, so a run of changes cannot push out the scheduled and
+// manual ones. The newest snapshot is always kept. It returns the names of
+// the removed snapshots.
+func Prune(dir string, keep int, maxAge time.Duration, now time.Time) ([]string, error) {
+	list, err := ListSnapshots(dir)
+	if err != nil {
+		return nil, err
+	}
+	seen := map[string]int{}
+	var removed []string
+	for i, s := range list {
+		class := reasonClass(s.Reason)
+		seen[class]++
+		if i == 0 {
+			continue
+		}
+		if (keep > 0 && seen[class] > keep) || (maxAge > 0 && now.Sub(s.CreatedAt) > maxAge) {
+			if err := os.Remove(filepath.Join(dir, s.Name)); err != nil {
+				return removed, err
+			}
+			removed = append(removed, s.Name)
+		}
+	}
+	return removed, nil
+}
+
+// reasonClass groups snapshot reasons for pruning: all the "before-..."
+// snapshots taken ahead of changes form one class, any other reason (such
+// as "scheduled" or "manual") its own.
+func reasonClass(reason string) string {
+	if strings.HasPrefix(reason, "before-") {
+		return "before-"
+	}
+	return reason
+}
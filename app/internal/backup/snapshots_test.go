@@ -0,0 +1,96 @@
+package backup
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestSnapshots(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "backups")
+	now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	var names []string
+	for i, age := range []time.Duration{40 * 24 * time.Hour, 3 * time.Hour, 2 * time.Hour, time.Hour} {
+		b := testBundle()
+		b.Manifest.CreatedAt = now.Add(-age)
+		s, err := SaveSnapshot(dir, b, []string{"scheduled", "Before shares/edit", "manual", ""}[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, s.Name)
+	}
+	if names[1] != "snapshot-20261017-090000.000-before-shares-edit.tar.gz" || names[3] != "snapshot-20261017-110000.000-manual.tar.gz" {
+		t.Errorf("names = %q", names)
+	}
+	os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("x"), 0600)
+
+	list, err := ListSnapshots(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 4 || list[0].Name != names[3] || list[3].Reason != "scheduled" || list[0].Size == 0 {
+		t.Fatalf("list = %+v", list)
+	}
+
+	b, err := OpenSnapshot(dir, names[2], 1<<20)
+	if err != nil || len(b.Snippets()) != 1 {
+		t.Fatalf("open = %v, %v", b, err)
+	}
+	for _, name := range []string{"notes.txt", "../backups/" + names[2], "snapshot-20261017-000000.000-gone.tar.gz"} {
+		if _, err := OpenSnapshot(dir, name, 1<<20); err == nil {
+			t.Errorf("opened %q", name)
+		}
+	}
+
+	removed, err := Prune(dir, 2, 30*24*time.Hour, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(removed, []string{names[0]}) {
+		t.Errorf("removed = %q", removed)
+	}
+	// the newest one stays even if it is too old
+	removed, _ = Prune(dir, 0, time.Minute, now)
+	if list, _ := ListSnapshots(dir); len(list) != 1 || list[0].Name != names[3] || len(removed) != 2 {
+		t.Errorf("after pruning by age: %+v", list)
+	}
+}
+
+func TestPruneCountsPerReason(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "backups")
+	now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	save := func(age time.Duration, reason string) string {
+		t.Helper()
+		b := testBundle()
+		b.Manifest.CreatedAt = now.Add(-age)
+		s, err := SaveSnapshot(dir, b, reason)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s.Name
+	}
+	scheduled := []string{save(48*time.Hour, "scheduled"), save(24*time.Hour, "scheduled")}
+	manual := save(12*time.Hour, "manual")
+	var before []string
+	for i := 10; i > 0; i-- {
+		before = append(before, save(time.Duration(i)*time.Minute, "before-shares-edit"))
+	}
+
+	removed, err := Prune(dir, 2, 0, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 8 {
+		t.Errorf("removed %d: %q", len(removed), removed)
+	}
+	var kept []string
+	list, _ := ListSnapshots(dir)
+	for _, s := range list {
+		kept = append(kept, s.Name)
+	}
+	if want := []string{before[9], before[8], manual, scheduled[1], scheduled[0]}; !slices.Equal(kept, want) {
+		t.Errorf("kept %q, want %q", kept, want)
+	}
+}
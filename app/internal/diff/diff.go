@@ -0,0 +1,156 @@
+// Package diff computes line-based unified diffs of small texts such as
+// share snippets.
+package diff
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Line is one line of a unified diff.
+type Line struct {
+	Kind string `json:"kind"` // file, hunk, context, add, del
+	Text string `json:"text"`
+}
+
+// maxCells bounds the LCS table; larger inputs are shown as replaced
+// entirely.
+const maxCells = 4 << 20
+
+type edit struct {
+	op   byte // ' ', '-', '+'
+	text string
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+// edits returns a shortest edit script from a to b (longest common
+// subsequence).
+func edits(a, b []string) []edit {
+	n, m := len(a), len(b)
+	var res []edit
+	if (n+1)*(m+1) > maxCells {
+		for _, l := range a {
+			res = append(res, edit{'-', l})
+		}
+		for _, l := range b {
+			res = append(res, edit{'+', l})
+		}
+		return res
+	}
+	// lcs[i][j] is the LCS length of a[i:] and b[j:].
+	lcs := make([][]int, n+1)
+	for i := range lcs {
+		lcs[i] = make([]int, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	i, j := 0, 0
+	for i < n || j < m {
+		switch {
+		case i < n && j < m && a[i] == b[j]:
+			res = append(res, edit{' ', a[i]})
+			i++
+			j++
+		case i < n && (j == m || lcs[i+1][j] >= lcs[i][j+1]):
+			res = append(res, edit{'-', a[i]})
+			i++
+		default:
+			res = append(res, edit{'+', b[j]})
+			j++
+		}
+	}
+	return res
+}
+
+// Unified compares from and to line by line and returns a unified diff
+// with context lines around each change. It is empty if they are equal.
+func Unified(fromName, toName, from, to string, context int) []Line {
+	es := edits(splitLines(from), splitLines(to))
+
+	// pos[k] counts the lines of from and to before edit k.
+	type pos struct{ a, b int }
+	pre := make([]pos, len(es)+1)
+	changed := false
+	for k, e := range es {
+		p := pre[k]
+		if e.op != '+' {
+			p.a++
+		}
+		if e.op != '-' {
+			p.b++
+		}
+		pre[k+1] = p
+		changed = changed || e.op != ' '
+	}
+	if !changed {
+		return nil
+	}
+
+	out := []Line{{"file", "--- " + fromName}, {"file", "+++ " + toName}}
+	for k := 0; k < len(es); {
+		for k < len(es) && es[k].op == ' ' {
+			k++
+		}
+		if k == len(es) {
+			break
+		}
+		start, end := max(k-context, 0), k
+		for {
+			for end < len(es) && es[end].op != ' ' {
+				end++
+			}
+			next := end
+			for next < len(es) && es[next].op == ' ' {
+				next++
+			}
+			if next < len(es) && next-end <= 2*context {
+				end = next
+				continue
+			}
+			end = min(end+context, len(es))
+			break
+		}
+
+		a, b := pre[start], pre[end]
+		out = append(out, Line{"hunk", fmt.Sprintf("@@ -%s +%s @@", hunkRange(a.a, b.a-a.a), hunkRange(a.b, b.b-a.b))})
+		for _, e := range es[start:end] {
+			kind := map[byte]string{' ': "context", '-': "del", '+': "add"}[e.op]
+			out = append(out, Line{kind, string(e.op) + e.text})
+		}
+		k = end
+	}
+	return out
+}
+
+func hunkRange(start, n int) string {
+	switch n {
+	case 0:
+		return fmt.Sprintf("%d,0", start)
+	case 1:
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, n)
+}
+
+// Format renders lines as the text of a unified diff.
+func Format(lines []Line) string {
+	var b strings.Builder
+	for _, l := range lines {
+		b.WriteString(l.Text)
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
@@ -0,0 +1,50 @@
+package diff
+
+import "testing"
+
+func TestUnified(t *testing.T) {
+	from := "a\nb\nc\nd\ne\nf\ng\nh\ni\nj\n"
+	to := "a\nB\nc\nd\ne\nf\ng\nh\ni\nj\nk\n"
+	want := `--- a
++++ b
+@@ -1,3 +1,3 @@
+ a
+-b
++B
+ c
+@@ -10 +10,2 @@
+ j
++k
+`
+	if got := Format(Unified("a", "b", from, to, 1)); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+
+	// close changes share a hunk
+	want = `--- a
++++ b
+@@ -1,4 +1,4 @@
+-a
++A
+ b
+-c
++C
+ d
+`
+	if got := Format(Unified("a", "b", "a\nb\nc\nd\ne\n", "A\nb\nC\nd\ne\n", 1)); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+
+	want = `--- /dev/null
++++ b
+@@ -0,0 +1,2 @@
++x
++y
+`
+	if got := Format(Unified("/dev/null", "b", "", "x\ny\n", 3)); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+	if got := Unified("a", "b", "same\n", "same\n", 3); got != nil {
+		t.Errorf("equal texts gave %v", got)
+	}
+}
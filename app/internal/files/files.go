@@ -0,0 +1,197 @@
+// Package files browses and creates directories below a fixed root
+// (SHARE_ROOT). All access goes through os.Root, so neither ".." nor
+// symlinks can reach anything outside of it.
+package files
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ErrOutsideRoot is returned for paths that leave the root.
+var ErrOutsideRoot = errors.New("path is outside the share root")
+
+var dirNameRx = regexp.MustCompile(`^[^/\x00]+$`)
+
+// Entry is a directory below the root.
+type Entry struct {
+	Name    string
+	Rel     string // slash separated, relative to the root; "." for the root
+	Symlink bool
+	UID     int
+	GID     int
+	Mode    fs.FileMode
+	ModTime time.Time
+}
+
+// Octal returns the permission bits like chmod takes them, e.g. "2770".
+func (e Entry) Octal() string {
+	return Octal(e.Mode)
+}
+
+// Octal formats the permission, setuid, setgid and sticky bits of m.
+func Octal(m fs.FileMode) string {
+	v := uint32(m.Perm())
+	if m&fs.ModeSetuid != 0 {
+		v |= 0o4000
+	}
+	if m&fs.ModeSetgid != 0 {
+		v |= 0o2000
+	}
+	if m&fs.ModeSticky != 0 {
+		v |= 0o1000
+	}
+	return fmt.Sprintf("%04o", v)
+}
+
+// Listing is the content of one directory.
+type Listing struct {
+	Dir   Entry
+	Dirs  []Entry
+	Files int // number of non-directory entries, which are not listed
+	// Skipped counts symlinks pointing outside the root or nowhere.
+	Skipped int
+}
+
+// Clean turns a user supplied path into a root relative one. Leading
+// slashes and ".." cannot climb above the root.
+func Clean(rel string) string {
+	c := path.Clean("/" + rel)
+	if c == "/" {
+		return "."
+	}
+	return c[1:]
+}
+
+// Parent returns the parent of rel, or "" for the root.
+func Parent(rel string) string {
+	if rel == "." {
+		return ""
+	}
+	return path.Dir(rel)
+}
+
+// Abs returns the absolute path of rel below root.
+func Abs(root, rel string) string {
+	if rel == "." {
+		return path.Clean(root)
+	}
+	return path.Join(root, rel)
+}
+
+// List reads the directory rel below root.
+func List(root, rel string) (*Listing, error) {
+	rel = Clean(rel)
+	r, err := os.OpenRoot(root)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	dir, err := stat(r, rel)
+	if err != nil {
+		return nil, err
+	}
+	if !dir.Mode.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", rel)
+	}
+
+	f, err := r.Open(rel)
+	if err != nil {
+		return nil, wrap(err)
+	}
+	defer f.Close()
+	ents, err := f.ReadDir(-1)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &Listing{Dir: dir}
+	for _, de := range ents {
+		child := path.Join(rel, de.Name())
+		e, err := stat(r, child)
+		if err != nil {
+			l.Skipped++
+			continue
+		}
+		if !e.Mode.IsDir() {
+			l.Files++
+			continue
+		}
+		l.Dirs = append(l.Dirs, e)
+	}
+	sort.Slice(l.Dirs, func(i, j int) bool { return strings.ToLower(l.Dirs[i].Name) < strings.ToLower(l.Dirs[j].Name) })
+	return l, nil
+}
+
+// Mkdir creates the directory name in rel and returns its relative path.
+func Mkdir(root, rel, name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "." || name == ".." || !dirNameRx.MatchString(name) {
+		return "", fmt.Errorf("invalid directory name %q", name)
+	}
+	r, err := os.OpenRoot(root)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+
+	child := path.Join(Clean(rel), name)
+	if err := r.Mkdir(child, 0755); err != nil {
+		return "", wrap(err)
+	}
+	return child, nil
+}
+
+// stat follows symlinks as long as they stay inside the root.
+func stat(r *os.Root, rel string) (Entry, error) {
+	lfi, err := r.Lstat(rel)
+	if err != nil {
+		return Entry{}, wrap(err)
+	}
+	fi := lfi
+	if lfi.Mode()&fs.ModeSymlink != 0 {
+		if fi, err = r.Stat(rel); err != nil {
+			return Entry{}, wrap(err)
+		}
+	}
+
+	e := Entry{
+		Name:    path.Base(rel),
+		Rel:     rel,
+		Symlink: lfi.Mode()&fs.ModeSymlink != 0,
+		UID:     -1,
+		GID:     -1,
+		Mode:    fi.Mode(),
+		ModTime: fi.ModTime(),
+	}
+	if rel == "." {
+		e.Name = "/"
+	}
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		e.UID = int(st.Uid)
+		e.GID = int(st.Gid)
+	}
+	return e, nil
+}
+
+// wrap reports root escapes as ErrOutsideRoot and strips the path from
+// other errors, which would otherwise repeat it.
+func wrap(err error) error {
+	var pe *fs.PathError
+	if errors.As(err, &pe) {
+		if strings.Contains(pe.Err.Error(), "escapes") {
+			return ErrOutsideRoot
+		}
+		return fmt.Errorf("%s: %w", pe.Path, pe.Err)
+	}
+	return err
+}
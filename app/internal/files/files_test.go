@@ -0,0 +1,80 @@
+package files
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestClean(t *testing.T) {
+	for in, want := range map[string]string{
+		"":          ".",
+		"/":         ".",
+		"a/b/":      "a/b",
+		"/a/../b":   "b",
+		"../../etc": "etc",
+	} {
+		if got := Clean(in); got != want {
+			t.Errorf("Clean(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestListAndMkdir(t *testing.T) {
+	outside := t.TempDir()
+	root := t.TempDir()
+	must(t, os.Mkdir(filepath.Join(root, "media"), 0o2770))
+	must(t, os.Chmod(filepath.Join(root, "media"), 0o2770|fs.ModeSetgid))
+	must(t, os.WriteFile(filepath.Join(root, "notes.txt"), nil, 0644))
+	must(t, os.Symlink("media", filepath.Join(root, "link")))
+	must(t, os.Symlink(outside, filepath.Join(root, "escape")))
+
+	l, err := List(root, "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(l.Dirs) != 2 || l.Dirs[0].Name != "link" || !l.Dirs[0].Symlink || l.Dirs[1].Name != "media" {
+		t.Fatalf("dirs = %+v", l.Dirs)
+	}
+	if l.Files != 1 || l.Skipped != 1 {
+		t.Errorf("files = %d, skipped = %d", l.Files, l.Skipped)
+	}
+	if got := l.Dirs[1].Octal(); got != "2770" {
+		t.Errorf("mode = %s, want 2770", got)
+	}
+
+	if _, err := List(root, "escape"); !errors.Is(err, ErrOutsideRoot) {
+		t.Errorf("List(escape) err = %v, want ErrOutsideRoot", err)
+	}
+	if _, err := Mkdir(root, "escape", "x"); !errors.Is(err, ErrOutsideRoot) {
+		t.Errorf("Mkdir(escape) err = %v, want ErrOutsideRoot", err)
+	}
+	if _, err := os.Stat(filepath.Join(outside, "x")); err == nil {
+		t.Error("directory created outside the root")
+	}
+
+	child, err := Mkdir(root, "media", "photos")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if child != "media/photos" {
+		t.Errorf("child = %q", child)
+	}
+	if _, err := Mkdir(root, "media", "photos"); !errors.Is(err, fs.ErrExist) {
+		t.Errorf("second Mkdir err = %v, want ErrExist", err)
+	}
+	for _, bad := range []string{"", "..", "a/b"} {
+		if _, err := Mkdir(root, ".", bad); err == nil {
+			t.Errorf("Mkdir(%q) succeeded", bad)
+		}
+	}
+}
+
+func must(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,178 @@
+package files
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Perms is the ownership and mode assigned by a permission preset.
+type Perms struct {
+	UID      int         // -1 keeps the current owner
+	GID      int         // -1 keeps the current group
+	DirMode  fs.FileMode // may include fs.ModeSetgid
+	FileMode fs.FileMode
+}
+
+func (p Perms) String() string {
+	return fmt.Sprintf("uid=%s gid=%s dirs=%s files=%s", keep(p.UID), keep(p.GID), Octal(p.DirMode), Octal(p.FileMode))
+}
+
+func keep(id int) string {
+	if id < 0 {
+		return "(unchanged)"
+	}
+	return fmt.Sprint(id)
+}
+
+// PermsResult summarizes a (recursive) ApplyPerms run.
+type PermsResult struct {
+	Changed int
+	Failed  int
+	Errors  []string // the first few failures
+}
+
+const maxPermErrors = 10
+
+// Rel returns abs relative to root, or ErrOutsideRoot if it is not below it.
+func Rel(root, abs string) (string, error) {
+	rel, err := filepath.Rel(filepath.Clean(root), filepath.Clean(abs))
+	if err != nil || rel == ".." || strings.HasPrefix(rel, "../") || filepath.IsAbs(rel) {
+		return "", ErrOutsideRoot
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+// Stat returns the entry for rel below root.
+func Stat(root, rel string) (Entry, error) {
+	r, err := os.OpenRoot(root)
+	if err != nil {
+		return Entry{}, err
+	}
+	defer r.Close()
+	return stat(r, Clean(rel))
+}
+
+// ApplyPerms sets p on the directory rel and, if recursive, on everything
+// below it. Symlinks are neither followed nor changed. progress, if not nil,
+// is called with the number of entries handled so far.
+func ApplyPerms(root, rel string, p Perms, recursive bool, progress func(done int)) (PermsResult, error) {
+	var res PermsResult
+	r, err := os.OpenRoot(root)
+	if err != nil {
+		return res, err
+	}
+	defer r.Close()
+
+	rel = Clean(rel)
+	fi, err := r.Lstat(rel)
+	if err != nil {
+		return res, wrap(err)
+	}
+	if !fi.IsDir() {
+		return res, fmt.Errorf("%s is not a directory", rel)
+	}
+
+	done := 0
+	set := func(name string, dir bool) {
+		mode := p.FileMode
+		if dir {
+			mode = p.DirMode
+		}
+		// chown first: it clears setgid on some systems
+		err := r.Lchown(name, p.UID, p.GID)
+		if err == nil {
+			err = r.Chmod(name, mode)
+		}
+		if err != nil {
+			res.Failed++
+			if len(res.Errors) < maxPermErrors {
+				res.Errors = append(res.Errors, wrap(err).Error())
+			}
+		} else {
+			res.Changed++
+		}
+		done++
+		if progress != nil {
+			progress(done)
+		}
+	}
+
+	if !recursive {
+		set(rel, true)
+		return res, nil
+	}
+
+	err = fs.WalkDir(r.FS(), rel, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			res.Failed++
+			if len(res.Errors) < maxPermErrors {
+				res.Errors = append(res.Errors, wrap(err).Error())
+			}
+			if d != nil && d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		switch {
+		case d.Type()&fs.ModeSymlink != 0:
+			return nil
+		case d.IsDir():
+			set(name, true)
+		case d.Type().IsRegular():
+			set(name, false)
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, fs.SkipDir) {
+		return res, err
+	}
+	return res, nil
+}
+
+// Count returns the number of entries ApplyPerms would visit below rel, for
+// progress display.
+func Count(root, rel string) (int, error) {
+	r, err := os.OpenRoot(root)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+
+	n := 0
+	err = fs.WalkDir(r.FS(), Clean(rel), func(name string, d fs.DirEntry, err error) error {
+		if err == nil && (d.IsDir() || d.Type().IsRegular()) {
+			n++
+		}
+		return nil
+	})
+	return n, err
+}
+
+// Dirs returns rel and all directories below it (relative to root), without
+// following symlinks.
+func Dirs(root, rel string) ([]string, error) {
+	r, err := os.OpenRoot(root)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	var dirs []string
+	err = fs.WalkDir(r.FS(), Clean(rel), func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if d != nil && d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			dirs = append(dirs, name)
+		}
+		return nil
+	})
+	return dirs, wrap(err)
+}
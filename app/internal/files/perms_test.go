@@ -0,0 +1,97 @@
+package files
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestRel(t *testing.T) {
+	if rel, err := Rel("/shares", "/shares/media/"); err != nil || rel != "media" {
+		t.Errorf("Rel = %q, %v", rel, err)
+	}
+	if rel, err := Rel("/shares", "/shares"); err != nil || rel != "." {
+		t.Errorf("Rel(root) = %q, %v", rel, err)
+	}
+	for _, p := range []string{"/", "/etc", "/shares/../etc", "/sharesx"} {
+		if _, err := Rel("/shares", p); !errors.Is(err, ErrOutsideRoot) {
+			t.Errorf("Rel(%q) err = %v", p, err)
+		}
+	}
+}
+
+func TestApplyPermsRecursive(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("chown needs root")
+	}
+	outside := t.TempDir()
+	must(t, os.WriteFile(filepath.Join(outside, "secret"), nil, 0600))
+	root := t.TempDir()
+	must(t, os.MkdirAll(filepath.Join(root, "media", "a", "b"), 0755))
+	must(t, os.WriteFile(filepath.Join(root, "media", "a", "f.txt"), nil, 0644))
+	must(t, os.Symlink(filepath.Join(outside, "secret"), filepath.Join(root, "media", "link")))
+
+	p := Perms{UID: -1, GID: 4242, DirMode: 0o770 | fs.ModeSetgid, FileMode: 0o660}
+	var last int
+	res, err := ApplyPerms(root, "media", p, true, func(done int) { last = done })
+	if err != nil {
+		t.Fatal(err)
+	}
+	// media, a, b and f.txt; the symlink is skipped
+	if res.Changed != 4 || res.Failed != 0 || last != 4 {
+		t.Fatalf("res = %+v, progress = %d", res, last)
+	}
+	if n, _ := Count(root, "media"); n != 4 {
+		t.Errorf("Count = %d", n)
+	}
+
+	dir, err := Stat(root, "media/a/b")
+	must(t, err)
+	if dir.Octal() != "2770" || dir.GID != 4242 {
+		t.Errorf("dir = %s gid=%d", dir.Octal(), dir.GID)
+	}
+	f, err := Stat(root, "media/a/f.txt")
+	must(t, err)
+	if f.Octal() != "0660" || f.GID != 4242 {
+		t.Errorf("file = %s gid=%d", f.Octal(), f.GID)
+	}
+	fi, err := os.Stat(filepath.Join(outside, "secret"))
+	must(t, err)
+	if fi.Mode().Perm() != 0600 {
+		t.Errorf("symlink target changed: %s", fi.Mode())
+	}
+}
+
+func TestApplyPermsTopOnly(t *testing.T) {
+	root := t.TempDir()
+	must(t, os.MkdirAll(filepath.Join(root, "home", "sub"), 0755))
+
+	res, err := ApplyPerms(root, "home", Perms{UID: -1, GID: -1, DirMode: 0o700, FileMode: 0o600}, false, nil)
+	if err != nil || res.Changed != 1 {
+		t.Fatalf("res = %+v, err = %v", res, err)
+	}
+	top, _ := Stat(root, "home")
+	sub, _ := Stat(root, "home/sub")
+	if top.Octal() != "0700" || sub.Octal() != "0755" {
+		t.Errorf("top = %s, sub = %s", top.Octal(), sub.Octal())
+	}
+}
+
+func TestDirs(t *testing.T) {
+	outside := t.TempDir()
+	root := t.TempDir()
+	must(t, os.MkdirAll(filepath.Join(root, "media", "a", "b"), 0755))
+	must(t, os.WriteFile(filepath.Join(root, "media", "a", "f.txt"), nil, 0644))
+	must(t, os.Symlink(outside, filepath.Join(root, "media", "link")))
+
+	dirs, err := Dirs(root, "media")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"media", "media/a", "media/a/b"}; !slices.Equal(dirs, want) {
+		t.Errorf("Dirs = %v, want %v", dirs, want)
+	}
+}
@@ -0,0 +1,112 @@
+// Package policy implements the password policy applied to Samba accounts.
+package policy
+
+import (
+	"bufio"
+	_ "embed"
+	"fmt"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+//go:embed common-passwords.txt
+var commonPasswordsTxt string
+
+var (
+	commonOnce      sync.Once
+	commonPasswords map[string]struct{}
+)
+
+// Password describes the requirements for a Samba account password.
+type Password struct {
+	MinLength int
+	// MinClasses is how many of lowercase, uppercase, digits and symbols a
+	// password has to contain (0-4).
+	MinClasses     int
+	RejectUsername bool
+	RejectCommon   bool
+}
+
+// Default is used when no PASSWORD_* settings are given.
+var Default = Password{
+	MinLength:      8,
+	MinClasses:     2,
+	RejectUsername: true,
+	RejectCommon:   true,
+}
+
+// Check returns a user-facing error if password violates p.
+func (p Password) Check(username, password string) error {
+	if password == "" {
+		return fmt.Errorf("password required")
+	}
+	if n := len([]rune(password)); n < p.MinLength {
+		return fmt.Errorf("password must be at least %d characters", p.MinLength)
+	}
+	if p.MinClasses > 0 && charClasses(password) < p.MinClasses {
+		return fmt.Errorf("password must contain at least %d of: lowercase letters, uppercase letters, digits, symbols", p.MinClasses)
+	}
+	if p.RejectUsername && username != "" && strings.Contains(strings.ToLower(password), strings.ToLower(username)) {
+		return fmt.Errorf("password must not contain the username")
+	}
+	if p.RejectCommon && isCommon(password) {
+		return fmt.Errorf("password is too common")
+	}
+	return nil
+}
+
+// Describe summarizes p for form hints.
+func (p Password) Describe() string {
+	parts := []string{fmt.Sprintf("at least %d characters", p.MinLength)}
+	if p.MinClasses > 0 {
+		parts = append(parts, fmt.Sprintf("%d of lowercase, uppercase, digits, symbols", p.MinClasses))
+	}
+	if p.RejectUsername {
+		parts = append(parts, "not containing the username")
+	}
+	if p.RejectCommon {
+		parts = append(parts, "not a common password")
+	}
+	s := strings.Join(parts, ", ")
+	return strings.ToUpper(s[:1]) + s[1:] + "."
+}
+
+func charClasses(s string) int {
+	var lower, upper, digit, other bool
+	for _, r := range s {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			other = true
+		}
+	}
+	n := 0
+	for _, b := range []bool{lower, upper, digit, other} {
+		if b {
+			n++
+		}
+	}
+	return n
+}
+
+func isCommon(password string) bool {
+	commonOnce.Do(func() {
+		commonPasswords = make(map[string]struct{})
+		sc := bufio.NewScanner(strings.NewReader(commonPasswordsTxt))
+		for sc.Scan() {
+			line := strings.TrimSpace(sc.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			commonPasswords[strings.ToLower(line)] = struct{}{}
+		}
+	})
+	_, ok := commonPasswords[strings.ToLower(password)]
+	return ok
+}
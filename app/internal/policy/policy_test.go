@@ -0,0 +1,54 @@
+package policy
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		p        Password
+		user, pw string
+		err      string // substring of the error; "" for none
+	}{
+		{"empty", Default, "alice", "", "required"},
+		{"too short", Default, "alice", "Ab1!xyz", "at least 8 characters"},
+		{"length counts runes", Password{MinLength: 4}, "alice", "äöüß", ""},
+		{"one class", Default, "alice", "abcdefghij", "at least 2 of"},
+		{"two classes", Default, "alice", "abcdefgh1", ""},
+		{"four classes required", Password{MinLength: 8, MinClasses: 4}, "alice", "Abcdefg1", "at least 4 of"},
+		{"four classes", Password{MinLength: 8, MinClasses: 4}, "alice", "Abcdef1!", ""},
+		{"symbols count", Password{MinLength: 8, MinClasses: 2}, "alice", "abcdefg!", ""},
+		{"contains username", Default, "alice", "myALICE99", "must not contain the username"},
+		{"username allowed", Password{MinLength: 8}, "alice", "myALICE99", ""},
+		{"no username", Default, "", "myALICE99", ""},
+		{"common", Default, "alice", "password1", "too common"},
+		{"common any case", Default, "alice", "PASSWORD1", "too common"},
+		{"common allowed", Password{MinLength: 8}, "alice", "password1", ""},
+		{"ok", Default, "alice", "Correct-Horse-7", ""},
+	} {
+		err := tc.p.Check(tc.user, tc.pw)
+		switch {
+		case tc.err == "" && err != nil:
+			t.Errorf("%s: unexpected error %v", tc.name, err)
+		case tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)):
+			t.Errorf("%s: error %v, want %q", tc.name, err, tc.err)
+		}
+	}
+}
+
+func TestDescribe(t *testing.T) {
+	for _, tc := range []struct {
+		p    Password
+		want string
+	}{
+		{Default, "At least 8 characters, 2 of lowercase, uppercase, digits, symbols, not containing the username, not a common password."},
+		{Password{MinLength: 12}, "At least 12 characters."},
+		{Password{MinLength: 10, MinClasses: 3, RejectCommon: true}, "At least 10 characters, 3 of lowercase, uppercase, digits, symbols, not a common password."},
+	} {
+		if got := tc.p.Describe(); got != tc.want {
+			t.Errorf("Describe(%+v) = %q, want %q", tc.p, got, tc.want)
+		}
+	}
+}
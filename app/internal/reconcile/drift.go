@@ -0,0 +1,410 @@
+package reconcile
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/florianibach/samba-admin-ui/internal/samba"
+	"github.com/florianibach/samba-admin-ui/internal/state"
+)
+
+// DriftKind classifies a difference between the DB (desired state) and the
+// live system that Apply does not take care of.
+type DriftKind string
+
+const (
+	DriftUnmanagedGroup      DriftKind = "unmanaged_group"      // Linux group not in DB
+	DriftGroupGID            DriftKind = "group_gid"            // DB gid differs from getent
+	DriftUnmanagedUser       DriftKind = "unmanaged_user"       // Linux user not in DB
+	DriftUserIDs             DriftKind = "user_ids"             // DB uid/gid differ from getent
+	DriftUnmanagedMembership DriftKind = "unmanaged_membership" // managed user in managed group, not in DB
+	DriftSambaOrphan         DriftKind = "samba_orphan"         // passdb entry without DB user
+)
+
+// Only accounts in this range are considered; everything below belongs to
+// the distribution, 65534 is nobody/nogroup.
+const (
+	minHumanID = 1000
+	nobodyID   = 65534
+)
+
+type DriftItem struct {
+	Kind  DriftKind `json:"kind"`
+	Name  string    `json:"name"`            // user or group name
+	Group string    `json:"group,omitempty"` // memberships only
+
+	Desired string `json:"desired,omitempty"` // DB side, empty if not in DB
+	Actual  string `json:"actual,omitempty"`  // system side
+
+	// Actions available for this item.
+	CanAdopt bool `json:"can_adopt"`
+	CanFix   bool `json:"can_fix"`
+
+	// actual values needed to adopt the item
+	uid, gid int
+}
+
+// Description is a one-line human readable summary.
+func (d DriftItem) Description() string {
+	switch d.Kind {
+	case DriftUnmanagedGroup:
+		return fmt.Sprintf("Linux group %s (%s) is not managed", d.Name, d.Actual)
+	case DriftGroupGID:
+		return fmt.Sprintf("group %s: DB has %s, system has %s", d.Name, d.Desired, d.Actual)
+	case DriftUnmanagedUser:
+		return fmt.Sprintf("Linux user %s (%s) is not managed", d.Name, d.Actual)
+	case DriftUserIDs:
+		return fmt.Sprintf("user %s: DB has %s, system has %s", d.Name, d.Desired, d.Actual)
+	case DriftUnmanagedMembership:
+		return fmt.Sprintf("%s is a member of %s, but not in the DB", d.Name, d.Group)
+	case DriftSambaOrphan:
+		return fmt.Sprintf("Samba user %s has no DB entry", d.Name)
+	}
+	return string(d.Kind) + " " + d.Name
+}
+
+// AdoptLabel / FixLabel describe what the two actions do for this item.
+func (d DriftItem) AdoptLabel() string {
+	switch d.Kind {
+	case DriftGroupGID, DriftUserIDs:
+		return "Take system IDs into DB"
+	case DriftUnmanagedMembership:
+		return "Add membership to DB"
+	}
+	return "Adopt into DB"
+}
+
+func (d DriftItem) FixLabel() string {
+	switch d.Kind {
+	case DriftUnmanagedGroup:
+		return "groupdel " + d.Name
+	case DriftGroupGID:
+		return "groupmod to " + d.Desired
+	case DriftUnmanagedUser:
+		return "userdel " + d.Name
+	case DriftUserIDs:
+		return "usermod to " + d.Desired
+	case DriftUnmanagedMembership:
+		return "gpasswd -d " + d.Name + " " + d.Group
+	case DriftSambaOrphan:
+		return "smbpasswd -x " + d.Name
+	}
+	return "Fix system"
+}
+
+// IsUser reports whether the item belongs on the users page (memberships
+// show up on both).
+func (d DriftItem) IsUser() bool {
+	return d.Kind != DriftUnmanagedGroup && d.Kind != DriftGroupGID
+}
+
+func (d DriftItem) IsGroup() bool {
+	return d.Kind == DriftUnmanagedGroup || d.Kind == DriftGroupGID || d.Kind == DriftUnmanagedMembership
+}
+
+type DriftReport struct {
+	Items []DriftItem `json:"items"`
+	// Warnings lists checks that could not be run (e.g. pdbedit missing).
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+func (r *DriftReport) UserItems() []DriftItem {
+	var res []DriftItem
+	for _, it := range r.Items {
+		if it.IsUser() {
+			res = append(res, it)
+		}
+	}
+	return res
+}
+
+func (r *DriftReport) GroupItems() []DriftItem {
+	var res []DriftItem
+	for _, it := range r.Items {
+		if it.IsGroup() {
+			res = append(res, it)
+		}
+	}
+	return res
+}
+
+// DetectDrift compares the DB with getent/pdbedit and lists what exists on
+// the system but not in the DB, and IDs that disagree. Missing objects are
+// not reported here; that is what Plan is for.
+func DetectDrift(store *state.Store) (*DriftReport, error) {
+	rep := &DriftReport{Items: []DriftItem{}}
+
+	groups, err := store.ListGroups()
+	if err != nil {
+		return nil, err
+	}
+	users, err := store.ListUsers()
+	if err != nil {
+		return nil, err
+	}
+	mems, err := store.ListMemberships()
+	if err != nil {
+		return nil, err
+	}
+
+	accounts, err := samba.LoadAccounts()
+	if err != nil {
+		return nil, err
+	}
+
+	dbGroups := map[string]state.Group{}
+	for _, g := range groups {
+		dbGroups[g.Name] = g
+	}
+	dbUsers := map[string]state.User{}
+	for _, u := range users {
+		dbUsers[u.Name] = u
+	}
+	dbMems := map[state.Membership]bool{}
+	for _, m := range mems {
+		dbMems[m] = true
+	}
+	// Groups
+	for _, lg := range accounts.Groups() {
+		if g, ok := dbGroups[lg.Name]; ok {
+			if g.GID != nil && *g.GID != lg.GID {
+				rep.Items = append(rep.Items, DriftItem{
+					Kind:     DriftGroupGID,
+					Name:     lg.Name,
+					Desired:  "gid=" + strconv.Itoa(*g.GID),
+					Actual:   "gid=" + strconv.Itoa(lg.GID),
+					CanAdopt: true,
+					CanFix:   true,
+					gid:      lg.GID,
+				})
+			}
+		} else if humanID(lg.GID) && !isUserPrivateGroup(lg, accounts) {
+			rep.Items = append(rep.Items, DriftItem{
+				Kind:     DriftUnmanagedGroup,
+				Name:     lg.Name,
+				Actual:   "gid=" + strconv.Itoa(lg.GID),
+				CanAdopt: true,
+				CanFix:   true,
+				gid:      lg.GID,
+			})
+		}
+
+		// Memberships of managed users in managed groups
+		if _, ok := dbGroups[lg.Name]; !ok {
+			continue
+		}
+		for _, member := range lg.Members {
+			if _, ok := dbUsers[member]; !ok {
+				continue
+			}
+			if dbMems[state.Membership{User: member, Group: lg.Name}] {
+				continue
+			}
+			rep.Items = append(rep.Items, DriftItem{
+				Kind:     DriftUnmanagedMembership,
+				Name:     member,
+				Group:    lg.Name,
+				Actual:   "member",
+				CanAdopt: true,
+				CanFix:   true,
+			})
+		}
+	}
+
+	// Users
+	for _, acc := range accounts.Users() {
+		if u, ok := dbUsers[acc.Name]; ok {
+			if (u.UID != nil && *u.UID != acc.UID) || (u.GID != nil && *u.GID != acc.GID) {
+				want := acc
+				if u.UID != nil {
+					want.UID = *u.UID
+				}
+				if u.GID != nil {
+					want.GID = *u.GID
+				}
+				rep.Items = append(rep.Items, DriftItem{
+					Kind:     DriftUserIDs,
+					Name:     acc.Name,
+					Desired:  fmt.Sprintf("uid=%d gid=%d", want.UID, want.GID),
+					Actual:   fmt.Sprintf("uid=%d gid=%d", acc.UID, acc.GID),
+					CanAdopt: true,
+					CanFix:   true,
+					uid:      acc.UID,
+					gid:      acc.GID,
+				})
+			}
+			continue
+		}
+		if humanID(acc.UID) {
+			rep.Items = append(rep.Items, DriftItem{
+				Kind:     DriftUnmanagedUser,
+				Name:     acc.Name,
+				Actual:   fmt.Sprintf("uid=%d gid=%d", acc.UID, acc.GID),
+				CanAdopt: true,
+				CanFix:   true,
+				uid:      acc.UID,
+				gid:      acc.GID,
+			})
+		}
+	}
+
+	// Samba passdb
+	sambaUsers, err := samba.ListSambaUsers()
+	if err != nil {
+		rep.Warnings = append(rep.Warnings, "Samba users not checked: "+err.Error())
+	}
+	for _, su := range sambaUsers {
+		if _, ok := dbUsers[su.Name]; ok {
+			continue
+		}
+		item := DriftItem{
+			Kind:   DriftSambaOrphan,
+			Name:   su.Name,
+			Actual: "passdb entry",
+			CanFix: true,
+		}
+		// Adopting needs the Linux account for its IDs.
+		if acc, ok := accounts.User(su.Name); ok {
+			item.CanAdopt = true
+			item.uid, item.gid = acc.UID, acc.GID
+		}
+		rep.Items = append(rep.Items, item)
+	}
+
+	sort.SliceStable(rep.Items, func(i, j int) bool {
+		if rep.Items[i].Kind != rep.Items[j].Kind {
+			return rep.Items[i].Kind < rep.Items[j].Kind
+		}
+		return rep.Items[i].Name < rep.Items[j].Name
+	})
+
+	return rep, nil
+}
+
+func humanID(id int) bool {
+	return id >= minHumanID && id != nobodyID
+}
+
+// isUserPrivateGroup reports whether g is the per-user group useradd creates
+// alongside an account of the same name.
+func isUserPrivateGroup(g samba.GroupEntry, accounts *samba.Accounts) bool {
+	acc, ok := accounts.User(g.Name)
+	return ok && acc.GID == g.GID
+}
+
+// ErrNoDrift is returned by AdoptDrift/FixDrift when the item is no longer
+// (or never was) reported by DetectDrift.
+var ErrNoDrift = errors.New("no such drift item")
+
+// findDrift re-detects drift and returns the current item matching the
+// identity sent back from the UI/API.
+func findDrift(store *state.Store, kind DriftKind, name, group string) (DriftItem, error) {
+	rep, err := DetectDrift(store)
+	if err != nil {
+		return DriftItem{}, err
+	}
+	for _, it := range rep.Items {
+		if it.Kind == kind && it.Name == name && it.Group == group {
+			return it, nil
+		}
+	}
+	return DriftItem{}, fmt.Errorf("%w: %s %s", ErrNoDrift, kind, name)
+}
+
+// AdoptDrift makes the DB match the system for one drift item.
+func AdoptDrift(store *state.Store, kind DriftKind, name, group string) error {
+	it, err := findDrift(store, kind, name, group)
+	if err != nil {
+		return err
+	}
+	if !it.CanAdopt {
+		return fmt.Errorf("%s cannot be adopted", it.Description())
+	}
+
+	switch it.Kind {
+	case DriftUnmanagedGroup:
+		gid := it.gid
+		return store.UpsertGroup(state.Group{Name: it.Name, GID: &gid})
+	case DriftGroupGID:
+		return store.UpdateGroupGID(it.Name, it.gid)
+	case DriftUnmanagedUser, DriftSambaOrphan:
+		uid, gid := it.uid, it.gid
+		return store.UpsertUser(state.User{Name: it.Name, UID: &uid, GID: &gid})
+	case DriftUserIDs:
+		return store.UpdateUserIDs(it.Name, it.uid, it.gid)
+	case DriftUnmanagedMembership:
+		return store.AddMembership(it.Name, it.Group)
+	}
+	return fmt.Errorf("unknown drift kind %q", it.Kind)
+}
+
+// FixDrift makes the system match the DB for one drift item, which for
+// unmanaged objects means removing them.
+func FixDrift(store *state.Store, kind DriftKind, name, group string) error {
+	it, err := findDrift(store, kind, name, group)
+	if err != nil {
+		return err
+	}
+	if !it.CanFix {
+		return fmt.Errorf("%s cannot be fixed", it.Description())
+	}
+
+	switch it.Kind {
+	case DriftUnmanagedGroup:
+		used, err := samba.IsPrimaryGroupGIDUsed(it.gid)
+		if err != nil {
+			return err
+		}
+		if used {
+			return fmt.Errorf("cannot delete group %s: it is the primary group of a user", it.Name)
+		}
+		return samba.DeleteLinuxGroup(it.Name)
+	case DriftGroupGID:
+		g, ok, err := store.GetGroup(it.Name)
+		if err != nil {
+			return err
+		}
+		if !ok || g.GID == nil {
+			return fmt.Errorf("group %s has no gid in DB", it.Name)
+		}
+		return samba.SetLinuxGroupGID(it.Name, *g.GID)
+	case DriftUnmanagedUser:
+		return samba.DeleteLinuxUser(it.Name)
+	case DriftUserIDs:
+		uid, gid := it.uid, it.gid
+		u, ok, err := getUser(store, it.Name)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("user %s not in DB", it.Name)
+		}
+		if u.UID != nil {
+			uid = *u.UID
+		}
+		if u.GID != nil {
+			gid = *u.GID
+		}
+		return samba.SetLinuxUserIDs(it.Name, uid, gid)
+	case DriftUnmanagedMembership:
+		return samba.RemoveUserFromGroup(it.Name, it.Group)
+	case DriftSambaOrphan:
+		return samba.DeleteSambaUser(it.Name)
+	}
+	return fmt.Errorf("unknown drift kind %q", it.Kind)
+}
+
+func getUser(store *state.Store, name string) (state.User, bool, error) {
+	users, err := store.ListUsers()
+	if err != nil {
+		return state.User{}, false, err
+	}
+	for _, u := range users {
+		if u.Name == name {
+			return u, true, nil
+		}
+	}
+	return state.User{}, false, nil
+}
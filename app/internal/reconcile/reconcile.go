@@ -11,66 +11,137 @@ import (
 
 type Result struct {
 	Actions []string
+	// DryRun is set for results of Plan: Actions were computed, not executed.
+	DryRun bool
 }
 
+// Apply brings Linux in line with the DB. On error the returned Result still
+// lists the actions that were carried out before the failure.
 func Apply(store *state.Store) (*Result, error) {
-	res := &Result{}
+	return apply(store, false)
+}
+
+// Plan computes the actions Apply would take without executing any of them
+// or writing to the DB.
+func Plan(store *state.Store) (*Result, error) {
+	return apply(store, true)
+}
+
+func apply(store *state.Store, dryRun bool) (*Result, error) {
+	res := &Result{DryRun: dryRun}
 
 	groups, err := store.ListGroups()
 	if err != nil {
-		return nil, err
+		return res, err
 	}
 	users, err := store.ListUsers()
 	if err != nil {
-		return nil, err
+		return res, err
 	}
 	mems, err := store.ListMemberships()
 	if err != nil {
-		return nil, err
+		return res, err
+	}
+
+	// One snapshot of passwd/group for all checks; it is only re-read after
+	// this run changed the system.
+	accounts, err := samba.LoadAccounts()
+	if err != nil {
+		return res, err
+	}
+	changed := false
+	snapshot := func() (*samba.Accounts, error) {
+		if changed {
+			acc, err := samba.LoadAccounts()
+			if err != nil {
+				return nil, err
+			}
+			accounts, changed = acc, false
+		}
+		return accounts, nil
 	}
 
+	// In plan mode nothing gets created, so remember what would have been.
+	plannedGroups := map[string]bool{}
+	plannedUsers := map[string]bool{}
+
 	// 1) Ensure groups (and persist learned GID)
 	for _, g := range groups {
-		if !samba.LinuxGroupExists(g.Name) {
-			if err := samba.CreateLinuxGroup(g.Name, g.GID); err != nil {
-				return nil, fmt.Errorf("create group %s: %w", g.Name, err)
+		acc, err := snapshot()
+		if err != nil {
+			return res, err
+		}
+		created := false
+		if _, ok := acc.Group(g.Name); !ok {
+			if !dryRun {
+				if err := samba.CreateLinuxGroup(g.Name, g.GID); err != nil {
+					return res, fmt.Errorf("create group %s: %w", g.Name, err)
+				}
+				changed = true
 			}
 			res.Actions = append(res.Actions, "groupadd "+g.Name)
+			plannedGroups[g.Name] = true
+			created = true
 		}
 
 		// If DB has no GID yet, learn from OS and persist.
 		if g.GID == nil {
-			gid, err := samba.GetLinuxGroupGID(g.Name)
+			if dryRun && created {
+				res.Actions = append(res.Actions, fmt.Sprintf("db: set group %s gid from new group", g.Name))
+				continue
+			}
+			acc, err := snapshot()
 			if err != nil {
-				return nil, fmt.Errorf("read gid for group %s: %w", g.Name, err)
+				return res, err
 			}
-			if gid != nil {
-				if err := store.UpdateGroupGID(g.Name, *gid); err != nil {
-					return nil, fmt.Errorf("persist gid for group %s: %w", g.Name, err)
+			lg, ok := acc.Group(g.Name)
+			if !ok {
+				return res, fmt.Errorf("read gid for group %s: no such group", g.Name)
+			}
+			if !dryRun {
+				if err := store.UpdateGroupGID(g.Name, lg.GID); err != nil {
+					return res, fmt.Errorf("persist gid for group %s: %w", g.Name, err)
 				}
-				res.Actions = append(res.Actions, fmt.Sprintf("db: set group %s gid=%d", g.Name, *gid))
 			}
+			res.Actions = append(res.Actions, fmt.Sprintf("db: set group %s gid=%d", g.Name, lg.GID))
 		}
 	}
 
 	// 2) Ensure users (and persist learned UID/GID)
 	for _, u := range users {
+		acc, err := snapshot()
+		if err != nil {
+			return res, err
+		}
 		created := false
-		if !samba.LinuxUserExists(u.Name) {
-			if err := samba.CreateLinuxUser(u.Name, u.UID, u.GID); err != nil {
-				return nil, fmt.Errorf("create user %s: %w", u.Name, err)
+		if _, ok := acc.User(u.Name); !ok {
+			if !dryRun {
+				if err := samba.CreateLinuxUser(u.Name, u.UID, u.GID); err != nil {
+					return res, fmt.Errorf("create user %s: %w", u.Name, err)
+				}
+				changed = true
 			}
 			res.Actions = append(res.Actions, "useradd "+u.Name)
+			plannedUsers[u.Name] = true
 			created = true
 		}
 
 		// If DB UID or GID is missing, learn from OS and persist.
 		// We do this even if the user already existed, because DB might be empty/new.
 		if u.UID == nil || u.GID == nil || created {
-			uid, gid, err := samba.GetLinuxUserUIDGID(u.Name)
+			if dryRun && created {
+				res.Actions = append(res.Actions, fmt.Sprintf("db: set user %s uid/gid from new account", u.Name))
+				continue
+			}
+			acc, err := snapshot()
 			if err != nil {
-				return nil, fmt.Errorf("read uid/gid for user %s: %w", u.Name, err)
+				return res, err
 			}
+			lu, ok := acc.User(u.Name)
+			if !ok {
+				return res, fmt.Errorf("read uid/gid for user %s: no such user", u.Name)
+			}
+			uid, gid := lu.UID, lu.GID
 
 			needPersist := false
 			newUID := uid
@@ -97,8 +168,10 @@ func Apply(store *state.Store) (*Result, error) {
 			}
 
 			if needPersist {
-				if err := store.UpdateUserIDs(u.Name, newUID, newGID); err != nil {
-					return nil, fmt.Errorf("persist uid/gid for user %s: %w", u.Name, err)
+				if !dryRun {
+					if err := store.UpdateUserIDs(u.Name, newUID, newGID); err != nil {
+						return res, fmt.Errorf("persist uid/gid for user %s: %w", u.Name, err)
+					}
 				}
 				res.Actions = append(res.Actions, fmt.Sprintf("db: set user %s uid=%d gid=%d", u.Name, newUID, newGID))
 			}
@@ -107,26 +180,39 @@ func Apply(store *state.Store) (*Result, error) {
 
 	// 3) Ensure memberships (idempotent)
 	for _, m := range mems {
-		ok, err := samba.IsUserInGroup(m.User, m.Group)
+		// A user that only exists in the plan has no groups yet.
+		acc, err := snapshot()
 		if err != nil {
-			return nil, fmt.Errorf("check membership %s in %s: %w", m.User, m.Group, err)
+			return res, err
 		}
-		if ok {
-			continue
+		if !plannedUsers[m.User] {
+			if _, ok := acc.User(m.User); !ok {
+				return res, fmt.Errorf("check membership %s in %s: no such user", m.User, m.Group)
+			}
+			if acc.IsMember(m.User, m.Group) {
+				continue
+			}
 		}
 
 		// best effort: ensure group exists
-		if !samba.LinuxGroupExists(m.Group) {
+		if _, exists := acc.Group(m.Group); !plannedGroups[m.Group] && !exists {
 			// if a group membership exists in DB, the group should exist in DB too.
 			// but handle gracefully.
-			if err := samba.CreateLinuxGroup(m.Group, nil); err != nil {
-				return nil, fmt.Errorf("create missing group %s for membership: %w", m.Group, err)
+			if !dryRun {
+				if err := samba.CreateLinuxGroup(m.Group, nil); err != nil {
+					return res, fmt.Errorf("create missing group %s for membership: %w", m.Group, err)
+				}
+				changed = true
 			}
 			res.Actions = append(res.Actions, "groupadd "+m.Group)
+			plannedGroups[m.Group] = true
 		}
 
-		if err := samba.AddUserToGroup(m.User, m.Group); err != nil {
-			return nil, fmt.Errorf("add %s to %s: %w", m.User, m.Group, err)
+		if !dryRun {
+			if err := samba.AddUserToGroup(m.User, m.Group); err != nil {
+				return res, fmt.Errorf("add %s to %s: %w", m.User, m.Group, err)
+			}
+			changed = true
 		}
 		res.Actions = append(res.Actions, "usermod -aG "+m.Group+" "+m.User)
 	}
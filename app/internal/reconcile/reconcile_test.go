@@ -0,0 +1,259 @@
+package reconcile
+
+import (
+	"errors"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/florianibach/samba-admin-ui/internal/samba/sambatest"
+	"github.com/florianibach/samba-admin-ui/internal/state"
+)
+
+func newStore(t *testing.T) *state.Store {
+	t.Helper()
+	st, err := state.Open(filepath.Join(t.TempDir(), "app.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { st.Close() })
+	return st
+}
+
+func intp(v int) *int { return &v }
+
+func TestApplyCreatesGroupsUsersAndMemberships(t *testing.T) {
+	sys := sambatest.Install(t)
+	st := newStore(t)
+
+	must(t, st.UpsertGroup(state.Group{Name: "family", GID: intp(2000)}))
+	must(t, st.UpsertUser(state.User{Name: "alice"}))
+	must(t, st.SetUserGroups("alice", []string{"family"}))
+
+	res, err := Apply(st)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"groupadd family",
+		"useradd alice",
+		"db: set user alice uid=1000 gid=1000",
+		"usermod -aG family alice",
+	}
+	if !slices.Equal(res.Actions, want) {
+		t.Fatalf("actions = %q, want %q", res.Actions, want)
+	}
+
+	if g := sys.Groups["family"]; g == nil || g.GID != 2000 {
+		t.Errorf("family not created with gid 2000: %+v", g)
+	}
+	if !sys.MemberOf("alice", "family") {
+		t.Error("alice not in family")
+	}
+
+	users, err := st.ListUsers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 1 || users[0].UID == nil || *users[0].UID != 1000 {
+		t.Errorf("uid not persisted: %+v", users)
+	}
+}
+
+func TestApplyIsIdempotent(t *testing.T) {
+	sys := sambatest.Install(t)
+	st := newStore(t)
+
+	must(t, st.UpsertGroup(state.Group{Name: "family"}))
+	must(t, st.UpsertUser(state.User{Name: "alice"}))
+	must(t, st.SetUserGroups("alice", []string{"family"}))
+
+	if _, err := Apply(st); err != nil {
+		t.Fatal(err)
+	}
+	before := len(sys.Calls())
+	res, err := Apply(st)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Actions) != 0 {
+		t.Fatalf("second Apply did something: %q", res.Actions)
+	}
+	// all checks are answered from the passwd/group snapshot
+	if calls := sys.Calls()[before:]; len(calls) != 0 {
+		t.Errorf("second Apply ran commands: %q", calls)
+	}
+}
+
+func TestApplyLearnsGIDOfExistingGroup(t *testing.T) {
+	sys := sambatest.Install(t)
+	sys.AddGroup("media", 1500)
+	st := newStore(t)
+
+	must(t, st.UpsertGroup(state.Group{Name: "media"}))
+
+	res, err := Apply(st)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(res.Actions, []string{"db: set group media gid=1500"}) {
+		t.Fatalf("actions = %q", res.Actions)
+	}
+	g, _, err := st.GetGroup("media")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.GID == nil || *g.GID != 1500 {
+		t.Errorf("gid not persisted: %+v", g)
+	}
+	if m := sys.Mutations(); len(m) != 0 {
+		t.Errorf("unexpected system changes: %q", m)
+	}
+}
+
+func TestApplyReportsDoneActionsOnFailure(t *testing.T) {
+	sys := sambatest.Install(t)
+	sys.Script("useradd", func(stdin string, args []string) (string, string, int) {
+		return "", "useradd: cannot lock /etc/passwd\n", 1
+	})
+	st := newStore(t)
+
+	must(t, st.UpsertGroup(state.Group{Name: "family", GID: intp(2000)}))
+	must(t, st.UpsertUser(state.User{Name: "alice"}))
+
+	res, err := Apply(st)
+	if err == nil || !strings.Contains(err.Error(), "cannot lock") {
+		t.Fatalf("err = %v, want useradd failure", err)
+	}
+	if !slices.Equal(res.Actions, []string{"groupadd family"}) {
+		t.Errorf("actions = %q", res.Actions)
+	}
+}
+
+func TestPlanDoesNotChangeAnything(t *testing.T) {
+	sys := sambatest.Install(t)
+	sys.AddGroup("media", 1500)
+	st := newStore(t)
+
+	must(t, st.UpsertGroup(state.Group{Name: "family"}))
+	must(t, st.UpsertGroup(state.Group{Name: "media"}))
+	must(t, st.UpsertUser(state.User{Name: "alice"}))
+	must(t, st.SetUserGroups("alice", []string{"family", "media"}))
+
+	res, err := Plan(st)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.DryRun {
+		t.Error("DryRun not set")
+	}
+	want := []string{
+		"groupadd family",
+		"db: set group family gid from new group",
+		"db: set group media gid=1500",
+		"useradd alice",
+		"db: set user alice uid/gid from new account",
+		"usermod -aG family alice",
+		"usermod -aG media alice",
+	}
+	if !slices.Equal(res.Actions, want) {
+		t.Fatalf("actions = %q, want %q", res.Actions, want)
+	}
+
+	if m := sys.Mutations(); len(m) != 0 {
+		t.Errorf("plan changed the system: %q", m)
+	}
+	g, _, err := st.GetGroup("media")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.GID != nil {
+		t.Errorf("plan wrote to the DB: %+v", g)
+	}
+}
+
+func TestDetectDrift(t *testing.T) {
+	sys := sambatest.Install(t)
+	sys.AddUser("bob", 1001, "family")
+	sys.AddUser("carol", 1002)
+	sys.AddGroup("family", 2000)
+	sys.AddGroup("games", 2001)
+	sys.Passdb["bob"] = &sambatest.SambaAccount{}
+	sys.Passdb["carol"] = &sambatest.SambaAccount{}
+	st := newStore(t)
+
+	must(t, st.UpsertGroup(state.Group{Name: "family", GID: intp(2100)}))
+	must(t, st.UpsertUser(state.User{Name: "bob", UID: intp(1001), GID: intp(1001)}))
+
+	rep, err := DetectDrift(st)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, it := range rep.Items {
+		got = append(got, string(it.Kind)+" "+it.Name+" "+it.Group)
+	}
+	want := []string{
+		"group_gid family ",
+		"samba_orphan carol ",
+		"unmanaged_group games ",
+		"unmanaged_membership bob family",
+		"unmanaged_user carol ",
+	}
+	if !slices.Equal(got, want) {
+		t.Fatalf("drift = %q, want %q", got, want)
+	}
+}
+
+func TestAdoptAndFixDrift(t *testing.T) {
+	sys := sambatest.Install(t)
+	sys.AddUser("bob", 1001, "family")
+	sys.AddGroup("family", 2000)
+	sys.AddGroup("games", 2001)
+	st := newStore(t)
+
+	must(t, st.UpsertGroup(state.Group{Name: "family", GID: intp(2100)}))
+	must(t, st.UpsertUser(state.User{Name: "bob", UID: intp(1001), GID: intp(1001)}))
+
+	// adopt: DB follows the system
+	must(t, AdoptDrift(st, DriftUnmanagedMembership, "bob", "family"))
+	must(t, AdoptDrift(st, DriftGroupGID, "family", ""))
+	groups, err := st.ListUserGroups("bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(groups, []string{"family"}) {
+		t.Errorf("bob groups in DB = %q", groups)
+	}
+	if g, _, _ := st.GetGroup("family"); g.GID == nil || *g.GID != 2000 {
+		t.Errorf("family gid = %v, want 2000", g.GID)
+	}
+
+	// fix: system follows the DB
+	must(t, FixDrift(st, DriftUnmanagedGroup, "games", ""))
+	if _, ok := sys.Groups["games"]; ok {
+		t.Error("games still exists")
+	}
+
+	rep, err := DetectDrift(st)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rep.Items) != 0 {
+		t.Errorf("drift left: %+v", rep.Items)
+	}
+
+	if err := FixDrift(st, DriftUnmanagedGroup, "games", ""); !errors.Is(err, ErrNoDrift) {
+		t.Errorf("err = %v, want ErrNoDrift", err)
+	}
+}
+
+func must(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
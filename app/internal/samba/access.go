@@ -0,0 +1,395 @@
+package samba
+
+import (
+	"fmt"
+	"io/fs"
+	"strings"
+)
+
+// AccessLevel is what a user can do on a share.
+type AccessLevel int
+
+const (
+	AccessNone AccessLevel = iota
+	AccessRead
+	AccessWrite
+)
+
+func (l AccessLevel) String() string {
+	switch l {
+	case AccessRead:
+		return "read"
+	case AccessWrite:
+		return "write"
+	}
+	return "none"
+}
+
+func (l AccessLevel) MarshalText() ([]byte, error) {
+	return []byte(l.String()), nil
+}
+
+// AccessStep is one rule looked at by EvaluateAccess, in evaluation order.
+type AccessStep struct {
+	Rule   string      `json:"rule"`   // e.g. "valid users = @family"
+	Result string      `json:"result"` // what the rule means for the user
+	Level  AccessLevel `json:"level"`  // the access this step allows at most
+	// Decisive marks the step that determined the final level.
+	Decisive bool `json:"decisive"`
+}
+
+// AccessDir is the share directory as the filesystem check sees it.
+type AccessDir struct {
+	Path  string
+	UID   int
+	GID   int
+	Mode  fs.FileMode
+	Owner string // names, for the explanation
+	Group string
+	ACL   *ACL // nil if unavailable; then the mode bits are used
+}
+
+// AccessInput is everything EvaluateAccess needs. The caller resolves
+// accounts so the evaluation itself is a pure function.
+type AccessInput struct {
+	Share  string
+	Params map[string]string // effective share parameters (testparm -s)
+
+	User     string
+	Groups   []GroupEntry // all groups of User, primary included
+	Disabled bool         // Samba account disabled
+
+	// FSUser, FSUID and FSGroups are the identity used on the filesystem;
+	// it differs from User with force user / force group.
+	FSUser   string
+	FSUID    int
+	FSGroups []GroupEntry
+
+	Dir *AccessDir // nil if the path cannot be read
+}
+
+type AccessResult struct {
+	User  string       `json:"user"`
+	Level AccessLevel  `json:"level"`
+	Share AccessLevel  `json:"share"`      // what the share parameters allow
+	FS    AccessLevel  `json:"filesystem"` // what the directory permissions allow
+	Steps []AccessStep `json:"steps"`
+}
+
+// DecidedBy returns the decisive step.
+func (r *AccessResult) DecidedBy() AccessStep {
+	for _, s := range r.Steps {
+		if s.Decisive {
+			return s
+		}
+	}
+	return AccessStep{}
+}
+
+// EvaluateAccess explains the access of in.User to a share. The share
+// parameters are evaluated the way smbd does for a connection (available,
+// invalid users, valid users, admin users, read only, read list, write list)
+// and the result is capped by what the filesystem identity may do in the
+// share directory.
+func EvaluateAccess(in AccessInput) *AccessResult {
+	res := &AccessResult{User: in.User}
+	step := func(rule, result string, l AccessLevel) int {
+		res.Steps = append(res.Steps, AccessStep{Rule: rule, Result: result, Level: l})
+		return len(res.Steps) - 1
+	}
+	deny := func(rule, result string) *AccessResult {
+		res.Steps[step(rule, result, AccessNone)].Decisive = true
+		return res
+	}
+	p := func(k string) string { return in.Params[k] }
+
+	if in.Disabled {
+		return deny("Samba account", in.User+" is disabled and cannot log in")
+	}
+	if v := p("available"); isNo(v) {
+		return deny("available = "+v, "the share is switched off")
+	}
+
+	if v := p("invalid users"); v != "" {
+		if m, ok := matchUserList(v, in); ok {
+			return deny("invalid users = "+v, "matches "+m+", access refused")
+		}
+		step("invalid users = "+v, "does not match", AccessWrite)
+	}
+	if v := p("valid users"); v != "" {
+		m, ok := matchUserList(v, in)
+		if !ok {
+			return deny("valid users = "+v, in.User+" is not listed, access refused")
+		}
+		step("valid users = "+v, "matches "+m, AccessWrite)
+	} else {
+		step("valid users", "not set, every Samba user may connect", AccessWrite)
+	}
+	var force []string
+	if v := p("force user"); v != "" {
+		force = append(force, "force user = "+v)
+	}
+	if v := p("force group"); v != "" {
+		force = append(force, "force group = "+v)
+	}
+	if len(force) > 0 {
+		step(strings.Join(force, ", "), "files are accessed as "+in.FSUser+" ("+groupNames(in.FSGroups)+")", AccessWrite)
+	}
+
+	// share level: read only, then read list, then write list; admin users
+	// override everything
+	shareStep := 0
+	if v := p("admin users"); v != "" {
+		if m, ok := matchUserList(v, in); ok {
+			shareStep = step("admin users = "+v, "matches "+m+", works as root", AccessWrite)
+			res.Share = AccessWrite
+			in.FSUID, in.FSUser, in.FSGroups = 0, "root", nil
+		}
+	}
+	if res.Share != AccessWrite {
+		ro := p("read only")
+		switch {
+		case ro == "":
+			shareStep = step("read only", "not set, defaults to yes: read-only", AccessRead)
+			res.Share = AccessRead
+		case isNo(ro):
+			shareStep = step("read only = "+ro, "writable", AccessWrite)
+			res.Share = AccessWrite
+		default:
+			shareStep = step("read only = "+ro, "read-only", AccessRead)
+			res.Share = AccessRead
+		}
+		if v := p("read list"); v != "" {
+			if m, ok := matchUserList(v, in); ok {
+				shareStep = step("read list = "+v, "matches "+m+", read-only", AccessRead)
+				res.Share = AccessRead
+			} else {
+				step("read list = "+v, "does not match", res.Share)
+			}
+		}
+		if v := p("write list"); v != "" {
+			if m, ok := matchUserList(v, in); ok {
+				shareStep = step("write list = "+v, "matches "+m+", writable", AccessWrite)
+				res.Share = AccessWrite
+			} else {
+				step("write list = "+v, "does not match", res.Share)
+			}
+		}
+	}
+
+	fsStep := evaluateDir(in, step, &res.FS)
+
+	if res.FS < res.Share {
+		res.Level = res.FS
+		res.Steps[fsStep].Decisive = true
+	} else {
+		res.Level = res.Share
+		res.Steps[shareStep].Decisive = true
+	}
+	return res
+}
+
+// evaluateDir checks the share directory for the filesystem identity and
+// stores the level in lvl; it returns the index of its final step.
+func evaluateDir(in AccessInput, step func(rule, result string, l AccessLevel) int, lvl *AccessLevel) int {
+	d := in.Dir
+	if d == nil {
+		*lvl = AccessNone
+		return step("directory", "cannot be read, smbd would refuse the connection", AccessNone)
+	}
+	if in.FSUID == 0 {
+		*lvl = AccessWrite
+		return step("directory "+d.Path, "root may do anything", AccessWrite)
+	}
+
+	rule, perms := dirPerms(in, d)
+	*lvl = levelOf(perms)
+	return step(rule, fmt.Sprintf("%s for %s: %s access", perms, in.FSUser, *lvl), *lvl)
+}
+
+// dirPerms implements the POSIX ACL access check algorithm (owner, named
+// users, groups, other) for the directory and returns the matching rule and
+// its effective permissions in "rwx" form.
+func dirPerms(in AccessInput, d *AccessDir) (rule, perms string) {
+	loc := fmt.Sprintf("%s (%s:%s %s)", d.Path, d.Owner, d.Group, modeString(d.Mode))
+
+	acl := d.ACL
+	if acl == nil {
+		acl = &ACL{Access: []ACLEntry{
+			{Tag: "user", Perms: permBits(d.Mode >> 6)},
+			{Tag: "group", Perms: permBits(d.Mode >> 3)},
+			{Tag: "other", Perms: permBits(d.Mode)},
+		}}
+	}
+	entry := func(tag, q string) (ACLEntry, bool) {
+		for _, e := range acl.Access {
+			if e.Tag == tag && e.Qualifier == q {
+				return e, true
+			}
+		}
+		return ACLEntry{}, false
+	}
+	masked := func(e ACLEntry) string {
+		if e.Effective != "" {
+			return e.Effective
+		}
+		if m, ok := entry("mask", ""); ok {
+			return maskPerms(e.Perms, m.Perms)
+		}
+		return e.Perms
+	}
+
+	if in.FSUID == d.UID {
+		e, _ := entry("user", "")
+		return "owner of " + loc, e.Perms
+	}
+	if e, ok := entry("user", in.FSUser); ok {
+		return "ACL entry user:" + in.FSUser + " on " + loc, masked(e)
+	}
+
+	// group class: any matching group entry that grants the permissions wins
+	var matched []string
+	best := ""
+	consider := func(label, p string) {
+		matched = append(matched, label)
+		if best == "" || levelOf(p) > levelOf(best) {
+			best = p
+		}
+	}
+	for _, g := range in.FSGroups {
+		if g.GID == d.GID {
+			e, _ := entry("group", "")
+			consider("owning group "+g.Name, masked(e))
+		}
+	}
+	for _, e := range acl.Access {
+		if e.Tag == "group" && e.Qualifier != "" && hasGroup(in.FSGroups, e.Qualifier) {
+			consider("ACL entry group:"+e.Qualifier, masked(e))
+		}
+	}
+	if len(matched) > 0 {
+		return strings.Join(matched, ", ") + " on " + loc, best
+	}
+
+	e, _ := entry("other", "")
+	return "others on " + loc, e.Perms
+}
+
+// levelOf maps directory permissions to share access: listing needs r-x,
+// creating and changing files needs rwx.
+func levelOf(p string) AccessLevel {
+	switch {
+	case p == "rwx":
+		return AccessWrite
+	case len(p) == 3 && p[0] == 'r' && p[2] == 'x':
+		return AccessRead
+	}
+	return AccessNone
+}
+
+func permBits(m fs.FileMode) string {
+	b := []byte("---")
+	for i, c := range "rwx" {
+		if m&(4>>i) != 0 {
+			b[i] = byte(c)
+		}
+	}
+	return string(b)
+}
+
+func maskPerms(p, mask string) string {
+	b := []byte(p)
+	for i := range b {
+		if i < len(mask) && mask[i] == '-' {
+			b[i] = '-'
+		}
+	}
+	return string(b)
+}
+
+func modeString(m fs.FileMode) string {
+	o := uint32(m.Perm())
+	if m&fs.ModeSetuid != 0 {
+		o |= 0o4000
+	}
+	if m&fs.ModeSetgid != 0 {
+		o |= 0o2000
+	}
+	if m&fs.ModeSticky != 0 {
+		o |= 0o1000
+	}
+	return fmt.Sprintf("%04o", o)
+}
+
+func hasGroup(groups []GroupEntry, name string) bool {
+	for _, g := range groups {
+		if g.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func groupNames(groups []GroupEntry) string {
+	names := make([]string, len(groups))
+	for i, g := range groups {
+		names[i] = g.Name
+	}
+	return strings.Join(names, ", ")
+}
+
+func isNo(v string) bool {
+	switch strings.ToLower(strings.TrimSpace(v)) {
+	case "no", "false", "0":
+		return true
+	}
+	return false
+}
+
+// matchUserList reports whether the connecting user matches a smb.conf user
+// list such as "alice, @family +staff" and returns the matching item. %S and
+// %U are substituted; netgroups (&) are not supported.
+func matchUserList(list string, in AccessInput) (string, bool) {
+	for _, item := range splitUserList(list) {
+		item = strings.NewReplacer("%S", in.Share, "%U", in.User, "%u", in.User).Replace(item)
+		name := strings.TrimLeft(item, "@+&")
+		switch {
+		case name == "":
+			continue
+		case strings.HasPrefix(item, "&"):
+			continue
+		case name != item:
+			if hasGroup(in.Groups, name) {
+				return item, true
+			}
+		case item == in.User:
+			return item, true
+		}
+	}
+	return "", false
+}
+
+// splitUserList splits on commas and whitespace, keeping quoted names (which
+// may contain spaces) together.
+func splitUserList(list string) []string {
+	var items []string
+	var cur strings.Builder
+	quoted := false
+	for _, c := range list {
+		switch {
+		case c == '"':
+			quoted = !quoted
+		case !quoted && (c == ',' || c == ' ' || c == '\t'):
+			if cur.Len() > 0 {
+				items = append(items, cur.String())
+				cur.Reset()
+			}
+		default:
+			cur.WriteRune(c)
+		}
+	}
+	if cur.Len() > 0 {
+		items = append(items, cur.String())
+	}
+	return items
+}
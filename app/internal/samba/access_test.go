@@ -0,0 +1,77 @@
+package samba
+
+import (
+	"io/fs"
+	"testing"
+)
+
+func TestEvaluateAccess(t *testing.T) {
+	family := GroupEntry{Name: "family", GID: 1100}
+	alice := GroupEntry{Name: "alice", GID: 1000}
+	dir := func(mode fs.FileMode, acl *ACL) *AccessDir {
+		return &AccessDir{Path: "/shares/media", UID: 0, GID: 1100, Mode: fs.ModeDir | mode, Owner: "root", Group: "family", ACL: acl}
+	}
+	input := func(params map[string]string, d *AccessDir) AccessInput {
+		return AccessInput{
+			Share: "media", Params: params,
+			User: "alice", Groups: []GroupEntry{alice, family},
+			FSUser: "alice", FSUID: 1000, FSGroups: []GroupEntry{alice, family},
+			Dir: d,
+		}
+	}
+
+	for _, tc := range []struct {
+		name     string
+		in       AccessInput
+		want     AccessLevel
+		decisive string
+	}{
+		{"group writable", input(map[string]string{"read only": "No", "valid users": "@family"}, dir(0o770, nil)), AccessWrite, "read only = No"},
+		{"default read only", input(map[string]string{}, dir(0o770, nil)), AccessRead, "read only"},
+		{"write list", input(map[string]string{"read only": "Yes", "write list": "bob, +family"}, dir(0o770, nil)), AccessWrite, "write list = bob, +family"},
+		{"read list", input(map[string]string{"read only": "No", "read list": "alice"}, dir(0o770, nil)), AccessRead, "read list = alice"},
+		{"not valid", input(map[string]string{"valid users": "bob @staff"}, dir(0o777, nil)), AccessNone, "valid users = bob @staff"},
+		{"invalid", input(map[string]string{"invalid users": "%U"}, dir(0o777, nil)), AccessNone, "invalid users = %U"},
+		{"fs read only", input(map[string]string{"read only": "No"}, dir(0o750, nil)), AccessRead, "owning group family on /shares/media (root:family 0750)"},
+		{"fs other", input(map[string]string{"read only": "No"}, &AccessDir{Path: "/x", UID: 0, GID: 0, Mode: fs.ModeDir | 0o700, Owner: "root", Group: "root"}), AccessNone, "others on /x (root:root 0700)"},
+		{"missing dir", input(map[string]string{"read only": "No"}, nil), AccessNone, "directory"},
+		{"masked acl", input(map[string]string{"read only": "No"}, &AccessDir{Path: "/x", Mode: fs.ModeDir | 0o750, Owner: "root", Group: "root", ACL: &ACL{Access: []ACLEntry{
+			{Tag: "user", Perms: "rwx"},
+			{Tag: "user", Qualifier: "alice", Perms: "rwx", Effective: "r-x"},
+			{Tag: "group", Perms: "r-x"},
+			{Tag: "mask", Perms: "r-x"},
+			{Tag: "other", Perms: "---"},
+		}}}), AccessRead, "ACL entry user:alice on /x (root:root 0750)"},
+		{"admin", input(map[string]string{"admin users": "alice"}, dir(0o700, nil)), AccessWrite, "admin users = alice"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			res := EvaluateAccess(tc.in)
+			if res.Level != tc.want {
+				t.Errorf("level = %s, want %s (steps %+v)", res.Level, tc.want, res.Steps)
+			}
+			if got := res.DecidedBy().Rule; got != tc.decisive {
+				t.Errorf("decided by %q, want %q", got, tc.decisive)
+			}
+		})
+	}
+}
+
+func TestEvaluateAccessDisabled(t *testing.T) {
+	res := EvaluateAccess(AccessInput{User: "alice", Disabled: true, Params: map[string]string{}})
+	if res.Level != AccessNone || len(res.Steps) != 1 {
+		t.Errorf("result = %+v", res)
+	}
+}
+
+func TestSplitUserList(t *testing.T) {
+	got := splitUserList(`alice, "Domain Users"  @family,+staff`)
+	want := []string{"alice", "Domain Users", "@family", "+staff"}
+	if len(got) != len(want) {
+		t.Fatalf("got %q", got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("item %d = %q, want %q", i, got[i], want[i])
+		}
+	}
+}
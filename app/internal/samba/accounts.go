@@ -0,0 +1,263 @@
+package samba
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Account databases read by LoadAccounts. Only the "files" NSS source is
+// supported, which is all the container uses.
+const (
+	PasswdFile = "/etc/passwd"
+	GroupFile  = "/etc/group"
+	ShadowFile = "/etc/shadow"
+)
+
+// AccountFileReader can be implemented by a Runner to supply the account
+// databases itself (used by sambatest); otherwise they are read from disk.
+type AccountFileReader interface {
+	ReadAccountFile(path string) ([]byte, error)
+}
+
+type PasswdEntry struct {
+	Name  string
+	UID   int
+	GID   int
+	Gecos string
+	Home  string
+	Shell string
+}
+
+type GroupEntry struct {
+	Name    string
+	GID     int
+	Members []string // supplementary members listed in /etc/group
+}
+
+// Accounts is a parsed, indexed snapshot of passwd, group and shadow. Load
+// it once and use its lookups instead of calling getent/id per item.
+type Accounts struct {
+	users  []PasswdEntry
+	groups []GroupEntry
+
+	userByName  map[string]int
+	userByUID   map[int]int
+	groupByName map[string]int
+	groupByGID  map[int]int
+
+	// shadow is nil if the shadow file could not be read.
+	shadow map[string]bool
+}
+
+// LoadAccounts reads and parses the account databases.
+func LoadAccounts() (*Accounts, error) {
+	read := os.ReadFile
+	if r, ok := currentRunner().(AccountFileReader); ok {
+		read = r.ReadAccountFile
+	}
+
+	passwd, err := read(PasswdFile)
+	if err != nil {
+		return nil, err
+	}
+	group, err := read(GroupFile)
+	if err != nil {
+		return nil, err
+	}
+	shadow, err := read(ShadowFile)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) && !errors.Is(err, fs.ErrPermission) {
+		return nil, err
+	}
+
+	return ParseAccounts(passwd, group, shadow), nil
+}
+
+// ParseAccounts builds a snapshot from file contents. shadow may be nil.
+// Malformed lines are skipped; for duplicate names the first entry wins,
+// like in NSS.
+func ParseAccounts(passwd, group, shadow []byte) *Accounts {
+	a := &Accounts{
+		userByName:  map[string]int{},
+		userByUID:   map[int]int{},
+		groupByName: map[string]int{},
+		groupByGID:  map[int]int{},
+	}
+
+	eachRecord(passwd, func(f []string) {
+		// name:password:uid:gid:gecos:home:shell
+		if len(f) < 7 {
+			return
+		}
+		uid, err1 := strconv.Atoi(f[2])
+		gid, err2 := strconv.Atoi(f[3])
+		if err1 != nil || err2 != nil {
+			return
+		}
+		if _, dup := a.userByName[f[0]]; dup {
+			return
+		}
+		a.users = append(a.users, PasswdEntry{Name: f[0], UID: uid, GID: gid, Gecos: f[4], Home: f[5], Shell: f[6]})
+		i := len(a.users) - 1
+		a.userByName[f[0]] = i
+		if _, dup := a.userByUID[uid]; !dup {
+			a.userByUID[uid] = i
+		}
+	})
+
+	eachRecord(group, func(f []string) {
+		// name:password:gid:member1,member2
+		if len(f) < 3 {
+			return
+		}
+		gid, err := strconv.Atoi(f[2])
+		if err != nil {
+			return
+		}
+		if _, dup := a.groupByName[f[0]]; dup {
+			return
+		}
+		var members []string
+		if len(f) >= 4 {
+			for _, m := range strings.Split(f[3], ",") {
+				if m = strings.TrimSpace(m); m != "" {
+					members = append(members, m)
+				}
+			}
+		}
+		a.groups = append(a.groups, GroupEntry{Name: f[0], GID: gid, Members: members})
+		i := len(a.groups) - 1
+		a.groupByName[f[0]] = i
+		if _, dup := a.groupByGID[gid]; !dup {
+			a.groupByGID[gid] = i
+		}
+	})
+
+	if shadow != nil {
+		a.shadow = map[string]bool{}
+		eachRecord(shadow, func(f []string) {
+			a.shadow[f[0]] = true
+		})
+	}
+
+	return a
+}
+
+func eachRecord(b []byte, fn func(fields []string)) {
+	sc := bufio.NewScanner(bytes.NewReader(b))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		// "+"/"-" lines are NIS compat entries, which we do not resolve.
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "+") || strings.HasPrefix(line, "-") {
+			continue
+		}
+		f := strings.Split(line, ":")
+		if f[0] == "" {
+			continue
+		}
+		fn(f)
+	}
+}
+
+// Users returns all passwd entries in file order.
+func (a *Accounts) Users() []PasswdEntry { return a.users }
+
+// Groups returns all group entries in file order.
+func (a *Accounts) Groups() []GroupEntry { return a.groups }
+
+func (a *Accounts) User(name string) (PasswdEntry, bool) {
+	i, ok := a.userByName[name]
+	if !ok {
+		return PasswdEntry{}, false
+	}
+	return a.users[i], true
+}
+
+func (a *Accounts) UserByUID(uid int) (PasswdEntry, bool) {
+	i, ok := a.userByUID[uid]
+	if !ok {
+		return PasswdEntry{}, false
+	}
+	return a.users[i], true
+}
+
+func (a *Accounts) Group(name string) (GroupEntry, bool) {
+	i, ok := a.groupByName[name]
+	if !ok {
+		return GroupEntry{}, false
+	}
+	return a.groups[i], true
+}
+
+func (a *Accounts) GroupByGID(gid int) (GroupEntry, bool) {
+	i, ok := a.groupByGID[gid]
+	if !ok {
+		return GroupEntry{}, false
+	}
+	return a.groups[i], true
+}
+
+// HasShadow reports whether user has a shadow entry. known is false if the
+// shadow file could not be read.
+func (a *Accounts) HasShadow(user string) (has, known bool) {
+	if a.shadow == nil {
+		return false, false
+	}
+	return a.shadow[user], true
+}
+
+// GroupsOf returns the primary group of user followed by its supplementary
+// groups (sorted by name), like `id -G`.
+func (a *Accounts) GroupsOf(user string) []GroupEntry {
+	u, ok := a.User(user)
+	if !ok {
+		return nil
+	}
+
+	var res []GroupEntry
+	primary, hasPrimary := a.GroupByGID(u.GID)
+	if hasPrimary {
+		res = append(res, primary)
+	}
+
+	var supp []GroupEntry
+	for _, g := range a.groups {
+		if hasPrimary && g.Name == primary.Name {
+			continue
+		}
+		for _, m := range g.Members {
+			if m == user {
+				supp = append(supp, g)
+				break
+			}
+		}
+	}
+	sort.Slice(supp, func(i, j int) bool { return supp[i].Name < supp[j].Name })
+	return append(res, supp...)
+}
+
+// IsMember reports whether user belongs to group, as primary or
+// supplementary member.
+func (a *Accounts) IsMember(user, group string) bool {
+	for _, g := range a.GroupsOf(user) {
+		if g.Name == group {
+			return true
+		}
+	}
+	return false
+}
+
+// PrimaryGIDUsed reports whether any user has gid as primary group.
+func (a *Accounts) PrimaryGIDUsed(gid int) bool {
+	for _, u := range a.users {
+		if u.GID == gid {
+			return true
+		}
+	}
+	return false
+}
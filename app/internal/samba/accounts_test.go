@@ -0,0 +1,68 @@
+package samba
+
+import (
+	"slices"
+	"testing"
+)
+
+const testPasswd = `root:x:0:0:root:/root:/bin/bash
+# comment
+nobody:x:65534:65534:nobody:/nonexistent:/usr/sbin/nologin
+alice:x:1000:1000:Alice,,,:/home/alice:/bin/bash
+bob:x:1001:100::/home/bob:/usr/sbin/nologin
+broken:x:notanumber:1000::/:/bin/sh
+alice:x:1999:1999::/:/bin/sh
+`
+
+const testGroup = `root:x:0:
+users:x:100:
+alice:x:1000:
+family:x:2000:bob,alice
+media:x:2001:bob
+nogroup:x:65534:
+`
+
+func TestParseAccounts(t *testing.T) {
+	acc := ParseAccounts([]byte(testPasswd), []byte(testGroup), []byte("alice:$y$...:19000:0:99999:7:::\n"))
+
+	if n := len(acc.Users()); n != 4 {
+		t.Fatalf("users = %d, want 4 (malformed line and duplicate skipped)", n)
+	}
+	alice, ok := acc.User("alice")
+	if !ok || alice.UID != 1000 || alice.Gecos != "Alice,,," {
+		t.Errorf("alice = %+v", alice)
+	}
+	if u, ok := acc.UserByUID(1001); !ok || u.Name != "bob" {
+		t.Errorf("uid 1001 = %+v", u)
+	}
+	if g, ok := acc.GroupByGID(2000); !ok || !slices.Equal(g.Members, []string{"bob", "alice"}) {
+		t.Errorf("gid 2000 = %+v", g)
+	}
+	if _, ok := acc.User("broken"); ok {
+		t.Error("malformed entry parsed")
+	}
+
+	var bobGroups []string
+	for _, g := range acc.GroupsOf("bob") {
+		bobGroups = append(bobGroups, g.Name)
+	}
+	if !slices.Equal(bobGroups, []string{"users", "family", "media"}) {
+		t.Errorf("bob groups = %q", bobGroups)
+	}
+	if !acc.IsMember("alice", "alice") || !acc.IsMember("alice", "family") || acc.IsMember("alice", "media") {
+		t.Error("wrong membership for alice")
+	}
+	if !acc.PrimaryGIDUsed(100) || acc.PrimaryGIDUsed(2000) {
+		t.Error("wrong primary gid usage")
+	}
+
+	if has, known := acc.HasShadow("alice"); !has || !known {
+		t.Errorf("alice shadow = %v/%v", has, known)
+	}
+	if has, known := acc.HasShadow("bob"); has || !known {
+		t.Errorf("bob shadow = %v/%v", has, known)
+	}
+	if _, known := ParseAccounts(nil, nil, nil).HasShadow("alice"); known {
+		t.Error("shadow known without shadow file")
+	}
+}
@@ -0,0 +1,198 @@
+package samba
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// POSIX ACLs are read and written with getfacl/setfacl, so mask handling and
+// name resolution behave exactly as on the command line.
+
+type ACLEntry struct {
+	Default   bool   `json:"default"`
+	Tag       string `json:"tag"`       // user, group, mask or other
+	Qualifier string `json:"qualifier"` // user/group name; empty for the owner, owning group, mask and other
+	Perms     string `json:"perms"`     // "rwx" form, e.g. "r-x"
+	// Effective is set when the mask reduces Perms.
+	Effective string `json:"effective,omitempty"`
+}
+
+// Named reports whether e is an extra user or group entry (the kind that
+// can be added and removed) rather than one of the base entries.
+func (e ACLEntry) Named() bool {
+	return e.Qualifier != "" && (e.Tag == "user" || e.Tag == "group")
+}
+
+// Spec formats e for setfacl; without perms for removal.
+func (e ACLEntry) Spec(withPerms bool) string {
+	s := e.Tag + ":" + e.Qualifier
+	if withPerms {
+		s += ":" + e.Perms
+	}
+	if e.Default {
+		s = "default:" + s
+	}
+	return s
+}
+
+// Label is how the entry reads in the UI ("user alice", "owner", ...).
+func (e ACLEntry) Label() string {
+	switch {
+	case e.Tag == "user" && e.Qualifier == "":
+		return "owner"
+	case e.Tag == "group" && e.Qualifier == "":
+		return "owning group"
+	case e.Qualifier != "":
+		return e.Tag + " " + e.Qualifier
+	}
+	return e.Tag
+}
+
+type ACL struct {
+	Path    string     `json:"path"`
+	Owner   string     `json:"owner"`
+	Group   string     `json:"group"`
+	Flags   string     `json:"flags,omitempty"` // e.g. "-s-" for setgid
+	Access  []ACLEntry `json:"access"`
+	Default []ACLEntry `json:"default"`
+}
+
+// Extended reports whether the ACL has more than the mode bits: named
+// entries or any default entries.
+func (a *ACL) Extended() bool {
+	if len(a.Default) > 0 {
+		return true
+	}
+	for _, e := range a.Access {
+		if e.Named() {
+			return true
+		}
+	}
+	return false
+}
+
+// Entries returns the access entries followed by the default entries.
+func (a *ACL) Entries() []ACLEntry {
+	return append(slices.Clip(a.Access), a.Default...)
+}
+
+// GetACL reads the access and default ACL of path.
+func GetACL(path string) (*ACL, error) {
+	out, errStr, code, err := run(10*time.Second, "getfacl", "-p", "--", path)
+	if code != 0 {
+		if errStr == "" && err != nil {
+			errStr = err.Error()
+		}
+		return nil, fmt.Errorf("getfacl failed: %s", strings.TrimSpace(errStr))
+	}
+	return ParseGetfacl(out), nil
+}
+
+// ParseGetfacl parses the output of getfacl for a single file.
+func ParseGetfacl(out string) *ACL {
+	acl := &ACL{Access: []ACLEntry{}, Default: []ACLEntry{}}
+	for _, ln := range strings.Split(out, "\n") {
+		line := strings.TrimSpace(ln)
+		if line == "" {
+			continue
+		}
+		if rest, ok := strings.CutPrefix(line, "#"); ok {
+			k, v, _ := strings.Cut(rest, ":")
+			v = strings.TrimSpace(v)
+			switch strings.TrimSpace(k) {
+			case "file":
+				acl.Path = v
+			case "owner":
+				acl.Owner = v
+			case "group":
+				acl.Group = v
+			case "flags":
+				acl.Flags = v
+			}
+			continue
+		}
+
+		spec, comment, _ := strings.Cut(line, "#")
+		e := ACLEntry{}
+		spec = strings.TrimSpace(spec)
+		if rest, ok := strings.CutPrefix(spec, "default:"); ok {
+			e.Default = true
+			spec = rest
+		}
+		f := strings.Split(spec, ":")
+		if len(f) != 3 {
+			continue
+		}
+		e.Tag, e.Qualifier, e.Perms = f[0], f[1], f[2]
+		if eff, ok := strings.CutPrefix(strings.TrimSpace(comment), "effective:"); ok {
+			e.Effective = eff
+		}
+
+		if e.Default {
+			acl.Default = append(acl.Default, e)
+		} else {
+			acl.Access = append(acl.Access, e)
+		}
+	}
+	return acl
+}
+
+var aclPermsRx = regexp.MustCompile(`^[rwx-]{0,3}$`)
+
+// NormalizeACLPerms turns "rw", "wr", "r-x" or "" into the "rwx" form.
+func NormalizeACLPerms(p string) (string, error) {
+	if !aclPermsRx.MatchString(p) {
+		return "", fmt.Errorf("invalid permissions %q (use r, w and x)", p)
+	}
+	res := []byte("---")
+	for i, c := range "rwx" {
+		if strings.ContainsRune(p, c) {
+			res[i] = byte(c)
+		}
+	}
+	return string(res), nil
+}
+
+// SetACLEntry adds or replaces a named entry on paths (and everything below
+// them if recursive). setfacl recalculates the mask.
+func SetACLEntry(e ACLEntry, recursive bool, paths ...string) error {
+	if !e.Named() {
+		return fmt.Errorf("only named user and group entries can be set")
+	}
+	return setfacl(paths, recursive, "-m", e.Spec(true))
+}
+
+// RemoveACLEntry removes a named entry from paths (and below if recursive).
+func RemoveACLEntry(e ACLEntry, recursive bool, paths ...string) error {
+	if !e.Named() {
+		return fmt.Errorf("only named user and group entries can be removed")
+	}
+	return setfacl(paths, recursive, "-x", e.Spec(false))
+}
+
+// setfaclBatch bounds the number of paths per setfacl call.
+const setfaclBatch = 200
+
+func setfacl(paths []string, recursive bool, op, spec string) error {
+	for chunk := range slices.Chunk(paths, setfaclBatch) {
+		args := []string{}
+		if recursive {
+			// -P: do not follow symlinks out of the share
+			args = append(args, "-R", "-P")
+		}
+		args = append(args, op, spec, "--")
+		args = append(args, chunk...)
+
+		_, errStr, code, err := run(5*time.Minute, "setfacl", args...)
+		if code != 0 {
+			if errStr == "" && err != nil {
+				errStr = err.Error()
+			}
+			return fmt.Errorf("setfacl failed: %s", strings.TrimSpace(errStr))
+		}
+	}
+	return nil
+}
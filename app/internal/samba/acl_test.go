@@ -0,0 +1,94 @@
+package samba_test
+
+import (
+	"testing"
+
+	"github.com/florianibach/samba-admin-ui/internal/samba"
+	"github.com/florianibach/samba-admin-ui/internal/samba/sambatest"
+)
+
+func TestParseGetfacl(t *testing.T) {
+	acl := samba.ParseGetfacl(`# file: /srv/media
+# owner: root
+# group: family
+# flags: -s-
+user::rwx
+user:alice:rwx			#effective:r-x
+group::r-x
+mask::r-x
+other::---
+default:user::rwx
+default:group:family:rwx
+default:mask::rwx
+default:other::---
+
+`)
+	if acl.Path != "/srv/media" || acl.Owner != "root" || acl.Group != "family" || acl.Flags != "-s-" {
+		t.Errorf("header = %+v", acl)
+	}
+	if len(acl.Access) != 5 || len(acl.Default) != 4 {
+		t.Fatalf("access %d default %d", len(acl.Access), len(acl.Default))
+	}
+	alice := acl.Access[1]
+	if !alice.Named() || alice.Qualifier != "alice" || alice.Perms != "rwx" || alice.Effective != "r-x" {
+		t.Errorf("alice = %+v", alice)
+	}
+	if acl.Access[0].Named() || acl.Access[0].Label() != "owner" {
+		t.Errorf("owner entry = %+v", acl.Access[0])
+	}
+	if got := acl.Default[1].Spec(true); got != "default:group:family:rwx" {
+		t.Errorf("spec = %q", got)
+	}
+	if !acl.Extended() {
+		t.Error("not extended")
+	}
+	if len(acl.Entries()) != 9 {
+		t.Errorf("entries = %d", len(acl.Entries()))
+	}
+}
+
+func TestNormalizeACLPerms(t *testing.T) {
+	for in, want := range map[string]string{"": "---", "rwx": "rwx", "xr": "r-x", "r-x": "r-x", "w": "-w-"} {
+		if got, err := samba.NormalizeACLPerms(in); err != nil || got != want {
+			t.Errorf("NormalizeACLPerms(%q) = %q, %v", in, got, err)
+		}
+	}
+	if _, err := samba.NormalizeACLPerms("rwz"); err == nil {
+		t.Error("rwz accepted")
+	}
+}
+
+func TestSetAndRemoveACLEntry(t *testing.T) {
+	sys := sambatest.Install(t)
+	sys.AddUser("alice", 1000)
+	sys.AddGroup("family", 1100)
+
+	e := samba.ACLEntry{Default: true, Tag: "group", Qualifier: "family", Perms: "rwx"}
+	if err := samba.SetACLEntry(e, false, "/srv/media", "/srv/media/sub"); err != nil {
+		t.Fatal(err)
+	}
+	acl, err := samba.GetACL("/srv/media")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !acl.Extended() || len(acl.Default) == 0 {
+		t.Fatalf("default entry missing: %+v", acl)
+	}
+	if sub, _ := samba.GetACL("/srv/media/sub"); !sub.Extended() {
+		t.Errorf("second path not changed: %+v", sub)
+	}
+
+	if err := samba.SetACLEntry(samba.ACLEntry{Tag: "user", Qualifier: "nobody", Perms: "r--"}, false, "/srv/media"); err == nil {
+		t.Error("unknown user accepted")
+	}
+	if err := samba.SetACLEntry(samba.ACLEntry{Tag: "mask", Perms: "r--"}, false, "/srv/media"); err == nil {
+		t.Error("mask entry accepted")
+	}
+
+	if err := samba.RemoveACLEntry(e, false, "/srv/media"); err != nil {
+		t.Fatal(err)
+	}
+	if acl, _ := samba.GetACL("/srv/media"); acl.Extended() {
+		t.Errorf("entry not removed: %+v", acl)
+	}
+}
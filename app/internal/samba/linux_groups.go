@@ -8,8 +8,12 @@ import (
 )
 
 func LinuxGroupExists(name string) bool {
-	_, _, code, _ := run(2*time.Second, "getent", "group", name)
-	return code == 0
+	acc, err := LoadAccounts()
+	if err != nil {
+		return false
+	}
+	_, ok := acc.Group(name)
+	return ok
 }
 
 func CreateLinuxGroup(name string, gid *int) error {
@@ -26,20 +30,14 @@ func CreateLinuxGroup(name string, gid *int) error {
 }
 
 func IsUserInGroup(user, group string) (bool, error) {
-	// id -nG user -> "grp1 grp2 ..."
-	out, errStr, code, err := run(3*time.Second, "id", "-nG", user)
-	if err != nil && code == 0 {
+	acc, err := LoadAccounts()
+	if err != nil {
 		return false, err
 	}
-	if code != 0 {
-		return false, fmt.Errorf("id -nG failed: %s", strings.TrimSpace(errStr))
-	}
-	for _, g := range strings.Fields(out) {
-		if g == group {
-			return true, nil
-		}
+	if _, ok := acc.User(user); !ok {
+		return false, fmt.Errorf("no such user: %s", user)
 	}
-	return false, nil
+	return acc.IsMember(user, group), nil
 }
 
 func AddUserToGroup(user, group string) error {
@@ -60,51 +58,42 @@ func DeleteLinuxGroup(name string) error {
 
 // true wenn irgendein User diese GID als Primärgruppe hat
 func IsPrimaryGroupGIDUsed(gid int) (bool, error) {
-	out, errStr, code, err := run(3*time.Second, "getent", "passwd")
-	if err != nil && code == 0 {
+	acc, err := LoadAccounts()
+	if err != nil {
 		return false, err
 	}
-	if code != 0 {
-		return false, fmt.Errorf("getent passwd failed: %s", strings.TrimSpace(errStr))
-	}
-
-	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
-		// name:x:uid:gid:...
-		parts := strings.Split(line, ":")
-		if len(parts) < 4 {
-			continue
-		}
-		pgid, convErr := strconv.Atoi(parts[3])
-		if convErr != nil {
-			continue
-		}
-		if pgid == gid {
-			return true, nil
-		}
-	}
-	return false, nil
+	return acc.PrimaryGIDUsed(gid), nil
 }
 
 func GetPrimaryGroupName(user string) (string, error) {
-	out, errStr, code, err := run(3*time.Second, "id", "-gn", user)
-	if err != nil && code == 0 {
+	acc, err := LoadAccounts()
+	if err != nil {
 		return "", err
 	}
-	if code != 0 {
-		return "", fmt.Errorf("id -gn failed: %s", strings.TrimSpace(errStr))
+	u, ok := acc.User(user)
+	if !ok {
+		return "", fmt.Errorf("no such user: %s", user)
+	}
+	if g, ok := acc.GroupByGID(u.GID); ok {
+		return g.Name, nil
 	}
-	return strings.TrimSpace(out), nil
+	// like id -gn, fall back to the number for groups without an entry
+	return strconv.Itoa(u.GID), nil
 }
 
 func GetUserGroups(user string) ([]string, error) {
-	out, errStr, code, err := run(3*time.Second, "id", "-nG", user)
-	if err != nil && code == 0 {
+	acc, err := LoadAccounts()
+	if err != nil {
 		return nil, err
 	}
-	if code != 0 {
-		return nil, fmt.Errorf("id -nG failed: %s", strings.TrimSpace(errStr))
+	if _, ok := acc.User(user); !ok {
+		return nil, fmt.Errorf("no such user: %s", user)
 	}
-	return strings.Fields(out), nil
+	var names []string
+	for _, g := range acc.GroupsOf(user) {
+		names = append(names, g.Name)
+	}
+	return names, nil
 }
 
 // Sets supplementary groups exactly to the given list.
@@ -120,21 +109,30 @@ func SetUserSupplementaryGroups(user string, groups []string) error {
 }
 
 func GetLinuxGroupGID(name string) (*int, error) {
-	out, errStr, code, err := run(3*time.Second, "getent", "group", name)
-	if err != nil && code == 0 {
+	acc, err := LoadAccounts()
+	if err != nil {
 		return nil, err
 	}
-	if code != 0 {
-		return nil, fmt.Errorf("getent group failed: %s", strings.TrimSpace(errStr))
+	g, ok := acc.Group(name)
+	if !ok {
+		return nil, fmt.Errorf("no such group: %s", name)
 	}
-	// name:x:gid:members
-	parts := strings.Split(strings.TrimSpace(out), ":")
-	if len(parts) < 3 {
-		return nil, fmt.Errorf("unexpected getent group format")
+	gid := g.GID
+	return &gid, nil
+}
+
+func SetLinuxGroupGID(name string, gid int) error {
+	_, errStr, code, _ := run(5*time.Second, "groupmod", "-g", strconv.Itoa(gid), name)
+	if code != 0 {
+		return fmt.Errorf("groupmod failed: %s", strings.TrimSpace(errStr))
 	}
-	gid, convErr := strconv.Atoi(parts[2])
-	if convErr != nil {
-		return nil, convErr
+	return nil
+}
+
+func RemoveUserFromGroup(user, group string) error {
+	_, errStr, code, _ := run(5*time.Second, "gpasswd", "-d", user, group)
+	if code != 0 {
+		return fmt.Errorf("gpasswd -d failed: %s", strings.TrimSpace(errStr))
 	}
-	return &gid, nil
+	return nil
 }
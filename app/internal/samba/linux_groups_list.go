@@ -1,11 +1,8 @@
 package samba
 
 import (
-	"fmt"
 	"sort"
-	"strconv"
 	"strings"
-	"time"
 )
 
 type LinuxGroupInfo struct {
@@ -15,38 +12,16 @@ type LinuxGroupInfo struct {
 }
 
 func ListLinuxGroups() ([]LinuxGroupInfo, error) {
-	out, errStr, code, _ := run(3*time.Second, "getent", "group")
-	if code != 0 {
-		return nil, fmt.Errorf("getent group failed: %s", strings.TrimSpace(errStr))
+	acc, err := LoadAccounts()
+	if err != nil {
+		return nil, err
 	}
 
-	lines := strings.Split(strings.TrimSpace(out), "\n")
-	res := make([]LinuxGroupInfo, 0, len(lines))
-
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if line == "" {
-			continue
-		}
-
-		// name:x:gid:member1,member2
-		parts := strings.Split(line, ":")
-		if len(parts) < 3 {
-			continue
-		}
-
-		name := parts[0]
-		gid, err := strconv.Atoi(parts[2])
-		if err != nil {
-			continue
-		}
-
+	res := make([]LinuxGroupInfo, 0, len(acc.Groups()))
+	for _, g := range acc.Groups() {
 		members := []string{}
-		if len(parts) >= 4 && strings.TrimSpace(parts[3]) != "" {
-			members = strings.Split(parts[3], ",")
-		}
-
-		res = append(res, LinuxGroupInfo{Name: name, GID: gid, Members: members})
+		members = append(members, g.Members...)
+		res = append(res, LinuxGroupInfo{Name: g.Name, GID: g.GID, Members: members})
 	}
 
 	sort.Slice(res, func(i, j int) bool {
@@ -8,24 +8,30 @@ import (
 )
 
 func GetLinuxUserUIDGID(name string) (uid int, gid int, err error) {
-	out, errStr, code, e := run(3*time.Second, "getent", "passwd", name)
-	if e != nil && code == 0 {
-		return 0, 0, e
-	}
-	if code != 0 {
-		return 0, 0, fmt.Errorf("getent passwd failed: %s", strings.TrimSpace(errStr))
-	}
-	parts := strings.Split(strings.TrimSpace(out), ":")
-	if len(parts) < 4 {
-		return 0, 0, fmt.Errorf("unexpected getent passwd format")
-	}
-	uid, err = strconv.Atoi(parts[2])
+	acc, err := LoadAccounts()
 	if err != nil {
 		return 0, 0, err
 	}
-	gid, err = strconv.Atoi(parts[3])
-	if err != nil {
-		return 0, 0, err
+	u, ok := acc.User(name)
+	if !ok {
+		return 0, 0, fmt.Errorf("no such user: %s", name)
+	}
+	return u.UID, u.GID, nil
+}
+
+func SetLinuxUserIDs(name string, uid, gid int) error {
+	_, errStr, code, _ := run(10*time.Second, "usermod", "-u", strconv.Itoa(uid), "-g", strconv.Itoa(gid), name)
+	if code != 0 {
+		return fmt.Errorf("usermod failed: %s", strings.TrimSpace(errStr))
+	}
+	return nil
+}
+
+// DeleteLinuxUser removes the account but keeps any files it owns.
+func DeleteLinuxUser(name string) error {
+	_, errStr, code, _ := run(10*time.Second, "userdel", name)
+	if code != 0 {
+		return fmt.Errorf("userdel failed: %s", strings.TrimSpace(errStr))
 	}
-	return uid, gid, nil
+	return nil
 }
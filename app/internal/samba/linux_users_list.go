@@ -1,10 +1,7 @@
 package samba
 
 import (
-	"fmt"
-	"os/exec"
 	"sort"
-	"strconv"
 	"strings"
 )
 
@@ -12,49 +9,38 @@ type LinuxUserInfo struct {
 	Name string
 	UID  int
 	GIDs []int
+	// Shadow is whether the user has a shadow entry; nil if unknown.
+	Shadow *bool
 }
 
 func ListLinuxUsersHuman() ([]LinuxUserInfo, error) {
-	out, err := exec.Command("getent", "passwd").Output()
+	acc, err := LoadAccounts()
 	if err != nil {
-		return nil, fmt.Errorf("getent passwd failed: %w", err)
+		return nil, err
 	}
 
-	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
-	users := make([]LinuxUserInfo, 0, len(lines))
-
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if line == "" {
-			continue
-		}
-
-		parts := strings.Split(line, ":")
-		if len(parts) < 4 {
+	users := make([]LinuxUserInfo, 0, len(acc.Users()))
+	for _, u := range acc.Users() {
+		if u.Name == "nobody" || u.UID < 1000 {
 			continue
 		}
 
-		name := parts[0]
-		if name == "nobody" {
-			continue
+		groups := acc.GroupsOf(u.Name)
+		gids := make([]int, 0, len(groups)+1)
+		if len(groups) == 0 || groups[0].GID != u.GID {
+			// primary group without a group entry
+			gids = append(gids, u.GID)
 		}
-
-		uid, err := strconv.Atoi(parts[2])
-		if err != nil {
-			continue
+		for _, g := range groups {
+			gids = append(gids, g.GID)
 		}
+		sort.Ints(gids)
 
-		if uid < 1000 {
-			continue
+		info := LinuxUserInfo{Name: u.Name, UID: u.UID, GIDs: gids}
+		if has, known := acc.HasShadow(u.Name); known {
+			info.Shadow = &has
 		}
-
-		gids, _ := userGIDs(name)
-
-		users = append(users, LinuxUserInfo{
-			Name: name,
-			UID:  uid,
-			GIDs: gids,
-		})
+		users = append(users, info)
 	}
 
 	sort.Slice(users, func(i, j int) bool {
@@ -63,22 +49,3 @@ func ListLinuxUsersHuman() ([]LinuxUserInfo, error) {
 
 	return users, nil
 }
-
-func userGIDs(user string) ([]int, error) {
-	out, err := exec.Command("id", "-G", user).Output()
-	if err != nil {
-		return nil, err
-	}
-
-	fields := strings.Fields(strings.TrimSpace(string(out)))
-	gids := make([]int, 0, len(fields))
-	for _, f := range fields {
-		n, err := strconv.Atoi(f)
-		if err != nil {
-			continue
-		}
-		gids = append(gids, n)
-	}
-	sort.Ints(gids)
-	return gids, nil
-}
@@ -0,0 +1,299 @@
+package samba
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Samba writes one log file per client with "log file = .../log.%m", plus
+// log.smbd and log.nmbd for the daemons. Each entry is a header line
+//
+//	[2024/01/01 12:00:00.123456,  2, pid=1234, effective(0, 0), real(0, 0)] ../../source3/smbd/service.c:1140(make_connection_snum)
+//
+// followed by indented message lines.
+
+// LogFile is one file in the log directory.
+type LogFile struct {
+	Name    string    `json:"name"`   // e.g. "log.192.168.1.10"
+	Client  string    `json:"client"` // the %m part
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+// ErrBadLogName is returned for names that are not log files in the log
+// directory.
+var ErrBadLogName = errors.New("not a Samba log file")
+
+// ValidLogName reports whether name may be read from the log directory.
+func ValidLogName(name string) bool {
+	return len(name) > len("log.") && strings.HasPrefix(name, "log.") &&
+		!strings.ContainsAny(name, "/\\") && !strings.Contains(name, "..")
+}
+
+// ListLogFiles returns the log files in dir, most recently written first.
+func ListLogFiles(dir string) ([]LogFile, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	files := []LogFile{}
+	for _, e := range entries {
+		if !e.Type().IsRegular() || !ValidLogName(e.Name()) {
+			continue
+		}
+		fi, err := e.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, LogFile{
+			Name:    e.Name(),
+			Client:  strings.TrimPrefix(e.Name(), "log."),
+			Size:    fi.Size(),
+			ModTime: fi.ModTime(),
+		})
+	}
+	slices.SortFunc(files, func(a, b LogFile) int {
+		if c := b.ModTime.Compare(a.ModTime); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Name, b.Name)
+	})
+	return files, nil
+}
+
+// LogRecord is one log entry.
+type LogRecord struct {
+	File    string    `json:"file"`
+	Time    time.Time `json:"time"`  // zero if the entry had no header
+	Level   int       `json:"level"` // debug level; -1 if unknown
+	PID     string    `json:"pid,omitempty"`
+	Source  string    `json:"source,omitempty"` // e.g. "../../source3/smbd/server.c:1234(main)"
+	Message string    `json:"message"`
+}
+
+// Severity names the debug level the way the UI shows it.
+func (r LogRecord) Severity() string {
+	switch {
+	case r.Level < 0:
+		return "unknown"
+	case r.Level == 0:
+		return "error"
+	case r.Level == 1:
+		return "warning"
+	case r.Level == 2:
+		return "notice"
+	case r.Level == 3:
+		return "info"
+	}
+	return "debug"
+}
+
+var logHeaderRx = regexp.MustCompile(`^\[(\d{4}/\d\d/\d\d \d\d:\d\d:\d\d(?:\.\d+)?),\s*(\d+)([^\]]*)\]\s*(.*)$`)
+
+var logPidRx = regexp.MustCompile(`pid=(\d+)`)
+
+// LogParser turns log lines into records. A record is complete when the
+// next header arrives or on Flush.
+type LogParser struct {
+	File string
+	cur  *LogRecord
+	body []string
+}
+
+// Line feeds one line (without newline) and returns the record it
+// completed, if any.
+func (p *LogParser) Line(line string) *LogRecord {
+	line = strings.TrimRight(line, "\r")
+	m := logHeaderRx.FindStringSubmatch(line)
+	if m == nil {
+		if p.cur == nil {
+			if strings.TrimSpace(line) == "" {
+				return nil
+			}
+			p.cur = &LogRecord{File: p.File, Level: -1}
+		}
+		p.body = append(p.body, line)
+		return nil
+	}
+
+	done := p.Flush()
+	p.cur = &LogRecord{File: p.File, Level: -1, Source: m[4]}
+	if t, err := time.ParseInLocation("2006/01/02 15:04:05.999999", m[1], time.Local); err == nil {
+		p.cur.Time = t
+	}
+	if lvl, err := strconv.Atoi(m[2]); err == nil {
+		p.cur.Level = lvl
+	}
+	if pm := logPidRx.FindStringSubmatch(m[3]); pm != nil {
+		p.cur.PID = pm[1]
+	}
+	return done
+}
+
+// Flush returns the pending record, if any.
+func (p *LogParser) Flush() *LogRecord {
+	if p.cur == nil {
+		return nil
+	}
+	r := p.cur
+	r.Message = dedent(p.body)
+	p.cur, p.body = nil, nil
+	return r
+}
+
+// dedent strips the common indentation of message lines and trailing blank
+// lines.
+func dedent(lines []string) string {
+	for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+		lines = lines[:len(lines)-1]
+	}
+	indent := -1
+	for _, l := range lines {
+		if strings.TrimSpace(l) == "" {
+			continue
+		}
+		n := len(l) - len(strings.TrimLeft(l, " \t"))
+		if indent < 0 || n < indent {
+			indent = n
+		}
+	}
+	out := make([]string, len(lines))
+	for i, l := range lines {
+		if len(l) >= indent && indent > 0 {
+			l = l[indent:]
+		}
+		out[i] = l
+	}
+	return strings.Join(out, "\n")
+}
+
+// ParseLog parses a complete chunk of log data.
+func ParseLog(file string, data []byte) []LogRecord {
+	p := &LogParser{File: file}
+	var recs []LogRecord
+	for _, line := range strings.Split(string(data), "\n") {
+		if r := p.Line(line); r != nil {
+			recs = append(recs, *r)
+		}
+	}
+	if r := p.Flush(); r != nil {
+		recs = append(recs, *r)
+	}
+	return recs
+}
+
+// LogFilter selects records.
+type LogFilter struct {
+	MaxLevel int    // show levels up to this; records without level always match
+	Keyword  string // case-insensitive, in message or source
+}
+
+// Match reports whether r passes the filter.
+func (f LogFilter) Match(r LogRecord) bool {
+	if r.Level >= 0 && r.Level > f.MaxLevel {
+		return false
+	}
+	if f.Keyword != "" {
+		kw := strings.ToLower(f.Keyword)
+		if !strings.Contains(strings.ToLower(r.Message), kw) && !strings.Contains(strings.ToLower(r.Source), kw) {
+			return false
+		}
+	}
+	return true
+}
+
+func openLog(dir, name string) (*os.File, error) {
+	if !ValidLogName(name) {
+		return nil, ErrBadLogName
+	}
+	r, err := os.OpenRoot(dir)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	f, err := r.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	if fi, err := f.Stat(); err != nil || !fi.Mode().IsRegular() {
+		f.Close()
+		return nil, ErrBadLogName
+	}
+	return f, nil
+}
+
+// ReadLogTail parses about the last limit bytes of log file name in dir and
+// returns the records and the offset reading stopped at. A record cut off
+// at the start is dropped.
+func ReadLogTail(dir, name string, limit int64) ([]LogRecord, int64, error) {
+	f, err := openLog(dir, name)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, 0, err
+	}
+	start := max(0, fi.Size()-limit)
+	data := make([]byte, fi.Size()-start)
+	n, err := f.ReadAt(data, start)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, 0, err
+	}
+	data = data[:n]
+	if start > 0 {
+		// skip to the first header after the cut
+		i := bytes.Index(data, []byte("\n["))
+		if i < 0 {
+			return nil, start + int64(n), nil
+		}
+		data = data[i+1:]
+	}
+	return ParseLog(name, data), start + int64(n), nil
+}
+
+// ReadLogFrom returns the complete lines appended to log file name since
+// offset and the new offset. If the file shrank (rotated), it starts over.
+func ReadLogFrom(dir, name string, offset int64) ([]string, int64, error) {
+	f, err := openLog(dir, name)
+	if err != nil {
+		return nil, offset, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, offset, err
+	}
+	if fi.Size() < offset {
+		offset = 0
+	}
+	if fi.Size() == offset {
+		return nil, offset, nil
+	}
+	const maxChunk = 1 << 20
+	data := make([]byte, min(fi.Size()-offset, maxChunk))
+	n, err := f.ReadAt(data, offset)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, offset, err
+	}
+	data = data[:n]
+	end := bytes.LastIndexByte(data, '\n')
+	if end < 0 {
+		if len(data) == maxChunk {
+			// a single huge line; pass it on rather than stall
+			return []string{string(data)}, offset + int64(n), nil
+		}
+		return nil, offset, nil
+	}
+	return strings.Split(string(data[:end]), "\n"), offset + int64(end) + 1, nil
+}
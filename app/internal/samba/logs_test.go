@@ -0,0 +1,119 @@
+package samba
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const smbLog = `  continued from an entry cut off by rotation
+[2024/01/01 12:00:00.123456,  0] ../../source3/smbd/server.c:1734(main)
+  smbd version 4.17.12-Debian started.
+  Copyright Andrew Tridgell and the Samba Team 1992-2022
+[2024/01/01 12:00:05.000001,  3, pid=1234, effective(0, 0), real(0, 0), class=auth] ../../source3/auth/auth.c:201(auth_check_ntlm_password)
+  check_ntlm_password:  Checking password for unmapped user [WORKGROUP]\[alice]@[PC] with the new password interface
+[2024/01/01 12:00:06,  1, pid=1234] ../../source3/smbd/service.c:1140(make_connection_snum)
+  make_connection_snum: NT_STATUS_BAD_NETWORK_NAME
+`
+
+func TestParseLog(t *testing.T) {
+	recs := ParseLog("log.192.168.1.10", []byte(smbLog))
+	if len(recs) != 4 {
+		t.Fatalf("got %d records: %+v", len(recs), recs)
+	}
+	if r := recs[0]; r.Level != -1 || r.Message != "continued from an entry cut off by rotation" || r.Severity() != "unknown" {
+		t.Errorf("headerless record = %+v", r)
+	}
+	r := recs[1]
+	if r.Level != 0 || r.Severity() != "error" || r.Source != "../../source3/smbd/server.c:1734(main)" || r.File != "log.192.168.1.10" {
+		t.Errorf("record = %+v", r)
+	}
+	if r.Message != "smbd version 4.17.12-Debian started.\nCopyright Andrew Tridgell and the Samba Team 1992-2022" {
+		t.Errorf("message = %q", r.Message)
+	}
+	if got := r.Time.Format("2006-01-02 15:04:05.000000"); got != "2024-01-01 12:00:00.123456" {
+		t.Errorf("time = %s", got)
+	}
+	if r := recs[2]; r.Level != 3 || r.PID != "1234" || !strings.HasPrefix(r.Message, "check_ntlm_password:") {
+		t.Errorf("record = %+v", r)
+	}
+	if r := recs[3]; r.Level != 1 || r.Time.Second() != 6 || r.Severity() != "warning" {
+		t.Errorf("record = %+v", r)
+	}
+}
+
+func TestLogFilter(t *testing.T) {
+	recs := ParseLog("log.smbd", []byte(smbLog))
+	count := func(f LogFilter) int {
+		n := 0
+		for _, r := range recs {
+			if f.Match(r) {
+				n++
+			}
+		}
+		return n
+	}
+	if n := count(LogFilter{MaxLevel: 1}); n != 3 {
+		t.Errorf("level <= 1: %d records", n)
+	}
+	if n := count(LogFilter{MaxLevel: 10, Keyword: "bad_network"}); n != 1 {
+		t.Errorf("keyword: %d records", n)
+	}
+	if n := count(LogFilter{MaxLevel: 10, Keyword: "service.c"}); n != 1 {
+		t.Errorf("keyword in source: %d records", n)
+	}
+}
+
+func TestReadLog(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "log.pc1")
+	if err := os.WriteFile(path, []byte(smbLog), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "smb.conf"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := ListLogFiles(dir)
+	if err != nil || len(files) != 1 || files[0].Client != "pc1" {
+		t.Fatalf("ListLogFiles = %+v, %v", files, err)
+	}
+
+	// cut inside the second record: the partial entry is dropped
+	recs, end, err := ReadLogTail(dir, "log.pc1", int64(len(smbLog)-strings.Index(smbLog, "smbd version")))
+	if err != nil || end != int64(len(smbLog)) {
+		t.Fatalf("ReadLogTail = %d, %v", end, err)
+	}
+	if len(recs) != 2 || recs[0].Level != 3 {
+		t.Errorf("tail = %+v", recs)
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("[2024/01/01 12:01:00,  2] x.c:1(f)\n  first\n  part")
+	f.Close()
+	lines, off, err := ReadLogFrom(dir, "log.pc1", end)
+	if err != nil || len(lines) != 2 || lines[1] != "  first" {
+		t.Fatalf("ReadLogFrom = %q, %v", lines, err)
+	}
+	if lines, _, _ := ReadLogFrom(dir, "log.pc1", off); lines != nil {
+		t.Errorf("incomplete line returned: %q", lines)
+	}
+
+	// rotated: the new file is shorter than the offset
+	if err := os.WriteFile(path, []byte("[2024/01/01 12:02:00,  0] y.c:2(g)\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if lines, _, _ := ReadLogFrom(dir, "log.pc1", off); len(lines) != 1 {
+		t.Errorf("after rotation = %q", lines)
+	}
+
+	for _, name := range []string{"smb.conf", "../log.x", "log.", "log.a/b"} {
+		if _, _, err := ReadLogTail(dir, name, 100); err != ErrBadLogName {
+			t.Errorf("ReadLogTail(%q) err = %v", name, err)
+		}
+	}
+}
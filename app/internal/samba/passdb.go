@@ -0,0 +1,105 @@
+package samba
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PassdbEntry is one line of a passdb export in smbpasswd format:
+//
+//	name:uid:LM hash:NT hash:[flags]:LCT-hex:
+type PassdbEntry struct {
+	Name     string
+	UID      int
+	Flags    string // e.g. "[U          ]"
+	Disabled bool
+
+	hashes string // LM and NT hash, only compared
+}
+
+// SameSecret reports whether e and o carry the same password hashes.
+func (e PassdbEntry) SameSecret(o PassdbEntry) bool {
+	return e.hashes == o.hashes
+}
+
+// ExportPassdb returns the passdb in smbpasswd format (`pdbedit -e`). The
+// result contains password hashes.
+func ExportPassdb() ([]byte, error) {
+	dir, err := os.MkdirTemp("", "passdb-export-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "smbpasswd")
+	_, errStr, code, err := run(30*time.Second, "pdbedit", "-e", "smbpasswd:"+path)
+	if code != 0 {
+		if errStr == "" && err != nil {
+			errStr = err.Error()
+		}
+		return nil, fmt.Errorf("pdbedit -e failed: %s", strings.TrimSpace(errStr))
+	}
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return []byte{}, nil // empty passdb
+	}
+	return b, err
+}
+
+// ImportPassdb adds or updates the accounts of an smbpasswd export in the
+// passdb (`pdbedit -i`). Their Linux users must exist.
+func ImportPassdb(data []byte) error {
+	dir, err := os.MkdirTemp("", "passdb-import-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "smbpasswd")
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return err
+	}
+	_, errStr, code, err := run(30*time.Second, "pdbedit", "-i", "smbpasswd:"+path)
+	if code != 0 {
+		if errStr == "" && err != nil {
+			errStr = err.Error()
+		}
+		return fmt.Errorf("pdbedit -i failed: %s", strings.TrimSpace(errStr))
+	}
+	return nil
+}
+
+// ParseSmbpasswd parses a passdb export. Comment and empty lines are
+// skipped.
+func ParseSmbpasswd(data []byte) ([]PassdbEntry, error) {
+	var res []PassdbEntry
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		f := strings.Split(line, ":")
+		if len(f) < 6 || f[0] == "" {
+			return nil, fmt.Errorf("smbpasswd line %d: expected name:uid:lm:nt:flags:lct", i+1)
+		}
+		uid, err := strconv.Atoi(f[1])
+		if err != nil {
+			return nil, fmt.Errorf("smbpasswd line %d: bad uid %q", i+1, f[1])
+		}
+		if !strings.HasPrefix(f[4], "[") || !strings.HasSuffix(f[4], "]") {
+			return nil, fmt.Errorf("smbpasswd line %d: bad account flags %q", i+1, f[4])
+		}
+		res = append(res, PassdbEntry{
+			Name:     f[0],
+			UID:      uid,
+			Flags:    f[4],
+			Disabled: strings.Contains(f[4], "D"),
+			hashes:   f[2] + ":" + f[3],
+		})
+	}
+	return res, nil
+}
@@ -0,0 +1,24 @@
+package samba
+
+import "testing"
+
+func TestParseSmbpasswd(t *testing.T) {
+	data := []byte(`# comment
+alice:1000:XXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXX:8846F7EAEE8FB117AD06BDD830B7586C:[U          ]:LCT-65920000:
+bob:1001:XXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXX:0CB6948805F797BF2A82807973B89537:[DU         ]:LCT-65920000:
+`)
+	entries, err := ParseSmbpasswd(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Name != "alice" || entries[0].UID != 1000 || entries[0].Disabled || !entries[1].Disabled {
+		t.Fatalf("entries = %+v", entries)
+	}
+	if entries[0].SameSecret(entries[1]) || !entries[0].SameSecret(entries[0]) {
+		t.Error("SameSecret compares the wrong thing")
+	}
+
+	if _, err := ParseSmbpasswd([]byte("alice:x:y\n")); err == nil {
+		t.Error("short line accepted")
+	}
+}
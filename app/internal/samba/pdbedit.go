@@ -0,0 +1,144 @@
+package samba
+
+import (
+	"bufio"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SambaUser is one passdb entry as reported by `pdbedit -L -v`.
+type SambaUser struct {
+	Name         string `json:"name"`
+	FullName     string `json:"full_name,omitempty"`
+	SID          string `json:"sid,omitempty"`
+	AccountFlags string `json:"account_flags"` // e.g. "[U          ]"
+
+	Disabled             bool `json:"disabled"`
+	Locked               bool `json:"locked"`
+	PasswordNeverExpires bool `json:"password_never_expires"`
+	PasswordNotRequired  bool `json:"password_not_required"`
+
+	PasswordLastSet  *time.Time `json:"password_last_set,omitempty"`
+	LogonTime        *time.Time `json:"logon_time,omitempty"`
+	LogoffTime       *time.Time `json:"logoff_time,omitempty"`
+	LastBadPassword  *time.Time `json:"last_bad_password,omitempty"`
+	BadPasswordCount int        `json:"bad_password_count"`
+}
+
+// pdbeditTimeLayouts are the formats pdbedit uses for timestamps
+// (http_timestring), with and without a zone abbreviation.
+var pdbeditTimeLayouts = []string{
+	"Mon, 02 Jan 2006 15:04:05 MST",
+	"Mon, 02 Jan 2006 15:04:05 -0700",
+	"Mon, 02 Jan 2006 15:04:05",
+}
+
+// pdbeditEndOfTime is just before the "end of time" sentinel pdbedit prints
+// for logoff and kickoff times that never arrive (Wed, 06 Feb 2036 or Thu,
+// 07 Feb 2036, depending on the Samba version and the local zone).
+var pdbeditEndOfTime = time.Date(2036, time.February, 5, 0, 0, 0, 0, time.UTC)
+
+func ListSambaUsers() ([]SambaUser, error) {
+	out, errStr, code, err := run(10*time.Second, "pdbedit", "-L", "-v")
+	if err != nil && code == 0 {
+		return nil, err
+	}
+	if code != 0 {
+		return nil, fmt.Errorf("pdbedit failed: %s", strings.TrimSpace(errStr))
+	}
+	return ParsePdbeditVerbose(out)
+}
+
+// ParsePdbeditVerbose parses the output of `pdbedit -L -v`: blocks of
+// "Key: value" lines separated by dashed lines.
+func ParsePdbeditVerbose(out string) ([]SambaUser, error) {
+	var users []SambaUser
+	var cur *SambaUser
+
+	flush := func() {
+		if cur != nil && cur.Name != "" {
+			users = append(users, *cur)
+		}
+		cur = nil
+	}
+
+	sc := bufio.NewScanner(strings.NewReader(out))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "---") {
+			flush()
+			continue
+		}
+
+		k, v, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key := strings.ToLower(strings.TrimSpace(k))
+		val := strings.TrimSpace(v)
+
+		if cur == nil {
+			cur = &SambaUser{}
+		}
+		switch key {
+		case "unix username":
+			if cur.Name != "" {
+				// no separator between entries
+				flush()
+				cur = &SambaUser{}
+			}
+			cur.Name = val
+		case "full name":
+			cur.FullName = val
+		case "user sid":
+			cur.SID = val
+		case "account flags":
+			cur.AccountFlags = val
+			flags := strings.Trim(val, "[] ")
+			cur.Disabled = strings.ContainsRune(flags, 'D')
+			cur.Locked = strings.ContainsRune(flags, 'L')
+			cur.PasswordNeverExpires = strings.ContainsRune(flags, 'X')
+			cur.PasswordNotRequired = strings.ContainsRune(flags, 'N')
+		case "password last set":
+			cur.PasswordLastSet = parsePdbeditTime(val)
+		case "logon time":
+			cur.LogonTime = parsePdbeditTime(val)
+		case "logoff time":
+			cur.LogoffTime = parsePdbeditTime(val)
+		case "last bad password":
+			cur.LastBadPassword = parsePdbeditTime(val)
+		case "bad password count":
+			cur.BadPasswordCount, _ = strconv.Atoi(val)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	flush()
+
+	return users, nil
+}
+
+// parsePdbeditTime returns nil for "0", "never", the epoch, the 2036
+// sentinel and unparsable values.
+func parsePdbeditTime(v string) *time.Time {
+	v = strings.TrimSpace(v)
+	if v == "" || v == "0" || strings.EqualFold(v, "never") {
+		return nil
+	}
+	for _, layout := range pdbeditTimeLayouts {
+		if t, err := time.ParseInLocation(layout, v, time.Local); err == nil {
+			// Epoch-ish values and the 2036 sentinel stand for "never".
+			if t.Year() <= 1970 || !t.Before(pdbeditEndOfTime) {
+				return nil
+			}
+			return &t
+		}
+	}
+	return nil
+}
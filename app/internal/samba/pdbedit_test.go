@@ -0,0 +1,172 @@
+package samba
+
+import (
+	"testing"
+	"time"
+)
+
+// pdbeditAlice and pdbeditBob are `pdbedit -L -v` records as Samba 4.17
+// prints them.
+const pdbeditAlice = `Unix username:        alice
+NT username:
+Account Flags:        [U          ]
+User SID:             S-1-5-21-1234567890-1234567890-1234567890-1000
+Primary Group SID:    S-1-5-21-1234567890-1234567890-1234567890-513
+Full Name:            Alice Example
+Home Directory:       \\nas\alice
+HomeDir Drive:
+Logon Script:
+Profile Path:         \\nas\alice\profile
+Domain:               NAS
+Account desc:
+Workstations:
+Munged dial:
+Logon time:           0
+Logoff time:          Wed, 06 Feb 2036 15:06:39 UTC
+Kickoff time:         Wed, 06 Feb 2036 15:06:39 UTC
+Password last set:    Sun, 12 Jan 2025 10:00:00 UTC
+Password can change:  Sun, 12 Jan 2025 10:00:00 UTC
+Password must change: never
+Last bad password   : 0
+Bad password count  : 0
+Logon hours         : FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFF
+`
+
+const pdbeditBob = `Unix username:        bob
+NT username:
+Account Flags:        [DU         ]
+User SID:             S-1-5-21-1234567890-1234567890-1234567890-1001
+Primary Group SID:    S-1-5-21-1234567890-1234567890-1234567890-513
+Full Name:
+Logon time:           Thu, 01 Jan 1970 00:00:00 UTC
+Logoff time:          Thu, 07 Feb 2036 06:28:15 UTC
+Kickoff time:         Thu, 07 Feb 2036 06:28:15 UTC
+Password last set:    Thu, 01 Jan 1970 00:00:00 UTC
+Password can change:  0
+Password must change: never
+Last bad password   : Mon, 03 Mar 2025 08:15:00 UTC
+Bad password count  : 3
+Logon hours         : FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFF
+`
+
+func TestParsePdbeditVerbose(t *testing.T) {
+	date := func(s string) *time.Time {
+		t, _ := time.Parse(time.RFC3339, s)
+		return &t
+	}
+
+	tests := []struct {
+		name      string
+		out       string
+		want      []SambaUser
+		namesOnly bool
+	}{
+		{
+			name: "separated records",
+			out:  "---------------\n" + pdbeditAlice + "---------------\n" + pdbeditBob,
+			want: []SambaUser{
+				{
+					Name:            "alice",
+					FullName:        "Alice Example",
+					SID:             "S-1-5-21-1234567890-1234567890-1234567890-1000",
+					AccountFlags:    "[U          ]",
+					PasswordLastSet: date("2025-01-12T10:00:00Z"),
+				},
+				{
+					Name:             "bob",
+					SID:              "S-1-5-21-1234567890-1234567890-1234567890-1001",
+					AccountFlags:     "[DU         ]",
+					Disabled:         true,
+					LastBadPassword:  date("2025-03-03T08:15:00Z"),
+					BadPasswordCount: 3,
+				},
+			},
+		},
+		{
+			name:      "records without separator",
+			out:       pdbeditAlice + pdbeditBob,
+			want:      []SambaUser{{Name: "alice"}, {Name: "bob"}},
+			namesOnly: true,
+		},
+		{
+			name: "missing fields",
+			out:  "Unix username: carol\n---------------\nUnix username: dave\nAccount Flags: [ULX        ]\n",
+			want: []SambaUser{
+				{Name: "carol"},
+				{Name: "dave", AccountFlags: "[ULX        ]", Locked: true, PasswordNeverExpires: true},
+			},
+		},
+		{
+			name: "unknown fields and lines",
+			out:  "---------------\nUnix username: erin\nFavourite colour: blue\nno colon here\nLogoff time: never\nLogon time: yesterday\n---------------\n",
+			want: []SambaUser{{Name: "erin"}},
+		},
+		{
+			name: "record without a name",
+			out:  "Full Name: nobody\nAccount Flags: [N          ]\n",
+		},
+		{
+			name: "empty",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParsePdbeditVerbose(tt.out)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d users, want %d: %+v", len(got), len(tt.want), got)
+			}
+			for i, want := range tt.want {
+				g := got[i]
+				if g.Name != want.Name {
+					t.Errorf("user %d = %q, want %q", i, g.Name, want.Name)
+				}
+				if tt.namesOnly {
+					continue
+				}
+				if g.FullName != want.FullName || g.SID != want.SID || g.AccountFlags != want.AccountFlags {
+					t.Errorf("%s: name/sid/flags = %q %q %q", g.Name, g.FullName, g.SID, g.AccountFlags)
+				}
+				if g.Disabled != want.Disabled || g.Locked != want.Locked ||
+					g.PasswordNeverExpires != want.PasswordNeverExpires || g.PasswordNotRequired != want.PasswordNotRequired {
+					t.Errorf("%s: flags = %+v", g.Name, g)
+				}
+				if g.BadPasswordCount != want.BadPasswordCount {
+					t.Errorf("%s: bad password count = %d", g.Name, g.BadPasswordCount)
+				}
+				for field, pair := range map[string][2]*time.Time{
+					"password last set": {g.PasswordLastSet, want.PasswordLastSet},
+					"logon time":        {g.LogonTime, want.LogonTime},
+					"logoff time":       {g.LogoffTime, want.LogoffTime},
+					"last bad password": {g.LastBadPassword, want.LastBadPassword},
+				} {
+					if (pair[0] == nil) != (pair[1] == nil) || pair[0] != nil && !pair[0].Equal(*pair[1]) {
+						t.Errorf("%s: %s = %v, want %v", g.Name, field, pair[0], pair[1])
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestParsePdbeditTime(t *testing.T) {
+	for _, v := range []string{
+		"", "0", "never", "Never",
+		"Thu, 01 Jan 1970 00:00:00 UTC",
+		"Thu, 01 Jan 1970 01:00:00 CET",
+		"Wed, 06 Feb 2036 15:06:39 CET",
+		"Thu, 07 Feb 2036 06:28:15 UTC",
+		"Thu, 07 Feb 2036 07:28:15 +0100",
+		"9223372036854775807",
+	} {
+		if got := parsePdbeditTime(v); got != nil {
+			t.Errorf("%q = %v, want never", v, got)
+		}
+	}
+	if got := parsePdbeditTime("Sun, 12 Jan 2025 10:00:00 +0100"); got == nil || got.UTC().Hour() != 9 {
+		t.Errorf("real date = %v", got)
+	}
+}
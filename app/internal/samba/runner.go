@@ -0,0 +1,80 @@
+package samba
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Runner executes external commands on behalf of this package. The default
+// runs them via os/exec; tests install a fake (see package sambatest).
+type Runner interface {
+	// Run executes name with args, feeding stdin if non-empty, and returns
+	// stdout, stderr and the exit code. err is only set if the command
+	// could not be run at all (not found, timeout, ...).
+	Run(ctx context.Context, stdin string, name string, args ...string) (stdout, stderr string, code int, err error)
+}
+
+var (
+	runnerMu sync.RWMutex
+	runner   Runner = ExecRunner{}
+)
+
+// SetRunner replaces the Runner used by all functions of this package and
+// returns the previous one.
+func SetRunner(r Runner) Runner {
+	runnerMu.Lock()
+	defer runnerMu.Unlock()
+	prev := runner
+	runner = r
+	return prev
+}
+
+func currentRunner() Runner {
+	runnerMu.RLock()
+	defer runnerMu.RUnlock()
+	return runner
+}
+
+// ExecRunner runs commands as real processes.
+type ExecRunner struct{}
+
+func (ExecRunner) Run(ctx context.Context, stdin string, name string, args ...string) (string, string, int, error) {
+	cmd := exec.CommandContext(ctx, name, args...)
+	var out, errb bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &errb
+	if stdin != "" {
+		cmd.Stdin = strings.NewReader(stdin)
+	}
+
+	err := cmd.Run()
+
+	exitCode := 0
+	if err != nil {
+		var ee *exec.ExitError
+		if errors.As(err, &ee) {
+			exitCode = ee.ExitCode()
+		} else if errors.Is(err, context.DeadlineExceeded) {
+			return out.String(), errb.String(), 124, fmt.Errorf("timeout running %s", name)
+		} else {
+			return out.String(), errb.String(), 1, err
+		}
+	}
+	return out.String(), errb.String(), exitCode, nil
+}
+
+func run(timeout time.Duration, name string, args ...string) (string, string, int, error) {
+	return runWithStdin(timeout, "", name, args...)
+}
+
+func runWithStdin(timeout time.Duration, stdin string, name string, args ...string) (string, string, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return currentRunner().Run(ctx, stdin, name, args...)
+}
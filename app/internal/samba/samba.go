@@ -1,70 +1,14 @@
 package samba
 
 import (
-	"bytes"
-	"context"
-	"errors"
 	"fmt"
 	"os"
-	"os/exec"
 	"strconv"
 	"strings"
 	"syscall"
 	"time"
 )
 
-func run(timeout time.Duration, name string, args ...string) (string, string, int, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
-	defer cancel()
-
-	cmd := exec.CommandContext(ctx, name, args...)
-	var out, errb bytes.Buffer
-	cmd.Stdout = &out
-	cmd.Stderr = &errb
-
-	err := cmd.Run()
-
-	exitCode := 0
-	if err != nil {
-		var ee *exec.ExitError
-		if errors.As(err, &ee) {
-			exitCode = ee.ExitCode()
-		} else if errors.Is(err, context.DeadlineExceeded) {
-			return out.String(), errb.String(), 124, fmt.Errorf("timeout running %s", name)
-		} else {
-			return out.String(), errb.String(), 1, err
-		}
-	}
-
-	return out.String(), errb.String(), exitCode, nil
-}
-
-func runWithStdin(timeout time.Duration, stdin string, name string, args ...string) (string, string, int, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
-	defer cancel()
-
-	cmd := exec.CommandContext(ctx, name, args...)
-	var out, errb bytes.Buffer
-	cmd.Stdout = &out
-	cmd.Stderr = &errb
-	cmd.Stdin = strings.NewReader(stdin)
-
-	err := cmd.Run()
-
-	exitCode := 0
-	if err != nil {
-		var ee *exec.ExitError
-		if errors.As(err, &ee) {
-			exitCode = ee.ExitCode()
-		} else if errors.Is(err, context.DeadlineExceeded) {
-			return out.String(), errb.String(), 124, fmt.Errorf("timeout running %s", name)
-		} else {
-			return out.String(), errb.String(), 1, err
-		}
-	}
-	return out.String(), errb.String(), exitCode, nil
-}
-
 func TestparmOK(smbConf string) (bool, string) {
 	_, errStr, code, err := run(5*time.Second, "testparm", "-s", smbConf)
 	if err != nil && code == 0 {
@@ -151,32 +95,13 @@ func ReloadConfig() error {
 	return nil
 }
 
-func ListSambaUsers() ([]string, error) {
-	out, errStr, code, err := run(5*time.Second, "pdbedit", "-L")
-	if err != nil && code == 0 {
-		return nil, err
-	}
-	if code != 0 {
-		return nil, fmt.Errorf("pdbedit failed: %s", strings.TrimSpace(errStr))
-	}
-	var users []string
-	for _, ln := range strings.Split(out, "\n") {
-		line := strings.TrimSpace(ln)
-		if line == "" {
-			continue
-		}
-		// format: user:UID:... (varies)
-		parts := strings.SplitN(line, ":", 2)
-		if len(parts) >= 1 && parts[0] != "" {
-			users = append(users, parts[0])
-		}
-	}
-	return users, nil
-}
-
 func LinuxUserExists(user string) bool {
-	_, _, code, _ := run(2*time.Second, "getent", "passwd", user)
-	return code == 0
+	acc, err := LoadAccounts()
+	if err != nil {
+		return false
+	}
+	_, ok := acc.User(user)
+	return ok
 }
 
 func PathPerms(path string) (bool, string) {
@@ -238,8 +163,11 @@ func CreateSambaUser(name string, password string) error {
 
 func EnsureGroupExists(gid int) error {
 	// check by gid
-	_, _, code, _ := run(2*time.Second, "getent", "group", strconv.Itoa(gid))
-	if code == 0 {
+	acc, err := LoadAccounts()
+	if err != nil {
+		return err
+	}
+	if _, ok := acc.GroupByGID(gid); ok {
 		return nil
 	}
 
@@ -0,0 +1,824 @@
+// Package sambatest provides an in-memory stand-in for the system commands
+// used by package samba, so that code built on it can be tested without
+// Samba or root.
+package sambatest
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/florianibach/samba-admin-ui/internal/samba"
+)
+
+type User struct {
+	UID    int
+	GID    int // primary group
+	Groups map[string]bool
+}
+
+type Group struct {
+	GID int
+}
+
+type SambaAccount struct {
+	Password string
+	Disabled bool
+	FullName string
+}
+
+// Handler implements one scripted command.
+type Handler func(stdin string, args []string) (stdout, stderr string, code int)
+
+// System is a fake Linux/Samba host implementing samba.Runner and
+// samba.AccountFileReader. It simulates getent, id, useradd, userdel,
+// usermod, groupadd, groupdel, groupmod, gpasswd, pdbedit (including
+// smbpasswd-format export and import), smbpasswd, testparm, getfacl,
+// setfacl, smbstatus, smbcontrol and pidof. Anything else fails with exit
+// code 127 unless scripted with Script.
+type System struct {
+	mu sync.Mutex
+
+	Users  map[string]*User
+	Groups map[string]*Group
+	Passdb map[string]*SambaAccount
+
+	// ACLs holds the named and default ACL entries per path in setfacl
+	// syntax ("user:alice:rwx", "default:group:family:r-x").
+	ACLs map[string][]string
+
+	// SmbStatus is the output of smbstatus --json; empty means no
+	// connections.
+	SmbStatus string
+
+	// TestparmError makes testparm fail with this message.
+	TestparmError string
+	SmbdRunning   bool
+
+	calls        []string
+	accountReads int
+	scripts      map[string]Handler
+}
+
+// NewSystem returns an empty host that only knows root.
+func NewSystem() *System {
+	return &System{
+		Users:       map[string]*User{"root": {UID: 0, GID: 0, Groups: map[string]bool{}}},
+		Groups:      map[string]*Group{"root": {GID: 0}},
+		Passdb:      map[string]*SambaAccount{},
+		ACLs:        map[string][]string{},
+		SmbdRunning: true,
+		scripts:     map[string]Handler{},
+	}
+}
+
+// Install creates a System and makes it the samba package's Runner for the
+// duration of the test.
+func Install(t testing.TB) *System {
+	t.Helper()
+	sys := NewSystem()
+	prev := samba.SetRunner(sys)
+	t.Cleanup(func() { samba.SetRunner(prev) })
+	return sys
+}
+
+// Script overrides (or adds) the command name. Calls are still recorded.
+func (s *System) Script(name string, h Handler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.scripts[name] = h
+}
+
+// AddUser creates a user together with its user private group.
+func (s *System) AddUser(name string, uid int, groups ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Groups[name] = &Group{GID: uid}
+	u := &User{UID: uid, GID: uid, Groups: map[string]bool{}}
+	for _, g := range groups {
+		u.Groups[g] = true
+	}
+	s.Users[name] = u
+}
+
+func (s *System) AddGroup(name string, gid int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Groups[name] = &Group{GID: gid}
+}
+
+// Calls returns every command run so far as "name arg1 arg2 ...".
+func (s *System) Calls() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.calls...)
+}
+
+// AccountReads returns how often the passwd file was read.
+func (s *System) AccountReads() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.accountReads
+}
+
+// Mutations returns the calls that would change the system.
+func (s *System) Mutations() []string {
+	var res []string
+	for _, c := range s.Calls() {
+		f := strings.Fields(c)
+		switch f[0] {
+		case "getent", "id", "testparm", "getfacl", "smbstatus", "pidof":
+			continue
+		case "pdbedit":
+			if len(f) > 1 && (f[1] == "-L" || f[1] == "-e") {
+				continue
+			}
+		}
+		res = append(res, c)
+	}
+	return res
+}
+
+// MemberOf reports whether user has group as a supplementary group.
+func (s *System) MemberOf(user, group string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.Users[user]
+	return ok && u.Groups[group]
+}
+
+func (s *System) Run(ctx context.Context, stdin string, name string, args ...string) (string, string, int, error) {
+	if err := ctx.Err(); err != nil {
+		return "", "", 124, err
+	}
+
+	s.mu.Lock()
+	s.calls = append(s.calls, strings.TrimSpace(name+" "+strings.Join(args, " ")))
+	h, scripted := s.scripts[name]
+	s.mu.Unlock()
+
+	if scripted {
+		out, errStr, code := h(stdin, args)
+		return out, errStr, code, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var out, errStr string
+	var code int
+	switch name {
+	case "getent":
+		out, errStr, code = s.getent(args)
+	case "id":
+		out, errStr, code = s.id(args)
+	case "useradd":
+		out, errStr, code = s.useradd(args)
+	case "userdel":
+		out, errStr, code = s.userdel(args)
+	case "usermod":
+		out, errStr, code = s.usermod(args)
+	case "groupadd":
+		out, errStr, code = s.groupadd(args)
+	case "groupdel":
+		out, errStr, code = s.groupdel(args)
+	case "groupmod":
+		out, errStr, code = s.groupmod(args)
+	case "gpasswd":
+		out, errStr, code = s.gpasswd(args)
+	case "pdbedit":
+		out, errStr, code = s.pdbedit(args)
+	case "smbpasswd":
+		out, errStr, code = s.smbpasswd(stdin, args)
+	case "testparm":
+		out, errStr, code = s.testparm(args)
+	case "getfacl":
+		out, errStr, code = s.getfacl(args)
+	case "setfacl":
+		out, errStr, code = s.setfacl(args)
+	case "smbstatus":
+		out = s.SmbStatus
+		if out == "" {
+			out = `{"sessions": {}, "tcons": {}, "open_files": {}}`
+		}
+	case "smbcontrol":
+		code = 0
+	case "pidof":
+		if !s.SmbdRunning {
+			code = 1
+		}
+	default:
+		return "", "", 127, fmt.Errorf("exec: %q: executable file not found in $PATH", name)
+	}
+	return out, errStr, code, nil
+}
+
+// ReadAccountFile renders the current users and groups as passwd, group and
+// shadow files for samba.LoadAccounts.
+func (s *System) ReadAccountFile(path string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var b strings.Builder
+	switch path {
+	case samba.PasswdFile:
+		s.accountReads++
+		for _, name := range sortedKeys(s.Users, func(a, b string) bool { return s.Users[a].UID < s.Users[b].UID }) {
+			b.WriteString(s.passwdLine(name) + "\n")
+		}
+	case samba.GroupFile:
+		for _, name := range sortedKeys(s.Groups, func(a, b string) bool { return s.Groups[a].GID < s.Groups[b].GID }) {
+			b.WriteString(s.groupLine(name) + "\n")
+		}
+	case samba.ShadowFile:
+		for _, name := range sortedKeys(s.Users, func(a, b string) bool { return a < b }) {
+			b.WriteString(name + ":!:19723:0:99999:7:::\n")
+		}
+	default:
+		return nil, &os.PathError{Op: "open", Path: path, Err: os.ErrNotExist}
+	}
+	return []byte(b.String()), nil
+}
+
+// --- passwd / group ---
+
+func (s *System) passwdLine(name string) string {
+	u := s.Users[name]
+	return fmt.Sprintf("%s:x:%d:%d::/home/%s:/usr/sbin/nologin", name, u.UID, u.GID, name)
+}
+
+func (s *System) groupLine(name string) string {
+	return fmt.Sprintf("%s:x:%d:%s", name, s.Groups[name].GID, strings.Join(s.members(name), ","))
+}
+
+func (s *System) members(group string) []string {
+	var res []string
+	for name, u := range s.Users {
+		if u.Groups[group] {
+			res = append(res, name)
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+func (s *System) groupByGID(gid int) (string, bool) {
+	for name, g := range s.Groups {
+		if g.GID == gid {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// resolveGroup accepts a group name or numeric gid.
+func (s *System) resolveGroup(v string) (string, bool) {
+	if _, ok := s.Groups[v]; ok {
+		return v, true
+	}
+	if gid, err := strconv.Atoi(v); err == nil {
+		return s.groupByGID(gid)
+	}
+	return "", false
+}
+
+func sortedKeys[T any](m map[string]T, less func(a, b string) bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return less(keys[i], keys[j]) })
+	return keys
+}
+
+func (s *System) getent(args []string) (string, string, int) {
+	if len(args) == 0 {
+		return "", "usage: getent database [key ...]\n", 1
+	}
+	switch args[0] {
+	case "passwd":
+		if len(args) > 1 {
+			if _, ok := s.Users[args[1]]; !ok {
+				return "", "", 2
+			}
+			return s.passwdLine(args[1]) + "\n", "", 0
+		}
+		var b strings.Builder
+		for _, name := range sortedKeys(s.Users, func(a, b string) bool { return s.Users[a].UID < s.Users[b].UID }) {
+			b.WriteString(s.passwdLine(name) + "\n")
+		}
+		return b.String(), "", 0
+	case "group":
+		if len(args) > 1 {
+			name, ok := s.resolveGroup(args[1])
+			if !ok {
+				return "", "", 2
+			}
+			return s.groupLine(name) + "\n", "", 0
+		}
+		var b strings.Builder
+		for _, name := range sortedKeys(s.Groups, func(a, b string) bool { return s.Groups[a].GID < s.Groups[b].GID }) {
+			b.WriteString(s.groupLine(name) + "\n")
+		}
+		return b.String(), "", 0
+	}
+	return "", "Unknown database: " + args[0] + "\n", 1
+}
+
+func (s *System) id(args []string) (string, string, int) {
+	if len(args) != 2 {
+		return "", "id: unsupported arguments\n", 1
+	}
+	u, ok := s.Users[args[1]]
+	if !ok {
+		return "", fmt.Sprintf("id: '%s': no such user\n", args[1]), 1
+	}
+	primary, _ := s.groupByGID(u.GID)
+	supp := sortedKeys(u.Groups, func(a, b string) bool { return a < b })
+
+	switch args[0] {
+	case "-gn":
+		return primary + "\n", "", 0
+	case "-nG":
+		names := append([]string{primary}, supp...)
+		return strings.Join(names, " ") + "\n", "", 0
+	case "-G":
+		ids := []string{strconv.Itoa(u.GID)}
+		for _, g := range supp {
+			if grp, ok := s.Groups[g]; ok {
+				ids = append(ids, strconv.Itoa(grp.GID))
+			}
+		}
+		return strings.Join(ids, " ") + "\n", "", 0
+	}
+	return "", "id: unsupported arguments\n", 1
+}
+
+// flags splits args into option values and positional arguments. valued
+// lists the options that take a value.
+func flags(args []string, valued string) (map[string]string, []string) {
+	opts := map[string]string{}
+	var pos []string
+	for i := 0; i < len(args); i++ {
+		a := args[i]
+		if !strings.HasPrefix(a, "-") || a == "-" {
+			pos = append(pos, a)
+			continue
+		}
+		// combined short flags like -aG
+		for j := 1; j < len(a); j++ {
+			f := string(a[j])
+			if strings.Contains(valued, f) && i+1 < len(args) {
+				i++
+				opts[f] = args[i]
+				break
+			}
+			opts[f] = ""
+		}
+	}
+	return opts, pos
+}
+
+func (s *System) nextGID(want int) int {
+	for gid := want; ; gid++ {
+		if _, used := s.groupByGID(gid); !used {
+			return gid
+		}
+	}
+}
+
+func (s *System) nextUID() int {
+	used := map[int]bool{}
+	for _, u := range s.Users {
+		used[u.UID] = true
+	}
+	for uid := 1000; ; uid++ {
+		if !used[uid] {
+			return uid
+		}
+	}
+}
+
+func (s *System) useradd(args []string) (string, string, int) {
+	opts, pos := flags(args, "usgdc")
+	if len(pos) != 1 {
+		return "", "useradd: invalid arguments\n", 2
+	}
+	name := pos[0]
+	if _, ok := s.Users[name]; ok {
+		return "", fmt.Sprintf("useradd: user '%s' already exists\n", name), 9
+	}
+
+	uid := s.nextUID()
+	if v, ok := opts["u"]; ok {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return "", "useradd: invalid user ID '" + v + "'\n", 3
+		}
+		for _, u := range s.Users {
+			if u.UID == n {
+				return "", fmt.Sprintf("useradd: UID %d is not unique\n", n), 4
+			}
+		}
+		uid = n
+	}
+
+	var gid int
+	if v, ok := opts["g"]; ok {
+		g, ok := s.resolveGroup(v)
+		if !ok {
+			return "", fmt.Sprintf("useradd: group '%s' does not exist\n", v), 6
+		}
+		gid = s.Groups[g].GID
+	} else {
+		// user private group
+		if _, ok := s.Groups[name]; ok {
+			return "", fmt.Sprintf("useradd: group %s exists - if you want to add this user to that group, use -g.\n", name), 9
+		}
+		gid = s.nextGID(uid)
+		s.Groups[name] = &Group{GID: gid}
+	}
+
+	s.Users[name] = &User{UID: uid, GID: gid, Groups: map[string]bool{}}
+	return "", "", 0
+}
+
+func (s *System) userdel(args []string) (string, string, int) {
+	_, pos := flags(args, "")
+	if len(pos) != 1 {
+		return "", "userdel: invalid arguments\n", 2
+	}
+	u, ok := s.Users[pos[0]]
+	if !ok {
+		return "", fmt.Sprintf("userdel: user '%s' does not exist\n", pos[0]), 6
+	}
+	delete(s.Users, pos[0])
+	if g, ok := s.Groups[pos[0]]; ok && g.GID == u.GID {
+		delete(s.Groups, pos[0])
+	}
+	return "", "", 0
+}
+
+func (s *System) usermod(args []string) (string, string, int) {
+	opts, pos := flags(args, "Gugs")
+	if len(pos) != 1 {
+		return "", "usermod: invalid arguments\n", 2
+	}
+	u, ok := s.Users[pos[0]]
+	if !ok {
+		return "", fmt.Sprintf("usermod: user '%s' does not exist\n", pos[0]), 6
+	}
+
+	if v, ok := opts["u"]; ok {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return "", "usermod: invalid user ID '" + v + "'\n", 3
+		}
+		u.UID = n
+	}
+	if v, ok := opts["g"]; ok {
+		g, ok := s.resolveGroup(v)
+		if !ok {
+			return "", fmt.Sprintf("usermod: group '%s' does not exist\n", v), 6
+		}
+		u.GID = s.Groups[g].GID
+	}
+	if v, ok := opts["G"]; ok {
+		var list []string
+		if v != "" {
+			list = strings.Split(v, ",")
+		}
+		for _, g := range list {
+			if _, ok := s.Groups[g]; !ok {
+				return "", fmt.Sprintf("usermod: group '%s' does not exist\n", g), 6
+			}
+		}
+		if _, appendMode := opts["a"]; !appendMode {
+			u.Groups = map[string]bool{}
+		}
+		for _, g := range list {
+			u.Groups[g] = true
+		}
+	}
+	return "", "", 0
+}
+
+func (s *System) groupadd(args []string) (string, string, int) {
+	opts, pos := flags(args, "g")
+	if len(pos) != 1 {
+		return "", "groupadd: invalid arguments\n", 2
+	}
+	name := pos[0]
+	if _, ok := s.Groups[name]; ok {
+		return "", fmt.Sprintf("groupadd: group '%s' already exists\n", name), 9
+	}
+	gid := s.nextGID(1000)
+	if v, ok := opts["g"]; ok {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return "", "groupadd: invalid group ID '" + v + "'\n", 3
+		}
+		if _, used := s.groupByGID(n); used {
+			return "", fmt.Sprintf("groupadd: GID '%d' already exists\n", n), 4
+		}
+		gid = n
+	}
+	s.Groups[name] = &Group{GID: gid}
+	return "", "", 0
+}
+
+func (s *System) groupdel(args []string) (string, string, int) {
+	if len(args) != 1 {
+		return "", "groupdel: invalid arguments\n", 2
+	}
+	g, ok := s.Groups[args[0]]
+	if !ok {
+		return "", fmt.Sprintf("groupdel: group '%s' does not exist\n", args[0]), 6
+	}
+	for name, u := range s.Users {
+		if u.GID == g.GID {
+			return "", fmt.Sprintf("groupdel: cannot remove the primary group of user '%s'\n", name), 8
+		}
+	}
+	delete(s.Groups, args[0])
+	for _, u := range s.Users {
+		delete(u.Groups, args[0])
+	}
+	return "", "", 0
+}
+
+func (s *System) groupmod(args []string) (string, string, int) {
+	opts, pos := flags(args, "g")
+	if len(pos) != 1 {
+		return "", "groupmod: invalid arguments\n", 2
+	}
+	g, ok := s.Groups[pos[0]]
+	if !ok {
+		return "", fmt.Sprintf("groupmod: group '%s' does not exist\n", pos[0]), 6
+	}
+	if v, ok := opts["g"]; ok {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return "", "groupmod: invalid group ID '" + v + "'\n", 3
+		}
+		for _, u := range s.Users {
+			if u.GID == g.GID {
+				u.GID = n
+			}
+		}
+		g.GID = n
+	}
+	return "", "", 0
+}
+
+func (s *System) gpasswd(args []string) (string, string, int) {
+	if len(args) != 3 || args[0] != "-d" {
+		return "", "gpasswd: unsupported arguments\n", 2
+	}
+	u, ok := s.Users[args[1]]
+	if !ok || !u.Groups[args[2]] {
+		return "", fmt.Sprintf("gpasswd: user '%s' is not a member of '%s'\n", args[1], args[2]), 3
+	}
+	delete(u.Groups, args[2])
+	return "Removing user " + args[1] + " from group " + args[2] + "\n", "", 0
+}
+
+// --- samba ---
+
+func (s *System) pdbedit(args []string) (string, string, int) {
+	if len(args) == 2 && (args[0] == "-e" || args[0] == "-i") && strings.HasPrefix(args[1], "smbpasswd:") {
+		return s.pdbeditTransfer(args[0] == "-e", strings.TrimPrefix(args[1], "smbpasswd:"))
+	}
+	if len(args) == 0 || args[0] != "-L" {
+		return "", "pdbedit: unsupported arguments\n", 1
+	}
+	var b strings.Builder
+	for _, name := range sortedKeys(s.Passdb, func(a, b string) bool { return a < b }) {
+		acc := s.Passdb[name]
+		acctFlags := "U"
+		if acc.Disabled {
+			acctFlags = "DU"
+		}
+		if len(args) > 1 && args[1] == "-v" {
+			b.WriteString("---------------\n")
+			fmt.Fprintf(&b, "Unix username:        %s\n", name)
+			fmt.Fprintf(&b, "Account Flags:        [%-11s]\n", acctFlags)
+			fmt.Fprintf(&b, "Full Name:            %s\n", acc.FullName)
+			b.WriteString("Logon time:           0\n")
+			b.WriteString("Password last set:    Mon, 01 Jan 2024 12:00:00 UTC\n")
+			b.WriteString("Bad password count  : 0\n")
+			continue
+		}
+		uid := -1
+		if u, ok := s.Users[name]; ok {
+			uid = u.UID
+		}
+		fmt.Fprintf(&b, "%s:%d:%s\n", name, uid, acc.FullName)
+	}
+	return b.String(), "", 0
+}
+
+// pdbeditTransfer exports the passdb to or imports it from an smbpasswd
+// file. The fake "NT hash" is the hex-encoded password.
+func (s *System) pdbeditTransfer(export bool, path string) (string, string, int) {
+	if export {
+		var b strings.Builder
+		for _, name := range sortedKeys(s.Passdb, func(a, b string) bool { return a < b }) {
+			acc := s.Passdb[name]
+			flags := "[U          ]"
+			if acc.Disabled {
+				flags = "[DU         ]"
+			}
+			uid := -1
+			if u, ok := s.Users[name]; ok {
+				uid = u.UID
+			}
+			fmt.Fprintf(&b, "%s:%d:%s:%X:%s:LCT-65920000:\n", name, uid, strings.Repeat("X", 32), acc.Password, flags)
+		}
+		if err := os.WriteFile(path, []byte(b.String()), 0600); err != nil {
+			return "", err.Error() + "\n", 1
+		}
+		return "", "", 0
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err.Error() + "\n", 1
+	}
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		f := strings.Split(line, ":")
+		if len(f) < 6 {
+			continue
+		}
+		if _, ok := s.Users[f[0]]; !ok {
+			return "", "Unable to import " + f[0] + ": no such Unix user\n", 1
+		}
+		pw, _ := hex.DecodeString(f[3])
+		acc, ok := s.Passdb[f[0]]
+		if !ok {
+			acc = &SambaAccount{}
+			s.Passdb[f[0]] = acc
+		}
+		acc.Password = string(pw)
+		acc.Disabled = strings.Contains(f[4], "D")
+	}
+	return "", "", 0
+}
+
+func (s *System) smbpasswd(stdin string, args []string) (string, string, int) {
+	opts, pos := flags(args, "")
+	if len(pos) != 1 {
+		return "", "smbpasswd: invalid arguments\n", 1
+	}
+	name := pos[0]
+	acc, exists := s.Passdb[name]
+
+	password := func() (string, bool) {
+		lines := strings.Split(stdin, "\n")
+		if len(lines) < 2 || lines[0] != lines[1] {
+			return "", false
+		}
+		return lines[0], true
+	}
+
+	switch {
+	case has(opts, "a"):
+		if _, ok := s.Users[name]; !ok {
+			return "", "Failed to add entry for user " + name + ".\n", 1
+		}
+		pw, ok := password()
+		if !ok {
+			return "", "Mismatch - password unchanged.\n", 1
+		}
+		if exists {
+			acc.Password = pw
+		} else {
+			s.Passdb[name] = &SambaAccount{Password: pw}
+		}
+		return "Added user " + name + ".\n", "", 0
+	case !exists:
+		return "", "Failed to find entry for user " + name + ".\n", 1
+	case has(opts, "x"):
+		delete(s.Passdb, name)
+		return "Deleted user " + name + ".\n", "", 0
+	case has(opts, "e"):
+		acc.Disabled = false
+		return "Enabled user " + name + ".\n", "", 0
+	case has(opts, "d"):
+		acc.Disabled = true
+		return "Disabled user " + name + ".\n", "", 0
+	default:
+		pw, ok := password()
+		if !ok {
+			return "", "Mismatch - password unchanged.\n", 1
+		}
+		acc.Password = pw
+		return "", "", 0
+	}
+}
+
+// testparm echoes the config file, which is enough for ReadEffectiveConfig
+// on files without includes.
+func (s *System) testparm(args []string) (string, string, int) {
+	if s.TestparmError != "" {
+		return "", s.TestparmError + "\n", 1
+	}
+	_, pos := flags(args, "")
+	if len(pos) == 0 {
+		return "", "", 0
+	}
+	b, err := os.ReadFile(pos[0])
+	if err != nil {
+		return "", "Can't load " + pos[0] + " - run testparm to debug it\n", 1
+	}
+	return string(b), "Loaded services file OK.\n", 0
+}
+
+func has(opts map[string]string, f string) bool {
+	_, ok := opts[f]
+	return ok
+}
+
+// getfacl prints the base entries of a 0755 root:root directory plus the
+// entries stored in ACLs.
+func (s *System) getfacl(args []string) (string, string, int) {
+	_, pos := flags(args, "")
+	if len(pos) == 0 {
+		return "", "Usage: getfacl [-aceEsRLPtpndvh] file ...\n", 2
+	}
+	path := pos[len(pos)-1]
+
+	var named, defaults []string
+	for _, e := range s.ACLs[path] {
+		if strings.HasPrefix(e, "default:") {
+			defaults = append(defaults, e)
+		} else {
+			named = append(named, e)
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# file: %s\n# owner: root\n# group: root\nuser::rwx\n", path)
+	for _, e := range named {
+		b.WriteString(e + "\n")
+	}
+	b.WriteString("group::r-x\n")
+	if len(named) > 0 {
+		b.WriteString("mask::rwx\n")
+	}
+	b.WriteString("other::r-x\n")
+	if len(defaults) > 0 {
+		b.WriteString("default:user::rwx\n")
+		for _, e := range defaults {
+			b.WriteString(e + "\n")
+		}
+		b.WriteString("default:group::r-x\ndefault:mask::rwx\ndefault:other::r-x\n")
+	}
+	return b.String() + "\n", "", 0
+}
+
+// setfacl supports -m and -x with a single named entry on one or more
+// files; -R and -P are accepted.
+func (s *System) setfacl(args []string) (string, string, int) {
+	opts, paths := flags(args, "mx")
+	if len(paths) == 0 {
+		return "", "setfacl: no file given\n", 2
+	}
+
+	spec, modify := opts["m"], true
+	if x, ok := opts["x"]; ok {
+		spec, modify = x, false
+	}
+	f := strings.Split(strings.TrimPrefix(spec, "default:"), ":")
+	if len(f) < 2 {
+		return "", "setfacl: Option -m: Invalid argument near character 1\n", 2
+	}
+	switch {
+	case f[0] == "user" && s.Users[f[1]] == nil, f[0] == "group" && s.Groups[f[1]] == nil:
+		return "", "setfacl: Option -m: Invalid argument near character 6\n", 2
+	}
+
+	key := strings.TrimSuffix(spec, ":"+f[len(f)-1])
+	if !modify {
+		key = spec
+	}
+	for _, path := range paths {
+		var kept []string
+		for _, e := range s.ACLs[path] {
+			if !strings.HasPrefix(e, key+":") {
+				kept = append(kept, e)
+			}
+		}
+		if modify {
+			kept = append(kept, spec)
+		}
+		s.ACLs[path] = kept
+	}
+	return "", "", 0
+}
@@ -0,0 +1,191 @@
+package samba
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ShareImport describes how a share defined by hand in smb.conf (or a file it
+// includes) is moved under UI management: its section body becomes a snippet
+// and the index gets a marker block. The original file is never touched; the
+// admin removes Remove from Source afterwards.
+type ShareImport struct {
+	Name string
+
+	Source string     // file the section is defined in
+	Remove []ConfLine // lines of Source that must be deleted
+
+	SnippetPath string
+	Snippet     string
+
+	IndexPath  string
+	IndexBlock string
+}
+
+// reserved sections that are not plain shares
+var importReserved = map[string]bool{"global": true, "homes": true, "printers": true, "print$": true}
+
+// PlanShareImport prepares the import of share name. It fails if the share
+// cannot be moved as a whole.
+func PlanShareImport(smbConf, snippetDir, indexPath, name string) (*ShareImport, error) {
+	if !shareNameRx.MatchString(name) || importReserved[strings.ToLower(name)] {
+		return nil, fmt.Errorf("share %s cannot be imported", name)
+	}
+
+	conf, err := LoadSmbConf(smbConf)
+	if err != nil {
+		return nil, err
+	}
+	managed, err := ReadManagedSharesIndex(indexPath)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := managed[name]; ok {
+		return nil, fmt.Errorf("share %s is already managed by UI", name)
+	}
+	sec := conf.Section(name)
+	if sec == nil {
+		return nil, fmt.Errorf("share %s is not defined in %s", name, smbConf)
+	}
+
+	spans := conf.SectionSpans(name)
+	if len(spans) != 1 {
+		return nil, fmt.Errorf("share %s is defined in %d places; merge them by hand first", name, len(spans))
+	}
+	sp := spans[0]
+	if filepath.Clean(sp.File) == filepath.Clean(indexPath) {
+		return nil, fmt.Errorf("share %s is defined in the UI index without markers", name)
+	}
+	for _, p := range sec.Params {
+		if p.File != sp.File || p.Line < sp.Start || p.Line > sp.End {
+			return nil, fmt.Errorf("share %s has settings outside its section (%s)", name, p.Origin())
+		}
+	}
+
+	snippetPath := filepath.Join(snippetDir, name+".conf")
+	if _, err := os.Stat(snippetPath); err == nil {
+		return nil, fmt.Errorf("share file %s already exists", snippetPath)
+	}
+
+	imp := &ShareImport{
+		Name:        name,
+		Source:      sp.File,
+		SnippetPath: snippetPath,
+		IndexPath:   indexPath,
+		IndexBlock:  indexBlock(name, snippetPath, false),
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# imported from %s:%d-%d\n", sp.File, sp.Start, sp.End)
+	for _, l := range conf.File(sp.File).Lines[sp.Start-1 : sp.End] {
+		imp.Remove = append(imp.Remove, l)
+		if l.Kind == ConfSection {
+			continue
+		}
+		b.WriteString(strings.TrimSpace(strings.TrimSuffix(l.Raw, "\r")) + "\n")
+	}
+	b.WriteString("\n")
+	imp.Snippet = b.String()
+
+	return imp, nil
+}
+
+// Validate runs testparm against a copy of the whole configuration as it
+// will look once the import is done and the section is removed from Source,
+// and makes sure the share is then defined exactly once.
+func (imp *ShareImport) Validate(smbConf string) error {
+	conf, err := LoadSmbConf(smbConf)
+	if err != nil {
+		return err
+	}
+
+	dir, err := os.MkdirTemp("", "samba-admin-ui-import-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	// every loaded file (and the index, which may not exist yet) gets a copy
+	copies := map[string]string{}
+	for i, f := range conf.Files {
+		copies[f.Path] = filepath.Join(dir, fmt.Sprintf("%d.conf", i))
+	}
+	snippet := filepath.Join(dir, "snippet.conf")
+	if err := os.WriteFile(snippet, []byte(imp.Snippet), 0600); err != nil {
+		return err
+	}
+	index := filepath.Clean(imp.IndexPath)
+	if _, ok := copies[index]; !ok {
+		copies[index] = filepath.Join(dir, "index.conf")
+		if err := os.WriteFile(copies[index], []byte(indexBlock(imp.Name, snippet, false)), 0600); err != nil {
+			return err
+		}
+	}
+
+	for _, f := range conf.Files {
+		var b strings.Builder
+		for _, l := range f.Lines {
+			switch {
+			case f.Path == imp.Source && imp.removes(l.Num):
+				continue
+			case l.Kind == ConfParam && CanonicalParamName(l.Key) == "include":
+				if c, ok := copies[resolveInclude(f.Path, l.Value)]; ok {
+					b.WriteString("include = " + c + "\n")
+					continue
+				}
+			}
+			b.WriteString(l.Raw + "\n")
+		}
+		if f.Path == index {
+			b.WriteString(indexBlock(imp.Name, snippet, false))
+		}
+		if err := os.WriteFile(copies[f.Path], []byte(b.String()), 0600); err != nil {
+			return err
+		}
+	}
+
+	main := copies[conf.Files[0].Path]
+	after, err := LoadSmbConf(main)
+	if err != nil {
+		return err
+	}
+	if n := len(after.SectionSpans(imp.Name)); n != 1 {
+		return fmt.Errorf("share %s would be defined %d times after the import", imp.Name, n)
+	}
+	if ok, errStr := TestparmOK(main); !ok {
+		return fmt.Errorf("testparm rejected the imported config: %s", errStr)
+	}
+	return nil
+}
+
+func (imp *ShareImport) removes(num int) bool {
+	return len(imp.Remove) > 0 && num >= imp.Remove[0].Num && num <= imp.Remove[len(imp.Remove)-1].Num
+}
+
+// Apply writes the snippet and adds the marker block to the index.
+func (imp *ShareImport) Apply() error {
+	if err := os.MkdirAll(filepath.Dir(imp.SnippetPath), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(imp.SnippetPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(imp.Snippet); err != nil {
+		_ = f.Close()
+		_ = os.Remove(imp.SnippetPath)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		_ = os.Remove(imp.SnippetPath)
+		return err
+	}
+
+	if err := EnsureIndexReferencesShare(imp.IndexPath, imp.Name, imp.SnippetPath); err != nil {
+		_ = os.Remove(imp.SnippetPath)
+		return err
+	}
+	return nil
+}
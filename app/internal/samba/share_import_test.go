@@ -0,0 +1,122 @@
+package samba_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/florianibach/samba-admin-ui/internal/samba"
+	"github.com/florianibach/samba-admin-ui/internal/samba/sambatest"
+)
+
+const manualConf = `[global]
+   workgroup = HOME
+   include = %s
+
+# media share, set up by hand
+[media]
+   path = /srv/media
+   force group = family
+   valid users = @family, \
+      bob
+   writable = yes
+
+[backup]
+   path = /srv/backup
+`
+
+func setupImport(t *testing.T) (smbConf, sharesDir, index string) {
+	t.Helper()
+	dir := t.TempDir()
+	smbConf = filepath.Join(dir, "smb.conf")
+	sharesDir = filepath.Join(dir, "ui")
+	index = filepath.Join(sharesDir, "shares.conf")
+	content := strings.Replace(manualConf, "%s", index, 1)
+	if err := os.WriteFile(smbConf, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return smbConf, sharesDir, index
+}
+
+func TestShareImport(t *testing.T) {
+	sambatest.Install(t)
+	smbConf, sharesDir, index := setupImport(t)
+
+	imp, err := samba.PlanShareImport(smbConf, sharesDir, index, "media")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if imp.Source != smbConf || len(imp.Remove) != 6 || imp.Remove[0].Num != 6 {
+		t.Fatalf("remove = %+v from %s", imp.Remove, imp.Source)
+	}
+	if err := imp.Validate(smbConf); err != nil {
+		t.Fatal(err)
+	}
+	if err := imp.Apply(); err != nil {
+		t.Fatal(err)
+	}
+
+	managed, err := samba.ReadManagedSharesIndex(index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := managed["media"]; !ok {
+		t.Fatalf("media not in index: %+v", managed)
+	}
+
+	// the edit form sees the known fields, everything else is kept
+	opt, err := samba.ReadShareSnippet(sharesDir, "media")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opt.Path != "/srv/media" || opt.ReadOnly || opt.ValidUsers != "@family, bob" {
+		t.Errorf("opt = %+v", opt)
+	}
+	if len(opt.Extra) != 1 || opt.Extra[0] != "force group = family" {
+		t.Errorf("extra = %q", opt.Extra)
+	}
+
+	if _, err := samba.PlanShareImport(smbConf, sharesDir, index, "media"); err == nil {
+		t.Error("importing a managed share again should fail")
+	}
+}
+
+func TestShareImportRejectsDuplicateResult(t *testing.T) {
+	sambatest.Install(t)
+	smbConf, sharesDir, index := setupImport(t)
+
+	// a second [backup] section in an included file
+	extra := filepath.Join(filepath.Dir(smbConf), "extra.conf")
+	if err := os.WriteFile(extra, []byte("[backup]\n   read only = yes\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.OpenFile(smbConf, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = f.WriteString("[extra]\n   include = " + extra + "\n")
+	f.Close()
+
+	_, err = samba.PlanShareImport(smbConf, sharesDir, index, "backup")
+	if err == nil || !strings.Contains(err.Error(), "2 places") {
+		t.Fatalf("err = %v, want duplicate definition", err)
+	}
+}
+
+func TestShareImportValidatesWithTestparm(t *testing.T) {
+	sys := sambatest.Install(t)
+	smbConf, sharesDir, index := setupImport(t)
+
+	imp, err := samba.PlanShareImport(smbConf, sharesDir, index, "backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sys.TestparmError = "Unknown parameter encountered"
+	if err := imp.Validate(smbConf); err == nil || !strings.Contains(err.Error(), "testparm") {
+		t.Fatalf("err = %v, want testparm failure", err)
+	}
+	if _, err := os.Stat(imp.SnippetPath); !os.IsNotExist(err) {
+		t.Error("validation wrote the snippet")
+	}
+}
@@ -6,28 +6,28 @@ import (
 	"path/filepath"
 	"regexp"
 	"strings"
+	"unicode"
 )
 
 var shareNameRx = regexp.MustCompile(`^[a-zA-Z0-9._-]+$`)
 
 func CheckSmbConfIncludesIndex(smbConfPath string, indexPath string) error {
-	b, err := os.ReadFile(smbConfPath)
+	conf, err := LoadSmbConf(smbConfPath)
 	if err != nil {
 		return err
 	}
-	txt := string(b)
 
-	// Accept include with either absolute path or with different spacing
-	needle := "include"
-	if !strings.Contains(strings.ToLower(txt), needle) {
+	includes := conf.Includes()
+	if len(includes) == 0 {
 		return fmt.Errorf("smb.conf has no include statements; expected include = %s", indexPath)
 	}
-
-	// Very MVP: require the indexPath string to appear somewhere
-	if !strings.Contains(txt, indexPath) {
-		return fmt.Errorf("missing required include in smb.conf: include = %s", indexPath)
+	want := filepath.Clean(indexPath)
+	for _, inc := range includes {
+		if inc == want {
+			return nil
+		}
 	}
-	return nil
+	return fmt.Errorf("missing required include in smb.conf: include = %s", indexPath)
 }
 
 type CreateShareOptions struct {
@@ -36,23 +36,191 @@ type CreateShareOptions struct {
 	ReadOnly   bool
 	Browseable bool
 	ValidUsers string // e.g. "vater, @eltern"
+
+	// Extra holds "key = value" lines the form does not edit (e.g. from an
+	// imported share); they are written back unchanged.
+	Extra []string
 }
 
 func CreateShareSnippet(snippetDir string, opt CreateShareOptions) (string, error) {
+	content, err := renderShareSnippet(opt)
+	if err != nil {
+		return "", err
+	}
+
+	file := filepath.Join(snippetDir, strings.TrimSpace(opt.Name)+".conf")
+	if err := os.MkdirAll(snippetDir, 0755); err != nil {
+		return "", err
+	}
+
+	if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+		return "", err
+	}
+	return file, nil
+}
+
+// UpdateShareSnippet replaces <name>.conf in snippetDir. The new content is
+// written to a staged file next to it and validated with testparm first; the
+// live snippet is only swapped (via rename) once Samba accepts it.
+func UpdateShareSnippet(snippetDir string, opt CreateShareOptions) (string, error) {
+	content, err := renderShareSnippet(opt)
+	if err != nil {
+		return "", err
+	}
+
+	name := strings.TrimSpace(opt.Name)
+	file := filepath.Join(snippetDir, name+".conf")
+	staged := filepath.Join(snippetDir, "."+name+".conf.staged")
+	if err := os.MkdirAll(snippetDir, 0755); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(staged, []byte(content), 0644); err != nil {
+		return "", err
+	}
+	defer os.Remove(staged)
+
+	if err := ValidateShareSnippet(name, staged); err != nil {
+		return "", err
+	}
+
+	if err := os.Rename(staged, file); err != nil {
+		return "", err
+	}
+	return file, nil
+}
+
+// WriteShareSnippet replaces <name>.conf in snippetDir with content as is,
+// through a staged file and rename. Unlike UpdateShareSnippet it does not
+// validate; the caller checks the whole configuration afterwards.
+func WriteShareSnippet(snippetDir, name, content string) error {
+	file := filepath.Join(snippetDir, name+".conf")
+	staged := filepath.Join(snippetDir, "."+name+".conf.staged")
+	if err := os.MkdirAll(snippetDir, 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(staged, []byte(content), 0644); err != nil {
+		return err
+	}
+	defer os.Remove(staged)
+	return os.Rename(staged, file)
+}
+
+// ValidateShareSnippet runs testparm against a throwaway config that only
+// contains a [name] section including snippetFile.
+func ValidateShareSnippet(name, snippetFile string) error {
+	f, err := os.CreateTemp("", "samba-admin-ui-*.conf")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	conf := fmt.Sprintf("[global]\n\n[%s]\n   include = %s\n", name, snippetFile)
+	if _, err := f.WriteString(conf); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if ok, errStr := TestparmOK(f.Name()); !ok {
+		return fmt.Errorf("testparm rejected share config: %s", errStr)
+	}
+	return nil
+}
+
+// ValidateShareContent checks content as the snippet of share name with
+// testparm through a staged file in snippetDir; <name>.conf is left alone.
+func ValidateShareContent(snippetDir, name, content string) error {
+	staged := filepath.Join(snippetDir, "."+name+".conf.staged")
+	if err := os.MkdirAll(snippetDir, 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(staged, []byte(content), 0644); err != nil {
+		return err
+	}
+	defer os.Remove(staged)
+	return ValidateShareSnippet(name, staged)
+}
+
+// ReadShareSnippet parses a snippet written by CreateShareSnippet (or
+// imported by ShareImport) back into the options it was created from.
+func ReadShareSnippet(snippetDir, name string) (CreateShareOptions, error) {
+	b, err := os.ReadFile(filepath.Join(snippetDir, name+".conf"))
+	if err != nil {
+		return CreateShareOptions{}, err
+	}
+
+	opt := CreateShareOptions{Name: name, Browseable: true}
+	for _, l := range ParseConfFile(name+".conf", b, name).Lines {
+		if l.Kind != ConfParam {
+			continue
+		}
+		switch CanonicalParamName(l.Key) {
+		case "path":
+			opt.Path = l.Value
+		case "readonly":
+			opt.ReadOnly = isYes(l.Value)
+		case "writeable", "writable", "writeok":
+			opt.ReadOnly = !isYes(l.Value)
+		case "browseable", "browsable":
+			opt.Browseable = isYes(l.Value)
+		case "validusers":
+			opt.ValidUsers = l.Value
+		default:
+			opt.Extra = append(opt.Extra, l.Key+" = "+l.Value)
+		}
+	}
+	return opt, nil
+}
+
+func isYes(v string) bool {
+	switch strings.ToLower(strings.TrimSpace(v)) {
+	case "yes", "true", "1":
+		return true
+	}
+	return false
+}
+
+// ValidateShareOptions checks the user-supplied fields of opt without
+// touching the filesystem.
+func ValidateShareOptions(opt CreateShareOptions) error {
 	name := strings.TrimSpace(opt.Name)
 	if name == "" || !shareNameRx.MatchString(name) {
-		return "", fmt.Errorf("invalid share name (use letters, numbers, . _ -)")
+		return fmt.Errorf("invalid share name (use letters, numbers, . _ -)")
 	}
 
 	path := strings.TrimSpace(opt.Path)
 	if path == "" || !strings.HasPrefix(path, "/") {
-		return "", fmt.Errorf("path must be an absolute path")
+		return fmt.Errorf("path must be an absolute path")
 	}
 
-	file := filepath.Join(snippetDir, name+".conf")
-	if err := os.MkdirAll(snippetDir, 0755); err != nil {
+	// Every field ends up on a line of its own in the snippet; a line break
+	// would start another parameter.
+	if hasControl(opt.Path) {
+		return fmt.Errorf("path must not contain control characters")
+	}
+	if hasControl(opt.ValidUsers) {
+		return fmt.Errorf("valid users must not contain control characters")
+	}
+	for _, l := range opt.Extra {
+		if hasControl(l) {
+			return fmt.Errorf("share parameter %q contains control characters", l)
+		}
+	}
+	return nil
+}
+
+// hasControl reports whether s contains a control character other than tab.
+func hasControl(s string) bool {
+	return strings.ContainsFunc(s, func(r rune) bool { return r != '\t' && unicode.IsControl(r) })
+}
+
+func renderShareSnippet(opt CreateShareOptions) (string, error) {
+	if err := ValidateShareOptions(opt); err != nil {
 		return "", err
 	}
+	path := strings.TrimSpace(opt.Path)
 
 	ro := "no"
 	if opt.ReadOnly {
@@ -67,7 +235,6 @@ func CreateShareSnippet(snippetDir string, opt CreateShareOptions) (string, erro
 	b.WriteString(fmt.Sprintf("path = %s\n", path))
 	b.WriteString(fmt.Sprintf("read only = %s\n", ro))
 	b.WriteString(fmt.Sprintf("browseable = %s\n", br))
-	b.WriteString("guest ok = no\n")
 
 	vu := strings.TrimSpace(opt.ValidUsers)
 	if vu != "" {
@@ -83,15 +250,26 @@ func CreateShareSnippet(snippetDir string, opt CreateShareOptions) (string, erro
 		}
 	}
 
+	extra := map[string]bool{}
+	for _, l := range opt.Extra {
+		k, _, _ := strings.Cut(l, "=")
+		extra[CanonicalParamName(strings.TrimSpace(k))] = true
+		b.WriteString(l + "\n")
+	}
+
 	// Optional Defaults (wenn du willst – passt zu deinen anderen Shares)
-	b.WriteString("create mask = 0660\n")
-	b.WriteString("directory mask = 0770\n")
+	for _, d := range []struct{ key, line string }{
+		{"guestok", "guest ok = no"},
+		{"createmask", "create mask = 0660"},
+		{"directorymask", "directory mask = 0770"},
+	} {
+		if !extra[d.key] {
+			b.WriteString(d.line + "\n")
+		}
+	}
 
 	// Wichtig: Datei endet mit Newline
 	b.WriteString("\n")
 
-	if err := os.WriteFile(file, []byte(b.String()), 0644); err != nil {
-		return "", err
-	}
-	return file, nil
+	return b.String(), nil
 }
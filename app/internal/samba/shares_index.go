@@ -1,6 +1,7 @@
 package samba
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -21,6 +22,11 @@ func indexBlock(shareName, shareFile string, disabled bool) string {
 	return b.String()
 }
 
+// indexBlockRx matches the marker block written by indexBlock for shareName.
+func indexBlockRx(shareName string) *regexp.Regexp {
+	return regexp.MustCompile(`(?s)\n; samba-admin-ui:begin ` + regexp.QuoteMeta(shareName) + `\n.*?; samba-admin-ui:end ` + regexp.QuoteMeta(shareName) + `\n`)
+}
+
 func EnsureIndexReferencesShare(indexPath string, shareName string, shareFilePath string) error {
 	if err := os.MkdirAll(filepath.Dir(indexPath), 0755); err != nil {
 		return err
@@ -51,7 +57,7 @@ func SetShareDisabled(indexPath, shareName, shareFilePath string, disabled bool)
 	}
 
 	// Prefer marker-based replace
-	re := regexp.MustCompile(`(?s)\n; samba-admin-ui:begin ` + regexp.QuoteMeta(shareName) + `\n.*?; samba-admin-ui:end ` + regexp.QuoteMeta(shareName) + `\n`)
+	re := indexBlockRx(shareName)
 	if re.MatchString(existing) {
 		repl := indexBlock(shareName, shareFilePath, disabled)
 		out := re.ReplaceAllString(existing, repl)
@@ -72,7 +78,7 @@ func RemoveShareFromIndex(indexPath, shareName string) error {
 	existing := string(b)
 
 	// Remove marker block if present
-	re := regexp.MustCompile(`(?s)\n; samba-admin-ui:begin ` + regexp.QuoteMeta(shareName) + `\n.*?; samba-admin-ui:end ` + regexp.QuoteMeta(shareName) + `\n`)
+	re := indexBlockRx(shareName)
 	if re.MatchString(existing) {
 		out := re.ReplaceAllString(existing, "\n")
 		return os.WriteFile(indexPath, []byte(out), 0644)
@@ -81,3 +87,70 @@ func RemoveShareFromIndex(indexPath, shareName string) error {
 	// No marker -> do not attempt risky deletes in MVP
 	return fmt.Errorf("share block for %s not managed by UI (no markers found)", shareName)
 }
+
+// RenameShareInIndex replaces the marker block of oldName with a block for
+// newName pointing at newShareFile, keeping its position and disabled state.
+func RenameShareInIndex(indexPath, oldName, newName, newShareFile string, disabled bool) error {
+	b, err := os.ReadFile(indexPath)
+	if err != nil {
+		return err
+	}
+	existing := string(b)
+
+	re := indexBlockRx(oldName)
+	if !re.MatchString(existing) {
+		return fmt.Errorf("share block for %s not managed by UI (no markers found)", oldName)
+	}
+	if newName != oldName && strings.Contains(existing, fmt.Sprintf("[%s]", newName)) {
+		return fmt.Errorf("share %s already exists in index", newName)
+	}
+
+	out := re.ReplaceAllLiteralString(existing, indexBlock(newName, newShareFile, disabled))
+	return os.WriteFile(indexPath, []byte(out), 0644)
+}
+
+// ShareIndexBlock returns the marker block of shareName in the index as it
+// is written by indexBlock, or ok=false if there is none.
+func ShareIndexBlock(indexPath, shareName string) (block string, ok bool, err error) {
+	b, err := os.ReadFile(indexPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	m := indexBlockRx(shareName).FindString(string(b))
+	return m, m != "", nil
+}
+
+// SetShareIndexBlock puts block (as returned by ShareIndexBlock) in place of
+// the marker block of shareName, appending it if there is none. An empty
+// block removes the share from the index.
+func SetShareIndexBlock(indexPath, shareName, block string) error {
+	if block != "" && !indexBlockRx(shareName).MatchString(block) {
+		return fmt.Errorf("not an index block for share %s", shareName)
+	}
+	if err := os.MkdirAll(filepath.Dir(indexPath), 0755); err != nil {
+		return err
+	}
+	existing := ""
+	if b, err := os.ReadFile(indexPath); err == nil {
+		existing = string(b)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	re := indexBlockRx(shareName)
+	var out string
+	switch {
+	case re.MatchString(existing) && block == "":
+		out = re.ReplaceAllLiteralString(existing, "\n")
+	case re.MatchString(existing):
+		out = re.ReplaceAllLiteralString(existing, block)
+	case block == "":
+		return nil
+	default:
+		out = existing + block
+	}
+	return os.WriteFile(indexPath, []byte(out), 0644)
+}
@@ -0,0 +1,106 @@
+package samba_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/florianibach/samba-admin-ui/internal/samba"
+	"github.com/florianibach/samba-admin-ui/internal/samba/sambatest"
+)
+
+func TestValidateShareOptions(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		opt  samba.CreateShareOptions
+		err  string // substring; "" for none
+	}{
+		{"ok", samba.CreateShareOptions{Name: "media", Path: "/srv/media", ValidUsers: "bob, @family"}, ""},
+		{"bad name", samba.CreateShareOptions{Name: "me dia", Path: "/srv/media"}, "invalid share name"},
+		{"relative path", samba.CreateShareOptions{Name: "media", Path: "srv/media"}, "absolute"},
+		{"newline in path", samba.CreateShareOptions{Name: "media", Path: "/srv\nroot preexec = /bin/sh"}, "control characters"},
+		{"carriage return in path", samba.CreateShareOptions{Name: "media", Path: "/srv\rx"}, "control characters"},
+		{"NUL in path", samba.CreateShareOptions{Name: "media", Path: "/srv\x00"}, "control characters"},
+		{"newline in valid users", samba.CreateShareOptions{Name: "media", Path: "/srv", ValidUsers: "bob\nguest ok = yes"}, "control characters"},
+		{"newline in extra", samba.CreateShareOptions{Name: "media", Path: "/srv", Extra: []string{"comment = a\nguest ok = yes"}}, "control characters"},
+		{"tab in extra", samba.CreateShareOptions{Name: "media", Path: "/srv", Extra: []string{"comment = a\tb"}}, ""},
+	} {
+		err := samba.ValidateShareOptions(tc.opt)
+		switch {
+		case tc.err == "" && err != nil:
+			t.Errorf("%s: %v", tc.name, err)
+		case tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)):
+			t.Errorf("%s: err = %v, want %q", tc.name, err, tc.err)
+		}
+	}
+}
+
+func TestUpdateShareSnippet(t *testing.T) {
+	sys := sambatest.Install(t)
+	dir := t.TempDir()
+	file := filepath.Join(dir, "media.conf")
+	opt := samba.CreateShareOptions{Name: "media", Path: "/srv/media", Browseable: true}
+	if _, err := samba.CreateShareSnippet(dir, opt); err != nil {
+		t.Fatal(err)
+	}
+
+	opt.ReadOnly = true
+	if got, err := samba.UpdateShareSnippet(dir, opt); err != nil || got != file {
+		t.Fatalf("update = %q, %v", got, err)
+	}
+	if b, _ := os.ReadFile(file); !strings.Contains(string(b), "read only = yes\n") {
+		t.Errorf("snippet = %q", b)
+	}
+
+	before, _ := os.ReadFile(file)
+	sys.TestparmError = "Unknown parameter encountered"
+	opt.Path = "/srv/other"
+	if _, err := samba.UpdateShareSnippet(dir, opt); err == nil || !strings.Contains(err.Error(), "testparm") {
+		t.Fatalf("err = %v, want testparm failure", err)
+	}
+	if b, _ := os.ReadFile(file); string(b) != string(before) {
+		t.Errorf("rejected update changed the snippet to %q", b)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("staged file left behind: %v", entries)
+	}
+}
+
+func TestRenameShareInIndex(t *testing.T) {
+	dir := t.TempDir()
+	index := filepath.Join(dir, "shares.conf")
+	for _, name := range []string{"media", "backup"} {
+		if err := samba.EnsureIndexReferencesShare(index, name, filepath.Join(dir, name+".conf")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := samba.SetShareDisabled(index, "media", filepath.Join(dir, "media.conf"), true); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := samba.RenameShareInIndex(index, "media", "films", filepath.Join(dir, "films.conf"), true); err != nil {
+		t.Fatal(err)
+	}
+	b, _ := os.ReadFile(index)
+	if strings.Contains(string(b), "media") {
+		t.Errorf("old name left in index:\n%s", b)
+	}
+	if i, j := strings.Index(string(b), "begin films"), strings.Index(string(b), "begin backup"); i < 0 || i > j {
+		t.Errorf("renamed block moved:\n%s", b)
+	}
+	managed, err := samba.ReadManagedSharesIndex(index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st, ok := managed["films"]; !ok || !st.Disabled {
+		t.Errorf("films = %+v, %v", st, ok)
+	}
+
+	if err := samba.RenameShareInIndex(index, "films", "backup", filepath.Join(dir, "backup.conf"), false); err == nil {
+		t.Error("renamed onto an existing share")
+	}
+	if err := samba.RenameShareInIndex(index, "ghost", "spirit", filepath.Join(dir, "spirit.conf"), false); err == nil {
+		t.Error("renamed a share without marker block")
+	}
+}
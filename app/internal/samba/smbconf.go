@@ -0,0 +1,358 @@
+package samba
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// The smb.conf parser below works on the files as written (unlike
+// ReadEffectiveConfig, which sees testparm's expanded output): it follows
+// include directives, remembers where every parameter comes from and can
+// write files back byte for byte.
+
+type ConfLineKind int
+
+const (
+	ConfBlank ConfLineKind = iota
+	ConfComment
+	ConfSection
+	ConfParam
+	ConfContinuation // physical line continuing the previous one ("\" at the end)
+	ConfInvalid
+)
+
+// ConfLine is one physical line of a config file.
+type ConfLine struct {
+	Num  int // 1-based
+	Raw  string
+	Kind ConfLineKind
+
+	Section string // section header name, or the section a param belongs to
+	Key     string // as written
+	Value   string // continuation lines joined
+}
+
+// ConfFile is a single parsed file. Lines hold the exact original text.
+type ConfFile struct {
+	Path  string
+	Lines []ConfLine
+
+	trailingNewline bool
+}
+
+// Bytes returns the file content exactly as it was read.
+func (f *ConfFile) Bytes() []byte {
+	var b strings.Builder
+	for i, l := range f.Lines {
+		if i > 0 {
+			b.WriteByte('\n')
+		}
+		b.WriteString(l.Raw)
+	}
+	if f.trailingNewline {
+		b.WriteByte('\n')
+	}
+	return []byte(b.String())
+}
+
+// WriteFile atomically replaces path with the file's content, keeping the
+// mode of an existing file.
+func (f *ConfFile) WriteFile(path string) error {
+	mode := os.FileMode(0644)
+	if fi, err := os.Stat(path); err == nil {
+		mode = fi.Mode().Perm()
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(f.Bytes()); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), mode); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// ConfParamEntry is a parameter with the place it was set.
+type ConfParamEntry struct {
+	Key   string // as written
+	Value string
+	File  string
+	Line  int
+}
+
+// Origin formats the source location as "file:line".
+func (p ConfParamEntry) Origin() string {
+	return fmt.Sprintf("%s:%d", p.File, p.Line)
+}
+
+type ConfSectionEntry struct {
+	Name   string
+	File   string // where the section header first appeared
+	Line   int
+	Params []ConfParamEntry
+}
+
+// Get returns the effective (last) setting of key. Keys are compared the
+// way Samba does: case-insensitive, ignoring spaces and underscores.
+func (s *ConfSectionEntry) Get(key string) (ConfParamEntry, bool) {
+	want := CanonicalParamName(key)
+	for i := len(s.Params) - 1; i >= 0; i-- {
+		if CanonicalParamName(s.Params[i].Key) == want {
+			return s.Params[i], true
+		}
+	}
+	return ConfParamEntry{}, false
+}
+
+// SmbConf is smb.conf with all includes resolved.
+type SmbConf struct {
+	Path     string
+	Files    []*ConfFile // load order; Files[0] is Path
+	Sections []*ConfSectionEntry
+	// Warnings lists includes that could not be followed.
+	Warnings []string
+}
+
+// Section looks up a section by name (case-insensitive).
+func (c *SmbConf) Section(name string) *ConfSectionEntry {
+	for _, s := range c.Sections {
+		if strings.EqualFold(s.Name, name) {
+			return s
+		}
+	}
+	return nil
+}
+
+// File returns the parsed file for path, if it was loaded.
+func (c *SmbConf) File(path string) *ConfFile {
+	for _, f := range c.Files {
+		if f.Path == path {
+			return f
+		}
+	}
+	return nil
+}
+
+// Includes returns the resolved targets of all include directives.
+func (c *SmbConf) Includes() []string {
+	var res []string
+	for _, f := range c.Files {
+		for _, l := range f.Lines {
+			if l.Kind == ConfParam && CanonicalParamName(l.Key) == "include" {
+				res = append(res, resolveInclude(f.Path, l.Value))
+			}
+		}
+	}
+	return res
+}
+
+// CanonicalParamName normalizes a parameter name for comparison.
+func CanonicalParamName(k string) string {
+	k = strings.ToLower(k)
+	k = strings.ReplaceAll(k, " ", "")
+	k = strings.ReplaceAll(k, "\t", "")
+	return strings.ReplaceAll(k, "_", "")
+}
+
+const maxIncludeDepth = 16
+
+// LoadSmbConf parses path and every file it includes.
+func LoadSmbConf(path string) (*SmbConf, error) {
+	c := &SmbConf{Path: path}
+	l := &confLoader{conf: c, sections: map[string]*ConfSectionEntry{}}
+	if _, err := l.load(path, "", nil); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+type confLoader struct {
+	conf     *SmbConf
+	sections map[string]*ConfSectionEntry
+}
+
+func (l *confLoader) section(name, file string, line int) *ConfSectionEntry {
+	key := strings.ToLower(name)
+	if s, ok := l.sections[key]; ok {
+		return s
+	}
+	s := &ConfSectionEntry{Name: name, File: file, Line: line}
+	l.sections[key] = s
+	l.conf.Sections = append(l.conf.Sections, s)
+	return s
+}
+
+// load parses path with section as the current section (includes are
+// textual in Samba, so a section may continue into or out of a file) and
+// returns the section that is current at its end.
+func (l *confLoader) load(path, section string, stack []string) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return section, err
+	}
+	f := ParseConfFile(path, b, section)
+	l.conf.Files = append(l.conf.Files, f)
+	stack = append(stack, path)
+
+	for _, ln := range f.Lines {
+		switch ln.Kind {
+		case ConfSection:
+			section = ln.Section
+			l.section(section, path, ln.Num)
+		case ConfParam:
+			if CanonicalParamName(ln.Key) == "include" {
+				section, err = l.include(path, ln, section, stack)
+				if err != nil {
+					return section, err
+				}
+				continue
+			}
+			if section == "" {
+				// parameters before any header belong to [global]
+				section = "global"
+			}
+			s := l.section(section, path, ln.Num)
+			s.Params = append(s.Params, ConfParamEntry{Key: ln.Key, Value: ln.Value, File: path, Line: ln.Num})
+		}
+	}
+	return section, nil
+}
+
+func (l *confLoader) include(from string, ln ConfLine, section string, stack []string) (string, error) {
+	target := resolveInclude(from, ln.Value)
+	where := fmt.Sprintf("%s:%d", from, ln.Num)
+
+	switch {
+	case strings.Contains(ln.Value, "%"):
+		l.conf.Warnings = append(l.conf.Warnings, fmt.Sprintf("%s: include %q uses substitutions; not followed", where, ln.Value))
+		return section, nil
+	case len(stack) >= maxIncludeDepth:
+		l.conf.Warnings = append(l.conf.Warnings, fmt.Sprintf("%s: includes nested too deeply", where))
+		return section, nil
+	}
+	for _, p := range stack {
+		if p == target {
+			l.conf.Warnings = append(l.conf.Warnings, fmt.Sprintf("%s: include loop via %s", where, target))
+			return section, nil
+		}
+	}
+
+	next, err := l.load(target, section, stack)
+	if err != nil {
+		// Samba skips missing includes, so do we.
+		l.conf.Warnings = append(l.conf.Warnings, fmt.Sprintf("%s: %v", where, err))
+		return section, nil
+	}
+	return next, nil
+}
+
+// resolveInclude makes relative include paths relative to the including
+// file.
+func resolveInclude(from, value string) string {
+	p := strings.TrimSpace(value)
+	if !filepath.IsAbs(p) {
+		p = filepath.Join(filepath.Dir(from), p)
+	}
+	return filepath.Clean(p)
+}
+
+// ParseConfFile parses one file without following includes. section is the
+// section in effect at its first line.
+func ParseConfFile(path string, b []byte, section string) *ConfFile {
+	text := string(b)
+	f := &ConfFile{Path: path, trailingNewline: strings.HasSuffix(text, "\n")}
+	text = strings.TrimSuffix(text, "\n")
+	if text == "" && !f.trailingNewline {
+		return f
+	}
+
+	cont := false // the previous param's value continues on this line
+	for i, raw := range strings.Split(text, "\n") {
+		ln := ConfLine{Num: i + 1, Raw: raw, Section: section}
+		line := strings.TrimSpace(strings.TrimSuffix(raw, "\r"))
+
+		if cont {
+			ln.Kind = ConfContinuation
+			more, ok := strings.CutSuffix(line, "\\")
+			cont = ok
+			more = strings.TrimSpace(more)
+			idx := len(f.Lines) - 1
+			for f.Lines[idx].Kind == ConfContinuation {
+				idx--
+			}
+			f.Lines[idx].Value = strings.TrimSpace(f.Lines[idx].Value + " " + more)
+			f.Lines = append(f.Lines, ln)
+			continue
+		}
+
+		switch {
+		case line == "":
+			ln.Kind = ConfBlank
+		case strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";"):
+			ln.Kind = ConfComment
+		case strings.HasPrefix(line, "["):
+			end := strings.Index(line, "]")
+			if end < 0 {
+				ln.Kind = ConfInvalid
+				break
+			}
+			ln.Kind = ConfSection
+			section = strings.TrimSpace(line[1:end])
+			ln.Section = section
+		default:
+			k, v, ok := strings.Cut(line, "=")
+			if !ok {
+				ln.Kind = ConfInvalid
+				break
+			}
+			ln.Kind = ConfParam
+			ln.Key = strings.TrimSpace(k)
+			v, cont = strings.CutSuffix(strings.TrimSpace(v), "\\")
+			ln.Value = strings.TrimSpace(v)
+		}
+		f.Lines = append(f.Lines, ln)
+	}
+	return f
+}
+
+// ConfSpan is the block of lines a section header introduces in one file:
+// from the header to its last parameter, before the next section or include.
+type ConfSpan struct {
+	File  string
+	Start int
+	End   int
+}
+
+// SectionSpans returns every block headed by [name], in load order.
+func (c *SmbConf) SectionSpans(name string) []ConfSpan {
+	var res []ConfSpan
+	for _, f := range c.Files {
+		for i, l := range f.Lines {
+			if l.Kind != ConfSection || !strings.EqualFold(l.Section, name) {
+				continue
+			}
+			sp := ConfSpan{File: f.Path, Start: l.Num, End: l.Num}
+			for _, next := range f.Lines[i+1:] {
+				if next.Kind == ConfSection || (next.Kind == ConfParam && CanonicalParamName(next.Key) == "include") {
+					break
+				}
+				if next.Kind == ConfParam || next.Kind == ConfContinuation {
+					sp.End = next.Num
+				}
+			}
+			res = append(res, sp)
+		}
+	}
+	return res
+}
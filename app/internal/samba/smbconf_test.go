@@ -0,0 +1,94 @@
+package samba
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParseConfFileRoundTrip(t *testing.T) {
+	for _, in := range []string{
+		"",
+		"[global]\n",
+		"[global]",
+		"# comment\n; other\n\n[global]\n   workgroup = HOME  \r\n\thosts allow = 10.0.0.0/8 \\\n   192.168.0.0/16\nbroken line\n",
+	} {
+		f := ParseConfFile("smb.conf", []byte(in), "")
+		if got := string(f.Bytes()); got != in {
+			t.Errorf("round trip of %q = %q", in, got)
+		}
+	}
+}
+
+func TestParseConfFileContinuation(t *testing.T) {
+	f := ParseConfFile("smb.conf", []byte("[s]\nvalid users = a, \\\n  b, \\\n  c\npath = /x\n"), "")
+
+	var kinds []ConfLineKind
+	for _, l := range f.Lines {
+		kinds = append(kinds, l.Kind)
+	}
+	want := []ConfLineKind{ConfSection, ConfParam, ConfContinuation, ConfContinuation, ConfParam}
+	if !slices.Equal(kinds, want) {
+		t.Fatalf("kinds = %v, want %v", kinds, want)
+	}
+	if v := f.Lines[1].Value; v != "a, b, c" {
+		t.Errorf("value = %q", v)
+	}
+	if l := f.Lines[4]; l.Key != "path" || l.Section != "s" || l.Num != 5 {
+		t.Errorf("path line = %+v", l)
+	}
+}
+
+func TestLoadSmbConfFollowsIncludes(t *testing.T) {
+	dir := t.TempDir()
+	main := filepath.Join(dir, "smb.conf")
+	index := filepath.Join(dir, "shares.d", "index.conf")
+	snippet := filepath.Join(dir, "shares.d", "media.conf")
+
+	writeFile(t, main, "[global]\nworkgroup = HOME\ninclude = shares.d/index.conf\ninclude = /nonexistent/x.conf\ninclude = /etc/samba/%m.conf\n")
+	writeFile(t, index, "include = "+snippet+"\ninclude = "+index+"\n")
+	writeFile(t, snippet, "[Media]\npath = /srv/media\nread only = no\nRead_Only = yes\n")
+
+	conf, err := LoadSmbConf(main)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(conf.Files) != 3 {
+		t.Fatalf("loaded %d files", len(conf.Files))
+	}
+	if len(conf.Warnings) != 3 {
+		t.Errorf("warnings = %q, want missing file, macro and loop", conf.Warnings)
+	}
+
+	sec := conf.Section("media")
+	if sec == nil {
+		t.Fatal("section media not found")
+	}
+	p, ok := sec.Get("read only")
+	if !ok || p.Value != "yes" || p.Origin() != snippet+":4" {
+		t.Errorf("read only = %+v", p)
+	}
+	if p, _ := conf.Section("global").Get("workgroup"); p.Origin() != main+":2" {
+		t.Errorf("workgroup origin = %q", p.Origin())
+	}
+
+	if err := CheckSmbConfIncludesIndex(main, index); err != nil {
+		t.Errorf("index include not found: %v", err)
+	}
+	if err := CheckSmbConfIncludesIndex(main, filepath.Join(dir, "other.conf")); err == nil || !strings.Contains(err.Error(), "missing") {
+		t.Errorf("err = %v, want missing include", err)
+	}
+}
@@ -0,0 +1,395 @@
+package samba
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Connection state as reported by smbstatus. smbstatus --json (Samba 4.16+)
+// is preferred; older versions or builds without JSON support fall back to
+// parsing the text tables, which lack byte-range locks.
+
+type SmbSession struct {
+	PID        string `json:"pid"`
+	SessionID  string `json:"session_id,omitempty"`
+	Username   string `json:"username"`
+	Group      string `json:"group"`
+	UID        int    `json:"uid"`
+	GID        int    `json:"gid"`
+	Machine    string `json:"machine"`
+	Hostname   string `json:"hostname,omitempty"` // e.g. "ipv4:192.168.1.10:52345"
+	Dialect    string `json:"dialect"`
+	Encryption string `json:"encryption"`
+	Signing    string `json:"signing"`
+}
+
+// SmbTcon is a tree connect: a session using a share.
+type SmbTcon struct {
+	Service     string `json:"service"`
+	PID         string `json:"pid"`
+	SessionID   string `json:"session_id,omitempty"`
+	Machine     string `json:"machine"`
+	ConnectedAt string `json:"connected_at"`
+	Encryption  string `json:"encryption"`
+	Signing     string `json:"signing"`
+}
+
+type SmbOpenFile struct {
+	PID       string `json:"pid"`
+	UID       int    `json:"uid"`
+	SharePath string `json:"share_path"`
+	Name      string `json:"name"` // relative to SharePath
+	ShareMode string `json:"share_mode"`
+	Access    string `json:"access"`
+	Oplock    string `json:"oplock"`
+	OpenedAt  string `json:"opened_at"`
+}
+
+// SmbLock is a byte-range lock.
+type SmbLock struct {
+	PID       string `json:"pid"`
+	SharePath string `json:"share_path"`
+	Name      string `json:"name"`
+	Type      string `json:"type"`    // R or W
+	Flavour   string `json:"flavour"` // Posix or Windows
+	Start     uint64 `json:"start"`
+	Size      uint64 `json:"size"`
+}
+
+type SmbStatus struct {
+	Version   string        `json:"version"`
+	Sessions  []SmbSession  `json:"sessions"`
+	Tcons     []SmbTcon     `json:"tcons"`
+	OpenFiles []SmbOpenFile `json:"open_files"`
+	Locks     []SmbLock     `json:"locks"`
+	// Text is set when the text output had to be parsed; locks are then
+	// not available.
+	Text bool `json:"text"`
+}
+
+// Session returns the session served by smbd process pid.
+func (s *SmbStatus) Session(pid string) (SmbSession, bool) {
+	for _, ss := range s.Sessions {
+		if ss.PID == pid {
+			return ss, true
+		}
+	}
+	return SmbSession{}, false
+}
+
+// ForShare returns the tree connects to share and the files open in its
+// path.
+func (s *SmbStatus) ForShare(share, path string) (tcons []SmbTcon, open []SmbOpenFile) {
+	for _, t := range s.Tcons {
+		if strings.EqualFold(t.Service, share) {
+			tcons = append(tcons, t)
+		}
+	}
+	for _, f := range s.OpenFiles {
+		if path != "" && f.SharePath == path {
+			open = append(open, f)
+		}
+	}
+	return tcons, open
+}
+
+// GetSmbStatus runs smbstatus, as JSON if supported.
+func GetSmbStatus() (*SmbStatus, error) {
+	out, _, code, _ := run(10*time.Second, "smbstatus", "--json")
+	if code == 0 {
+		if st, err := ParseSmbstatusJSON([]byte(out)); err == nil {
+			return st, nil
+		}
+	}
+
+	out, errStr, code, err := run(10*time.Second, "smbstatus")
+	if code != 0 {
+		if errStr == "" && err != nil {
+			errStr = err.Error()
+		}
+		return nil, fmt.Errorf("smbstatus failed: %s", strings.TrimSpace(errStr))
+	}
+	return ParseSmbstatusText(out), nil
+}
+
+// jsonStr accepts a JSON string or number; smbstatus is not consistent
+// between versions (pids are strings, uids numbers, ...).
+type jsonStr string
+
+func (s *jsonStr) UnmarshalJSON(b []byte) error {
+	if len(b) > 0 && b[0] == '"' {
+		var v string
+		if err := json.Unmarshal(b, &v); err != nil {
+			return err
+		}
+		*s = jsonStr(v)
+		return nil
+	}
+	*s = jsonStr(bytes.Trim(b, " "))
+	return nil
+}
+
+func (s jsonStr) int() int {
+	n, _ := strconv.Atoi(string(s))
+	return n
+}
+
+type jsonServerID struct {
+	PID jsonStr `json:"pid"`
+}
+
+// jsonText is one of smbstatus' flag objects ({"hex": ..., "text": ...});
+// only the text is used.
+type jsonText struct {
+	Text   string `json:"text"`
+	Cipher string `json:"cipher"`
+	Degree string `json:"degree"`
+}
+
+func (t jsonText) crypto() string {
+	switch {
+	case t.Degree == "" || t.Degree == "none":
+		return "-"
+	case t.Cipher == "":
+		return t.Degree
+	}
+	return t.Degree + "(" + t.Cipher + ")"
+}
+
+type jsonStatus struct {
+	Version  string `json:"version"`
+	Sessions map[string]struct {
+		SessionID     jsonStr      `json:"session_id"`
+		ServerID      jsonServerID `json:"server_id"`
+		UID           jsonStr      `json:"uid"`
+		GID           jsonStr      `json:"gid"`
+		Username      string       `json:"username"`
+		Groupname     string       `json:"groupname"`
+		RemoteMachine string       `json:"remote_machine"`
+		Hostname      string       `json:"hostname"`
+		Dialect       string       `json:"session_dialect"`
+		Encryption    jsonText     `json:"encryption"`
+		Signing       jsonText     `json:"signing"`
+	} `json:"sessions"`
+	Tcons map[string]struct {
+		Service     string       `json:"service"`
+		ServerID    jsonServerID `json:"server_id"`
+		SessionID   jsonStr      `json:"session_id"`
+		Machine     string       `json:"machine"`
+		ConnectedAt string       `json:"connected_at"`
+		Encryption  jsonText     `json:"encryption"`
+		Signing     jsonText     `json:"signing"`
+	} `json:"tcons"`
+	OpenFiles map[string]struct {
+		ServicePath string `json:"service_path"`
+		Filename    string `json:"filename"`
+		Opens       map[string]struct {
+			ServerID  jsonServerID `json:"server_id"`
+			UID       jsonStr      `json:"uid"`
+			ShareMode jsonText     `json:"sharemode"`
+			Access    jsonText     `json:"access_mask"`
+			Caching   jsonText     `json:"caching"`
+			OpenedAt  string       `json:"opened_at"`
+		} `json:"opens"`
+	} `json:"open_files"`
+	Locks map[string]struct {
+		FileName  string `json:"file_name"`
+		SharePath string `json:"share_path"`
+		Locks     []struct {
+			ServerID jsonServerID `json:"server_id"`
+			Type     string       `json:"type"`
+			Flavour  string       `json:"flavour"`
+			Start    uint64       `json:"start"`
+			Size     uint64       `json:"size"`
+		} `json:"locks"`
+	} `json:"byte_range_locks"`
+}
+
+// ParseSmbstatusJSON parses the output of smbstatus --json.
+func ParseSmbstatusJSON(b []byte) (*SmbStatus, error) {
+	var js jsonStatus
+	if err := json.Unmarshal(b, &js); err != nil {
+		return nil, fmt.Errorf("invalid smbstatus JSON: %w", err)
+	}
+
+	st := &SmbStatus{Version: js.Version, Sessions: []SmbSession{}, Tcons: []SmbTcon{}, OpenFiles: []SmbOpenFile{}, Locks: []SmbLock{}}
+	for _, s := range js.Sessions {
+		st.Sessions = append(st.Sessions, SmbSession{
+			PID: string(s.ServerID.PID), SessionID: string(s.SessionID),
+			Username: s.Username, Group: s.Groupname, UID: s.UID.int(), GID: s.GID.int(),
+			Machine: s.RemoteMachine, Hostname: s.Hostname, Dialect: s.Dialect,
+			Encryption: s.Encryption.crypto(), Signing: s.Signing.crypto(),
+		})
+	}
+	for _, t := range js.Tcons {
+		st.Tcons = append(st.Tcons, SmbTcon{
+			Service: t.Service, PID: string(t.ServerID.PID), SessionID: string(t.SessionID),
+			Machine: t.Machine, ConnectedAt: t.ConnectedAt,
+			Encryption: t.Encryption.crypto(), Signing: t.Signing.crypto(),
+		})
+	}
+	for _, f := range js.OpenFiles {
+		for _, o := range f.Opens {
+			st.OpenFiles = append(st.OpenFiles, SmbOpenFile{
+				PID: string(o.ServerID.PID), UID: o.UID.int(),
+				SharePath: f.ServicePath, Name: f.Filename,
+				ShareMode: o.ShareMode.Text, Access: o.Access.Text, Oplock: o.Caching.Text,
+				OpenedAt: o.OpenedAt,
+			})
+		}
+	}
+	for _, f := range js.Locks {
+		for _, l := range f.Locks {
+			st.Locks = append(st.Locks, SmbLock{
+				PID: string(l.ServerID.PID), SharePath: f.SharePath, Name: f.FileName,
+				Type: l.Type, Flavour: l.Flavour, Start: l.Start, Size: l.Size,
+			})
+		}
+	}
+	st.sort()
+	return st, nil
+}
+
+func (st *SmbStatus) sort() {
+	slices.SortFunc(st.Sessions, func(a, b SmbSession) int {
+		return strings.Compare(a.Username+"\x00"+a.PID, b.Username+"\x00"+b.PID)
+	})
+	slices.SortFunc(st.Tcons, func(a, b SmbTcon) int {
+		return strings.Compare(a.Service+"\x00"+a.PID, b.Service+"\x00"+b.PID)
+	})
+	slices.SortFunc(st.OpenFiles, func(a, b SmbOpenFile) int {
+		return strings.Compare(a.SharePath+"/"+a.Name+"\x00"+a.PID, b.SharePath+"/"+b.Name+"\x00"+b.PID)
+	})
+	slices.SortFunc(st.Locks, func(a, b SmbLock) int {
+		if c := strings.Compare(a.SharePath+"/"+a.Name, b.SharePath+"/"+b.Name); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.Start, b.Start)
+	})
+}
+
+var (
+	smbstatusVersionRx = regexp.MustCompile(`^Samba version (\S+)`)
+	// e.g. "Mon Jan  1 12:00:00 2024" with an optional zone
+	smbstatusTimeRx = regexp.MustCompile(`\s(\w{3} \w{3} +\d+ \d\d:\d\d:\d\d \d{4}(?: \S+)?)\s*$`)
+)
+
+// ParseSmbstatusText parses the tables of plain smbstatus: sessions, shares
+// (tree connects) and locked (open) files.
+func ParseSmbstatusText(out string) *SmbStatus {
+	st := &SmbStatus{Sessions: []SmbSession{}, Tcons: []SmbTcon{}, OpenFiles: []SmbOpenFile{}, Locks: []SmbLock{}, Text: true}
+
+	section := ""
+	for _, ln := range strings.Split(out, "\n") {
+		line := strings.TrimRight(ln, " \t\r")
+		trimmed := strings.TrimSpace(line)
+		f := strings.Fields(line)
+		switch {
+		case trimmed == "":
+			section = ""
+			continue
+		case smbstatusVersionRx.MatchString(trimmed):
+			st.Version = smbstatusVersionRx.FindStringSubmatch(trimmed)[1]
+			continue
+		case strings.HasPrefix(trimmed, "---"), strings.HasPrefix(trimmed, "Locked files"), strings.HasPrefix(trimmed, "No locked files"):
+			continue
+		case len(f) > 1 && f[0] == "PID" && f[1] == "Username":
+			section = "sessions"
+			continue
+		case len(f) > 1 && f[0] == "Service" && f[1] == "pid":
+			section = "tcons"
+			continue
+		case len(f) > 1 && f[0] == "Pid" && strings.HasPrefix(f[1], "User"):
+			section = "files"
+			continue
+		}
+
+		switch section {
+		case "sessions":
+			// PID Username Group Machine [(addr)] Protocol Encryption Signing
+			if len(f) < 7 {
+				continue
+			}
+			s := SmbSession{PID: f[0], Username: f[1], Group: f[2], Machine: f[3],
+				Dialect: f[len(f)-3], Encryption: f[len(f)-2], Signing: f[len(f)-1]}
+			if len(f) > 7 {
+				s.Hostname = strings.Trim(strings.Join(f[4:len(f)-3], " "), "()")
+			}
+			st.Sessions = append(st.Sessions, s)
+		case "tcons":
+			// Service pid Machine Connected-at... Encryption Signing
+			if len(f) < 6 {
+				continue
+			}
+			st.Tcons = append(st.Tcons, SmbTcon{Service: f[0], PID: f[1], Machine: f[2],
+				ConnectedAt: strings.Join(f[3:len(f)-2], " "), Encryption: f[len(f)-2], Signing: f[len(f)-1]})
+		case "files":
+			// Pid User(ID) DenyMode Access R/W Oplock SharePath Name... Time
+			m := smbstatusTimeRx.FindStringSubmatchIndex(line)
+			if m == nil {
+				continue
+			}
+			f = strings.Fields(line[:m[0]])
+			if len(f) < 8 {
+				continue
+			}
+			uid, _ := strconv.Atoi(f[1])
+			st.OpenFiles = append(st.OpenFiles, SmbOpenFile{PID: f[0], UID: uid, ShareMode: f[2], Access: f[4],
+				Oplock: f[5], SharePath: f[6], Name: strings.Join(f[7:], " "), OpenedAt: line[m[2]:m[3]]})
+		}
+	}
+	st.sort()
+	return st
+}
+
+var pidRx = regexp.MustCompile(`^[0-9]+$`)
+
+// CloseShare asks smbd process pid to close its connections to share.
+func CloseShare(pid, share string) error {
+	if !pidRx.MatchString(pid) {
+		return fmt.Errorf("invalid pid %q", pid)
+	}
+	_, errStr, code, err := run(5*time.Second, "smbcontrol", pid, "close-share", share)
+	if code != 0 {
+		if errStr == "" && err != nil {
+			errStr = err.Error()
+		}
+		return fmt.Errorf("smbcontrol failed: %s", strings.TrimSpace(errStr))
+	}
+	return nil
+}
+
+// CloseShareAll asks every smbd process to close its connections to share.
+func CloseShareAll(share string) error {
+	_, errStr, code, err := run(5*time.Second, "smbcontrol", "smbd", "close-share", share)
+	if code != 0 {
+		if errStr == "" && err != nil {
+			errStr = err.Error()
+		}
+		return fmt.Errorf("smbcontrol failed: %s", strings.TrimSpace(errStr))
+	}
+	return nil
+}
+
+// KillSession terminates smbd process pid, dropping the client's session.
+// The client usually reconnects on its own.
+func KillSession(pid string) error {
+	if !pidRx.MatchString(pid) {
+		return fmt.Errorf("invalid pid %q", pid)
+	}
+	_, errStr, code, err := run(5*time.Second, "kill", "-TERM", pid)
+	if code != 0 {
+		if errStr == "" && err != nil {
+			errStr = err.Error()
+		}
+		return fmt.Errorf("kill failed: %s", strings.TrimSpace(errStr))
+	}
+	return nil
+}
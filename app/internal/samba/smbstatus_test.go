@@ -0,0 +1,133 @@
+package samba
+
+import "testing"
+
+const smbstatusJSON = `{
+  "timestamp": "2024-01-01T12:00:00.000000+0100",
+  "version": "4.17.12-Debian",
+  "smb_conf": "/etc/samba/smb.conf",
+  "sessions": {
+    "3203645701": {
+      "session_id": "3203645701",
+      "server_id": {"pid": "1234", "task_id": "0", "vnn": "4294967295", "unique_id": "1"},
+      "uid": 1000,
+      "gid": 1000,
+      "username": "alice",
+      "groupname": "alice",
+      "remote_machine": "192.168.1.10",
+      "hostname": "ipv4:192.168.1.10:52345",
+      "session_dialect": "SMB3_11",
+      "encryption": {"cipher": "", "degree": "none"},
+      "signing": {"cipher": "AES-128-GMAC", "degree": "partial"}
+    }
+  },
+  "tcons": {
+    "884": {
+      "service": "media",
+      "server_id": {"pid": "1234", "task_id": "0", "vnn": "4294967295", "unique_id": "1"},
+      "tcon_id": "884",
+      "session_id": "3203645701",
+      "machine": "192.168.1.10",
+      "connected_at": "2024-01-01T11:58:00.000000+0100",
+      "encryption": {"cipher": "", "degree": "none"},
+      "signing": {"cipher": "", "degree": "none"}
+    }
+  },
+  "open_files": {
+    "/srv/media/movie.mkv": {
+      "service_path": "/srv/media",
+      "filename": "movie.mkv",
+      "num_pending_deletes": 0,
+      "opens": {
+        "1234/56": {
+          "server_id": {"pid": "1234", "task_id": "0", "vnn": "4294967295", "unique_id": "1"},
+          "uid": 1000,
+          "share_file_id": "56",
+          "sharemode": {"hex": "0x00000003", "READ": true, "WRITE": true, "DELETE": false, "text": "RW"},
+          "access_mask": {"hex": "0x00120089", "READ_DATA": true, "text": "R"},
+          "caching": {"READ": true, "WRITE": false, "HANDLE": true, "hex": "0x5", "text": "RH"},
+          "opened_at": "2024-01-01T11:59:00.000000+0100"
+        }
+      }
+    }
+  },
+  "byte_range_locks": {
+    "/srv/media/movie.mkv": {
+      "fileid": {"devid": 1, "inode": 2, "extid": 0},
+      "file_name": "movie.mkv",
+      "share_path": "/srv/media",
+      "locks": [
+        {"server_id": {"pid": "1234"}, "type": "R", "flavour": "Windows", "start": 100, "size": 10},
+        {"server_id": {"pid": "1234"}, "type": "W", "flavour": "Windows", "start": 0, "size": 4}
+      ]
+    }
+  }
+}`
+
+func TestParseSmbstatusJSON(t *testing.T) {
+	st, err := ParseSmbstatusJSON([]byte(smbstatusJSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Version != "4.17.12-Debian" || st.Text {
+		t.Errorf("status = %+v", st)
+	}
+	if len(st.Sessions) != 1 {
+		t.Fatalf("sessions = %+v", st.Sessions)
+	}
+	s := st.Sessions[0]
+	if s.PID != "1234" || s.Username != "alice" || s.UID != 1000 || s.Dialect != "SMB3_11" || s.Encryption != "-" || s.Signing != "partial(AES-128-GMAC)" {
+		t.Errorf("session = %+v", s)
+	}
+	tcons, files := st.ForShare("Media", "/srv/media")
+	if len(tcons) != 1 || tcons[0].PID != "1234" || len(files) != 1 {
+		t.Fatalf("ForShare = %+v, %+v", tcons, files)
+	}
+	if f := files[0]; f.Name != "movie.mkv" || f.Access != "R" || f.ShareMode != "RW" || f.Oplock != "RH" {
+		t.Errorf("open file = %+v", f)
+	}
+	if len(st.Locks) != 2 || st.Locks[0].Start != 0 || st.Locks[1].Type != "R" {
+		t.Errorf("locks = %+v", st.Locks)
+	}
+}
+
+const smbstatusText = `
+Samba version 4.13.13-Debian
+PID     Username     Group        Machine                                   Protocol Version  Encryption           Signing
+----------------------------------------------------------------------------------------------------------------------------------------
+1234    alice        alice        192.168.1.10 (ipv4:192.168.1.10:52345)    SMB3_11           -                    partial(AES-128-CMAC)
+1240    bob          family       192.168.1.11 (ipv4:192.168.1.11:50000)    SMB3_11           -                    -
+
+Service      pid     Machine       Connected at                     Encryption   Signing
+---------------------------------------------------------------------------------------------
+media        1234    192.168.1.10  Mon Jan  1 12:00:00 2024 CET     -            -
+IPC$         1240    192.168.1.11  Mon Jan  1 12:01:00 2024 CET     -            -
+
+Locked files:
+Pid          User(ID)   DenyMode   Access      R/W        Oplock           SharePath   Name   Time
+--------------------------------------------------------------------------------------------------
+1234         1000       DENY_NONE  0x120089    RDONLY     LEASE(RWH)       /srv/media   holiday 2023/clip one.mp4   Mon Jan  1 12:02:00 2024
+
+`
+
+func TestParseSmbstatusText(t *testing.T) {
+	st := ParseSmbstatusText(smbstatusText)
+	if !st.Text || st.Version != "4.13.13-Debian" {
+		t.Errorf("status = %+v", st)
+	}
+	if len(st.Sessions) != 2 {
+		t.Fatalf("sessions = %+v", st.Sessions)
+	}
+	if s := st.Sessions[0]; s.PID != "1234" || s.Machine != "192.168.1.10" || s.Hostname != "ipv4:192.168.1.10:52345" || s.Signing != "partial(AES-128-CMAC)" {
+		t.Errorf("session = %+v", s)
+	}
+	if len(st.Tcons) != 2 || st.Tcons[1].Service != "media" || st.Tcons[1].ConnectedAt != "Mon Jan 1 12:00:00 2024 CET" {
+		t.Errorf("tcons = %+v", st.Tcons)
+	}
+	if len(st.OpenFiles) != 1 {
+		t.Fatalf("open files = %+v", st.OpenFiles)
+	}
+	if f := st.OpenFiles[0]; f.Name != "holiday 2023/clip one.mp4" || f.UID != 1000 || f.Access != "RDONLY" || f.OpenedAt != "Mon Jan  1 12:02:00 2024" {
+		t.Errorf("open file = %+v", f)
+	}
+}
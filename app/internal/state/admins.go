@@ -0,0 +1,32 @@
+package state
+
+import (
+	"database/sql"
+	"errors"
+)
+
+func (s *Store) CountAdmins() (int, error) {
+	row := s.DB.QueryRow(`SELECT COUNT(*) FROM admins`)
+	var n int
+	if err := row.Scan(&n); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+func (s *Store) CreateAdmin(name, passwordHash string) error {
+	_, err := s.DB.Exec(`INSERT INTO admins (name, password_hash) VALUES (?, ?)`, name, passwordHash)
+	return err
+}
+
+func (s *Store) GetAdmin(name string) (Admin, bool, error) {
+	row := s.DB.QueryRow(`SELECT name, password_hash FROM admins WHERE name = ?`, name)
+	var a Admin
+	if err := row.Scan(&a.Name, &a.PasswordHash); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Admin{}, false, nil
+		}
+		return Admin{}, false, err
+	}
+	return a, true, nil
+}
@@ -0,0 +1,71 @@
+package state
+
+import (
+	"database/sql"
+	"errors"
+)
+
+func (s *Store) ListAPITokens() ([]APIToken, error) {
+	rows, err := s.DB.Query(`SELECT id, name, read_only, created_by, created_at, last_used_at FROM api_tokens ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []APIToken
+	for rows.Next() {
+		t, err := scanAPIToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, t)
+	}
+	return out, rows.Err()
+}
+
+func (s *Store) CreateAPIToken(name, tokenHash string, readOnly bool, createdBy string) error {
+	_, err := s.DB.Exec(
+		`INSERT INTO api_tokens (name, token_hash, read_only, created_by) VALUES (?, ?, ?, ?)`,
+		name, tokenHash, readOnly, createdBy,
+	)
+	return err
+}
+
+// GetAPITokenByHash looks up a token by its hash and records the use.
+func (s *Store) GetAPITokenByHash(tokenHash string) (APIToken, bool, error) {
+	row := s.DB.QueryRow(
+		`SELECT id, name, read_only, created_by, created_at, last_used_at FROM api_tokens WHERE token_hash = ?`,
+		tokenHash,
+	)
+	t, err := scanAPIToken(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return APIToken{}, false, nil
+		}
+		return APIToken{}, false, err
+	}
+	if _, err := s.DB.Exec(`UPDATE api_tokens SET last_used_at = datetime('now') WHERE id = ?`, t.ID); err != nil {
+		return APIToken{}, false, err
+	}
+	return t, true, nil
+}
+
+func (s *Store) DeleteAPIToken(id int64) error {
+	_, err := s.DB.Exec(`DELETE FROM api_tokens WHERE id = ?`, id)
+	return err
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanAPIToken(row rowScanner) (APIToken, error) {
+	var t APIToken
+	var createdAt, lastUsed sql.NullString
+	if err := row.Scan(&t.ID, &t.Name, &t.ReadOnly, &t.CreatedBy, &createdAt, &lastUsed); err != nil {
+		return APIToken{}, err
+	}
+	t.CreatedAt = createdAt.String
+	t.LastUsedAt = lastUsed.String
+	return t, nil
+}
@@ -0,0 +1,100 @@
+package state
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+const auditTimeFormat = time.RFC3339Nano
+
+func (s *Store) AddAudit(e AuditEntry) error {
+	if e.At.IsZero() {
+		e.At = time.Now()
+	}
+	_, err := s.DB.Exec(
+		`INSERT INTO audit_log (at, actor, client_ip, action, target, outcome, error) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		e.At.UTC().Format(auditTimeFormat), e.Actor, e.ClientIP, e.Action, e.Target, e.Outcome, e.Error,
+	)
+	return err
+}
+
+// ListAudit returns matching entries, newest first.
+func (s *Store) ListAudit(f AuditFilter) ([]AuditEntry, error) {
+	q := `SELECT id, at, actor, client_ip, action, target, outcome, error FROM audit_log WHERE 1=1`
+	var args []any
+	if f.Action != "" {
+		q += ` AND (action = ? OR action LIKE ? ESCAPE '\')`
+		args = append(args, f.Action, escapeLike(f.Action)+".%")
+	}
+	if f.Target != "" {
+		q += ` AND target LIKE ? ESCAPE '\'`
+		args = append(args, "%"+escapeLike(f.Target)+"%")
+	}
+	if f.Actor != "" {
+		q += ` AND actor = ?`
+		args = append(args, f.Actor)
+	}
+	// at has a varying number of fractional digits, so compare as time
+	if !f.Since.IsZero() {
+		q += ` AND julianday(at) >= julianday(?)`
+		args = append(args, f.Since.UTC().Format(auditTimeFormat))
+	}
+	if !f.Until.IsZero() {
+		q += ` AND julianday(at) < julianday(?)`
+		args = append(args, f.Until.UTC().Format(auditTimeFormat))
+	}
+	q += ` ORDER BY id DESC`
+	if f.Limit > 0 {
+		q += ` LIMIT ?`
+		args = append(args, f.Limit)
+	}
+
+	rows, err := s.DB.Query(q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []AuditEntry
+	for rows.Next() {
+		var e AuditEntry
+		var at string
+		if err := rows.Scan(&e.ID, &at, &e.Actor, &e.ClientIP, &e.Action, &e.Target, &e.Outcome, &e.Error); err != nil {
+			return nil, err
+		}
+		e.At, _ = time.Parse(auditTimeFormat, at)
+		out = append(out, e)
+	}
+	return out, rows.Err()
+}
+
+// ListAuditActionTypes returns the distinct action types ("share", "user", ...).
+func (s *Store) ListAuditActionTypes() ([]string, error) {
+	rows, err := s.DB.Query(`SELECT DISTINCT action FROM audit_log`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	seen := map[string]bool{}
+	var out []string
+	for rows.Next() {
+		var a string
+		if err := rows.Scan(&a); err != nil {
+			return nil, err
+		}
+		t, _, _ := strings.Cut(a, ".")
+		if !seen[t] {
+			seen[t] = true
+			out = append(out, t)
+		}
+	}
+	sort.Strings(out)
+	return out, rows.Err()
+}
+
+func escapeLike(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return r.Replace(s)
+}
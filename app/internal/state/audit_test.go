@@ -0,0 +1,70 @@
+package state
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestListAuditFilters(t *testing.T) {
+	s, err := Open(filepath.Join(t.TempDir(), "app.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	day := time.Date(2026, 10, 17, 0, 0, 0, 0, time.UTC)
+	for _, e := range []AuditEntry{
+		{At: day.Add(-time.Hour), Actor: "admin", Action: "share.create", Target: "media"},
+		{At: day.Add(time.Second), Actor: "admin", Action: "share.delete", Target: "100%_done"},
+		{At: day.Add(1500 * time.Millisecond), Actor: "token:ci", Action: "user.create", Target: "1000x_done"},
+		{At: day.Add(25 * time.Hour), Actor: "system", Action: "shares.weird", Target: "media"},
+		{At: day.Add(26 * time.Hour), Actor: "admin", Action: "share", Target: "media"},
+	} {
+		e.Outcome = OutcomeSuccess
+		if err := s.AddAudit(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tc := range []struct {
+		name string
+		f    AuditFilter
+		want []string // targets, newest first
+	}{
+		{"all", AuditFilter{}, []string{"media", "media", "1000x_done", "100%_done", "media"}},
+		{"limit", AuditFilter{Limit: 2}, []string{"media", "media"}},
+		{"action type", AuditFilter{Action: "share"}, []string{"media", "100%_done", "media"}},
+		{"exact action", AuditFilter{Action: "share.delete"}, []string{"100%_done"}},
+		{"action is not a LIKE pattern", AuditFilter{Action: "s_are"}, nil},
+		{"actor", AuditFilter{Actor: "admin"}, []string{"media", "100%_done", "media"}},
+		{"actor is exact", AuditFilter{Actor: "adm"}, nil},
+		{"target substring", AuditFilter{Target: "done"}, []string{"1000x_done", "100%_done"}},
+		{"percent is literal", AuditFilter{Target: "0%"}, []string{"100%_done"}},
+		{"underscore is literal", AuditFilter{Target: "x_d"}, []string{"1000x_done"}},
+		{"since", AuditFilter{Since: day.Add(time.Second)}, []string{"media", "media", "1000x_done", "100%_done"}},
+		{"since with fraction", AuditFilter{Since: day.Add(1200 * time.Millisecond)}, []string{"media", "media", "1000x_done"}},
+		{"until is exclusive", AuditFilter{Until: day.Add(1500 * time.Millisecond)}, []string{"100%_done", "media"}},
+		{"one day", AuditFilter{Since: day, Until: day.AddDate(0, 0, 1)}, []string{"1000x_done", "100%_done"}},
+		{"combined", AuditFilter{Actor: "admin", Action: "share", Since: day}, []string{"media", "100%_done"}},
+	} {
+		entries, err := s.ListAudit(tc.f)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		var got []string
+		for _, e := range entries {
+			got = append(got, e.Target)
+		}
+		if len(got) != len(tc.want) {
+			t.Errorf("%s: %q, want %q", tc.name, got, tc.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tc.want[i] {
+				t.Errorf("%s: %q, want %q", tc.name, got, tc.want)
+				break
+			}
+		}
+	}
+}
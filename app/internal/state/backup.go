@@ -0,0 +1,290 @@
+package state
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// backupTables are the tables a backup restores, in restore order. Tables
+// with Replace are emptied and refilled; the others are merged by Key so
+// that rows which stay (e.g. the logged-in admin) keep their sessions.
+// Sessions and the audit log are never restored.
+var backupTables = []struct {
+	Name    string
+	Key     []string
+	Replace bool
+}{
+	{"groups", []string{"name"}, false},
+	{"users", []string{"name"}, false},
+	{"user_groups", []string{"user_name", "group_name"}, true},
+	{"admins", []string{"name"}, false},
+	{"api_tokens", []string{"name"}, true},
+}
+
+// TableDiff lists the rows (by key) a restore would add, remove or change.
+type TableDiff struct {
+	Table   string   `json:"table"`
+	Added   []string `json:"added,omitempty"`
+	Removed []string `json:"removed,omitempty"`
+	Changed []string `json:"changed,omitempty"`
+}
+
+// Empty reports whether the table would stay as it is.
+func (d TableDiff) Empty() bool {
+	return len(d.Added)+len(d.Removed)+len(d.Changed) == 0
+}
+
+// volatileColumns change without the configuration changing and are left
+// out of Fingerprint.
+var volatileColumns = map[string]bool{"api_tokens.last_used_at": true}
+
+// Fingerprint returns a hash of the restorable tables. It changes whenever
+// a restore would change something.
+func (s *Store) Fingerprint() (string, error) {
+	h := sha256.New()
+	for _, t := range backupTables {
+		rows, err := s.DB.Query(fmt.Sprintf(`SELECT * FROM %s ORDER BY %s`, t.Name, strings.Join(t.Key, ", ")))
+		if err != nil {
+			return "", err
+		}
+		cols, err := rows.Columns()
+		if err != nil {
+			rows.Close()
+			return "", err
+		}
+		vals := make([]any, len(cols))
+		ptrs := make([]any, len(cols))
+		for i := range vals {
+			ptrs[i] = &vals[i]
+		}
+		fmt.Fprintf(h, "%s\n", t.Name)
+		for rows.Next() {
+			if err := rows.Scan(ptrs...); err != nil {
+				rows.Close()
+				return "", err
+			}
+			for i, c := range cols {
+				if !volatileColumns[t.Name+"."+c] {
+					fmt.Fprintf(h, "%s=%v\x1f", c, vals[i])
+				}
+			}
+			h.Write([]byte{'\n'})
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// SnapshotTo writes a consistent copy of the database to path, which must
+// not exist yet.
+func (s *Store) SnapshotTo(path string) error {
+	_, err := s.DB.Exec(`VACUUM INTO ?`, path)
+	return err
+}
+
+// withBackup runs fn on one connection with the database file path attached
+// as "bak".
+func (s *Store) withBackup(path string, fn func(ctx context.Context, conn *sql.Conn) error) error {
+	ctx := context.Background()
+	conn, err := s.DB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, `ATTACH DATABASE ? AS bak`, path); err != nil {
+		return fmt.Errorf("open backup database: %w", err)
+	}
+	defer conn.ExecContext(ctx, `DETACH DATABASE bak`)
+
+	for _, t := range backupTables {
+		var n int
+		if err := conn.QueryRowContext(ctx, `SELECT count(*) FROM bak.sqlite_master WHERE type = 'table' AND name = ?`, t.Name).Scan(&n); err != nil {
+			return fmt.Errorf("read backup database: %w", err)
+		}
+		if n == 0 {
+			return fmt.Errorf("backup database has no table %s", t.Name)
+		}
+	}
+	return fn(ctx, conn)
+}
+
+// commonColumns returns the columns table has in both databases.
+func commonColumns(ctx context.Context, conn *sql.Conn, table string) ([]string, error) {
+	cols := func(schema string) ([]string, error) {
+		rows, err := conn.QueryContext(ctx, `SELECT name FROM pragma_table_info(?, ?)`, table, schema)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		var res []string
+		for rows.Next() {
+			var c string
+			if err := rows.Scan(&c); err != nil {
+				return nil, err
+			}
+			res = append(res, c)
+		}
+		return res, rows.Err()
+	}
+	mine, err := cols("main")
+	if err != nil {
+		return nil, err
+	}
+	theirs, err := cols("bak")
+	if err != nil {
+		return nil, err
+	}
+	var res []string
+	for _, c := range mine {
+		for _, o := range theirs {
+			if c == o {
+				res = append(res, `"`+c+`"`)
+			}
+		}
+	}
+	return res, nil
+}
+
+// keyMatch is the join condition of a and b on key.
+func keyMatch(key []string, a, b string) string {
+	var conds []string
+	for _, k := range key {
+		conds = append(conds, fmt.Sprintf(`%s."%s" = %s."%s"`, a, k, b, k))
+	}
+	return strings.Join(conds, " AND ")
+}
+
+func keyList(key []string, alias string) string {
+	var cols []string
+	for _, k := range key {
+		cols = append(cols, fmt.Sprintf(`%s."%s"`, alias, k))
+	}
+	return strings.Join(cols, ` || '/' || `)
+}
+
+func queryKeys(ctx context.Context, conn *sql.Conn, q string) ([]string, error) {
+	rows, err := conn.QueryContext(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var res []string
+	for rows.Next() {
+		var k string
+		if err := rows.Scan(&k); err != nil {
+			return nil, err
+		}
+		res = append(res, k)
+	}
+	return res, rows.Err()
+}
+
+// DiffFrom compares the restorable tables with those of the database file
+// at path.
+func (s *Store) DiffFrom(path string) ([]TableDiff, error) {
+	var res []TableDiff
+	err := s.withBackup(path, func(ctx context.Context, conn *sql.Conn) error {
+		for _, t := range backupTables {
+			cols, err := commonColumns(ctx, conn, t.Name)
+			if err != nil {
+				return err
+			}
+			d := TableDiff{Table: t.Name}
+			match := keyMatch(t.Key, "m", "b")
+
+			d.Added, err = queryKeys(ctx, conn, fmt.Sprintf(`SELECT %s FROM bak.%s b WHERE NOT EXISTS (SELECT 1 FROM main.%s m WHERE %s) ORDER BY 1`,
+				keyList(t.Key, "b"), t.Name, t.Name, match))
+			if err != nil {
+				return err
+			}
+			d.Removed, err = queryKeys(ctx, conn, fmt.Sprintf(`SELECT %s FROM main.%s m WHERE NOT EXISTS (SELECT 1 FROM bak.%s b WHERE %s) ORDER BY 1`,
+				keyList(t.Key, "m"), t.Name, t.Name, match))
+			if err != nil {
+				return err
+			}
+			var same []string
+			for _, c := range cols {
+				same = append(same, fmt.Sprintf(`m.%s IS b.%s`, c, c))
+			}
+			d.Changed, err = queryKeys(ctx, conn, fmt.Sprintf(`SELECT %s FROM bak.%s b JOIN main.%s m ON %s WHERE NOT (%s) ORDER BY 1`,
+				keyList(t.Key, "b"), t.Name, t.Name, match, strings.Join(same, " AND ")))
+			if err != nil {
+				return err
+			}
+			res = append(res, d)
+		}
+		return nil
+	})
+	return res, err
+}
+
+// RestoreFrom replaces the restorable tables with those of the database
+// file at path in one transaction.
+func (s *Store) RestoreFrom(path string) error {
+	return s.withBackup(path, func(ctx context.Context, conn *sql.Conn) error {
+		cols := map[string][]string{}
+		for _, t := range backupTables {
+			c, err := commonColumns(ctx, conn, t.Name)
+			if err != nil {
+				return err
+			}
+			cols[t.Name] = c
+		}
+
+		tx, err := conn.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		// Remove merged rows that are gone first, so that their dependent
+		// rows cascade before anything is inserted.
+		for _, t := range backupTables {
+			if t.Replace {
+				continue
+			}
+			q := fmt.Sprintf(`DELETE FROM main.%s WHERE NOT EXISTS (SELECT 1 FROM bak.%s b WHERE %s)`,
+				t.Name, t.Name, keyMatch(t.Key, "main."+t.Name, "b"))
+			if _, err := tx.ExecContext(ctx, q); err != nil {
+				return fmt.Errorf("restore %s: %w", t.Name, err)
+			}
+		}
+
+		for _, t := range backupTables {
+			list := strings.Join(cols[t.Name], ", ")
+			var q string
+			if t.Replace {
+				if _, err := tx.ExecContext(ctx, fmt.Sprintf(`DELETE FROM main.%s`, t.Name)); err != nil {
+					return fmt.Errorf("restore %s: %w", t.Name, err)
+				}
+				q = fmt.Sprintf(`INSERT INTO main.%s (%s) SELECT %s FROM bak.%s`, t.Name, list, list, t.Name)
+			} else {
+				var keys, set []string
+				for _, k := range t.Key {
+					keys = append(keys, `"`+k+`"`)
+				}
+				for _, c := range cols[t.Name] {
+					if !slices.Contains(keys, c) {
+						set = append(set, fmt.Sprintf(`%s = excluded.%s`, c, c))
+					}
+				}
+				q = fmt.Sprintf(`INSERT INTO main.%s (%s) SELECT %s FROM bak.%s WHERE true ON CONFLICT (%s) DO UPDATE SET %s`,
+					t.Name, list, list, t.Name, strings.Join(keys, ", "), strings.Join(set, ", "))
+			}
+			if _, err := tx.ExecContext(ctx, q); err != nil {
+				return fmt.Errorf("restore %s: %w", t.Name, err)
+			}
+		}
+		return tx.Commit()
+	})
+}
@@ -0,0 +1,181 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// migration is one numbered step of the schema. Released migrations never
+// change; a schema change is a new migration at the end of the list. Each
+// one runs in its own transaction together with its schema_migrations row.
+type migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+// The first migrations use IF NOT EXISTS: databases from before
+// schema_migrations already have their tables.
+var migrations = []migration{
+	{1, "initial schema", `
+CREATE TABLE IF NOT EXISTS users (
+  name TEXT PRIMARY KEY,
+  uid INTEGER NULL,
+  gid INTEGER NULL,
+  created_at TEXT DEFAULT (datetime('now'))
+);
+
+CREATE TABLE IF NOT EXISTS groups (
+  name TEXT PRIMARY KEY,
+  gid INTEGER NULL,
+  created_at TEXT DEFAULT (datetime('now'))
+);
+
+CREATE TABLE IF NOT EXISTS user_groups (
+  user_name TEXT NOT NULL,
+  group_name TEXT NOT NULL,
+  PRIMARY KEY (user_name, group_name),
+  FOREIGN KEY (user_name) REFERENCES users(name) ON DELETE CASCADE,
+  FOREIGN KEY (group_name) REFERENCES groups(name) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS admins (
+  name TEXT PRIMARY KEY,
+  password_hash TEXT NOT NULL,
+  created_at TEXT DEFAULT (datetime('now'))
+);
+
+CREATE TABLE IF NOT EXISTS sessions (
+  token_hash TEXT PRIMARY KEY,
+  admin_name TEXT NOT NULL,
+  expires_at INTEGER NOT NULL,
+  created_at TEXT DEFAULT (datetime('now')),
+  FOREIGN KEY (admin_name) REFERENCES admins(name) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS api_tokens (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  name TEXT NOT NULL UNIQUE,
+  token_hash TEXT NOT NULL UNIQUE,
+  read_only INTEGER NOT NULL DEFAULT 0,
+  created_by TEXT NOT NULL DEFAULT '',
+  created_at TEXT DEFAULT (datetime('now')),
+  last_used_at TEXT NULL
+);
+
+CREATE TABLE IF NOT EXISTS audit_log (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  at TEXT NOT NULL,
+  actor TEXT NOT NULL,
+  client_ip TEXT NOT NULL DEFAULT '',
+  action TEXT NOT NULL,
+  target TEXT NOT NULL DEFAULT '',
+  outcome TEXT NOT NULL,
+  error TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS audit_log_at ON audit_log(at);
+`},
+	{2, "share revisions", `
+CREATE TABLE IF NOT EXISTS share_revisions (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  share TEXT NOT NULL,
+  snippet TEXT NULL,
+  index_block TEXT NULL,
+  at TEXT NOT NULL,
+  actor TEXT NOT NULL,
+  action TEXT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS share_revisions_share ON share_revisions(share, id);
+`},
+}
+
+// SchemaVersion is the schema version this binary migrates to.
+func SchemaVersion() int { return migrations[len(migrations)-1].Version }
+
+// ErrSchemaTooNew is returned by Open for a database written by a newer
+// version of the application.
+var ErrSchemaTooNew = errors.New("database schema is newer than this version supports")
+
+// MigrationReport says what Open did to the schema.
+type MigrationReport struct {
+	From, To int
+	// Backup is the copy of the database taken before migrating; empty if
+	// nothing was migrated or the database was new.
+	Backup string
+}
+
+// Version returns the schema version of the open database.
+func (s *Store) Version() (int, error) {
+	var v int
+	err := s.DB.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&v)
+	return v, err
+}
+
+// migrate applies the pending migrations. An existing database is copied
+// to <path>.pre-v<version>-<time>.bak first.
+func (s *Store) migrate(path string) error {
+	if _, err := s.DB.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+  version INTEGER PRIMARY KEY,
+  name TEXT NOT NULL,
+  applied_at TEXT NOT NULL
+)`); err != nil {
+		return fmt.Errorf("migrate: %w", err)
+	}
+	from, err := s.Version()
+	if err != nil {
+		return fmt.Errorf("migrate: %w", err)
+	}
+	s.Migration = MigrationReport{From: from, To: from}
+	if from > SchemaVersion() {
+		return fmt.Errorf("%w: database %s is at version %d, this binary knows up to %d", ErrSchemaTooNew, path, from, SchemaVersion())
+	}
+	if from == SchemaVersion() {
+		return nil
+	}
+
+	var tables int
+	if err := s.DB.QueryRow(`SELECT count(*) FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%' AND name != 'schema_migrations'`).Scan(&tables); err != nil {
+		return fmt.Errorf("migrate: %w", err)
+	}
+	if tables > 0 {
+		backup := fmt.Sprintf("%s.pre-v%d-%s.bak", path, from, time.Now().Format("20060102-150405"))
+		if err := s.SnapshotTo(backup); err != nil {
+			return fmt.Errorf("migrate: copying the database before migrating failed: %w", err)
+		}
+		if err := os.Chmod(backup, 0600); err != nil {
+			return fmt.Errorf("migrate: %w", err)
+		}
+		s.Migration.Backup = backup
+	}
+
+	for _, m := range migrations {
+		if m.Version <= from {
+			continue
+		}
+		if err := s.apply(m); err != nil {
+			return fmt.Errorf("migration %d (%s): %w", m.Version, m.Name, err)
+		}
+		s.Migration.To = m.Version
+	}
+	return nil
+}
+
+func (s *Store) apply(m migration) error {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(m.SQL); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)`,
+		m.Version, m.Name, time.Now().UTC().Format(auditTimeFormat)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
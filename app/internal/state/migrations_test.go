@@ -0,0 +1,94 @@
+package state
+
+import (
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestMigrationsAreNumberedInOrder(t *testing.T) {
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			t.Errorf("migration %d (%s) has version %d", i+1, m.Name, m.Version)
+		}
+	}
+}
+
+func TestMigrateLegacyDatabase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.db")
+
+	// a database from before schema_migrations
+	db, err := sql.Open("sqlite", "file:"+path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(migrations[0].SQL); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO users (name, uid) VALUES ('alice', 1000)`); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	s, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := s.Migration
+	if m.From != 0 || m.To != SchemaVersion() || m.Backup == "" {
+		t.Errorf("migration = %+v", m)
+	}
+	if v, err := s.Version(); err != nil || v != SchemaVersion() {
+		t.Errorf("version = %d, %v", v, err)
+	}
+	if users, err := s.ListUsers(); err != nil || len(users) != 1 || users[0].Name != "alice" {
+		t.Errorf("users = %v, %v", users, err)
+	}
+	if _, err := s.AddShareRevision(ShareRevision{Share: "media", Actor: "admin", Action: "share.create"}); err != nil {
+		t.Errorf("new table missing: %v", err)
+	}
+	s.Close()
+
+	// the copy is the database as it was
+	bak, err := sql.Open("sqlite", "file:"+m.Backup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bak.Close()
+	var n int
+	if err := bak.QueryRow(`SELECT count(*) FROM users`).Scan(&n); err != nil || n != 1 {
+		t.Errorf("backup users = %d, %v", n, err)
+	}
+	if err := bak.QueryRow(`SELECT count(*) FROM share_revisions`).Scan(&n); err == nil {
+		t.Error("backup was taken after migrating")
+	}
+
+	// up to date: nothing to do
+	s, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m := s.Migration; m.From != SchemaVersion() || m.To != SchemaVersion() || m.Backup != "" {
+		t.Errorf("second open = %+v", m)
+	}
+	if _, err := s.DB.Exec(`INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, 'future', '')`, SchemaVersion()+1); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	if _, err := Open(path); !errors.Is(err, ErrSchemaTooNew) {
+		t.Errorf("newer schema: %v", err)
+	}
+}
+
+func TestMigrateNewDatabase(t *testing.T) {
+	s, err := Open(filepath.Join(t.TempDir(), "app.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if m := s.Migration; m.From != 0 || m.To != SchemaVersion() || m.Backup != "" {
+		t.Errorf("migration = %+v", m)
+	}
+}
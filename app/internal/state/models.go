@@ -1,5 +1,7 @@
 package state
 
+import "time"
+
 type User struct {
 	Name string
 	UID  *int
@@ -15,3 +17,65 @@ type Membership struct {
 	User  string
 	Group string
 }
+
+type Admin struct {
+	Name         string
+	PasswordHash string
+}
+
+type Session struct {
+	TokenHash string
+	Admin     string
+	ExpiresAt time.Time
+}
+
+type APIToken struct {
+	ID         int64
+	Name       string
+	ReadOnly   bool
+	CreatedBy  string
+	CreatedAt  string
+	LastUsedAt string // empty if never used
+}
+
+const (
+	OutcomeSuccess = "success"
+	OutcomeError   = "error"
+)
+
+type AuditEntry struct {
+	ID       int64     `json:"id"`
+	At       time.Time `json:"at"`
+	Actor    string    `json:"actor"`
+	ClientIP string    `json:"client_ip"`
+	Action   string    `json:"action"`
+	Target   string    `json:"target"`
+	Outcome  string    `json:"outcome"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// AuditFilter narrows ListAudit. Action matches either the exact action or
+// its type prefix ("share" matches "share.create"); Target is a substring;
+// Actor must match exactly. Since and Until, if set, bound the time
+// (Until exclusive).
+type AuditFilter struct {
+	Action string
+	Target string
+	Actor  string
+	Since  time.Time
+	Until  time.Time
+	Limit  int
+}
+
+// ShareRevision is the content of a UI-managed share after a change: its
+// snippet file and its block in the shares index. Nil means the file or
+// block did not exist (e.g. after a delete).
+type ShareRevision struct {
+	ID         int64     `json:"id"`
+	Share      string    `json:"share"`
+	Snippet    *string   `json:"snippet"`
+	IndexBlock *string   `json:"index_block"`
+	At         time.Time `json:"at"`
+	Actor      string    `json:"actor"`
+	Action     string    `json:"action"`
+}
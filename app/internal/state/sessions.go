@@ -0,0 +1,43 @@
+package state
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+func (s *Store) CreateSession(tokenHash, admin string, expiresAt time.Time) error {
+	_, err := s.DB.Exec(
+		`INSERT INTO sessions (token_hash, admin_name, expires_at) VALUES (?, ?, ?)`,
+		tokenHash, admin, expiresAt.Unix(),
+	)
+	return err
+}
+
+// GetSession returns the session for tokenHash if it exists and has not expired.
+func (s *Store) GetSession(tokenHash string, now time.Time) (Session, bool, error) {
+	row := s.DB.QueryRow(
+		`SELECT token_hash, admin_name, expires_at FROM sessions WHERE token_hash = ? AND expires_at > ?`,
+		tokenHash, now.Unix(),
+	)
+	var sess Session
+	var exp int64
+	if err := row.Scan(&sess.TokenHash, &sess.Admin, &exp); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Session{}, false, nil
+		}
+		return Session{}, false, err
+	}
+	sess.ExpiresAt = time.Unix(exp, 0)
+	return sess, true, nil
+}
+
+func (s *Store) DeleteSession(tokenHash string) error {
+	_, err := s.DB.Exec(`DELETE FROM sessions WHERE token_hash = ?`, tokenHash)
+	return err
+}
+
+func (s *Store) DeleteExpiredSessions(now time.Time) error {
+	_, err := s.DB.Exec(`DELETE FROM sessions WHERE expires_at <= ?`, now.Unix())
+	return err
+}
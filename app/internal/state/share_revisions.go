@@ -0,0 +1,78 @@
+package state
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+const shareRevisionCols = `id, share, snippet, index_block, at, actor, action`
+
+func scanShareRevision(row interface{ Scan(...any) error }) (ShareRevision, error) {
+	var r ShareRevision
+	var snippet, block sql.NullString
+	var at string
+	if err := row.Scan(&r.ID, &r.Share, &snippet, &block, &at, &r.Actor, &r.Action); err != nil {
+		return ShareRevision{}, err
+	}
+	if snippet.Valid {
+		r.Snippet = &snippet.String
+	}
+	if block.Valid {
+		r.IndexBlock = &block.String
+	}
+	r.At, _ = time.Parse(auditTimeFormat, at)
+	return r, nil
+}
+
+// AddShareRevision stores r and returns its id.
+func (s *Store) AddShareRevision(r ShareRevision) (int64, error) {
+	if r.At.IsZero() {
+		r.At = time.Now()
+	}
+	res, err := s.DB.Exec(
+		`INSERT INTO share_revisions (share, snippet, index_block, at, actor, action) VALUES (?, ?, ?, ?, ?, ?)`,
+		r.Share, r.Snippet, r.IndexBlock, r.At.UTC().Format(auditTimeFormat), r.Actor, r.Action,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// ListShareRevisions returns the revisions of share, newest first.
+func (s *Store) ListShareRevisions(share string) ([]ShareRevision, error) {
+	rows, err := s.DB.Query(`SELECT `+shareRevisionCols+` FROM share_revisions WHERE share = ? ORDER BY id DESC`, share)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []ShareRevision
+	for rows.Next() {
+		r, err := scanShareRevision(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, r)
+	}
+	return out, rows.Err()
+}
+
+// LatestShareRevision returns the newest revision of share.
+func (s *Store) LatestShareRevision(share string) (ShareRevision, bool, error) {
+	r, err := scanShareRevision(s.DB.QueryRow(`SELECT `+shareRevisionCols+` FROM share_revisions WHERE share = ? ORDER BY id DESC LIMIT 1`, share))
+	if errors.Is(err, sql.ErrNoRows) {
+		return ShareRevision{}, false, nil
+	}
+	return r, err == nil, err
+}
+
+// GetShareRevision returns the revision with the given id.
+func (s *Store) GetShareRevision(id int64) (ShareRevision, bool, error) {
+	r, err := scanShareRevision(s.DB.QueryRow(`SELECT `+shareRevisionCols+` FROM share_revisions WHERE id = ?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return ShareRevision{}, false, nil
+	}
+	return r, err == nil, err
+}
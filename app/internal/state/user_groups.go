@@ -72,3 +72,12 @@ func (s *Store) CountGroupAssignments(group string) (int, error) {
 	}
 	return n, nil
 }
+
+func (s *Store) AddMembership(user, group string) error {
+	_, err := s.DB.Exec(
+		`INSERT INTO user_groups (user_name, group_name) VALUES (?, ?)
+		 ON CONFLICT DO NOTHING`,
+		user, group,
+	)
+	return err
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/florianibach/samba-admin-ui/internal/samba"
+)
+
+const (
+	logTailBytes    = 512 << 10 // read from the end of a single file
+	logTailAllBytes = 128 << 10 // ... and of each file when merging
+	logMaxFiles     = 20        // newest files merged into "all files"
+	logLimit        = 500       // records shown on the page
+)
+
+type logLevel struct {
+	Value int
+	Label string
+}
+
+// logLevels are the choices of the level filter.
+var logLevels = []logLevel{
+	{0, "0 – errors"},
+	{1, "1 – warnings"},
+	{2, "2 – notices"},
+	{3, "3 – info"},
+	{5, "5 – debug"},
+	{10, "10 – everything"},
+}
+
+// logEntry is a log record as sent to the browser and the API.
+type logEntry struct {
+	samba.LogRecord
+	Client   string `json:"client"`
+	Severity string `json:"severity"`
+	When     string `json:"when"`
+}
+
+func logEntryOf(rec samba.LogRecord) logEntry {
+	e := logEntry{
+		LogRecord: rec,
+		Client:    strings.TrimPrefix(rec.File, "log."),
+		Severity:  rec.Severity(),
+	}
+	if !rec.Time.IsZero() {
+		e.When = rec.Time.Format("2006-01-02 15:04:05")
+	}
+	return e
+}
+
+// logBadges are the Bootstrap badge classes per severity; logs.html has
+// the same table for streamed entries.
+var logBadges = map[string]string{
+	"error":   "bg-danger",
+	"warning": "bg-warning text-dark",
+	"notice":  "bg-primary",
+	"info":    "bg-info text-dark",
+}
+
+func (e logEntry) Badge() string {
+	if c, ok := logBadges[e.Severity]; ok {
+		return c
+	}
+	return "bg-secondary"
+}
+
+// LogsView is the /logs page.
+type LogsView struct {
+	Dir     string
+	Files   []samba.LogFile
+	File    string // "" merges all current log files
+	Level   int
+	Query   string
+	Levels  []logLevel
+	Entries []logEntry
+	Limited bool // older matching records were left out
+	Error   string
+}
+
+// logsQuery reads the file, level and keyword filter from the query string.
+func logsQuery(r *http.Request) (string, samba.LogFilter) {
+	q := r.URL.Query()
+	f := samba.LogFilter{MaxLevel: 3, Keyword: strings.TrimSpace(q.Get("q"))}
+	if v, err := strconv.Atoi(q.Get("level")); err == nil && v >= 0 {
+		f.MaxLevel = v
+	}
+	return q.Get("file"), f
+}
+
+// logFileErr turns errors from reading log file name into opErrors.
+func logFileErr(name string, err error) error {
+	switch {
+	case errors.Is(err, samba.ErrBadLogName):
+		return opErr(http.StatusBadRequest, "%q is not a Samba log file", name)
+	case errors.Is(err, fs.ErrNotExist):
+		return opErr(http.StatusNotFound, "log file %s not found", name)
+	}
+	return err
+}
+
+// liveLogs lists the files "all files" covers: the newest current logs,
+// without rotated .old files.
+func liveLogs(files []samba.LogFile) []samba.LogFile {
+	var res []samba.LogFile
+	for _, f := range files {
+		if !strings.HasSuffix(f.Name, ".old") && len(res) < logMaxFiles {
+			res = append(res, f)
+		}
+	}
+	return res
+}
+
+// logEntries returns the last limit records of file (or of all current
+// files, merged by time) that match f, and whether older ones were cut.
+func (a *App) logEntries(file string, f samba.LogFilter, limit int) ([]logEntry, bool, error) {
+	var recs []samba.LogRecord
+	if file != "" {
+		all, _, err := samba.ReadLogTail(a.logDir, file, logTailBytes)
+		if err != nil {
+			return nil, false, logFileErr(file, err)
+		}
+		recs = all
+	} else {
+		files, err := samba.ListLogFiles(a.logDir)
+		if err != nil {
+			return nil, false, fmt.Errorf("reading log directory: %w", err)
+		}
+		for _, lf := range liveLogs(files) {
+			all, _, err := samba.ReadLogTail(a.logDir, lf.Name, logTailAllBytes)
+			if err != nil {
+				continue // rotated away meanwhile
+			}
+			recs = append(recs, all...)
+		}
+		slices.SortStableFunc(recs, func(x, y samba.LogRecord) int {
+			return x.Time.Compare(y.Time)
+		})
+	}
+
+	var res []logEntry
+	for _, rec := range recs {
+		if f.Match(rec) {
+			res = append(res, logEntryOf(rec))
+		}
+	}
+	limited := len(res) > limit
+	if limited {
+		res = res[len(res)-limit:]
+	}
+	return res, limited, nil
+}
+
+func (a *App) logsPage(w http.ResponseWriter, r *http.Request) {
+	file, f := logsQuery(r)
+	v := LogsView{Dir: a.logDir, File: file, Level: f.MaxLevel, Query: f.Keyword, Levels: logLevels}
+
+	files, err := samba.ListLogFiles(a.logDir)
+	if err != nil {
+		v.Error = fmt.Sprintf("reading log directory: %s", err)
+		a.renderStatus(w, r, http.StatusInternalServerError, "logs.html", "Logs", v)
+		return
+	}
+	v.Files = files
+
+	v.Entries, v.Limited, err = a.logEntries(file, f, logLimit)
+	if err != nil {
+		v.Error = err.Error()
+		a.renderStatus(w, r, errStatus(err), "logs.html", "Logs", v)
+		return
+	}
+	a.render(w, r, "logs.html", "Logs", v)
+}
+
+// logsStream tails the selected log file (or all current ones) as
+// server-sent events, one "record" event per matching entry. Files are
+// polled once a second; a file that shrank was rotated and is read again
+// from the start.
+func (a *App) logsStream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	file, f := logsQuery(r)
+	if file != "" && !samba.ValidLogName(file) {
+		http.Error(w, fmt.Sprintf("%q is not a Samba log file", file), http.StatusBadRequest)
+		return
+	}
+	watched := func(files []samba.LogFile) []samba.LogFile {
+		if file == "" {
+			return liveLogs(files)
+		}
+		for _, lf := range files {
+			if lf.Name == file {
+				return []samba.LogFile{lf}
+			}
+		}
+		return nil
+	}
+
+	// start at the current end of every file
+	offsets := map[string]int64{}
+	parsers := map[string]*samba.LogParser{}
+	if files, err := samba.ListLogFiles(a.logDir); err == nil {
+		for _, lf := range watched(files) {
+			offsets[lf.Name] = lf.Size
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, ": tailing\n\n")
+	flusher.Flush()
+
+	send := func(rec *samba.LogRecord) bool {
+		if rec == nil || !f.Match(*rec) {
+			return false
+		}
+		data, _ := json.Marshal(logEntryOf(*rec))
+		fmt.Fprintf(w, "event: record\ndata: %s\n\n", data)
+		return true
+	}
+
+	tick := time.NewTicker(time.Second)
+	defer tick.Stop()
+	idle := 0
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-tick.C:
+		}
+
+		files, err := samba.ListLogFiles(a.logDir)
+		if err != nil {
+			data, _ := json.Marshal(err.Error())
+			fmt.Fprintf(w, "event: failure\ndata: %s\n\n", data)
+			flusher.Flush()
+			continue
+		}
+		wrote := false
+		for _, lf := range watched(files) {
+			p := parsers[lf.Name]
+			if p == nil {
+				p = &samba.LogParser{File: lf.Name}
+				parsers[lf.Name] = p
+			}
+			// files that appear later (new clients) are read from the start
+			var lines []string
+			if off := offsets[lf.Name]; lf.Size != off {
+				lines, offsets[lf.Name], err = samba.ReadLogFrom(a.logDir, lf.Name, off)
+				if err != nil {
+					continue
+				}
+			}
+			if len(lines) == 0 {
+				// an entry is complete once its file went quiet
+				if send(p.Flush()) {
+					wrote = true
+				}
+				continue
+			}
+			for _, line := range lines {
+				if send(p.Line(line)) {
+					wrote = true
+				}
+			}
+		}
+
+		if wrote {
+			idle = 0
+		} else if idle++; idle >= 15 {
+			idle = 0
+			fmt.Fprint(w, ": ping\n\n")
+			wrote = true
+		}
+		if wrote {
+			flusher.Flush()
+		}
+	}
+}
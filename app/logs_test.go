@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/florianibach/samba-admin-ui/internal/samba"
+)
+
+func TestLogEntries(t *testing.T) {
+	a := &App{logDir: t.TempDir()}
+	write := func(name, data string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(a.logDir, name), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("log.pc1", "[2024/01/01 12:00:00,  0] a.c:1(f)\n  pc1 first\n[2024/01/01 12:00:10,  3] a.c:2(f)\n  pc1 second\n")
+	write("log.pc2", "[2024/01/01 12:00:05,  1] b.c:1(g)\n  pc2 only\n")
+	write("log.pc2.old", "[2023/12/31 23:00:00,  0] b.c:1(g)\n  rotated\n")
+
+	all, limited, err := a.logEntries("", samba.LogFilter{MaxLevel: 10}, 10)
+	if err != nil || limited {
+		t.Fatalf("logEntries = %v, %v", limited, err)
+	}
+	var got []string
+	for _, e := range all {
+		got = append(got, e.Client+": "+e.Message)
+	}
+	want := []string{"pc1: pc1 first", "pc2: pc2 only", "pc1: pc1 second"}
+	if len(got) != len(want) {
+		t.Fatalf("entries = %q", got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("entry %d = %q, want %q", i, got[i], want[i])
+		}
+	}
+
+	last, limited, err := a.logEntries("", samba.LogFilter{MaxLevel: 1}, 1)
+	if err != nil || !limited || len(last) != 1 || last[0].Severity != "warning" || last[0].When != "2024-01-01 12:00:05" {
+		t.Errorf("limited entries = %+v, %v, %v", last, limited, err)
+	}
+
+	old, _, err := a.logEntries("log.pc2.old", samba.LogFilter{MaxLevel: 10}, 10)
+	if err != nil || len(old) != 1 || old[0].Message != "rotated" {
+		t.Errorf("old entries = %+v, %v", old, err)
+	}
+
+	if _, _, err := a.logEntries("log.missing", samba.LogFilter{}, 10); errStatus(err) != http.StatusNotFound {
+		t.Errorf("missing file: %v", err)
+	}
+	if _, _, err := a.logEntries("../app.db", samba.LogFilter{}, 10); errStatus(err) != http.StatusBadRequest {
+		t.Errorf("bad name: %v", err)
+	}
+}
@@ -2,16 +2,18 @@ package main
 
 import (
 	"embed"
+	"errors"
+	"fmt"
 	"html/template"
 	"log"
 	"net/http"
 	"os"
 	"path/filepath"
-	"sort"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/florianibach/samba-admin-ui/internal/policy"
 	"github.com/florianibach/samba-admin-ui/internal/reconcile"
 	"github.com/florianibach/samba-admin-ui/internal/samba"
 	"github.com/florianibach/samba-admin-ui/internal/state"
@@ -25,6 +27,7 @@ var staticFS embed.FS
 
 type View struct {
 	Title string
+	User  string
 	Data  any
 }
 
@@ -32,9 +35,17 @@ type App struct {
 	base      *template.Template
 	smbConf   string
 	shareRoot string
+	logDir    string
 	store     *state.Store
 
+	sessionTTL time.Duration
+	pwPolicy   policy.Password
+
 	lastReload time.Time
+
+	permJobs      permJobs
+	backupUploads backupUploads
+	snapshots     snapshotter
 }
 
 func main() {
@@ -42,60 +53,157 @@ func main() {
 	smbConf := getenv("SMB_CONF", "/etc/samba/smb.conf")
 	shareRoot := getenv("SHARE_ROOT", "/shares")
 
-	base := template.Must(template.New("").Funcs(template.FuncMap{
-		"now": time.Now,
-	}).ParseFS(templatesFS, "templates/layout.html"))
+	base := template.Must(parseBase())
 
 	app := &App{
 		base:      base,
 		smbConf:   smbConf,
 		shareRoot: shareRoot,
+		logDir:    getenv("SAMBA_LOG_DIR", "/var/log/samba"),
+
+		sessionTTL: 12 * time.Hour,
+		pwPolicy:   policy.Default,
 
 		lastReload: time.Now(),
 	}
 
+	if v := getenv("SESSION_TTL", ""); v != "" {
+		ttl, err := time.ParseDuration(v)
+		if err != nil {
+			log.Fatalf("invalid SESSION_TTL: %v", err)
+		}
+		app.sessionTTL = ttl
+	}
+
+	if err := loadPasswordPolicy(&app.pwPolicy); err != nil {
+		log.Fatalf("invalid password policy: %v", err)
+	}
+
+	if err := loadSnapshotConfig(&app.snapshots); err != nil {
+		log.Fatalf("invalid backup settings: %v", err)
+	}
+
 	dbPath := getenv("APP_DB", "/data/app.db")
 	store, err := state.Open(dbPath)
+	if errors.Is(err, state.ErrSchemaTooNew) {
+		log.Fatalf("%v; run a newer samba-admin-ui or restore the .bak copy taken before the upgrade", err)
+	}
 	if err != nil {
 		log.Fatal(err)
 	}
+	if m := store.Migration; m.To != m.From {
+		log.Printf("database schema migrated from version %d to %d", m.From, m.To)
+		if m.Backup != "" {
+			log.Printf("database copy from before the migration: %s", m.Backup)
+		}
+	}
 	app.store = store
 
-	if getenv("RECONCILE_ON_START", "true") == "true" {
-		if _, err := reconcile.Apply(store); err != nil {
+	if err := app.bootstrapAdmin(getenv("ADMIN_USER", ""), os.Getenv("ADMIN_PASSWORD")); err != nil {
+		log.Fatalf("bootstrap admin: %v", err)
+	}
+
+	switch mode := getenv("RECONCILE_ON_START", "true"); mode {
+	case "true":
+		if err := app.reconcile(systemActor); err != nil {
 			log.Printf("reconcile failed: %v", err)
 		}
+	case "plan":
+		// Only report; the changes can be reviewed and applied on /pending.
+		res, err := reconcile.Plan(app.store)
+		if err != nil {
+			log.Printf("reconcile plan failed: %v", err)
+		} else if len(res.Actions) == 0 {
+			log.Printf("reconcile plan: no pending changes")
+		} else {
+			for _, action := range res.Actions {
+				log.Printf("reconcile plan: %s", action)
+			}
+		}
 	}
 
-	mux := http.NewServeMux()
-	mux.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.FS(staticFS))))
-
-	mux.HandleFunc("/", app.dashboard)
-	mux.HandleFunc("/shares", app.shares)
-	mux.HandleFunc("/shares/", app.shareDetail) // /shares/{name}
-	mux.HandleFunc("/users", app.users)
-	mux.HandleFunc("/groups", app.groups)
-	mux.HandleFunc("/reload", app.reload)
-
-	mux.HandleFunc("/users/create", app.userCreate)
-	mux.HandleFunc("/users/password", app.userPassword)
-	mux.HandleFunc("/users/enable", app.userEnable)
-	mux.HandleFunc("/users/disable", app.userDisable)
-	mux.HandleFunc("/users/delete", app.userDelete)
-
-	mux.HandleFunc("/groups/create", app.groupsCreate)
-	mux.HandleFunc("/groups/delete", app.groupsDelete)
+	if app.snapshots.dir != "" && app.snapshots.schedule != nil {
+		go app.runSnapshotSchedule()
+	}
 
-	mux.HandleFunc("/users/groups", app.userGroups)          // GET (Form)
-	mux.HandleFunc("/users/groups/save", app.userGroupsSave) // POST
+	log.Printf("samba-admin-ui listening on %s", addr)
+	log.Fatal(http.ListenAndServe(addr, app.routes()))
+}
 
-	mux.HandleFunc("/shares/create", app.shareCreate)
-	mux.HandleFunc("/shares/disable", app.shareDisable)
-	mux.HandleFunc("/shares/enable", app.shareEnable)
-	mux.HandleFunc("/shares/delete", app.shareDelete)
+// routes returns the handler for all pages and the API, behind the
+// middleware every request goes through.
+func (a *App) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.FS(staticFS))))
 
-	log.Printf("samba-admin-ui listening on %s", addr)
-	log.Fatal(http.ListenAndServe(addr, withHeaders(mux)))
+	mux.HandleFunc("/login", a.login)
+	mux.HandleFunc("/logout", a.logout)
+	mux.HandleFunc("/setup", a.setup)
+
+	mux.HandleFunc("/", a.dashboard)
+	mux.HandleFunc("/shares", a.shares)
+	mux.HandleFunc("/shares/", a.shareDetail) // /shares/{name}
+	mux.HandleFunc("/users", a.users)
+	mux.HandleFunc("/groups", a.groups)
+	mux.HandleFunc("/reload", a.reload)
+
+	mux.HandleFunc("/users/create", a.userCreate)
+	mux.HandleFunc("/users/password", a.userPassword)
+	mux.HandleFunc("/users/enable", a.userEnable)
+	mux.HandleFunc("/users/disable", a.userDisable)
+	mux.HandleFunc("/users/delete", a.userDelete)
+
+	mux.HandleFunc("/groups/create", a.groupsCreate)
+	mux.HandleFunc("/groups/delete", a.groupsDelete)
+
+	mux.HandleFunc("/users/groups", a.userGroups)          // GET (Form)
+	mux.HandleFunc("/users/groups/save", a.userGroupsSave) // POST
+
+	mux.HandleFunc("/shares/create", a.shareCreate)
+	mux.HandleFunc("/shares/edit", a.shareEdit)
+	mux.HandleFunc("/shares/disable", a.shareDisable)
+	mux.HandleFunc("/shares/enable", a.shareEnable)
+	mux.HandleFunc("/shares/delete", a.shareDelete)
+	mux.HandleFunc("/shares/import", a.shareImport)
+	mux.HandleFunc("/shares/history", a.shareHistoryPage)
+	mux.HandleFunc("/shares/rollback", a.shareRollback)
+	mux.HandleFunc("/shares/permissions", a.sharePermissions)
+	mux.HandleFunc("/shares/acl/set", a.shareACLSet)
+	mux.HandleFunc("/shares/acl/remove", a.shareACLRemove)
+
+	mux.HandleFunc("/files", a.filesPage)
+	mux.HandleFunc("/connections", a.connectionsPage)
+	mux.HandleFunc("/connections/close", a.connectionClose)
+	mux.HandleFunc("/connections/kill", a.connectionKill)
+	mux.HandleFunc("/files/mkdir", a.filesMkdir)
+	mux.HandleFunc("/logs", a.logsPage)
+	mux.HandleFunc("/logs/stream", a.logsStream)
+
+	mux.HandleFunc("/drift/adopt", a.driftAction(true))
+	mux.HandleFunc("/drift/fix", a.driftAction(false))
+
+	mux.HandleFunc("/pending", a.pending)
+	mux.HandleFunc("/pending/apply", a.pendingApply)
+
+	mux.HandleFunc("/backup", a.backupPage)
+	mux.HandleFunc("/backup/download", a.backupDownload)
+	mux.HandleFunc("/backup/upload", a.backupUpload)
+	mux.HandleFunc("/backup/restore", a.backupRestore)
+	mux.HandleFunc("/backup/snapshots", a.snapshotsPage)
+	mux.HandleFunc("/backup/snapshots/create", a.snapshotCreate)
+	mux.HandleFunc("/backup/snapshots/download", a.snapshotDownload)
+	mux.HandleFunc("/backup/snapshots/restore", a.snapshotRestore)
+
+	mux.HandleFunc("/audit", a.auditLog)
+	mux.HandleFunc("/audit/export", a.auditExport)
+
+	mux.HandleFunc("/settings", a.settings)
+	mux.HandleFunc("/settings/tokens/create", a.tokenCreate)
+	mux.HandleFunc("/settings/tokens/revoke", a.tokenRevoke)
+
+	mux.Handle("/api/", a.apiHandler())
+
+	return withHeaders(a.withAuth(a.withCSRF(a.withSnapshots(mux))))
 }
 
 func withHeaders(next http.Handler) http.Handler {
@@ -115,6 +223,9 @@ func (a *App) dashboard(w http.ResponseWriter, r *http.Request) {
 		SmbdUp     bool
 		SmbdErr    string
 		LastReload *time.Time
+		Schema     int
+		SchemaErr  string
+		Drift      driftView
 	}
 
 	ok, errStr := samba.TestparmOK(a.smbConf)
@@ -126,7 +237,13 @@ func (a *App) dashboard(w http.ResponseWriter, r *http.Request) {
 		lr = &t
 	}
 
-	a.render(w, "dashboard.html", "Dashboard", vm{
+	schema, err := a.store.Version()
+	schemaErr := ""
+	if err != nil {
+		schemaErr = err.Error()
+	}
+
+	a.render(w, r, "dashboard.html", "Dashboard", vm{
 		Now:        time.Now(),
 		SmbConf:    a.smbConf,
 		ConfigOK:   ok,
@@ -134,90 +251,27 @@ func (a *App) dashboard(w http.ResponseWriter, r *http.Request) {
 		SmbdUp:     smbdUp,
 		SmbdErr:    smbdErr,
 		LastReload: lr,
+		Schema:     schema,
+		SchemaErr:  schemaErr,
+		Drift:      a.driftView("/", allDrift),
 	})
 }
 
 func (a *App) shares(w http.ResponseWriter, r *http.Request) {
-	sections, raw, err := samba.ReadEffectiveConfig(a.smbConf)
-	indexPath := getenv("UI_SHARES_INDEX", "/etc/samba/shares.d/ui/shares.conf")
-	managed, err := samba.ReadManagedSharesIndex(indexPath)
-	if err != nil {
-		// log + treat as empty so UI still works
-		managed = map[string]samba.ManagedShareState{}
-	}
-
-	type shareRow struct {
-		Name      string
-		Path      string
-		ReadOnly  string
-		ManagedBy string
-		PathOK    bool
-		Perms     string
-
-		Managed  bool
-		Disabled bool
-	}
 	type vm struct {
 		SmbConf string
 		Error   string
 		Raw     string
-		Shares  []shareRow
+		Shares  []ShareInfo
 	}
 
+	rows, raw, err := a.listShares()
 	if err != nil {
-		a.render(w, "shares.html", "Shares", vm{SmbConf: a.smbConf, Error: err.Error()})
+		a.render(w, r, "shares.html", "Shares", vm{SmbConf: a.smbConf, Error: err.Error()})
 		return
 	}
 
-	var rows []shareRow
-	for name, kv := range sections {
-		if strings.EqualFold(name, "global") {
-			continue
-		}
-		path := kv["path"]
-		if path == "" {
-			// Sometimes shares rely on defaults; still list them.
-			path = "(not set)"
-		}
-		ro := kv["read only"]
-		if ro == "" {
-			ro = kv["readonly"]
-		}
-		if ro == "" {
-			ro = "(unknown)"
-		}
-
-		pathOK, perms := samba.PathPerms(path)
-		st, ok := managed[name]
-		isManaged := ok
-		isDisabled := ok && st.Disabled
-
-		rows = append(rows, shareRow{
-			Name:     name,
-			Path:     path,
-			ReadOnly: ro,
-			PathOK:   pathOK,
-			Perms:    perms,
-			Managed:  isManaged,
-			Disabled: isDisabled,
-		})
-
-	}
-
-	sort.Slice(rows, func(i, j int) bool {
-		// UI-managed shares first
-		if rows[i].Managed != rows[j].Managed {
-			return rows[i].Managed && !rows[j].Managed
-		}
-		// Within managed: enabled first, then disabled
-		if rows[i].Disabled != rows[j].Disabled {
-			return !rows[i].Disabled && rows[j].Disabled
-		}
-		// Finally: alphabetical by name (case-insensitive)
-		return strings.ToLower(rows[i].Name) < strings.ToLower(rows[j].Name)
-	})
-
-	a.render(w, "shares.html", "Shares", vm{
+	a.render(w, r, "shares.html", "Shares", vm{
 		SmbConf: a.smbConf,
 		Raw:     raw,
 		Shares:  rows,
@@ -232,25 +286,73 @@ func (a *App) shareDetail(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	forms := shareForms{}
+	if u := strings.TrimSpace(r.URL.Query().Get("access")); u != "" {
+		forms.Access = &ShareAccessForm{User: u}
+	}
+	a.renderShareDetail(w, r, name, forms)
+}
+
+// ShareEditForm carries the edit form of a UI-managed share. Original is the
+// share name before the edit, so renames can find the old snippet.
+type ShareEditForm struct {
+	Original   string
+	Name       string
+	Path       string
+	ReadOnly   bool
+	Browseable bool
+	ValidUsers string
+	Error      string
+}
+
+// shareForms carries submitted forms of the share detail page back into it,
+// e.g. to show a validation error. Nil forms are filled in fresh.
+type shareForms struct {
+	Edit   *ShareEditForm
+	Perm   *SharePermForm
+	ACL    *ShareACLForm
+	Access *ShareAccessForm
+}
+
+// renderShareDetail renders /shares/{name}. If no edit form is given and the
+// share is UI-managed, it is pre-filled from its snippet; the permission form
+// is pre-filled from valid users.
+func (a *App) renderShareDetail(w http.ResponseWriter, r *http.Request, name string, forms shareForms) {
+	edit := forms.Edit
+	sharesDir, indexPath := shareDirs()
+
 	sections, _, err := samba.ReadEffectiveConfig(a.smbConf)
 	type vm struct {
 		Name     string
 		SmbConf  string
 		Error    string
 		KV       map[string]string
+		Origins  map[string]string // parameter -> "file:line"
+		Warnings []string
 		PathOK   bool
 		Perms    string
 		Resolved string
+
+		Managed  bool
+		Disabled bool
+		Edit     *ShareEditForm
+		// Leftovers are definitions of a managed share outside the index,
+		// e.g. the original section of an imported share.
+		Leftovers []samba.ConfSpan
+
+		Perm   *SharePermForm
+		ACL    *ShareACLForm
+		Access *ShareAccessForm
 	}
 
 	if err != nil {
-		a.render(w, "share_detail.html", "Share "+name, vm{Name: name, SmbConf: a.smbConf, Error: err.Error()})
+		a.render(w, r, "share_detail.html", "Share "+name, vm{Name: name, SmbConf: a.smbConf, Error: err.Error()})
 		return
 	}
 
 	kv, ok := sections[name]
 	if !ok {
-		a.render(w, "share_detail.html", "Share "+name, vm{Name: name, SmbConf: a.smbConf, Error: "share not found in effective config"})
+		a.render(w, r, "share_detail.html", "Share "+name, vm{Name: name, SmbConf: a.smbConf, Error: "share not found in effective config"})
 		return
 	}
 
@@ -264,39 +366,143 @@ func (a *App) shareDetail(w http.ResponseWriter, r *http.Request) {
 		resolved = path
 	}
 
-	a.render(w, "share_detail.html", "Share "+name, vm{
+	// testparm only tells us the values; the parser tells us where they are set
+	origins := map[string]string{}
+	var warnings []string
+	conf, err := samba.LoadSmbConf(a.smbConf)
+	if err != nil {
+		warnings = append(warnings, "cannot trace parameter sources: "+err.Error())
+	} else {
+		warnings = conf.Warnings
+		if sec := conf.Section(name); sec != nil {
+			for k := range kv {
+				if p, ok := sec.Get(k); ok {
+					origins[k] = p.Origin()
+				}
+			}
+		}
+	}
+
+	managed, err := samba.ReadManagedSharesIndex(indexPath)
+	if err != nil {
+		managed = map[string]samba.ManagedShareState{}
+	}
+	st, isManaged := managed[name]
+
+	var leftovers []samba.ConfSpan
+	if isManaged && conf != nil {
+		for _, sp := range conf.SectionSpans(name) {
+			if sp.File != filepath.Clean(indexPath) {
+				leftovers = append(leftovers, sp)
+			}
+		}
+	}
+
+	if isManaged && edit == nil {
+		opt, err := samba.ReadShareSnippet(sharesDir, name)
+		edit = &ShareEditForm{
+			Original:   name,
+			Name:       name,
+			Path:       opt.Path,
+			ReadOnly:   opt.ReadOnly,
+			Browseable: opt.Browseable,
+			ValidUsers: opt.ValidUsers,
+		}
+		if err != nil {
+			edit.Error = "failed to read share snippet: " + err.Error()
+		}
+	}
+
+	a.render(w, r, "share_detail.html", "Share "+name, vm{
 		Name:     name,
 		SmbConf:  a.smbConf,
 		KV:       kv,
+		Origins:  origins,
+		Warnings: warnings,
 		PathOK:   pathOK,
 		Perms:    perms,
 		Resolved: resolved,
+		Managed:  isManaged,
+		Disabled: st.Disabled,
+		Edit:     edit,
+
+		Leftovers: leftovers,
+		Perm:      a.sharePermForm(path, kv["valid users"], forms.Perm),
+		ACL:       a.shareACLForm(path, forms.ACL),
+		Access:    a.shareAccessForm(name, kv, forms.Access),
 	})
 }
 
-func (a *App) users(w http.ResponseWriter, r *http.Request) {
-	type userRow struct {
-		Name        string
-		LinuxExists bool
+func (a *App) shareEdit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/shares", http.StatusSeeOther)
+		return
 	}
-	type vm struct {
-		Error      string
-		Users      []userRow
-		LinuxUsers []samba.LinuxUserInfo
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
 	}
 
-	users, err := samba.ListSambaUsers()
+	form := ShareEditForm{
+		Original:   strings.TrimSpace(r.FormValue("original")),
+		Name:       strings.TrimSpace(r.FormValue("name")),
+		Path:       strings.TrimSpace(r.FormValue("path")),
+		ReadOnly:   r.FormValue("readOnly") == "on",
+		Browseable: r.FormValue("browseable") == "on",
+		ValidUsers: strings.TrimSpace(r.FormValue("validUsers")),
+	}
+	if form.Original == "" {
+		http.Error(w, "original name required", 400)
+		return
+	}
+
+	err := a.updateShare(actorOf(r), form.Original, samba.CreateShareOptions{
+		Name:       form.Name,
+		Path:       form.Path,
+		ReadOnly:   form.ReadOnly,
+		Browseable: form.Browseable,
+		ValidUsers: form.ValidUsers,
+	})
+	a.audit(actorOf(r), "share.update", form.Original, err)
 	if err != nil {
-		a.render(w, "users.html", "Users", vm{Error: err.Error()})
+		form.Error = err.Error()
+		a.renderShareDetail(w, r, form.Original, shareForms{Edit: &form})
 		return
 	}
 
-	rows := make([]userRow, 0, len(users))
-	for _, u := range users {
-		rows = append(rows, userRow{
-			Name:        u,
-			LinuxExists: samba.LinuxUserExists(u),
-		})
+	http.Redirect(w, r, "/shares/"+form.Name, http.StatusSeeOther)
+}
+
+type UserCreateForm struct {
+	Name  string
+	UID   string
+	GID   string
+	Error string
+}
+
+func (a *App) users(w http.ResponseWriter, r *http.Request) {
+	a.renderUsers(w, r, UserCreateForm{})
+}
+
+// renderUsers renders the users page; form is echoed back into the create
+// form so validation errors can be shown next to it.
+func (a *App) renderUsers(w http.ResponseWriter, r *http.Request, form UserCreateForm) {
+	type vm struct {
+		Error          string
+		Form           UserCreateForm
+		PasswordPolicy string
+		Users          []UserInfo
+		LinuxUsers     []samba.LinuxUserInfo
+		Drift          driftView
+	}
+
+	data := vm{Form: form, PasswordPolicy: a.pwPolicy.Describe()}
+
+	rows, err := a.listUsers()
+	if err != nil {
+		data.Error = err.Error()
+		a.render(w, r, "users.html", "Users", data)
+		return
 	}
 
 	linuxUsers, err := samba.ListLinuxUsersHuman()
@@ -304,10 +510,10 @@ func (a *App) users(w http.ResponseWriter, r *http.Request) {
 		linuxUsers = []samba.LinuxUserInfo{}
 	}
 
-	a.render(w, "users.html", "Users", vm{
-		Users:      rows,
-		LinuxUsers: linuxUsers,
-	})
+	data.Users = rows
+	data.LinuxUsers = linuxUsers
+	data.Drift = a.driftView("/users", (*reconcile.DriftReport).UserItems)
+	a.render(w, r, "users.html", "Users", data)
 }
 
 func (a *App) reload(w http.ResponseWriter, r *http.Request) {
@@ -315,15 +521,28 @@ func (a *App) reload(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 		return
 	}
-	if err := samba.ReloadConfig(); err != nil {
-		http.Error(w, "reload failed: "+err.Error(), http.StatusInternalServerError)
+	err := a.reloadSamba()
+	a.audit(actorOf(r), "config.reload", "", err)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	a.lastReload = time.Now()
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
 
-func (a *App) render(w http.ResponseWriter, pageFile string, title string, data any) {
+// parseBase parses the layout and the partials every page can use.
+func parseBase() (*template.Template, error) {
+	return template.New("").Funcs(template.FuncMap{
+		"now":       time.Now,
+		"csrfField": func() template.HTML { return "" },
+	}).ParseFS(templatesFS, "templates/layout.html", "templates/drift_items.html")
+}
+
+func (a *App) render(w http.ResponseWriter, r *http.Request, pageFile string, title string, data any) {
+	a.renderStatus(w, r, http.StatusOK, pageFile, title, data)
+}
+
+func (a *App) renderStatus(w http.ResponseWriter, r *http.Request, status int, pageFile string, title string, data any) {
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 
 	// Clone base layout and parse exactly one page file which defines {{ define "content" }}
@@ -332,19 +551,52 @@ func (a *App) render(w http.ResponseWriter, pageFile string, title string, data
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	csrf := csrfTokenFor(r)
+	tpl.Funcs(template.FuncMap{
+		"csrfField": func() template.HTML {
+			return template.HTML(`<input type="hidden" name="csrf_token" value="` + template.HTMLEscapeString(csrf) + `">`)
+		},
+	})
 	if _, err := tpl.ParseFS(templatesFS, "templates/"+pageFile); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	w.WriteHeader(status)
 	if err := tpl.ExecuteTemplate(w, "layout.html", View{
 		Title: title,
+		User:  currentUser(r),
 		Data:  data,
 	}); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 	}
 }
 
+// loadPasswordPolicy overrides p with the PASSWORD_* environment settings.
+func loadPasswordPolicy(p *policy.Password) error {
+	if v := getenv("PASSWORD_MIN_LENGTH", ""); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return fmt.Errorf("PASSWORD_MIN_LENGTH: %q", v)
+		}
+		p.MinLength = n
+	}
+	if v := getenv("PASSWORD_MIN_CLASSES", ""); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || n > 4 {
+			return fmt.Errorf("PASSWORD_MIN_CLASSES must be 0-4: %q", v)
+		}
+		p.MinClasses = n
+	}
+	if v := getenv("PASSWORD_REJECT_USERNAME", ""); v != "" {
+		p.RejectUsername = v == "true"
+	}
+	if v := getenv("PASSWORD_REJECT_COMMON", ""); v != "" {
+		p.RejectCommon = v == "true"
+	}
+	return nil
+}
+
 func getenv(k, def string) string {
 	if v := os.Getenv(k); v != "" {
 		return v
@@ -352,13 +604,6 @@ func getenv(k, def string) string {
 	return def
 }
 
-type CreateUserRequest struct {
-	Name     string
-	Password string
-	UID      string
-	GID      string
-}
-
 func (a *App) userCreate(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Redirect(w, r, "/users", http.StatusSeeOther)
@@ -369,50 +614,36 @@ func (a *App) userCreate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	name := strings.TrimSpace(r.FormValue("name"))
-	if name == "" {
-		http.Error(w, "name required", 400)
-		return
+	form := UserCreateForm{
+		Name: strings.TrimSpace(r.FormValue("name")),
+		UID:  strings.TrimSpace(r.FormValue("uid")),
+		GID:  strings.TrimSpace(r.FormValue("gid")),
 	}
-
 	pass := r.FormValue("password")
 	confirm := r.FormValue("confirm_password")
-	if pass == "" || confirm == "" {
-		http.Error(w, "password required", 400)
-		return
-	}
-	if pass != confirm {
-		http.Error(w, "passwords do not match", 400)
-		return
-	}
 
-	uid, err := parseOptionalInt(r.FormValue("uid"))
-	if err != nil {
-		http.Error(w, "invalid uid", 400)
-		return
+	uid, uidErr := parseOptionalInt(form.UID)
+	gid, gidErr := parseOptionalInt(form.GID)
+	switch {
+	case form.Name == "":
+		form.Error = "name required"
+	case pass != confirm:
+		form.Error = "passwords do not match"
+	case uidErr != nil:
+		form.Error = "invalid uid"
+	case gidErr != nil:
+		form.Error = "invalid gid"
 	}
-	gid, err := parseOptionalInt(r.FormValue("gid"))
-	if err != nil {
-		http.Error(w, "invalid gid", 400)
+	if form.Error != "" {
+		a.renderUsers(w, r, form)
 		return
 	}
 
-	if err := a.store.UpsertUser(state.User{
-		Name: name,
-		UID:  uid,
-		GID:  gid,
-	}); err != nil {
-		http.Error(w, err.Error(), 500)
-		return
-	}
-
-	if _, err := reconcile.Apply(a.store); err != nil {
-		http.Error(w, err.Error(), 500)
-		return
-	}
-
-	if err := samba.CreateSambaUser(name, pass); err != nil {
-		http.Error(w, err.Error(), 500)
+	err := a.createUser(actorOf(r), form.Name, pass, uid, gid)
+	a.audit(actorOf(r), "user.create", form.Name, err)
+	if err != nil {
+		form.Error = err.Error()
+		a.renderUsers(w, r, form)
 		return
 	}
 
@@ -431,21 +662,46 @@ func parseOptionalInt(v string) (*int, error) {
 	return &n, nil
 }
 
+type UserPasswordForm struct {
+	Name           string
+	PasswordPolicy string
+	Error          string
+}
+
+// userPassword shows (GET ?user=) and handles (POST) the change password form.
 func (a *App) userPassword(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
+	form := UserPasswordForm{PasswordPolicy: a.pwPolicy.Describe()}
+
+	switch r.Method {
+	case http.MethodGet:
+		form.Name = strings.TrimSpace(r.URL.Query().Get("user"))
+		if form.Name == "" {
+			http.Redirect(w, r, "/users", http.StatusSeeOther)
+			return
+		}
+		a.render(w, r, "user_password.html", "Change password", form)
+		return
+	case http.MethodPost:
+	default:
 		http.Redirect(w, r, "/users", http.StatusSeeOther)
 		return
 	}
+
 	_ = r.ParseForm()
-	name := strings.TrimSpace(r.FormValue("name"))
+	form.Name = strings.TrimSpace(r.FormValue("name"))
 	pw := r.FormValue("password")
-	if name == "" || pw == "" {
-		http.Error(w, "name and password required", 400)
+
+	if pw != r.FormValue("confirm_password") {
+		form.Error = "passwords do not match"
+		a.render(w, r, "user_password.html", "Change password", form)
 		return
 	}
 
-	if err := samba.SetSambaPassword(name, pw); err != nil {
-		http.Error(w, err.Error(), 500)
+	err := a.setUserPassword(form.Name, pw)
+	a.audit(actorOf(r), "user.password", form.Name, err)
+	if err != nil {
+		form.Error = err.Error()
+		a.render(w, r, "user_password.html", "Change password", form)
 		return
 	}
 	http.Redirect(w, r, "/users", http.StatusSeeOther)
@@ -462,7 +718,9 @@ func (a *App) userEnable(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "name required", 400)
 		return
 	}
-	if err := samba.EnableSambaUser(name); err != nil {
+	err := samba.EnableSambaUser(name)
+	a.audit(actorOf(r), "user.enable", name, err)
+	if err != nil {
 		http.Error(w, err.Error(), 500)
 		return
 	}
@@ -480,7 +738,9 @@ func (a *App) userDisable(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "name required", 400)
 		return
 	}
-	if err := samba.DisableSambaUser(name); err != nil {
+	err := samba.DisableSambaUser(name)
+	a.audit(actorOf(r), "user.disable", name, err)
+	if err != nil {
 		http.Error(w, err.Error(), 500)
 		return
 	}
@@ -498,7 +758,9 @@ func (a *App) userDelete(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "name required", 400)
 		return
 	}
-	if err := samba.DeleteSambaUser(name); err != nil {
+	err := samba.DeleteSambaUser(name)
+	a.audit(actorOf(r), "user.delete", name, err)
+	if err != nil {
 		http.Error(w, err.Error(), 500)
 		return
 	}
@@ -518,13 +780,15 @@ type ShareCreateForm struct {
 }
 
 func (a *App) shareCreate(w http.ResponseWriter, r *http.Request) {
-	sharesDir := getenv("UI_SHARES_DIR", "/etc/samba/shares.d/ui")
-	indexPath := getenv("UI_SHARES_INDEX", "/etc/samba/shares.d/ui/shares.conf")
+	sharesDir, _ := shareDirs()
 
 	if r.Method == http.MethodGet {
-		a.render(w, "share_create.html", "Create Share", ShareCreateForm{
+		// ?path=&name= prefill from the /files browser
+		a.render(w, r, "share_create.html", "Create Share", ShareCreateForm{
 			SmbConf:    a.smbConf,
 			SnippetDir: sharesDir,
+			Name:       r.URL.Query().Get("name"),
+			Path:       r.URL.Query().Get("path"),
 			Browseable: true,
 		})
 		return
@@ -549,39 +813,17 @@ func (a *App) shareCreate(w http.ResponseWriter, r *http.Request) {
 		ValidUsers: strings.TrimSpace(r.FormValue("validUsers")),
 	}
 
-	// 0) smb.conf must include our index file (read-only check)
-	if err := samba.CheckSmbConfIncludesIndex(a.smbConf, indexPath); err != nil {
-		form.Error = err.Error() + " (smb.conf is read-only; please add it manually)"
-		a.render(w, "share_create.html", "Create Share", form)
-		return
-	}
-
-	// 1) Write share file: /etc/samba/shares.d/ui/<name>.conf
-	shareFile := filepath.Join(sharesDir, form.Name+".conf")
-	_, err := samba.CreateShareSnippet(sharesDir, samba.CreateShareOptions{
+	err := a.createShare(actorOf(r), samba.CreateShareOptions{
 		Name:       form.Name,
 		Path:       form.Path,
 		ReadOnly:   form.ReadOnly,
 		Browseable: form.Browseable,
 		ValidUsers: form.ValidUsers,
 	})
+	a.audit(actorOf(r), "share.create", form.Name, err)
 	if err != nil {
 		form.Error = err.Error()
-		a.render(w, "share_create.html", "Create Share", form)
-		return
-	}
-
-	// 2) Ensure shares index references that share file
-	if err := samba.EnsureIndexReferencesShare(indexPath, form.Name, shareFile); err != nil {
-		form.Error = "failed to update shares index: " + err.Error()
-		a.render(w, "share_create.html", "Create Share", form)
-		return
-	}
-
-	// 3) Reload Samba
-	if err := samba.ReloadConfig(); err != nil {
-		form.Error = "reload failed: " + err.Error()
-		a.render(w, "share_create.html", "Create Share", form)
+		a.render(w, r, "share_create.html", "Create Share", form)
 		return
 	}
 
@@ -603,32 +845,11 @@ func (a *App) setShareDisabled(w http.ResponseWriter, r *http.Request, disabled
 	}
 	_ = r.ParseForm()
 	name := strings.TrimSpace(r.FormValue("name"))
-	if name == "" {
-		http.Error(w, "name required", 400)
-		return
-	}
-
-	sharesDir := getenv("UI_SHARES_DIR", "/etc/samba/shares.d/ui")
-	indexPath := getenv("UI_SHARES_INDEX", "/etc/samba/shares.d/ui/shares.conf")
 
-	// require include exists in smb.conf
-	if err := samba.CheckSmbConfIncludesIndex(a.smbConf, indexPath); err != nil {
-		http.Error(w, err.Error(), 400)
-		return
-	}
-
-	shareFile := filepath.Join(sharesDir, name+".conf")
-
-	// Ensure index entry exists (best effort)
-	_ = samba.EnsureIndexReferencesShare(indexPath, name, shareFile)
-
-	if err := samba.SetShareDisabled(indexPath, name, shareFile, disabled); err != nil {
-		http.Error(w, err.Error(), 500)
-		return
-	}
-
-	if err := samba.ReloadConfig(); err != nil {
-		http.Error(w, "reload failed: "+err.Error(), 500)
+	err := a.setShareState(actorOf(r), name, disabled, shareChangeOf(r))
+	a.audit(actorOf(r), shareStateAction(disabled), name, err)
+	if err != nil {
+		a.shareChangeFailed(w, r, "disable", name, err)
 		return
 	}
 	http.Redirect(w, r, "/shares", http.StatusSeeOther)
@@ -641,83 +862,54 @@ func (a *App) shareDelete(w http.ResponseWriter, r *http.Request) {
 	}
 	_ = r.ParseForm()
 	name := strings.TrimSpace(r.FormValue("name"))
-	if name == "" {
-		http.Error(w, "name required", 400)
-		return
-	}
-
-	sharesDir := getenv("UI_SHARES_DIR", "/etc/samba/shares.d/ui")
-	indexPath := getenv("UI_SHARES_INDEX", "/etc/samba/shares.d/ui/shares.conf")
-
-	if err := samba.CheckSmbConfIncludesIndex(a.smbConf, indexPath); err != nil {
-		http.Error(w, err.Error(), 400)
-		return
-	}
-
-	shareFile := filepath.Join(sharesDir, name+".conf")
 
-	// Remove from index (only if managed marker exists)
-	if err := samba.RemoveShareFromIndex(indexPath, name); err != nil {
-		http.Error(w, err.Error(), 500)
+	err := a.deleteShare(actorOf(r), name, shareChangeOf(r))
+	a.audit(actorOf(r), "share.delete", name, err)
+	if err != nil {
+		a.shareChangeFailed(w, r, "delete", name, err)
 		return
 	}
+	http.Redirect(w, r, "/shares", http.StatusSeeOther)
+}
 
-	// Delete share snippet file (ignore if missing)
-	_ = os.Remove(shareFile)
+// shareChangeOf reads the force/close fields of the in-use confirmation.
+func shareChangeOf(r *http.Request) shareChange {
+	return shareChange{Force: r.FormValue("force") == "on", Close: r.FormValue("close") == "on"}
+}
 
-	if err := samba.ReloadConfig(); err != nil {
-		http.Error(w, "reload failed: "+err.Error(), 500)
+// shareChangeFailed shows who is using the share if that stopped the
+// change, with a form to force it; other errors are plain.
+func (a *App) shareChangeFailed(w http.ResponseWriter, r *http.Request, action, name string, err error) {
+	var busy *shareBusyError
+	if !errors.As(err, &busy) {
+		http.Error(w, err.Error(), errStatus(err))
 		return
 	}
-	http.Redirect(w, r, "/shares", http.StatusSeeOther)
+	a.renderStatus(w, r, http.StatusConflict, "share_busy.html", "Share in use", struct {
+		Action  string
+		Name    string
+		Message string
+		Use     *shareUse
+	}{action, name, busy.Error(), busy.Use})
 }
 
 func (a *App) groups(w http.ResponseWriter, r *http.Request) {
-	type groupRow struct {
-		Name string
-
-		ActualGID int
-
-		Managed    bool
-		DesiredGID *int
-	}
-
 	type vm struct {
 		Error  string
-		Groups []groupRow
+		Groups []GroupInfo
+		Drift  driftView
 	}
 
-	linuxGroups, err := samba.ListLinuxGroups()
+	rows, err := a.listGroups()
 	if err != nil {
-		a.render(w, "groups.html", "Groups", vm{Error: err.Error()})
+		a.render(w, r, "groups.html", "Groups", vm{Error: err.Error()})
 		return
 	}
 
-	dbGroups, err := a.store.ListGroups()
-	if err != nil {
-		a.render(w, "groups.html", "Groups", vm{Error: err.Error()})
-		return
-	}
-
-	// map for quick lookup
-	dbByName := map[string]*int{}
-	for _, g := range dbGroups {
-		dbByName[g.Name] = g.GID
-	}
-
-	rows := make([]groupRow, 0, len(linuxGroups))
-	for _, g := range linuxGroups {
-		desired, managed := dbByName[g.Name]
-
-		rows = append(rows, groupRow{
-			Name:       g.Name,
-			ActualGID:  g.GID,
-			Managed:    managed,
-			DesiredGID: desired,
-		})
-	}
-
-	a.render(w, "groups.html", "Groups", vm{Groups: rows})
+	a.render(w, r, "groups.html", "Groups", vm{
+		Groups: rows,
+		Drift:  a.driftView("/groups", (*reconcile.DriftReport).GroupItems),
+	})
 }
 
 func (a *App) groupsCreate(w http.ResponseWriter, r *http.Request) {
@@ -742,16 +934,10 @@ func (a *App) groupsCreate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := a.store.UpsertGroup(state.Group{
-		Name: name,
-		GID:  gid,
-	}); err != nil {
-		http.Error(w, err.Error(), 500)
-		return
-	}
-
-	if _, err := reconcile.Apply(a.store); err != nil {
-		http.Error(w, err.Error(), 500)
+	err = a.createGroup(actorOf(r), name, gid)
+	a.audit(actorOf(r), "group.create", name, err)
+	if err != nil {
+		http.Error(w, err.Error(), errStatus(err))
 		return
 	}
 
@@ -774,54 +960,12 @@ func (a *App) groupsDelete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// 1) Nur managed groups löschen (optional, aber sinnvoll)
-	grp, ok, err := a.store.GetGroup(name)
-	if err != nil {
-		http.Error(w, err.Error(), 500)
-		return
-	}
-	if !ok {
-		// nichts zu tun
-		http.Redirect(w, r, "/groups", http.StatusSeeOther)
-		return
+	found, err := a.deleteGroup(name)
+	if found || err != nil {
+		a.audit(actorOf(r), "group.delete", name, err)
 	}
-
-	// 2) DB-Assignments blocken
-	cnt, err := a.store.CountGroupAssignments(name)
 	if err != nil {
-		http.Error(w, err.Error(), 500)
-		return
-	}
-	if cnt > 0 {
-		http.Error(w, "cannot delete group: users are still assigned in DB", 400)
-		return
-	}
-
-	// 3) Linux-Delete nur versuchen, wenn Gruppe existiert
-	if samba.LinuxGroupExists(name) {
-		// Primärgruppe-Check (wenn wir die GID kennen)
-		if grp.GID != nil {
-			used, err := samba.IsPrimaryGroupGIDUsed(*grp.GID)
-			if err != nil {
-				http.Error(w, err.Error(), 500)
-				return
-			}
-			if used {
-				http.Error(w, "cannot delete group: it is the primary group of at least one Linux user", 400)
-				return
-			}
-		}
-
-		// groupdel
-		if err := samba.DeleteLinuxGroup(name); err != nil {
-			http.Error(w, err.Error(), 400)
-			return
-		}
-	}
-
-	// 4) Danach DB löschen
-	if err := a.store.DeleteGroup(name); err != nil {
-		http.Error(w, err.Error(), 500)
+		http.Error(w, err.Error(), errStatus(err))
 		return
 	}
 
@@ -849,13 +993,13 @@ func (a *App) userGroups(w http.ResponseWriter, r *http.Request) {
 	// groups to show: DB groups (managed)
 	groups, err := a.store.ListGroups()
 	if err != nil {
-		a.render(w, "user_groups.html", "User Groups", vm{Error: err.Error(), User: user})
+		a.render(w, r, "user_groups.html", "User Groups", vm{Error: err.Error(), User: user})
 		return
 	}
 
 	selected, err := a.store.ListUserGroups(user)
 	if err != nil {
-		a.render(w, "user_groups.html", "User Groups", vm{Error: err.Error(), User: user})
+		a.render(w, r, "user_groups.html", "User Groups", vm{Error: err.Error(), User: user})
 		return
 	}
 	selectedSet := map[string]bool{}
@@ -871,7 +1015,7 @@ func (a *App) userGroups(w http.ResponseWriter, r *http.Request) {
 		})
 	}
 
-	a.render(w, "user_groups.html", "User Groups", vm{
+	a.render(w, r, "user_groups.html", "User Groups", vm{
 		User:   user,
 		Groups: rows,
 	})
@@ -896,91 +1040,10 @@ func (a *App) userGroupsSave(w http.ResponseWriter, r *http.Request) {
 	// Selected managed groups from checkboxes
 	selected := r.Form["groups"]
 
-	// --- helpers ---
-	uniqueSorted := func(set map[string]bool) []string {
-		res := make([]string, 0, len(set))
-		for k := range set {
-			res = append(res, k)
-		}
-		sort.Slice(res, func(i, j int) bool {
-			return strings.ToLower(res[i]) < strings.ToLower(res[j])
-		})
-		return res
-	}
-
-	// 1) Persist desired state in DB
-	if err := a.store.SetUserGroups(user, selected); err != nil {
-		http.Error(w, err.Error(), 500)
-		return
-	}
-
-	// 2) Apply to Linux
-
-	// user must exist on Linux for group assignment
-	if !samba.LinuxUserExists(user) {
-		http.Error(w, "linux user does not exist", 400)
-		return
-	}
-
-	// 2a) Determine which groups are "managed" (all groups present in DB)
-	dbGroups, err := a.store.ListGroups()
-	if err != nil {
-		http.Error(w, err.Error(), 500)
-		return
-	}
-	managedSet := make(map[string]bool, len(dbGroups))
-	for _, g := range dbGroups {
-		managedSet[g.Name] = true
-	}
-
-	// 2b) Ensure selected groups exist on Linux (optionally with GID)
-	// If you want to respect DB GID, do it here:
-	dbByName := map[string]*int{}
-	for _, g := range dbGroups {
-		dbByName[g.Name] = g.GID
-	}
-	for _, g := range selected {
-		if samba.LinuxGroupExists(g) {
-			continue
-		}
-		// create with desired gid if known
-		if err := samba.CreateLinuxGroup(g, dbByName[g]); err != nil {
-			http.Error(w, err.Error(), 500)
-			return
-		}
-	}
-
-	// 2c) Read current linux groups for user (contains primary + supplementary in most distros)
-	currentGroups, err := samba.GetUserGroups(user)
+	err := a.saveUserGroups(user, selected)
+	a.audit(actorOf(r), "user.groups", user, err)
 	if err != nil {
-		http.Error(w, err.Error(), 500)
-		return
-	}
-
-	// 2d) Build new group set:
-	// keep all NON-managed groups from current
-	newSet := map[string]bool{}
-	for _, g := range currentGroups {
-		if !managedSet[g] {
-			newSet[g] = true
-		}
-	}
-
-	// add selected managed groups
-	for _, g := range selected {
-		newSet[g] = true
-	}
-
-	pg, err := samba.GetPrimaryGroupName(user)
-	if err == nil && pg != "" {
-		delete(newSet, pg)
-	}
-
-	// 2e) Apply: set supplementary groups
-	// NOTE: usermod -G sets supplementary groups. Primary group is not changed.
-	newGroups := uniqueSorted(newSet)
-	if err := samba.SetUserSupplementaryGroups(user, newGroups); err != nil {
-		http.Error(w, err.Error(), 500)
+		http.Error(w, err.Error(), errStatus(err))
 		return
 	}
 
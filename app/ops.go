@@ -0,0 +1,692 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/florianibach/samba-admin-ui/internal/samba"
+	"github.com/florianibach/samba-admin-ui/internal/state"
+)
+
+// The operations in this file are shared by the HTML handlers and the JSON
+// API, so both apply exactly the same checks and side effects.
+
+// opError is an error that carries the HTTP status it should be reported with.
+type opError struct {
+	status int
+	msg    string
+}
+
+func (e *opError) Error() string { return e.msg }
+
+func opErr(status int, format string, args ...any) error {
+	return &opError{status: status, msg: fmt.Sprintf(format, args...)}
+}
+
+// errStatus returns the HTTP status for err (500 unless it is an opError).
+func errStatus(err error) int {
+	var oe *opError
+	if errors.As(err, &oe) {
+		return oe.status
+	}
+	return http.StatusInternalServerError
+}
+
+func shareDirs() (sharesDir, indexPath string) {
+	return getenv("UI_SHARES_DIR", "/etc/samba/shares.d/ui"),
+		getenv("UI_SHARES_INDEX", "/etc/samba/shares.d/ui/shares.conf")
+}
+
+type ShareInfo struct {
+	Name     string `json:"name"`
+	Path     string `json:"path"`
+	ReadOnly string `json:"read_only"`
+	PathOK   bool   `json:"path_ok"`
+	Perms    string `json:"perms"`
+
+	Managed  bool `json:"managed"`
+	Disabled bool `json:"disabled"`
+}
+
+// listShares returns all shares of the effective config (UI-managed first)
+// plus the raw testparm output.
+func (a *App) listShares() ([]ShareInfo, string, error) {
+	sections, raw, err := samba.ReadEffectiveConfig(a.smbConf)
+	if err != nil {
+		return nil, "", err
+	}
+	_, indexPath := shareDirs()
+	managed, err := samba.ReadManagedSharesIndex(indexPath)
+	if err != nil {
+		// treat as empty so UI still works
+		managed = map[string]samba.ManagedShareState{}
+	}
+
+	var rows []ShareInfo
+	for name, kv := range sections {
+		if strings.EqualFold(name, "global") {
+			continue
+		}
+		path := kv["path"]
+		if path == "" {
+			// Sometimes shares rely on defaults; still list them.
+			path = "(not set)"
+		}
+		ro := kv["read only"]
+		if ro == "" {
+			ro = kv["readonly"]
+		}
+		if ro == "" {
+			ro = "(unknown)"
+		}
+
+		pathOK, perms := samba.PathPerms(path)
+		st, ok := managed[name]
+
+		rows = append(rows, ShareInfo{
+			Name:     name,
+			Path:     path,
+			ReadOnly: ro,
+			PathOK:   pathOK,
+			Perms:    perms,
+			Managed:  ok,
+			Disabled: ok && st.Disabled,
+		})
+	}
+
+	sort.Slice(rows, func(i, j int) bool {
+		// UI-managed shares first
+		if rows[i].Managed != rows[j].Managed {
+			return rows[i].Managed && !rows[j].Managed
+		}
+		// Within managed: enabled first, then disabled
+		if rows[i].Disabled != rows[j].Disabled {
+			return !rows[i].Disabled && rows[j].Disabled
+		}
+		// Finally: alphabetical by name (case-insensitive)
+		return strings.ToLower(rows[i].Name) < strings.ToLower(rows[j].Name)
+	})
+
+	return rows, raw, nil
+}
+
+type UserInfo struct {
+	samba.SambaUser
+	LinuxExists bool `json:"linux_exists"`
+}
+
+// PasswordAge renders how long ago the password was last set, e.g. "12 days".
+func (u UserInfo) PasswordAge() string {
+	if u.PasswordLastSet == nil {
+		return ""
+	}
+	d := time.Since(*u.PasswordLastSet)
+	switch days := int(d.Hours() / 24); {
+	case d < time.Hour:
+		return "less than an hour"
+	case days == 0:
+		return fmt.Sprintf("%d hours", int(d.Hours()))
+	case days == 1:
+		return "1 day"
+	default:
+		return fmt.Sprintf("%d days", days)
+	}
+}
+
+func (a *App) listUsers() ([]UserInfo, error) {
+	users, err := samba.ListSambaUsers()
+	if err != nil {
+		return nil, err
+	}
+
+	acc, err := samba.LoadAccounts()
+	if err != nil {
+		return nil, err
+	}
+
+	rows := make([]UserInfo, 0, len(users))
+	for _, u := range users {
+		_, linux := acc.User(u.Name)
+		rows = append(rows, UserInfo{
+			SambaUser:   u,
+			LinuxExists: linux,
+		})
+	}
+	return rows, nil
+}
+
+type GroupInfo struct {
+	Name string `json:"name"`
+
+	ActualGID int `json:"gid"`
+
+	Managed    bool `json:"managed"`
+	DesiredGID *int `json:"desired_gid,omitempty"`
+}
+
+// listGroups returns all Linux groups, flagged with whether the DB manages them.
+func (a *App) listGroups() ([]GroupInfo, error) {
+	linuxGroups, err := samba.ListLinuxGroups()
+	if err != nil {
+		return nil, err
+	}
+
+	dbGroups, err := a.store.ListGroups()
+	if err != nil {
+		return nil, err
+	}
+
+	// map for quick lookup
+	dbByName := map[string]*int{}
+	for _, g := range dbGroups {
+		dbByName[g.Name] = g.GID
+	}
+
+	rows := make([]GroupInfo, 0, len(linuxGroups))
+	for _, g := range linuxGroups {
+		desired, managed := dbByName[g.Name]
+
+		rows = append(rows, GroupInfo{
+			Name:       g.Name,
+			ActualGID:  g.GID,
+			Managed:    managed,
+			DesiredGID: desired,
+		})
+	}
+	return rows, nil
+}
+
+func (a *App) checkIndexIncluded(indexPath string) error {
+	if err := samba.CheckSmbConfIncludesIndex(a.smbConf, indexPath); err != nil {
+		return opErr(http.StatusBadRequest, "%s (smb.conf is read-only; please add it manually)", err)
+	}
+	return nil
+}
+
+func (a *App) reloadSamba() error {
+	if err := samba.ReloadConfig(); err != nil {
+		return fmt.Errorf("reload failed: %w", err)
+	}
+	a.lastReload = time.Now()
+	return nil
+}
+
+func (a *App) createShare(act actor, opt samba.CreateShareOptions) error {
+	sharesDir, indexPath := shareDirs()
+
+	if err := samba.ValidateShareOptions(opt); err != nil {
+		return opErr(http.StatusBadRequest, "%s", err)
+	}
+
+	// 0) smb.conf must include our index file (read-only check)
+	if err := a.checkIndexIncluded(indexPath); err != nil {
+		return err
+	}
+
+	managed, err := samba.ReadManagedSharesIndex(indexPath)
+	if err != nil {
+		return fmt.Errorf("failed to read shares index: %w", err)
+	}
+	if _, exists := managed[opt.Name]; exists {
+		return opErr(http.StatusConflict, "a share named %s already exists", opt.Name)
+	}
+
+	defer a.trackShares(act, "share.create", opt.Name)()
+
+	// 1) Write share file: /etc/samba/shares.d/ui/<name>.conf
+	shareFile, err := samba.CreateShareSnippet(sharesDir, opt)
+	if err != nil {
+		return err
+	}
+
+	// 2) Ensure shares index references that share file
+	if err := samba.EnsureIndexReferencesShare(indexPath, opt.Name, shareFile); err != nil {
+		return fmt.Errorf("failed to update shares index: %w", err)
+	}
+
+	// 3) Reload Samba
+	return a.reloadSamba()
+}
+
+// updateShare rewrites the snippet of the UI-managed share original; a
+// changed opt.Name renames the share.
+func (a *App) updateShare(act actor, original string, opt samba.CreateShareOptions) error {
+	sharesDir, indexPath := shareDirs()
+
+	if err := samba.ValidateShareOptions(opt); err != nil {
+		return opErr(http.StatusBadRequest, "%s", err)
+	}
+
+	// only UI-managed shares can be edited
+	managed, err := samba.ReadManagedSharesIndex(indexPath)
+	if err != nil {
+		return fmt.Errorf("failed to read shares index: %w", err)
+	}
+	st, ok := managed[original]
+	if !ok {
+		return opErr(http.StatusNotFound, "share %s is not managed by UI", original)
+	}
+
+	if err := a.checkIndexIncluded(indexPath); err != nil {
+		return err
+	}
+
+	// keep settings the form does not cover (e.g. of imported shares)
+	if cur, err := samba.ReadShareSnippet(sharesDir, original); err == nil {
+		opt.Extra = cur.Extra
+	}
+
+	renamed := opt.Name != original
+	if renamed {
+		if _, exists := managed[opt.Name]; exists {
+			return opErr(http.StatusConflict, "a share named %s already exists", opt.Name)
+		}
+		if _, err := os.Stat(filepath.Join(sharesDir, opt.Name+".conf")); err == nil {
+			return opErr(http.StatusConflict, "share file for %s already exists", opt.Name)
+		}
+	}
+
+	defer a.trackShares(act, "share.update", original, opt.Name)()
+
+	// 1) Stage, validate (testparm) and atomically replace the snippet
+	shareFile, err := samba.UpdateShareSnippet(sharesDir, opt)
+	if err != nil {
+		return opErr(http.StatusBadRequest, "%s", err)
+	}
+
+	// 2) On rename: point the index block at the new file, drop the old one
+	if renamed {
+		if err := samba.RenameShareInIndex(indexPath, original, opt.Name, shareFile, st.Disabled); err != nil {
+			_ = os.Remove(shareFile)
+			return fmt.Errorf("failed to update shares index: %w", err)
+		}
+		_ = os.Remove(filepath.Join(sharesDir, original+".conf"))
+	}
+
+	// 3) Reload Samba
+	return a.reloadSamba()
+}
+
+// shareChange says how to disable or delete a share that is in use.
+type shareChange struct {
+	Force bool // go ahead although clients are connected
+	Close bool // with Force: make smbd close the share once the change is live
+}
+
+// shareBusyError stops a change of a share that clients are using.
+type shareBusyError struct {
+	*opError
+	Use *shareUse // nil if the connections could not be checked
+}
+
+func (e *shareBusyError) Unwrap() error { return e.opError }
+
+// checkShareIdle refuses to touch share name while it has connections or
+// open files, unless ch.Force is set.
+func (a *App) checkShareIdle(name string, ch shareChange) error {
+	if ch.Force {
+		return nil
+	}
+
+	use, err := a.shareInUse(name)
+	if err != nil {
+		return &shareBusyError{opError: &opError{status: http.StatusConflict, msg: fmt.Sprintf("cannot check connections to share %s (%s); force to continue anyway", name, err)}}
+	}
+	if use.Busy() {
+		msg := fmt.Sprintf("share %s is in use: %d connection(s), %d open file(s)", name, len(use.Tcons), len(use.Files))
+		return &shareBusyError{opError: &opError{status: http.StatusConflict, msg: msg}, Use: use}
+	}
+	return nil
+}
+
+// closeForcedShare disconnects the clients of share name after a forced
+// change has been written and reloaded, so they cannot reconnect to the
+// old definition in between.
+func closeForcedShare(name string, ch shareChange) error {
+	if !ch.Force || !ch.Close {
+		return nil
+	}
+	if err := samba.CloseShareAll(name); err != nil {
+		return opErr(http.StatusBadGateway, "share %s changed, but closing its connections failed: %s", name, err)
+	}
+	return nil
+}
+
+// requireManagedShare returns a 404 unless share name has a marker block in
+// the shares index or, with snippetOK, at least a share file.
+func requireManagedShare(sharesDir, indexPath, name string, snippetOK bool) error {
+	managed, err := samba.ReadManagedSharesIndex(indexPath)
+	if err != nil {
+		return fmt.Errorf("failed to read shares index: %w", err)
+	}
+	if _, ok := managed[name]; ok {
+		return nil
+	}
+	if snippetOK && !strings.Contains(name, "/") {
+		if _, err := os.Stat(filepath.Join(sharesDir, name+".conf")); err == nil {
+			return nil
+		}
+	}
+	return opErr(http.StatusNotFound, "share %s is not managed by UI", name)
+}
+
+func (a *App) setShareState(act actor, name string, disabled bool, ch shareChange) error {
+	if name == "" {
+		return opErr(http.StatusBadRequest, "name required")
+	}
+	sharesDir, indexPath := shareDirs()
+
+	// require include exists in smb.conf
+	if err := a.checkIndexIncluded(indexPath); err != nil {
+		return err
+	}
+	if err := requireManagedShare(sharesDir, indexPath, name, true); err != nil {
+		return err
+	}
+	if disabled {
+		if err := a.checkShareIdle(name, ch); err != nil {
+			return err
+		}
+	}
+
+	shareFile := filepath.Join(sharesDir, name+".conf")
+	defer a.trackShares(act, shareStateAction(disabled), name)()
+
+	// Ensure index entry exists (best effort)
+	_ = samba.EnsureIndexReferencesShare(indexPath, name, shareFile)
+
+	if err := samba.SetShareDisabled(indexPath, name, shareFile, disabled); err != nil {
+		return err
+	}
+
+	if err := a.reloadSamba(); err != nil {
+		return err
+	}
+	if disabled {
+		return closeForcedShare(name, ch)
+	}
+	return nil
+}
+
+func (a *App) deleteShare(act actor, name string, ch shareChange) error {
+	if name == "" {
+		return opErr(http.StatusBadRequest, "name required")
+	}
+	sharesDir, indexPath := shareDirs()
+
+	if err := a.checkIndexIncluded(indexPath); err != nil {
+		return err
+	}
+	if err := requireManagedShare(sharesDir, indexPath, name, false); err != nil {
+		return err
+	}
+	if err := a.checkShareIdle(name, ch); err != nil {
+		return err
+	}
+
+	shareFile := filepath.Join(sharesDir, name+".conf")
+	defer a.trackShares(act, "share.delete", name)()
+
+	// Remove from index (only if managed marker exists)
+	if err := samba.RemoveShareFromIndex(indexPath, name); err != nil {
+		return err
+	}
+
+	// Delete share snippet file (ignore if missing)
+	_ = os.Remove(shareFile)
+
+	if err := a.reloadSamba(); err != nil {
+		return err
+	}
+	return closeForcedShare(name, ch)
+}
+
+// planShareImport prepares moving the manually configured share name under
+// UI management. The returned error, if any, is what stops the import; a
+// validation failure of a plan is reported separately so it can be shown
+// together with the plan.
+func (a *App) planShareImport(name string) (imp *samba.ShareImport, invalid error, err error) {
+	sharesDir, indexPath := shareDirs()
+
+	if err := a.checkIndexIncluded(indexPath); err != nil {
+		return nil, nil, err
+	}
+	imp, err = samba.PlanShareImport(a.smbConf, sharesDir, indexPath, name)
+	if err != nil {
+		return nil, nil, opErr(http.StatusBadRequest, "%s", err)
+	}
+	return imp, imp.Validate(a.smbConf), nil
+}
+
+// importShare converts the manual share name into a UI-managed one. smb.conf
+// is left alone; until the admin removes the original section there, both
+// definitions are merged by Samba.
+func (a *App) importShare(act actor, name string) error {
+	imp, invalid, err := a.planShareImport(name)
+	if err != nil {
+		return err
+	}
+	if invalid != nil {
+		return opErr(http.StatusBadRequest, "%s", invalid)
+	}
+	defer a.trackShares(act, "share.import", name)()
+	if err := imp.Apply(); err != nil {
+		return fmt.Errorf("failed to import share: %w", err)
+	}
+	return a.reloadSamba()
+}
+
+// createUser persists the user in the DB, reconciles the Linux side and adds
+// the Samba account.
+func (a *App) createUser(act actor, name, password string, uid, gid *int) error {
+	if name == "" {
+		return opErr(http.StatusBadRequest, "name required")
+	}
+	if err := a.pwPolicy.Check(name, password); err != nil {
+		return opErr(http.StatusBadRequest, "%s", err)
+	}
+
+	if err := a.store.UpsertUser(state.User{
+		Name: name,
+		UID:  uid,
+		GID:  gid,
+	}); err != nil {
+		return err
+	}
+
+	if err := a.reconcile(act); err != nil {
+		return err
+	}
+
+	return samba.CreateSambaUser(name, password)
+}
+
+// requireSambaUser returns a 404 unless name has a Samba account.
+func requireSambaUser(name string) error {
+	users, err := samba.ListSambaUsers()
+	if err != nil {
+		return err
+	}
+	for _, u := range users {
+		if u.Name == name {
+			return nil
+		}
+	}
+	return opErr(http.StatusNotFound, "no Samba user %s", name)
+}
+
+// requireUser returns a 404 unless name is a user in the DB.
+func (a *App) requireUser(name string) error {
+	users, err := a.store.ListUsers()
+	if err != nil {
+		return err
+	}
+	for _, u := range users {
+		if u.Name == name {
+			return nil
+		}
+	}
+	return opErr(http.StatusNotFound, "no user %s", name)
+}
+
+func (a *App) setUserPassword(name, password string) error {
+	if name == "" {
+		return opErr(http.StatusBadRequest, "name required")
+	}
+	if err := a.pwPolicy.Check(name, password); err != nil {
+		return opErr(http.StatusBadRequest, "%s", err)
+	}
+	return samba.SetSambaPassword(name, password)
+}
+
+func (a *App) createGroup(act actor, name string, gid *int) error {
+	if name == "" {
+		return opErr(http.StatusBadRequest, "name required")
+	}
+	if err := a.store.UpsertGroup(state.Group{
+		Name: name,
+		GID:  gid,
+	}); err != nil {
+		return err
+	}
+
+	return a.reconcile(act)
+}
+
+// deleteGroup removes a managed group from Linux and the DB. Unknown groups
+// are reported as found=false without error.
+func (a *App) deleteGroup(name string) (found bool, err error) {
+	// 1) Nur managed groups löschen (optional, aber sinnvoll)
+	grp, ok, err := a.store.GetGroup(name)
+	if err != nil {
+		return false, err
+	}
+	if !ok {
+		// nichts zu tun
+		return false, nil
+	}
+
+	// 2) DB-Assignments blocken
+	cnt, err := a.store.CountGroupAssignments(name)
+	if err != nil {
+		return true, err
+	}
+	if cnt > 0 {
+		return true, opErr(http.StatusBadRequest, "cannot delete group: users are still assigned in DB")
+	}
+
+	// 3) Linux-Delete nur versuchen, wenn Gruppe existiert
+	if samba.LinuxGroupExists(name) {
+		// Primärgruppe-Check (wenn wir die GID kennen)
+		if grp.GID != nil {
+			used, err := samba.IsPrimaryGroupGIDUsed(*grp.GID)
+			if err != nil {
+				return true, err
+			}
+			if used {
+				return true, opErr(http.StatusBadRequest, "cannot delete group: it is the primary group of at least one Linux user")
+			}
+		}
+
+		// groupdel
+		if err := samba.DeleteLinuxGroup(name); err != nil {
+			return true, opErr(http.StatusBadRequest, "%s", err)
+		}
+	}
+
+	// 4) Danach DB löschen
+	return true, a.store.DeleteGroup(name)
+}
+
+// saveUserGroups stores the managed groups of user in the DB and applies them
+// to Linux, keeping all groups that are not managed by the UI.
+func (a *App) saveUserGroups(user string, selected []string) error {
+	// --- helpers ---
+	uniqueSorted := func(set map[string]bool) []string {
+		res := make([]string, 0, len(set))
+		for k := range set {
+			res = append(res, k)
+		}
+		sort.Slice(res, func(i, j int) bool {
+			return strings.ToLower(res[i]) < strings.ToLower(res[j])
+		})
+		return res
+	}
+
+	// 1) Persist desired state in DB
+	if err := a.store.SetUserGroups(user, selected); err != nil {
+		return err
+	}
+
+	// 2) Apply to Linux
+
+	// passwd and group are read once; creating groups below does not
+	// change the user's memberships
+	acc, err := samba.LoadAccounts()
+	if err != nil {
+		return err
+	}
+
+	// user must exist on Linux for group assignment
+	u, ok := acc.User(user)
+	if !ok {
+		return opErr(http.StatusBadRequest, "linux user does not exist")
+	}
+
+	// 2a) Determine which groups are "managed" (all groups present in DB)
+	dbGroups, err := a.store.ListGroups()
+	if err != nil {
+		return err
+	}
+	managedSet := make(map[string]bool, len(dbGroups))
+	for _, g := range dbGroups {
+		managedSet[g.Name] = true
+	}
+
+	// 2b) Ensure selected groups exist on Linux (optionally with GID)
+	// If you want to respect DB GID, do it here:
+	dbByName := map[string]*int{}
+	for _, g := range dbGroups {
+		dbByName[g.Name] = g.GID
+	}
+	for _, g := range selected {
+		if _, ok := acc.Group(g); ok {
+			continue
+		}
+		// create with desired gid if known
+		if err := samba.CreateLinuxGroup(g, dbByName[g]); err != nil {
+			return err
+		}
+	}
+
+	// 2c) Read current linux groups for user (contains primary + supplementary in most distros)
+	currentGroups := acc.GroupsOf(user)
+
+	// 2d) Build new group set:
+	// keep all NON-managed groups from current
+	newSet := map[string]bool{}
+	for _, g := range currentGroups {
+		if !managedSet[g.Name] {
+			newSet[g.Name] = true
+		}
+	}
+
+	// add selected managed groups
+	for _, g := range selected {
+		newSet[g] = true
+	}
+
+	if pg, ok := acc.GroupByGID(u.GID); ok {
+		delete(newSet, pg.Name)
+	}
+
+	// 2e) Apply: set supplementary groups
+	// NOTE: usermod -G sets supplementary groups. Primary group is not changed.
+	return samba.SetUserSupplementaryGroups(user, uniqueSorted(newSet))
+}
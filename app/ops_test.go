@@ -0,0 +1,308 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/florianibach/samba-admin-ui/internal/samba"
+	"github.com/florianibach/samba-admin-ui/internal/samba/sambatest"
+	"github.com/florianibach/samba-admin-ui/internal/state"
+)
+
+func newTestApp(t *testing.T) *App {
+	t.Helper()
+	st, err := state.Open(filepath.Join(t.TempDir(), "app.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { st.Close() })
+	return &App{store: st}
+}
+
+// withShareDirs points the UI share directory and index into a temporary
+// directory and writes an smb.conf that includes the index.
+func withShareDirs(t *testing.T, a *App) (sharesDir, indexPath string) {
+	t.Helper()
+	dir := t.TempDir()
+	sharesDir = filepath.Join(dir, "ui")
+	indexPath = filepath.Join(sharesDir, "shares.conf")
+	t.Setenv("UI_SHARES_DIR", sharesDir)
+	t.Setenv("UI_SHARES_INDEX", indexPath)
+	a.smbConf = filepath.Join(dir, "smb.conf")
+	must(t, os.WriteFile(a.smbConf, []byte("[global]\n   include = "+indexPath+"\n"), 0o644))
+	return sharesDir, indexPath
+}
+
+func intp(v int) *int { return &v }
+
+func supplementary(sys *sambatest.System, user string) []string {
+	var res []string
+	for g, ok := range sys.Users[user].Groups {
+		if ok {
+			res = append(res, g)
+		}
+	}
+	slices.Sort(res)
+	return res
+}
+
+func TestSaveUserGroupsKeepsUnmanagedGroups(t *testing.T) {
+	sys := sambatest.Install(t)
+	sys.AddGroup("docker", 999)
+	sys.AddGroup("family", 2000)
+	sys.AddGroup("media", 2001)
+	sys.AddUser("bob", 1001, "docker", "family")
+	a := newTestApp(t)
+
+	for _, g := range []string{"family", "media"} {
+		if err := a.store.UpsertGroup(state.Group{Name: g}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := a.store.UpsertUser(state.User{Name: "bob"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := a.saveUserGroups("bob", []string{"media"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// family (managed, deselected) is dropped, docker (unmanaged) is kept
+	if got := supplementary(sys, "bob"); !slices.Equal(got, []string{"docker", "media"}) {
+		t.Errorf("linux groups = %q", got)
+	}
+	dbGroups, err := a.store.ListUserGroups("bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(dbGroups, []string{"media"}) {
+		t.Errorf("db groups = %q", dbGroups)
+	}
+}
+
+func TestSaveUserGroupsDoesNotAddPrimaryGroup(t *testing.T) {
+	sys := sambatest.Install(t)
+	sys.AddUser("bob", 1001)
+	a := newTestApp(t)
+
+	// bob's own user private group is managed and selected
+	if err := a.store.UpsertGroup(state.Group{Name: "bob"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.store.UpsertUser(state.User{Name: "bob"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := a.saveUserGroups("bob", []string{"bob"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := supplementary(sys, "bob"); len(got) != 0 {
+		t.Errorf("linux groups = %q, want none", got)
+	}
+}
+
+func TestSaveUserGroupsCreatesMissingGroupWithDBGID(t *testing.T) {
+	sys := sambatest.Install(t)
+	sys.AddUser("bob", 1001)
+	a := newTestApp(t)
+
+	if err := a.store.UpsertGroup(state.Group{Name: "family", GID: intp(2500)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.store.UpsertUser(state.User{Name: "bob"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := a.saveUserGroups("bob", []string{"family"}); err != nil {
+		t.Fatal(err)
+	}
+	if g := sys.Groups["family"]; g == nil || g.GID != 2500 {
+		t.Fatalf("family = %+v, want gid 2500", g)
+	}
+	if !sys.MemberOf("bob", "family") {
+		t.Error("bob not in family")
+	}
+}
+
+func TestSaveUserGroupsRequiresLinuxUser(t *testing.T) {
+	sys := sambatest.Install(t)
+	a := newTestApp(t)
+
+	if err := a.store.UpsertUser(state.User{Name: "ghost"}); err != nil {
+		t.Fatal(err)
+	}
+
+	err := a.saveUserGroups("ghost", nil)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if errStatus(err) != 400 {
+		t.Errorf("status = %d, want 400", errStatus(err))
+	}
+	if m := sys.Mutations(); len(m) != 0 {
+		t.Errorf("unexpected system changes: %q", m)
+	}
+}
+
+func TestListUsersReadsAccountsOnce(t *testing.T) {
+	sys := sambatest.Install(t)
+	sys.AddGroup("media", 2001)
+	for i, name := range []string{"alice", "bob", "carol"} {
+		sys.AddUser(name, 1001+i, "media")
+		sys.Passdb[name] = &sambatest.SambaAccount{}
+	}
+	sys.Passdb["ghost"] = &sambatest.SambaAccount{}
+	a := newTestApp(t)
+
+	users, err := a.listUsers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 4 {
+		t.Fatalf("%d users", len(users))
+	}
+	for _, u := range users {
+		if u.LinuxExists != (u.Name != "ghost") {
+			t.Errorf("%s: linux = %v", u.Name, u.LinuxExists)
+		}
+	}
+	if n := sys.AccountReads(); n != 1 {
+		t.Errorf("passwd read %d times, want 1", n)
+	}
+}
+
+func TestUpdateShareInPlace(t *testing.T) {
+	sambatest.Install(t)
+	a := newTestApp(t)
+	sharesDir, indexPath := withShareDirs(t, a)
+	admin := actor{Name: "admin"}
+
+	must(t, a.createShare(admin, samba.CreateShareOptions{Name: "media", Path: "/shares/media", Browseable: true}))
+	must(t, a.setShareState(admin, "media", true, shareChange{}))
+	index, _ := os.ReadFile(indexPath)
+
+	must(t, a.updateShare(admin, "media", samba.CreateShareOptions{Name: "media", Path: "/shares/films", Browseable: true, ReadOnly: true}))
+	b, _ := os.ReadFile(filepath.Join(sharesDir, "media.conf"))
+	if !strings.Contains(string(b), "path = /shares/films\n") || !strings.Contains(string(b), "read only = yes\n") {
+		t.Errorf("snippet = %q", b)
+	}
+	if got, _ := os.ReadFile(indexPath); string(got) != string(index) {
+		t.Errorf("index changed:\n%s", got)
+	}
+}
+
+func TestUpdateShareRename(t *testing.T) {
+	sambatest.Install(t)
+	a := newTestApp(t)
+	sharesDir, indexPath := withShareDirs(t, a)
+	admin := actor{Name: "admin"}
+
+	must(t, a.createShare(admin, samba.CreateShareOptions{Name: "media", Path: "/shares/media", Browseable: true}))
+	must(t, a.createShare(admin, samba.CreateShareOptions{Name: "backup", Path: "/shares/backup", Browseable: true}))
+	must(t, a.setShareState(admin, "media", true, shareChange{}))
+
+	must(t, a.updateShare(admin, "media", samba.CreateShareOptions{Name: "films", Path: "/shares/media", Browseable: true}))
+	if _, err := os.Stat(filepath.Join(sharesDir, "media.conf")); !os.IsNotExist(err) {
+		t.Errorf("old snippet: %v", err)
+	}
+	if b, err := os.ReadFile(filepath.Join(sharesDir, "films.conf")); err != nil || !strings.Contains(string(b), "path = /shares/media\n") {
+		t.Errorf("new snippet = %q, %v", b, err)
+	}
+	managed, err := samba.ReadManagedSharesIndex(indexPath)
+	must(t, err)
+	if _, ok := managed["media"]; ok {
+		t.Error("media still in index")
+	}
+	if st, ok := managed["films"]; !ok || !st.Disabled {
+		t.Errorf("films = %+v, %v", st, ok)
+	}
+	if b, _ := os.ReadFile(indexPath); !strings.Contains(string(b), "include = "+filepath.Join(sharesDir, "films.conf")+"\n") {
+		t.Errorf("index:\n%s", b)
+	}
+
+	err = a.updateShare(admin, "films", samba.CreateShareOptions{Name: "backup", Path: "/shares/media"})
+	if errStatus(err) != 409 {
+		t.Errorf("rename onto backup = %v", err)
+	}
+}
+
+func TestUpdateShareRejectedByTestparm(t *testing.T) {
+	sys := sambatest.Install(t)
+	a := newTestApp(t)
+	sharesDir, indexPath := withShareDirs(t, a)
+	admin := actor{Name: "admin"}
+
+	must(t, a.createShare(admin, samba.CreateShareOptions{Name: "media", Path: "/shares/media", Browseable: true}))
+	snippet, _ := os.ReadFile(filepath.Join(sharesDir, "media.conf"))
+	index, _ := os.ReadFile(indexPath)
+
+	sys.TestparmError = "Unknown parameter encountered"
+	for _, name := range []string{"media", "films"} {
+		err := a.updateShare(admin, "media", samba.CreateShareOptions{Name: name, Path: "/shares/other"})
+		if errStatus(err) != 400 {
+			t.Errorf("update to %s = %v", name, err)
+		}
+		if b, _ := os.ReadFile(filepath.Join(sharesDir, "media.conf")); string(b) != string(snippet) {
+			t.Errorf("snippet changed to %q", b)
+		}
+		if b, _ := os.ReadFile(indexPath); string(b) != string(index) {
+			t.Errorf("index changed to %q", b)
+		}
+		if _, err := os.Stat(filepath.Join(sharesDir, "films.conf")); !os.IsNotExist(err) {
+			t.Errorf("films.conf: %v", err)
+		}
+	}
+}
+
+func TestForcedShareChangeClosesAfterReload(t *testing.T) {
+	sys := sambatest.Install(t)
+	a := newTestApp(t)
+	sharesDir, indexPath := withShareDirs(t, a)
+	admin := actor{Name: "admin"}
+
+	must(t, a.createShare(admin, samba.CreateShareOptions{Name: "media", Path: "/shares/media", Browseable: true}))
+	sys.SmbStatus = testSmbstatus
+	start := len(sys.Calls())
+
+	// what smbd would see when it is told to close the share
+	var seen []string
+	sys.Script("smbcontrol", func(stdin string, args []string) (string, string, int) {
+		if slices.Contains(args, "close-share") {
+			managed, _ := samba.ReadManagedSharesIndex(indexPath)
+			st, ok := managed["media"]
+			_, err := os.Stat(filepath.Join(sharesDir, "media.conf"))
+			seen = append(seen, fmt.Sprintf("indexed=%v disabled=%v snippet=%v", ok, st.Disabled, err == nil))
+		}
+		return "", "", 0
+	})
+	closeOrder := func() []string {
+		var order []string
+		for _, c := range sys.Calls()[start:] {
+			if strings.HasPrefix(c, "smbcontrol ") {
+				order = append(order, c)
+			}
+		}
+		return order
+	}
+
+	if err := a.setShareState(admin, "media", true, shareChange{}); errStatus(err) != 409 {
+		t.Fatalf("disable busy share = %v", err)
+	}
+	must(t, a.setShareState(admin, "media", true, shareChange{Force: true, Close: true}))
+	must(t, a.deleteShare(admin, "media", shareChange{Force: true, Close: true}))
+
+	want := []string{
+		"smbcontrol all reload-config", "smbcontrol smbd close-share media",
+		"smbcontrol all reload-config", "smbcontrol smbd close-share media",
+	}
+	if got := closeOrder(); !slices.Equal(got, want) {
+		t.Errorf("smbcontrol calls = %q, want %q", got, want)
+	}
+	if want := []string{"indexed=true disabled=true snippet=true", "indexed=false disabled=false snippet=false"}; !slices.Equal(seen, want) {
+		t.Errorf("at close-share: %q, want %q", seen, want)
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/florianibach/samba-admin-ui/internal/reconcile"
+)
+
+// pending shows what reconcile.Apply would change on this container right
+// now, without executing anything.
+func (a *App) pending(w http.ResponseWriter, r *http.Request) {
+	type vm struct {
+		Error   string
+		Actions []string
+		Applied bool
+	}
+
+	res, err := reconcile.Plan(a.store)
+	if err != nil {
+		a.render(w, r, "pending.html", "Pending changes", vm{Error: err.Error()})
+		return
+	}
+	a.render(w, r, "pending.html", "Pending changes", vm{
+		Actions: res.Actions,
+		Applied: r.URL.Query().Get("applied") == "1",
+	})
+}
+
+func (a *App) pendingApply(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/pending", http.StatusSeeOther)
+		return
+	}
+	if err := a.reconcile(actorOf(r)); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	http.Redirect(w, r, "/pending?applied=1", http.StatusSeeOther)
+}
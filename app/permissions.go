@@ -0,0 +1,327 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/florianibach/samba-admin-ui/internal/auth"
+	"github.com/florianibach/samba-admin-ui/internal/files"
+	"github.com/florianibach/samba-admin-ui/internal/samba"
+)
+
+// permPreset is a canned ownership/mode for a share directory.
+type permPreset struct {
+	ID         string
+	Label      string
+	Help       string
+	NeedsUser  bool
+	NeedsGroup bool
+}
+
+var permPresets = []permPreset{
+	{ID: "private", Label: "Private to user", Help: "owned by the user and its primary group, 0700 (files 0600)", NeedsUser: true},
+	{ID: "group", Label: "Group shared (setgid)", Help: "owned by the group, 2770 (files 0660); new files inherit the group", NeedsGroup: true},
+	{ID: "group-ro", Label: "Read-only for group", Help: "owner keeps write access, the group may read, 0750 (files 0640)", NeedsGroup: true},
+}
+
+func findPreset(id string) (permPreset, bool) {
+	for _, p := range permPresets {
+		if p.ID == id {
+			return p, true
+		}
+	}
+	return permPreset{}, false
+}
+
+// resolvePreset turns a preset and user/group names into numeric ownership.
+func resolvePreset(id, user, group string) (files.Perms, error) {
+	p, ok := findPreset(id)
+	if !ok {
+		return files.Perms{}, opErr(http.StatusBadRequest, "unknown preset %q", id)
+	}
+
+	if p.NeedsUser {
+		if user == "" {
+			return files.Perms{}, opErr(http.StatusBadRequest, "user required")
+		}
+		uid, gid, err := samba.GetLinuxUserUIDGID(user)
+		if err != nil {
+			return files.Perms{}, opErr(http.StatusBadRequest, "unknown Linux user %s", user)
+		}
+		return files.Perms{UID: uid, GID: gid, DirMode: 0o700, FileMode: 0o600}, nil
+	}
+
+	group = strings.TrimPrefix(group, "@")
+	if group == "" {
+		return files.Perms{}, opErr(http.StatusBadRequest, "group required")
+	}
+	gid, err := samba.GetLinuxGroupGID(group)
+	if err != nil || gid == nil {
+		return files.Perms{}, opErr(http.StatusBadRequest, "unknown Linux group %s", group)
+	}
+	if p.ID == "group" {
+		return files.Perms{UID: -1, GID: *gid, DirMode: 0o770 | fs.ModeSetgid, FileMode: 0o660}, nil
+	}
+	return files.Perms{UID: -1, GID: *gid, DirMode: 0o750, FileMode: 0o640}, nil
+}
+
+// shareDirRel returns the path of share name relative to SHARE_ROOT. Presets
+// are only applied inside SHARE_ROOT, never to arbitrary system paths.
+func (a *App) shareDirRel(name string) (abs, rel string, err error) {
+	sections, _, err := samba.ReadEffectiveConfig(a.smbConf)
+	if err != nil {
+		return "", "", err
+	}
+	kv, ok := sections[name]
+	if !ok {
+		return "", "", opErr(http.StatusNotFound, "share %s not found", name)
+	}
+	abs = kv["path"]
+	if abs == "" {
+		return "", "", opErr(http.StatusBadRequest, "share %s has no path", name)
+	}
+	rel, err = files.Rel(a.shareRoot, abs)
+	if err != nil {
+		return abs, "", opErr(http.StatusForbidden, "%s is outside SHARE_ROOT (%s)", abs, a.shareRoot)
+	}
+	return abs, rel, nil
+}
+
+// permJob is a running or finished preset application. Recursive runs on
+// large trees take a while, so they run in the background and the page
+// polls for progress.
+type permJob struct {
+	ID        string
+	Share     string
+	Path      string
+	Preset    permPreset
+	Perms     files.Perms
+	Recursive bool
+	Started   time.Time
+
+	total atomic.Int64
+	done  atomic.Int64
+
+	mu       sync.Mutex
+	before   files.Entry
+	after    *files.Entry
+	result   files.PermsResult
+	err      error
+	finished bool
+}
+
+// permJobView is a consistent snapshot of a permJob for rendering.
+type permJobView struct {
+	ID        string
+	Share     string
+	Path      string
+	Preset    permPreset
+	Perms     string
+	Recursive bool
+	Total     int
+	Done      int
+	Percent   int
+	Before    files.Entry
+	After     *files.Entry
+	Result    files.PermsResult
+	Error     string
+	Finished  bool
+}
+
+func (j *permJob) view() permJobView {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	v := permJobView{
+		ID: j.ID, Share: j.Share, Path: j.Path, Preset: j.Preset, Perms: j.Perms.String(), Recursive: j.Recursive,
+		Total: int(j.total.Load()), Done: int(j.done.Load()),
+		Before: j.before, After: j.after, Result: j.result, Finished: j.finished,
+	}
+	if j.err != nil {
+		v.Error = j.err.Error()
+	}
+	switch {
+	case v.Finished:
+		v.Percent = 100
+	case v.Total > 0:
+		v.Percent = min(99, v.Done*100/v.Total)
+	}
+	return v
+}
+
+const maxPermJobs = 20
+
+type permJobs struct {
+	mu   sync.Mutex
+	jobs []*permJob // oldest first
+}
+
+func (p *permJobs) add(j *permJob) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.jobs = append(p.jobs, j)
+	if len(p.jobs) > maxPermJobs {
+		p.jobs = p.jobs[len(p.jobs)-maxPermJobs:]
+	}
+}
+
+func (p *permJobs) get(id string) *permJob {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, j := range p.jobs {
+		if j.ID == id {
+			return j
+		}
+	}
+	return nil
+}
+
+// startPermissions validates the request and applies the preset to the
+// share's directory in the background.
+func (a *App) startPermissions(act actor, share, preset, user, group string, recursive bool) (*permJob, error) {
+	perms, err := resolvePreset(preset, strings.TrimSpace(user), strings.TrimSpace(group))
+	if err != nil {
+		return nil, err
+	}
+	abs, rel, err := a.shareDirRel(share)
+	if err != nil {
+		return nil, err
+	}
+	before, err := files.Stat(a.shareRoot, rel)
+	if err != nil {
+		return nil, fileErr(err)
+	}
+	if !before.Mode.IsDir() {
+		return nil, opErr(http.StatusBadRequest, "%s is not a directory", abs)
+	}
+	id, err := auth.NewToken()
+	if err != nil {
+		return nil, err
+	}
+
+	p, _ := findPreset(preset)
+	j := &permJob{ID: id[:16], Share: share, Path: abs, Preset: p, Perms: perms, Recursive: recursive, Started: time.Now(), before: before}
+	j.total.Store(1)
+	a.permJobs.add(j)
+
+	go a.runPermJob(act, j, rel)
+	return j, nil
+}
+
+func (a *App) runPermJob(act actor, j *permJob, rel string) {
+	if j.Recursive {
+		if n, err := files.Count(a.shareRoot, rel); err == nil {
+			j.total.Store(int64(n))
+		}
+	}
+
+	res, err := files.ApplyPerms(a.shareRoot, rel, j.Perms, j.Recursive, func(done int) {
+		j.done.Store(int64(done))
+	})
+	if err == nil && res.Failed > 0 {
+		err = fmt.Errorf("%d of %d entries failed: %s", res.Failed, res.Failed+res.Changed, strings.Join(res.Errors, "; "))
+	}
+
+	var after *files.Entry
+	if e, serr := files.Stat(a.shareRoot, rel); serr == nil {
+		after = &e
+	}
+
+	j.mu.Lock()
+	j.result, j.err, j.after, j.finished = res, err, after, true
+	j.mu.Unlock()
+
+	target := fmt.Sprintf("%s %s %s", j.Share, j.Preset.ID, j.Perms)
+	if j.Recursive {
+		target += " recursive"
+	}
+	a.audit(act, "share.permissions", target, err)
+}
+
+// sharePermissions starts a preset (POST) or shows a job's progress and
+// result (GET ?job=).
+func (a *App) sharePermissions(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		name := strings.TrimSpace(r.FormValue("name"))
+		j, err := a.startPermissions(actorOf(r), name, r.FormValue("preset"), r.FormValue("user"), r.FormValue("group"), r.FormValue("recursive") == "on")
+		if err != nil {
+			a.audit(actorOf(r), "share.permissions", name, err)
+			a.renderShareDetail(w, r, name, shareForms{Perm: &SharePermForm{
+				Preset:    r.FormValue("preset"),
+				User:      r.FormValue("user"),
+				Group:     r.FormValue("group"),
+				Recursive: r.FormValue("recursive") == "on",
+				Error:     err.Error(),
+			}})
+			return
+		}
+		http.Redirect(w, r, "/shares/permissions?job="+j.ID, http.StatusSeeOther)
+		return
+	}
+
+	j := a.permJobs.get(r.URL.Query().Get("job"))
+	if j == nil {
+		http.Error(w, "job not found (it may have expired)", http.StatusNotFound)
+		return
+	}
+	a.render(w, r, "share_permissions.html", "Permissions "+j.Share, j.view())
+}
+
+// SharePermForm is the permission preset form on the share detail page.
+type SharePermForm struct {
+	Presets   []permPreset
+	Preset    string
+	User      string
+	Group     string
+	Recursive bool
+	Error     string
+
+	// Current is the directory's state; Unavailable explains why presets
+	// cannot be used (e.g. path outside SHARE_ROOT).
+	Current     *fileRow
+	Unavailable string
+}
+
+// sharePermForm completes form (or a fresh one pre-filled from validUsers)
+// with the current state of the share directory at abs.
+func (a *App) sharePermForm(abs, validUsers string, form *SharePermForm) *SharePermForm {
+	if form == nil {
+		form = &SharePermForm{Preset: "group"}
+		for _, u := range strings.Split(validUsers, ",") {
+			u = strings.TrimSpace(u)
+			switch {
+			case strings.HasPrefix(u, "@") || strings.HasPrefix(u, "+"):
+				if form.Group == "" {
+					form.Group = strings.TrimLeft(u, "@+&")
+				}
+			case u != "" && form.User == "":
+				form.User = u
+			}
+		}
+		if form.Group == "" && form.User != "" {
+			form.Preset = "private"
+		}
+	}
+	form.Presets = permPresets
+
+	rel, err := files.Rel(a.shareRoot, abs)
+	if err != nil || abs == "" {
+		form.Unavailable = fmt.Sprintf("Presets are only available for paths below SHARE_ROOT (%s).", a.shareRoot)
+		return form
+	}
+	dir, _, _, err := a.listDir(rel)
+	if err != nil {
+		form.Unavailable = err.Error()
+		return form
+	}
+	form.Current = &dir
+	return form
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"io/fs"
+	"testing"
+
+	"github.com/florianibach/samba-admin-ui/internal/samba/sambatest"
+)
+
+func TestResolvePreset(t *testing.T) {
+	sys := sambatest.Install(t)
+	sys.AddUser("alice", 1000)
+	sys.AddGroup("family", 2000)
+
+	p, err := resolvePreset("private", "alice", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.UID != 1000 || p.GID != 1000 || p.DirMode != 0o700 || p.FileMode != 0o600 {
+		t.Errorf("private = %+v", p)
+	}
+
+	p, err = resolvePreset("group", "", "@family")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.UID != -1 || p.GID != 2000 || p.DirMode != 0o770|fs.ModeSetgid || p.FileMode != 0o660 {
+		t.Errorf("group = %+v", p)
+	}
+
+	for _, tc := range [][3]string{
+		{"private", "bob", ""},
+		{"group-ro", "", "games"},
+		{"group", "", ""},
+		{"world", "alice", "family"},
+	} {
+		if _, err := resolvePreset(tc[0], tc[1], tc[2]); errStatus(err) != 400 {
+			t.Errorf("resolvePreset%q err = %v, want 400", tc, err)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// shareImport previews (GET) and performs (POST) the conversion of a share
+// configured by hand in smb.conf into a UI-managed share.
+func (a *App) shareImport(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		name := strings.TrimSpace(r.FormValue("name"))
+		err := a.importShare(actorOf(r), name)
+		a.audit(actorOf(r), "share.import", name, err)
+		if err != nil {
+			a.renderShareImport(w, r, name, err.Error())
+			return
+		}
+		http.Redirect(w, r, "/shares/"+name, http.StatusSeeOther)
+		return
+	}
+
+	a.renderShareImport(w, r, strings.TrimSpace(r.URL.Query().Get("name")), "")
+}
+
+func (a *App) renderShareImport(w http.ResponseWriter, r *http.Request, name, applyErr string) {
+	type diffLine struct {
+		Num  int
+		Text string
+	}
+	type vm struct {
+		Name    string
+		Error   string
+		Invalid string
+
+		Source      string
+		Remove      []diffLine
+		SnippetPath string
+		Snippet     []string
+		IndexPath   string
+		IndexBlock  []string
+	}
+
+	title := "Convert share " + name
+	imp, invalid, err := a.planShareImport(name)
+	if err != nil {
+		a.renderStatus(w, r, errStatus(err), "share_import.html", title, vm{Name: name, Error: err.Error()})
+		return
+	}
+
+	v := vm{
+		Name:        name,
+		Error:       applyErr,
+		Source:      imp.Source,
+		SnippetPath: imp.SnippetPath,
+		Snippet:     strings.Split(strings.TrimRight(imp.Snippet, "\n"), "\n"),
+		IndexPath:   imp.IndexPath,
+		IndexBlock:  strings.Split(strings.Trim(imp.IndexBlock, "\n"), "\n"),
+	}
+	if invalid != nil {
+		v.Invalid = invalid.Error()
+	}
+	for _, l := range imp.Remove {
+		v.Remove = append(v.Remove, diffLine{Num: l.Num, Text: l.Raw})
+	}
+	a.render(w, r, "share_import.html", title, v)
+}
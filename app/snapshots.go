@@ -0,0 +1,326 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/florianibach/samba-admin-ui/internal/backup"
+)
+
+// snapshotter writes backup bundles to a directory on a schedule and before
+// configuration changes. The zero value is disabled.
+type snapshotter struct {
+	dir           string
+	schedule      *backup.Schedule // nil: no scheduled snapshots
+	beforeChanges bool
+	keep          int
+	maxAge        time.Duration
+
+	mu   sync.Mutex
+	last string // fingerprint of the newest snapshot
+}
+
+// loadSnapshotConfig reads BACKUP_DIR, BACKUP_SCHEDULE,
+// BACKUP_BEFORE_CHANGES, BACKUP_KEEP and BACKUP_MAX_AGE.
+func loadSnapshotConfig(s *snapshotter) error {
+	s.dir = getenv("BACKUP_DIR", "/data/backups")
+	if s.dir == "off" {
+		s.dir = ""
+		return nil
+	}
+	if v := getenv("BACKUP_SCHEDULE", "0 3 * * *"); v != "off" {
+		sched, err := backup.ParseSchedule(v)
+		if err != nil {
+			return fmt.Errorf("BACKUP_SCHEDULE: %w", err)
+		}
+		s.schedule = sched
+	}
+	s.beforeChanges = getenv("BACKUP_BEFORE_CHANGES", "true") == "true"
+
+	s.keep = 50
+	if v := getenv("BACKUP_KEEP", ""); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return fmt.Errorf("BACKUP_KEEP: %q", v)
+		}
+		s.keep = n
+	}
+	s.maxAge = 30 * 24 * time.Hour
+	if v := getenv("BACKUP_MAX_AGE", ""); v != "" {
+		d, err := parseAge(v)
+		if err != nil {
+			return fmt.Errorf("BACKUP_MAX_AGE: %w", err)
+		}
+		s.maxAge = d
+	}
+	return nil
+}
+
+// parseAge is time.ParseDuration that also takes whole days ("30d").
+func parseAge(v string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(v, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid number of days %q", v)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid duration %q", v)
+	}
+	return d, nil
+}
+
+// bundleFingerprint identifies the configuration in b. The database file
+// itself also holds sessions and the audit log, so the restorable tables
+// are hashed instead.
+func (a *App) bundleFingerprint(b *backup.Bundle) (string, error) {
+	db, err := a.store.Fingerprint()
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	h.Write([]byte(db))
+	for _, f := range b.Manifest.Files {
+		if f.Path != backup.DBPath {
+			fmt.Fprintf(h, "\n%s %s", f.Path, f.SHA256)
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// takeSnapshot writes a snapshot and prunes old ones. Unless force is set,
+// nothing is written if the configuration is the same as in the newest
+// snapshot; the returned snapshot is then nil.
+func (a *App) takeSnapshot(reason string, force bool) (*backup.Snapshot, error) {
+	s := &a.snapshots
+	if s.dir == "" {
+		return nil, opErr(http.StatusConflict, "automatic backups are disabled (BACKUP_DIR=off)")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b, err := a.backupBundle()
+	if err != nil {
+		return nil, err
+	}
+	fp, err := a.bundleFingerprint(b)
+	if err != nil {
+		return nil, err
+	}
+	if !force && fp == s.last {
+		return nil, nil
+	}
+	snap, err := backup.SaveSnapshot(s.dir, b, reason)
+	if err != nil {
+		return nil, err
+	}
+	s.last = fp
+
+	removed, err := backup.Prune(s.dir, s.keep, s.maxAge, time.Now())
+	for _, name := range removed {
+		log.Printf("backup: pruned snapshot %s", name)
+	}
+	if err != nil {
+		log.Printf("backup: pruning snapshots failed: %v", err)
+	}
+	return &snap, nil
+}
+
+// runSnapshotSchedule takes a snapshot whenever the schedule is due. It
+// does not return.
+func (a *App) runSnapshotSchedule() {
+	for {
+		next := a.snapshots.schedule.Next(time.Now())
+		if next.IsZero() {
+			log.Printf("backup: schedule %q never matches, no scheduled snapshots", a.snapshots.schedule)
+			return
+		}
+		time.Sleep(time.Until(next))
+
+		snap, err := a.takeSnapshot("scheduled", true)
+		target := "scheduled"
+		if snap != nil {
+			target = snap.Name
+		}
+		a.audit(systemActor, "backup.snapshot", target, err)
+		if err != nil {
+			log.Printf("backup: scheduled snapshot failed: %v", err)
+		}
+	}
+}
+
+// changesConfig reports whether a request may change the configuration and
+// so gets a snapshot first. Login, previews and connection handling don't.
+func changesConfig(r *http.Request) bool {
+	if isSafeMethod(r.Method) {
+		return false
+	}
+	switch p := r.URL.Path; {
+	case p == "/login", p == "/logout", p == "/setup",
+		p == "/backup/upload", p == "/backup/snapshots/create",
+		p == "/api/v1/backup/plan", r.Method == http.MethodPost && p == "/api/v1/backup/snapshots",
+		strings.HasPrefix(p, "/connections/"), strings.HasPrefix(p, "/api/v1/connections/"):
+		return false
+	}
+	return true
+}
+
+// changeReason names a snapshot after the request it precedes, e.g.
+// "before-shares-edit" or "before-delete-users-bob".
+func changeReason(r *http.Request) string {
+	p := strings.TrimPrefix(r.URL.Path, "/")
+	if api, ok := strings.CutPrefix(p, "api/v1/"); ok {
+		p = r.Method + "-" + api
+	}
+	return backup.SnapshotReason("before-" + p)
+}
+
+// withSnapshots takes a snapshot before every request that changes the
+// configuration. A failed snapshot is logged and audited but does not block
+// the change.
+func (a *App) withSnapshots(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if a.snapshots.dir != "" && a.snapshots.beforeChanges && changesConfig(r) {
+			reason := changeReason(r)
+			if _, err := a.takeSnapshot(reason, false); err != nil {
+				log.Printf("backup: snapshot %s failed: %v", reason, err)
+				a.audit(actorOf(r), "backup.snapshot", reason, err)
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// SnapshotsView is the /backup/snapshots page.
+type SnapshotsView struct {
+	Done      string
+	Error     string
+	Dir       string
+	Schedule  string
+	Next      time.Time
+	Before    bool
+	Keep      int
+	MaxAge    time.Duration
+	Snapshots []backup.Snapshot
+}
+
+// MaxAgeDays is the age limit in whole days, for display.
+func (v SnapshotsView) MaxAgeDays() int { return int(v.MaxAge / (24 * time.Hour)) }
+
+func (a *App) snapshotsView() SnapshotsView {
+	s := &a.snapshots
+	v := SnapshotsView{Dir: s.dir, Before: s.beforeChanges, Keep: s.keep, MaxAge: s.maxAge}
+	if s.schedule != nil {
+		v.Schedule = s.schedule.String()
+		v.Next = s.schedule.Next(time.Now())
+	}
+	if s.dir == "" {
+		return v
+	}
+	list, err := backup.ListSnapshots(s.dir)
+	if err != nil {
+		v.Error = err.Error()
+	}
+	v.Snapshots = list
+	return v
+}
+
+func (a *App) snapshotsPage(w http.ResponseWriter, r *http.Request) {
+	v := a.snapshotsView()
+	v.Done = r.URL.Query().Get("done")
+	a.render(w, r, "snapshots.html", "Snapshots", v)
+}
+
+func (a *App) snapshotsFail(w http.ResponseWriter, r *http.Request, err error) {
+	v := a.snapshotsView()
+	v.Error = err.Error()
+	a.renderStatus(w, r, errStatus(err), "snapshots.html", "Snapshots", v)
+}
+
+// openSnapshot reads a snapshot; unknown names are a 404.
+func (a *App) openSnapshot(name string) (*backup.Bundle, error) {
+	if a.snapshots.dir == "" {
+		return nil, opErr(http.StatusNotFound, "automatic backups are disabled")
+	}
+	b, err := backup.OpenSnapshot(a.snapshots.dir, name, maxBackupSize)
+	if errors.Is(err, backup.ErrBadSnapshotName) || errors.Is(err, fs.ErrNotExist) {
+		return nil, opErr(http.StatusNotFound, "no snapshot %q", name)
+	}
+	if err != nil {
+		return nil, opErr(http.StatusBadRequest, "%s", err)
+	}
+	return b, nil
+}
+
+func (a *App) snapshotCreate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/backup/snapshots", http.StatusSeeOther)
+		return
+	}
+	snap, err := a.takeSnapshot("manual", true)
+	target := "manual"
+	if snap != nil {
+		target = snap.Name
+	}
+	a.audit(actorOf(r), "backup.snapshot", target, err)
+	if err != nil {
+		a.snapshotsFail(w, r, err)
+		return
+	}
+	http.Redirect(w, r, "/backup/snapshots?done="+url.QueryEscape("Snapshot "+snap.Name+" written."), http.StatusSeeOther)
+}
+
+func (a *App) snapshotDownload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Redirect(w, r, "/backup/snapshots", http.StatusSeeOther)
+		return
+	}
+	b, err := a.openSnapshot(r.URL.Query().Get("name"))
+	if err != nil {
+		a.snapshotsFail(w, r, err)
+		return
+	}
+	writeBundle(w, b)
+}
+
+// snapshotRestore restores a snapshot right away; the page asks for
+// confirmation. withSnapshots has taken a snapshot of the state before.
+func (a *App) snapshotRestore(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/backup/snapshots", http.StatusSeeOther)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	name := r.FormValue("name")
+	b, err := a.openSnapshot(name)
+	if err != nil {
+		a.snapshotsFail(w, r, err)
+		return
+	}
+	res, err := a.restoreBackup(actorOf(r), b)
+	a.audit(actorOf(r), "backup.restore", "snapshot "+name, err)
+	if err != nil {
+		a.snapshotsFail(w, r, err)
+		return
+	}
+	done := "Restored snapshot " + name + "."
+	if len(res.Reconcile) > 0 {
+		done += fmt.Sprintf(" Reconcile: %s.", strings.Join(res.Reconcile, ", "))
+	}
+	http.Redirect(w, r, "/backup/snapshots?done="+url.QueryEscape(done), http.StatusSeeOther)
+}
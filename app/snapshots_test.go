@@ -0,0 +1,94 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/florianibach/samba-admin-ui/internal/auth"
+	"github.com/florianibach/samba-admin-ui/internal/backup"
+	"github.com/florianibach/samba-admin-ui/internal/samba/sambatest"
+	"github.com/florianibach/samba-admin-ui/internal/state"
+)
+
+func TestSnapshotsBeforeChanges(t *testing.T) {
+	sambatest.Install(t)
+	a := newTestApp(t)
+	dir := t.TempDir()
+	sharesDir := filepath.Join(dir, "ui")
+	t.Setenv("UI_SHARES_DIR", sharesDir)
+	t.Setenv("UI_SHARES_INDEX", filepath.Join(sharesDir, "shares.conf"))
+	a.smbConf = filepath.Join(dir, "smb.conf")
+	must(t, os.WriteFile(a.smbConf, []byte("[global]\n"), 0o644))
+	a.snapshots = snapshotter{dir: filepath.Join(dir, "backups"), beforeChanges: true, keep: 10}
+
+	calls := 0
+	h := a.withSnapshots(http.HandlerFunc(func(http.ResponseWriter, *http.Request) { calls++ }))
+	do := func(method, path string) {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(method, path, nil))
+	}
+	count := func() int {
+		list, err := backup.ListSnapshots(a.snapshots.dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(list)
+	}
+
+	do("GET", "/shares")
+	do("POST", "/login")
+	do("POST", "/connections/kill")
+	if n := count(); n != 0 {
+		t.Fatalf("%d snapshots for requests that change nothing", n)
+	}
+	do("POST", "/users/create")
+	do("POST", "/users/create") // nothing changed in between
+	if n := count(); n != 1 {
+		t.Fatalf("%d snapshots, want 1", n)
+	}
+	must(t, a.store.UpsertUser(state.User{Name: "alice"}))
+	do("DELETE", "/api/v1/users/alice")
+	must(t, os.MkdirAll(sharesDir, 0o755))
+	must(t, os.WriteFile(filepath.Join(sharesDir, "media.conf"), []byte("path = /x\n"), 0o644))
+	do("POST", "/shares/edit")
+	if calls != 7 {
+		t.Errorf("handler ran %d times", calls)
+	}
+
+	list, _ := backup.ListSnapshots(a.snapshots.dir)
+	var reasons []string
+	for _, s := range list {
+		reasons = append(reasons, s.Reason)
+	}
+	want := []string{"before-shares-edit", "before-delete-users-alice", "before-users-create"}
+	if len(reasons) != len(want) || reasons[0] != want[0] || reasons[1] != want[1] || reasons[2] != want[2] {
+		t.Errorf("reasons = %q, want %q", reasons, want)
+	}
+
+	// A snapshot restores like any other bundle.
+	b, err := a.openSnapshot(list[1].Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.restoreBackup(actor{Name: "admin"}, b); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(sharesDir, "media.conf")); !os.IsNotExist(err) {
+		t.Errorf("media.conf after restore: %v", err)
+	}
+	if _, err := a.openSnapshot("../app.db"); errStatus(err) != http.StatusNotFound {
+		t.Errorf("bad name = %v", err)
+	}
+
+	// snapshots hold password hashes: not for read-only tokens
+	c := newAPIClient(t, a, true)
+	must(t, a.store.CreateAPIToken("sau_rw", auth.TokenHash("sau_rw"), false, "admin"))
+	for token, code := range map[string]int{"sau_ro": http.StatusForbidden, "sau_rw": http.StatusOK} {
+		c.token = token
+		if w := c.do("GET", "/api/v1/backup/snapshots/"+list[0].Name, ""); w.Code != code {
+			t.Errorf("download with %s: %d %s, want %d", token, w.Code, w.Body, code)
+		}
+	}
+}
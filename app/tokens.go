@@ -0,0 +1,119 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/florianibach/samba-admin-ui/internal/auth"
+	"github.com/florianibach/samba-admin-ui/internal/state"
+)
+
+// apiTokenPrefix makes tokens recognizable in configs and secret scanners.
+const apiTokenPrefix = "sau_"
+
+func bearerToken(r *http.Request) (string, bool) {
+	h := r.Header.Get("Authorization")
+	if h == "" {
+		return "", false
+	}
+	scheme, tok, ok := strings.Cut(h, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	return strings.TrimSpace(tok), true
+}
+
+func (a *App) tokenPrincipal(token string) (principal, bool) {
+	if token == "" {
+		return principal{}, false
+	}
+	t, ok, err := a.store.GetAPITokenByHash(auth.TokenHash(token))
+	if err != nil || !ok {
+		return principal{}, false
+	}
+	return principal{Name: "token:" + t.Name, Token: true, ReadOnly: t.ReadOnly}, true
+}
+
+type settingsVM struct {
+	Error  string
+	Tokens []state.APIToken
+
+	// NewToken is shown exactly once, right after creation.
+	NewToken     string
+	NewTokenName string
+}
+
+func (a *App) settings(w http.ResponseWriter, r *http.Request) {
+	a.renderSettings(w, r, settingsVM{})
+}
+
+func (a *App) renderSettings(w http.ResponseWriter, r *http.Request, data settingsVM) {
+	tokens, err := a.store.ListAPITokens()
+	if err != nil && data.Error == "" {
+		data.Error = err.Error()
+	}
+	data.Tokens = tokens
+	a.render(w, r, "settings.html", "Settings", data)
+}
+
+func (a *App) tokenCreate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/settings", http.StatusSeeOther)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	if currentPrincipal(r).Token {
+		http.Error(w, "API tokens cannot create API tokens", http.StatusForbidden)
+		return
+	}
+
+	name := strings.TrimSpace(r.FormValue("name"))
+	if name == "" {
+		a.renderSettings(w, r, settingsVM{Error: "token name required"})
+		return
+	}
+	readOnly := r.FormValue("readOnly") == "on"
+
+	secret, err := auth.NewToken()
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	token := apiTokenPrefix + secret
+	err = a.store.CreateAPIToken(name, auth.TokenHash(token), readOnly, currentUser(r))
+	a.audit(actorOf(r), "token.create", name, err)
+	if err != nil {
+		a.renderSettings(w, r, settingsVM{Error: "failed to create token: " + err.Error()})
+		return
+	}
+
+	a.renderSettings(w, r, settingsVM{NewToken: token, NewTokenName: name})
+}
+
+func (a *App) tokenRevoke(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/settings", http.StatusSeeOther)
+		return
+	}
+	_ = r.ParseForm()
+	if currentPrincipal(r).Token {
+		http.Error(w, "API tokens cannot revoke API tokens", http.StatusForbidden)
+		return
+	}
+	id, err := strconv.ParseInt(r.FormValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid id", 400)
+		return
+	}
+	err = a.store.DeleteAPIToken(id)
+	a.audit(actorOf(r), "token.revoke", "id="+strconv.FormatInt(id, 10), err)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	http.Redirect(w, r, "/settings", http.StatusSeeOther)
+}